@@ -0,0 +1,300 @@
+// Package aiff is a simple AIFF file writer, the big-endian cousin of the
+// WAV writer for tools that prefer it, and a way to export the samples of a
+// song along with their loops.
+// See http://paulbourke.net/dataformats/audio/ for format documentation.
+package aiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	commFramesPos = 22 // offset of the number of frames in the COMM chunk
+	ssndSizePos   = 42 // offset of the SSND chunk size
+	ssndDataPos   = 54 // offset of the first sample
+
+	markerLoopStart = 1
+	markerLoopEnd   = 2
+	loopForward     = 1 // INST chunk play mode of a forward loop
+	middleC         = 60
+)
+
+// ErrInvalidLoop means that the loop given to SetLoop ends before it starts.
+var ErrInvalidLoop = errors.New("loop end is before loop start")
+
+// Format describes the audio in an AIFF file.
+type Format struct {
+	SampleRate    int
+	Channels      int // interleaved samples in each frame
+	BitsPerSample int // 8, 16, 24 or 32
+}
+
+// A Writer writes an AIFF file into WS
+type Writer struct {
+	WS io.WriteSeeker
+
+	format    Format
+	loop      bool
+	loopStart int
+	loopEnd   int
+	buf       []byte // samples converted to the file format
+}
+
+type comm struct {
+	Channels      int16
+	Frames        uint32
+	BitsPerSample int16
+	SampleRate    [10]byte // 80-bit extended precision float
+}
+
+type marker struct {
+	ID       int16
+	Position uint32
+	Name     [10]byte // pascal string, padded to an even length
+}
+
+type inst struct {
+	BaseNote     int8
+	Detune       int8
+	LowNote      int8
+	HighNote     int8
+	LowVelocity  int8
+	HighVelocity int8
+	Gain         int16
+	SustainLoop  [3]int16 // play mode, start marker, end marker
+	ReleaseLoop  [3]int16
+}
+
+// NewWriter returns a Writer that writes a 16-bit stereo AIFF file and
+// sample data to ws
+func NewWriter(ws io.WriteSeeker, sampleRate int) (*Writer, error) {
+	return NewFormatWriter(ws, Format{SampleRate: sampleRate, Channels: 2, BitsPerSample: 16})
+}
+
+// NewFormatWriter returns a Writer that writes an AIFF file of audio in
+// format f and sample data to ws.
+func NewFormatWriter(ws io.WriteSeeker, f Format) (*Writer, error) {
+	if f.SampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", f.SampleRate)
+	}
+	if f.Channels < 1 || f.Channels > math.MaxInt16 {
+		return nil, fmt.Errorf("invalid number of channels %d", f.Channels)
+	}
+	if f.BitsPerSample != 8 && f.BitsPerSample != 16 && f.BitsPerSample != 24 && f.BitsPerSample != 32 {
+		return nil, fmt.Errorf("invalid bits per sample %d", f.BitsPerSample)
+	}
+	w := &Writer{WS: ws, format: f}
+
+	// Sizes and the number of frames are filled in by Finish
+	if err := w.writeChunkHeader("FORM", 0); err != nil {
+		return nil, err
+	}
+	if _, err := ws.Write([]byte("AIFF")); err != nil {
+		return nil, err
+	}
+
+	header := comm{
+		Channels:      int16(f.Channels),
+		BitsPerSample: int16(f.BitsPerSample),
+		SampleRate:    extended(float64(f.SampleRate)),
+	}
+	if err := w.writeChunkHeader("COMM", binary.Size(header)); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(ws, binary.BigEndian, header); err != nil {
+		return nil, err
+	}
+
+	// Start audio data chunk, with no offset or block alignment
+	if err := w.writeChunkHeader("SSND", 0); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(ws, binary.BigEndian, [2]uint32{}); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Format returns the format of the audio being written.
+func (w *Writer) Format() Format {
+	return w.format
+}
+
+// SetLoop marks frames start up to end as a forward loop, for samplers
+// loading the file as an instrument. The loop is written by Finish.
+func (w *Writer) SetLoop(start, end int) error {
+	if start < 0 || end < start {
+		return ErrInvalidLoop
+	}
+	w.loop, w.loopStart, w.loopEnd = true, start, end
+	return nil
+}
+
+// WriteFrame writes the provided interleaved samples to w, Format().Channels
+// per frame. The samples are shifted to the number of bits per sample of the
+// file.
+func (w *Writer) WriteFrame(samples []int16) error {
+	if w.format.BitsPerSample == 16 {
+		return binary.Write(w.WS, binary.BigEndian, samples)
+	}
+
+	buf := w.buffer(len(samples))
+	for i, s := range samples {
+		w.putSample(buf, i, int32(s)<<16)
+	}
+	_, err := w.WS.Write(buf)
+	return err
+}
+
+// WriteInt8 writes the provided interleaved 8-bit samples to w, e.g. the
+// data of a MOD sample. The samples are shifted to the number of bits per
+// sample of the file.
+func (w *Writer) WriteInt8(samples []int8) error {
+	buf := w.buffer(len(samples))
+	for i, s := range samples {
+		w.putSample(buf, i, int32(s)<<24)
+	}
+	_, err := w.WS.Write(buf)
+	return err
+}
+
+// WriteFrames is WriteFrame, for the modplayer.Encoder interface.
+func (w *Writer) WriteFrames(samples []int16) error {
+	return w.WriteFrame(samples)
+}
+
+// Close is Finish, for the modplayer.Encoder interface. It doesn't close WS.
+func (w *Writer) Close() error {
+	_, err := w.Finish()
+	return err
+}
+
+// Returns a buffer for n samples in the file format.
+func (w *Writer) buffer(n int) []byte {
+	size := n * w.format.BitsPerSample / 8
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	return w.buf[:size]
+}
+
+// Stores sample i in buf, reduced from 32 bits to the file format.
+func (w *Writer) putSample(buf []byte, i int, s int32) {
+	switch w.format.BitsPerSample {
+	case 8:
+		buf[i] = byte(s >> 24)
+	case 16:
+		binary.BigEndian.PutUint16(buf[i*2:], uint16(s>>16))
+	case 24:
+		s >>= 8
+		buf[i*3+0], buf[i*3+1], buf[i*3+2] = byte(s>>16), byte(s>>8), byte(s)
+	case 32:
+		binary.BigEndian.PutUint32(buf[i*4:], uint32(s))
+	}
+}
+
+// Finish must be called when all data has been written to the writer
+// This writes the loop, if there is one, and updates placeholder values with
+// the correct values.
+func (w *Writer) Finish() (int64, error) {
+	end, err := w.WS.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	dataLen := end - ssndDataPos
+
+	// Chunks must have an even length
+	if dataLen%2 == 1 {
+		if _, err := w.WS.Write([]byte{0}); err != nil {
+			return 0, err
+		}
+	}
+	if w.loop {
+		if err := w.writeLoop(); err != nil {
+			return 0, err
+		}
+	}
+
+	flen, err := w.WS.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	frames := dataLen / int64(w.format.Channels*w.format.BitsPerSample/8)
+	for _, v := range []struct {
+		pos int64
+		val uint32
+	}{
+		{4, uint32(flen - 8)},
+		{commFramesPos, uint32(frames)},
+		{ssndSizePos, uint32(dataLen + 8)},
+	} {
+		if _, err := w.WS.Seek(v.pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if err := binary.Write(w.WS, binary.BigEndian, v.val); err != nil {
+			return 0, err
+		}
+	}
+
+	return flen, nil
+}
+
+// Writes the markers at the ends of the loop and an instrument chunk that
+// plays the loop.
+func (w *Writer) writeLoop() error {
+	markers := []marker{
+		{ID: markerLoopStart, Position: uint32(w.loopStart), Name: pstring("beg loop")},
+		{ID: markerLoopEnd, Position: uint32(w.loopEnd), Name: pstring("end loop")},
+	}
+	if err := w.writeChunkHeader("MARK", 2+binary.Size(markers)); err != nil {
+		return err
+	}
+	if err := binary.Write(w.WS, binary.BigEndian, uint16(len(markers))); err != nil {
+		return err
+	}
+	if err := binary.Write(w.WS, binary.BigEndian, markers); err != nil {
+		return err
+	}
+
+	instrument := inst{
+		BaseNote:     middleC,
+		HighNote:     127,
+		LowVelocity:  1,
+		HighVelocity: 127,
+		SustainLoop:  [3]int16{loopForward, markerLoopStart, markerLoopEnd},
+	}
+	if err := w.writeChunkHeader("INST", binary.Size(instrument)); err != nil {
+		return err
+	}
+	return binary.Write(w.WS, binary.BigEndian, instrument)
+}
+
+func (w *Writer) writeChunkHeader(chunk string, initialSize int) error {
+	if n, err := w.WS.Write([]byte(chunk)); n != 4 || err != nil {
+		return err
+	}
+
+	return binary.Write(w.WS, binary.BigEndian, int32(initialSize))
+}
+
+// Returns s as a pascal string, a length byte followed by the text.
+func pstring(s string) [10]byte {
+	var p [10]byte
+	p[0] = byte(copy(p[1:], s))
+	return p
+}
+
+// Returns v, which must be positive, as an 80-bit IEEE 754 extended precision
+// float, the format of the sample rate.
+func extended(v float64) [10]byte {
+	var e [10]byte
+	frac, exp := math.Frexp(v) // v = frac * 2^exp, frac in [0.5, 1)
+	binary.BigEndian.PutUint16(e[0:], uint16(16383+exp-1))
+	binary.BigEndian.PutUint64(e[2:], uint64(math.Ldexp(frac, 64)))
+	return e
+}
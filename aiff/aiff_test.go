@@ -0,0 +1,201 @@
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// An in-memory file for the writer.
+type memFile struct {
+	buf []byte
+	pos int64
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if end := int(f.pos) + len(p); end > len(f.buf) {
+		f.buf = append(f.buf, make([]byte, end-len(f.buf))...)
+	}
+	copy(f.buf[f.pos:], p)
+	f.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.buf))
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Returns the chunks of an AIFF file by ID, checking that they fill the file.
+func chunks(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	if string(data[0:4]) != "FORM" || string(data[8:12]) != "AIFF" {
+		t.Fatalf("Expected a FORM AIFF header, got %q", data[0:12])
+	}
+	if size := binary.BigEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Errorf("Expected FORM size %d, got %d", len(data)-8, size)
+	}
+	found := make(map[string][]byte)
+	pos := 12
+	for pos < len(data) {
+		id, size := string(data[pos:pos+4]), int(binary.BigEndian.Uint32(data[pos+4:]))
+		if pos+8+size > len(data) {
+			t.Fatalf("Chunk %q of %d bytes runs past the end of the file", id, size)
+		}
+		found[id] = data[pos+8 : pos+8+size]
+		pos += 8 + size + size%2
+	}
+	return found
+}
+
+func TestWriter(t *testing.T) {
+	f := &memFile{}
+	w, err := NewWriter(f, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrames([]int16{0x1234, -2, 1, -1}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(f.buf)) {
+		t.Errorf("Finish returned %d, expected the file size %d", n, len(f.buf))
+	}
+
+	c := chunks(t, f.buf)
+	var header comm
+	binary.Read(bytes.NewReader(c["COMM"]), binary.BigEndian, &header)
+	want := comm{
+		Channels:      2,
+		Frames:        2,
+		BitsPerSample: 16,
+		SampleRate:    [10]byte{0x40, 0x0E, 0xAC, 0x44}, // 44100
+	}
+	if header != want {
+		t.Errorf("Expected COMM %+v, got %+v", want, header)
+	}
+	wantSSND := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0x12, 0x34, 0xFF, 0xFE, 0x00, 0x01, 0xFF, 0xFF}
+	if !bytes.Equal(c["SSND"], wantSSND) {
+		t.Errorf("Expected SSND % x, got % x", wantSSND, c["SSND"])
+	}
+	if _, ok := c["MARK"]; ok {
+		t.Error("Unexpected MARK chunk without a loop")
+	}
+}
+
+func TestWideSamples(t *testing.T) {
+	for _, tc := range []struct {
+		bits int
+		want []byte
+	}{
+		{8, []byte{0x12, 0xFF}},
+		{24, []byte{0x12, 0x34, 0x00, 0xFF, 0xFE, 0x00}},
+		{32, []byte{0x12, 0x34, 0x00, 0x00, 0xFF, 0xFE, 0x00, 0x00}},
+	} {
+		f := &memFile{}
+		w, err := NewFormatWriter(f, Format{SampleRate: 8000, Channels: 1, BitsPerSample: tc.bits})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteFrame([]int16{0x1234, -2}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		c := chunks(t, f.buf)
+		if got := c["SSND"][8:]; !bytes.Equal(got, tc.want) {
+			t.Errorf("%d bits: expected samples % x, got % x", tc.bits, tc.want, got)
+		}
+		if frames := binary.BigEndian.Uint32(c["COMM"][2:]); frames != 2 {
+			t.Errorf("%d bits: expected 2 frames, got %d", tc.bits, frames)
+		}
+	}
+}
+
+func TestLoop(t *testing.T) {
+	// Three 8-bit frames leave the SSND chunk an odd length
+	f := &memFile{}
+	w, err := NewFormatWriter(f, Format{SampleRate: 16574, Channels: 1, BitsPerSample: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteInt8([]int8{1, -1, 127}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetLoop(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := chunks(t, f.buf)
+	if got, want := c["SSND"][8:], []byte{0x01, 0xFF, 0x7F}; !bytes.Equal(got, want) {
+		t.Errorf("Expected samples % x, got % x", want, got)
+	}
+	if rate := c["COMM"][8:18]; !bytes.Equal(rate, []byte{0x40, 0x0D, 0x81, 0x7C, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("Expected sample rate 16574, got % x", rate)
+	}
+
+	r := bytes.NewReader(c["MARK"])
+	var count uint16
+	binary.Read(r, binary.BigEndian, &count)
+	markers := make([]marker, count)
+	binary.Read(r, binary.BigEndian, markers)
+	wantMarkers := []marker{
+		{ID: markerLoopStart, Position: 1, Name: [10]byte{8, 'b', 'e', 'g', ' ', 'l', 'o', 'o', 'p'}},
+		{ID: markerLoopEnd, Position: 3, Name: [10]byte{8, 'e', 'n', 'd', ' ', 'l', 'o', 'o', 'p'}},
+	}
+	if count != 2 || markers[0] != wantMarkers[0] || markers[1] != wantMarkers[1] || r.Len() != 0 {
+		t.Errorf("Expected markers %+v, got %+v", wantMarkers, markers)
+	}
+
+	var instrument inst
+	binary.Read(bytes.NewReader(c["INST"]), binary.BigEndian, &instrument)
+	if instrument.BaseNote != middleC || instrument.SustainLoop != [3]int16{loopForward, markerLoopStart, markerLoopEnd} {
+		t.Errorf("Unexpected INST %+v", instrument)
+	}
+
+	if err := w.SetLoop(3, 1); err != ErrInvalidLoop {
+		t.Errorf("Expected ErrInvalidLoop, got %v", err)
+	}
+}
+
+func TestExtended(t *testing.T) {
+	for _, c := range []struct {
+		v    float64
+		want [10]byte
+	}{
+		{1, [10]byte{0x3F, 0xFF, 0x80}},
+		{8000, [10]byte{0x40, 0x0B, 0xFA}},
+		{44100, [10]byte{0x40, 0x0E, 0xAC, 0x44}},
+		{48000, [10]byte{0x40, 0x0E, 0xBB, 0x80}},
+	} {
+		if got := extended(c.v); got != c.want {
+			t.Errorf("%v: expected % x, got % x", c.v, c.want, got)
+		}
+	}
+}
+
+func TestNewFormatWriterErrors(t *testing.T) {
+	for _, f := range []Format{
+		{SampleRate: 0, Channels: 2, BitsPerSample: 16},
+		{SampleRate: 44100, Channels: 0, BitsPerSample: 16},
+		{SampleRate: 44100, Channels: 2, BitsPerSample: 12},
+	} {
+		if _, err := NewFormatWriter(&memFile{}, f); err == nil {
+			t.Errorf("%+v: expected an error", f)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package modplayer
+
+import (
+	"fmt"
+	"math"
+)
+
+const maxBassGain = 24 // loudest bass boost in dB
+
+// BassBoost is an Effect that strengthens the low end of the audio, which 8-bit
+// samples often lack on modern speakers. A low shelf filter raises the
+// frequencies below the cutoff, and an optional exciter adds harmonics of the
+// bass so that it can be heard on small speakers that can't reproduce it.
+// Samples that the boost pushes past full scale are clamped.
+type BassBoost struct {
+	shelf   biquad     // low shelf filter
+	exciter float64    // amount of harmonics added, 0 for none
+	lowpass float64    // one pole lowpass coefficient selecting the bass the exciter uses
+	low     [2]float64 // lowpass state of each stereo channel
+}
+
+// NewBassBoost returns a BassBoost for audio at sampleRate that raises the
+// frequencies below cutoff Hz by gain dB, which is 0 to 24.
+func NewBassBoost(sampleRate uint, cutoff, gain float64) (*BassBoost, error) {
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if cutoff <= 0 || cutoff >= float64(sampleRate)/2 || math.IsNaN(cutoff) {
+		return nil, fmt.Errorf("invalid bass cutoff %gHz", cutoff)
+	}
+	if gain < 0 || gain > maxBassGain || math.IsNaN(gain) {
+		return nil, fmt.Errorf("invalid bass gain %gdB", gain)
+	}
+
+	// Low shelf from the Audio EQ Cookbook with a slope of 1
+	a := math.Pow(10, gain/40)
+	w := 2 * math.Pi * cutoff / float64(sampleRate)
+	cos := math.Cos(w)
+	alpha := math.Sin(w) / math.Sqrt2
+	sq := 2 * math.Sqrt(a) * alpha
+
+	a0 := (a + 1) + (a-1)*cos + sq
+	return &BassBoost{
+		shelf: biquad{
+			b0: a * ((a + 1) - (a-1)*cos + sq) / a0,
+			b1: 2 * a * ((a - 1) - (a+1)*cos) / a0,
+			b2: a * ((a + 1) - (a-1)*cos - sq) / a0,
+			a1: -2 * ((a - 1) + (a+1)*cos) / a0,
+			a2: ((a + 1) + (a-1)*cos - sq) / a0,
+		},
+		lowpass: 1 - math.Exp(-w),
+	}, nil
+}
+
+// SetExciter sets the amount of harmonics of the bass that are added, from 0
+// for none, the default, to 1.
+func (b *BassBoost) SetExciter(amount float64) error {
+	if amount < 0 || amount > 1 || math.IsNaN(amount) {
+		return fmt.Errorf("invalid exciter amount %g", amount)
+	}
+	b.exciter = amount
+	return nil
+}
+
+// Process boosts the bass of in and writes the result to out.
+func (b *BassBoost) Process(in, out []int16) {
+	for i, s := range in {
+		c := i & 1 // left or right
+		x := float64(s) / 32768
+
+		y := b.shelf.process(x, c)
+
+		if b.exciter > 0 {
+			// Saturating the bass creates odd harmonics, add the change
+			// saturation makes to it
+			b.low[c] += (x - b.low[c]) * b.lowpass
+			drive := 4 * b.low[c]
+			y += b.exciter * (math.Tanh(drive) - drive) / 4
+		}
+
+		out[i] = int16(clamp(math.Round(y*32768), -32768, 32767))
+	}
+}
@@ -0,0 +1,110 @@
+package modplayer
+
+import "math"
+
+const (
+	headRadius   = 0.0875 // meters
+	speedOfSound = 343.0  // meters per second
+
+	// Shape of the head shadow, from Brown and Duda's spherical head model
+	shadowMinAlpha = 0.1             // treble gain of an ear facing directly away from the sound
+	shadowMinAngle = 5 * math.Pi / 6 // angle from the ear the treble is quietest at
+
+	binauralTail = 64 // samples the filters are run for after a channel goes silent
+)
+
+// Binaural rendering state of a channel, see PanningBinaural. The channel is
+// mixed to the center of buf, then each ear hears the result through the
+// delay and head shadow filter of a sound at the channel's pan position.
+type binauralState struct {
+	buf   []int     // channel mixed to the center
+	pan   int       // double resolution pan position the ears are set up for
+	ring  []float64 // recent input, for the interaural delay
+	head  int       // index in ring of the next input
+	delay [2]int    // samples each ear hears the sound after the other
+	tail  int       // samples left to run the filters for once the channel is silent
+
+	// Head shadow filter of each ear
+	b0, b1, a1 [2]float64
+	x1, y1     [2]float64
+}
+
+// Sets up the ears of b for a sound at pan, a double resolution pan position
+// from stereoPan. The head is modelled as a sphere, following Brown and Duda,
+// "A Structural Model for Binaural Sound Synthesis" (1998). A sound reaches
+// the far ear later and with its treble shadowed by the head, and its treble
+// is boosted a little in the near ear.
+func (p *Player) setupEars(b *binauralState, pan int) {
+	fs := float64(p.samplingFrequency)
+	if b.ring == nil {
+		n := int(math.Round(headRadius/speedOfSound*(1+math.Pi/2)*fs)) + 1
+		b.ring = make([]float64, n)
+	}
+	b.pan = pan
+
+	// Azimuth of the sound from straight ahead, positive to the right
+	az := float64(pan-127) / 127 * math.Pi / 2
+
+	var delay [2]float64
+	k := fs * headRadius / speedOfSound // bilinear transform of the filter
+	for e, theta := range [2]float64{math.Pi/2 + az, math.Pi/2 - az} {
+		// theta is the angle between the sound and the ear
+		if theta < math.Pi/2 {
+			delay[e] = 1 - math.Cos(theta)
+		} else {
+			delay[e] = 1 + theta - math.Pi/2
+		}
+		delay[e] *= headRadius / speedOfSound * fs
+
+		// One pole, one zero filter with a treble gain of alpha
+		alpha := (1 + shadowMinAlpha/2) + (1-shadowMinAlpha/2)*math.Cos(theta/shadowMinAngle*math.Pi)
+		b.b0[e] = (1 + alpha*k) / (1 + k)
+		b.b1[e] = (1 - alpha*k) / (1 + k)
+		b.a1[e] = (1 - k) / (1 + k)
+	}
+
+	// Only the difference between the ears matters
+	nearest := min(delay[0], delay[1])
+	for e := range delay {
+		b.delay[e] = min(int(math.Round(delay[e]-nearest)), len(b.ring)-1)
+	}
+}
+
+// Mixes channel like mixChannelInto, but places it around the listener's
+// head with a simple head related transfer function instead of panning it.
+func (p *Player) mixBinaural(mix []int, channel *channel, ci, nSamples, offset int) {
+	b := &channel.binaural
+	if channel.sample == -1 && b.tail <= 0 {
+		return // silent and the filters have settled
+	}
+
+	if len(b.buf) < nSamples*2 {
+		b.buf = make([]int, len(p.mixbuffer))
+	}
+	buf := b.buf[:nSamples*2]
+	clear(buf)
+	p.mixChannelAt(buf, channel, ci, nSamples, 0, 127)
+
+	if pan := p.stereoPan(channel); b.ring == nil || pan != b.pan {
+		p.setupEars(b, pan)
+	}
+	if channel.sample != -1 {
+		b.tail = len(b.ring) + binauralTail
+	} else {
+		b.tail -= nSamples
+	}
+
+	n := len(b.ring)
+	out := mix[offset*2 : (offset+nSamples)*2]
+	for i := 0; i < nSamples; i++ {
+		// Centered, the left and right of buf are the same
+		b.ring[b.head] = float64(buf[i*2])
+		for e := 0; e < 2; e++ {
+			x := b.ring[(b.head-b.delay[e]+n)%n]
+			y := b.b0[e]*x + b.b1[e]*b.x1[e] - b.a1[e]*b.y1[e]
+			b.x1[e], b.y1[e] = x, y
+			out[i*2+e] += int(math.Round(y))
+		}
+		b.head = (b.head + 1) % n
+	}
+}
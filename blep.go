@@ -0,0 +1,144 @@
+package modplayer
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	blepTaps      = 16                 // output samples a step is spread over
+	blepPhaseBits = 8                  // log2 of the number of phases
+	blepPhases    = 1 << blepPhaseBits // step positions between two output samples that have their own residuals
+	blepBits      = 16                 // fraction bits of the residuals
+	blepDelay     = blepTaps / 2       // output samples the steps are delayed by
+	blepOversamp  = 4                  // integration steps for each phase
+)
+
+var (
+	// Band-limited step residuals for each phase, built on first use by
+	// initBLEP. blepTable[ph][j] is the band-limited step minus the ideal
+	// step, j+1 output samples after a step at ph/blepPhases of an output
+	// sample.
+	blepTable [blepPhases + 1][blepTaps]int32
+	blepOnce  sync.Once
+)
+
+// Band-limited synthesis state of a channel, see InterpolationBLEP
+type blepState struct {
+	level int           // frame being output, with 8 bits of fraction
+	ring  [blepTaps]int // residuals of recent steps still to be output
+	head  int           // index in ring of the next output sample
+}
+
+// Builds the band-limited step table. The step is the integral of a
+// Blackman-windowed sinc cut off at the output Nyquist frequency, centered
+// blepDelay samples after the step so that it never reaches back in time.
+func initBLEP() {
+	blepOnce.Do(func() {
+		// Integrate the windowed sinc over the length of the step
+		const steps = blepTaps * blepPhases * blepOversamp
+		integral := make([]float64, steps+1)
+		for i := 1; i <= steps; i++ {
+			x := (float64(i)-0.5)/(blepPhases*blepOversamp) - blepDelay
+			integral[i] = integral[i-1] + sinc(x)*blackman(x/blepDelay)
+		}
+		total := integral[steps]
+
+		for ph := range blepTable {
+			for j := range blepTable[ph] {
+				// The step is ph/blepPhases into the output sample before
+				// output sample j+1
+				i := ((j+1)*blepPhases - ph) * blepOversamp
+				blepTable[ph][j] = int32(math.Round((integral[i]/total - 1) * (1 << blepBits)))
+			}
+		}
+	})
+}
+
+// Adds a step of delta in the channel's output, ph/blepPhases of an output
+// sample after the last sample output.
+func (b *blepState) step(delta, ph int) {
+	residuals := &blepTable[ph]
+	for j, r := range residuals {
+		b.ring[(b.head+j)%blepTaps] += (delta * int(r)) >> blepBits
+	}
+	b.level += delta
+}
+
+// Returns the next output sample of the channel, with 8 bits of fraction.
+func (b *blepState) next() int {
+	v := b.level + b.ring[b.head]
+	b.ring[b.head] = 0
+	b.head = (b.head + 1) % blepTaps
+	return v
+}
+
+// Mixes channel into mix from cur to end like the interpolated loop of
+// mixChannelInto, but reproduces the sound of the Amiga's Paula chip. Paula
+// holds each frame until the next one, the square steps between frames are
+// band-limited to stop them aliasing. The declick ramp is applied here as the
+// output is delayed. Returns the new sample position and whether the sample
+// ended.
+func (p *Player) mixBLEP(mix []int, channel *channel, sample *Sample, pos, dr, sampEnd uint, cur, end, lvol, rvol, rampLen int, sumSq, peakSq *int) (uint, bool) {
+	b := &channel.blep
+	ended := false
+
+	// A new note, or sample data that has moved since the channel was last
+	// mixed, steps straight to the current frame
+	if pos >= sampEnd && sample.LoopLen > 0 {
+		pos = loopWrap(pos, sample)
+	}
+	if pos < sampEnd {
+		if v := frame16(sample, int(pos>>16)); v != b.level {
+			b.step(v-b.level, 0)
+		}
+	}
+
+	for cur < end {
+		v := b.next()
+		l, r := (v*lvol)>>8, (v*rvol)>>8
+		if channel.rampPos < rampLen {
+			l = l * channel.rampPos / rampLen
+			r = r * channel.rampPos / rampLen
+			channel.rampPos++
+		}
+		mix[cur+0] += l
+		mix[cur+1] += r
+		sd := v >> 8
+		sq := sd * sd
+		*sumSq += sq
+		*peakSq = max(*peakSq, sq)
+		cur += 2
+
+		if ended {
+			continue // let the final step fade out
+		}
+
+		// Step to each frame the position moves into before the next output
+		// sample
+		for left := dr; ; {
+			toNext := (pos>>16+1)<<16 - pos
+			if toNext > left {
+				pos += left
+				break
+			}
+			pos += toNext
+			left -= toNext
+			ph := int((dr - left) * blepPhases / dr)
+
+			if pos >= sampEnd {
+				if sample.LoopLen == 0 {
+					b.step(-b.level, ph)
+					ended = true
+					break
+				}
+				pos = loopWrap(pos, sample)
+			}
+			if v := frame16(sample, int(pos>>16)); v != b.level {
+				b.step(v-b.level, ph)
+			}
+		}
+	}
+
+	return pos, ended
+}
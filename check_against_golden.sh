@@ -2,7 +2,8 @@
 # A script to verify the player output against previously generated golden files
 # It is a useful end-to-end test which has caught several bugs. Generate the
 # golden files with make_golden.sh first. This script should be run from the
-# project root.
+# project root. Only the audio in the data chunks is compared, so golden files
+# still match when the WAV writer adds or changes the other chunks.
 
 set -o pipefail
 
@@ -17,6 +18,25 @@ strip_extension() {
   echo "${filename%.*}"
 }
 
+# Prints the offset and size of the data chunk of the WAV file $1, found by
+# walking the chunks after the RIFF header
+data_chunk() {
+  local offset=12 id size
+  while true; do
+    id=$(dd if="$1" bs=1 skip=$offset count=4 2>/dev/null)
+    size=$(od -An -tu4 -j $((offset + 4)) -N4 "$1" | tr -d ' ')
+    if [ -z "$size" ]; then
+      return 1
+    fi
+    if [ "$id" == "data" ]; then
+      echo "$((offset + 8)) $size"
+      return 0
+    fi
+    # Chunks start at even offsets
+    offset=$((offset + 8 + size + size % 2))
+  done
+}
+
 if [ ! -d $GOLDENDIR ];
 then
     echo "Could not find golden directory '$GOLDENDIR', stopping"
@@ -49,7 +69,7 @@ do
   fi
 
   # Generate the candidate WAV file
-  go run ./cmd/modwav -reverb none -wav "$WAV_OUT" "$SONG_FILENAME" > /dev/null
+  go run ./cmd/modwav -reverb none -interp none -wav "$WAV_OUT" "$SONG_FILENAME" > /dev/null
 
   retVal=$?
   if [ $retVal -ne 0 ]; then
@@ -57,13 +77,16 @@ do
     exit $retVal
   fi
 
-  # Compare the candidate against the golden version
-  cmp -s "$WAV_OUT" "$GOLDEN_FILE"
+  # Compare the audio of the candidate against the golden version
+  read -r candOffset candSize <<< "$(data_chunk "$WAV_OUT")"
+  read -r goldOffset goldSize <<< "$(data_chunk "$GOLDEN_FILE")"
+  [ -n "$candSize" ] && [ "$candSize" == "$goldSize" ] &&
+    cmp -s -n "$candSize" "$WAV_OUT" "$GOLDEN_FILE" "$candOffset" "$goldOffset"
 
   retVal=$?
   if [ $retVal -ne 0 ]; then
-    echo -e "\n!!! $mod does not match golden"
-    echo "cmp -l $WAV_OUT $GOLDEN_FILE"
+    echo -e "\n!!! $song does not match golden"
+    echo "cmp -l $WAV_OUT $GOLDEN_FILE $candOffset $goldOffset"
     exit $retVal
   else
     printf "$GREEN_CHECK_MARK\n" # Print a green check mark and move to next line, see echo -n at top of loop
@@ -0,0 +1,75 @@
+// Package cli holds the command line parsing shared by modplay and modwav.
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+// ParseTime parses a time into the song like 1:30, 90 or 1:02:30.5, in
+// seconds, optionally preceded by minutes and hours, separated by colons.
+func ParseTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	secs := 0.0
+	for i, part := range parts {
+		digits := "0123456789"
+		if i == len(parts)-1 {
+			digits += "."
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if part == "" || strings.Trim(part, digits) != "" || err != nil || (i > 0 && v >= 60) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		secs = secs*60 + v
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// ParseClock parses the name of an Amiga timing, pal or ntsc.
+func ParseClock(s string) (modplayer.AmigaClock, error) {
+	switch s {
+	case "pal":
+		return modplayer.ClockPAL, nil
+	case "ntsc":
+		return modplayer.ClockNTSC, nil
+	}
+	return 0, fmt.Errorf("unrecognized clock %q", s)
+}
+
+// ParseInterpolation parses the name of a sample interpolation, none, linear,
+// cubic, sinc or blep.
+func ParseInterpolation(s string) (modplayer.Interpolation, error) {
+	switch s {
+	case "none":
+		return modplayer.InterpolationNone, nil
+	case "linear":
+		return modplayer.InterpolationLinear, nil
+	case "cubic":
+		return modplayer.InterpolationCubic, nil
+	case "sinc":
+		return modplayer.InterpolationSinc, nil
+	case "blep":
+		return modplayer.InterpolationBLEP, nil
+	}
+	return 0, fmt.Errorf("unrecognized interpolation %q", s)
+}
+
+// ParseLoopPolicy parses the name of a loop policy, loop, stop or fade.
+func ParseLoopPolicy(s string) (modplayer.LoopPolicy, error) {
+	switch s {
+	case "loop":
+		return modplayer.LoopPolicyLoop, nil
+	case "stop":
+		return modplayer.LoopPolicyStop, nil
+	case "fade":
+		return modplayer.LoopPolicyFade, nil
+	}
+	return 0, fmt.Errorf("unrecognized loop policy %q", s)
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+func TestParseTime(t *testing.T) {
+	cases := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"90", 90 * time.Second},
+		{"1:30", 90 * time.Second},
+		{"0:00.5", 500 * time.Millisecond},
+		{"1:02:30.5", time.Hour + 2*time.Minute + 30500*time.Millisecond},
+	}
+	for _, c := range cases {
+		if got, err := ParseTime(c.s); err != nil || got != c.want {
+			t.Errorf("ParseTime(%q): expected %v, got %v, %v", c.s, c.want, got, err)
+		}
+	}
+
+	for _, s := range []string{"", "1:", ":30", "1:60", "1.5:00", "-1", "1e3", "1:2:3:4"} {
+		if _, err := ParseTime(s); err == nil {
+			t.Errorf("ParseTime(%q): expected an error", s)
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	if c, err := ParseClock("ntsc"); err != nil || c != modplayer.ClockNTSC {
+		t.Errorf("ParseClock: expected NTSC, got %v, %v", c, err)
+	}
+	if i, err := ParseInterpolation("blep"); err != nil || i != modplayer.InterpolationBLEP {
+		t.Errorf("ParseInterpolation: expected BLEP, got %v, %v", i, err)
+	}
+	if l, err := ParseLoopPolicy("fade"); err != nil || l != modplayer.LoopPolicyFade {
+		t.Errorf("ParseLoopPolicy: expected fade, got %v, %v", l, err)
+	}
+
+	if _, err := ParseClock(""); err == nil {
+		t.Error("ParseClock: expected an error for an empty name")
+	}
+	if _, err := ParseInterpolation("Sinc"); err == nil {
+		t.Error("ParseInterpolation: expected an error for a misspelled name")
+	}
+	if _, err := ParseLoopPolicy("repeat"); err == nil {
+		t.Error("ParseLoopPolicy: expected an error for an unknown name")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -8,17 +9,24 @@ import (
 	"strings"
 
 	"github.com/chriskillpack/modplayer"
+	"github.com/chriskillpack/modplayer/aiff"
+)
+
+var (
+	flagSamples      = flag.String("samples", "", "directory to export each sample of the song to")
+	flagSampleFormat = flag.String("sampleformat", "aiff", "file format of the samples exported with -samples: aiff or wav")
 )
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("moddump: ")
+	flag.Parse()
 
-	if len(os.Args) <= 1 {
+	if len(flag.Args()) == 0 {
 		log.Fatal("Missing song filename")
 	}
 
-	songFName := os.Args[1]
+	songFName := flag.Arg(0)
 	songF, err := os.ReadFile(songFName)
 	if err != nil {
 		log.Fatal(err)
@@ -26,15 +34,74 @@ func main() {
 
 	modplayer.SetDumpWriter(os.Stdout)
 
+	var song *modplayer.Song
 	switch strings.ToLower(filepath.Ext(songFName)) {
 	case ".mod":
-		_, err = modplayer.NewMODSongFromBytes(songF)
+		song, err = modplayer.NewMODSongFromBytes(songF)
 	case ".s3m":
-		_, err = modplayer.NewS3MSongFromBytes(songF)
+		song, err = modplayer.NewS3MSongFromBytes(songF)
 	default:
 		err = fmt.Errorf("unsupported song %q", songFName)
 	}
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if *flagSamples != "" {
+		switch *flagSampleFormat {
+		case "aiff":
+			err = exportSamples(song, *flagSamples)
+		case "wav":
+			err = song.ExportSamples(*flagSamples)
+		default:
+			err = fmt.Errorf("unrecognized sample format %q", *flagSampleFormat)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// exportSamples writes each sample of song that has data to dir as an 8-bit
+// mono AIFF file at its middle C rate, with its loop. The files are named
+// after the sample numbers, 01.aiff onwards.
+func exportSamples(song *modplayer.Song, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for i, sample := range song.Samples {
+		if len(sample.Data) == 0 || sample.C4Speed <= 0 {
+			continue
+		}
+		if err := exportSample(sample, filepath.Join(dir, fmt.Sprintf("%02d.aiff", i+1))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportSample(sample modplayer.Sample, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := aiff.NewFormatWriter(f, aiff.Format{SampleRate: sample.C4Speed, Channels: 1, BitsPerSample: 8})
+	if err != nil {
+		return err
+	}
+	if sample.LoopLen > 0 {
+		if err := w.SetLoop(sample.LoopStart, sample.LoopStart+sample.LoopLen); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteInt8(sample.Data); err != nil {
+		return err
+	}
+	if _, err := w.Finish(); err != nil {
+		return err
+	}
+	return f.Close()
 }
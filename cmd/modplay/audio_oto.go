@@ -0,0 +1,60 @@
+//go:build oto
+
+package main
+
+import (
+	"errors"
+	"io"
+
+	"github.com/chriskillpack/modplayer/modoto"
+)
+
+// Stereo samples generated at a time
+const otoBufferLen = 1024
+
+// oto only plays through the default audio device
+var errNoDevices = errors.New("-device and -list-devices need modplay built without the oto tag")
+
+// Starts playing audio through oto, which only plays through the default
+// device, so device must be empty. fill is called from a separate goroutine
+// to generate each buffer of stereo samples. Returns a function that stops
+// playback.
+func startAudio(hz int, device string, fill func(out []int16)) (func(), error) {
+	if device != "" {
+		return nil, errNoDevices
+	}
+	out, err := modoto.NewOutput(hz)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		buf := make([]int16, otoBufferLen*2)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			fill(buf)
+			if err := out.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		out.Close()
+	}, nil
+}
+
+func listDevices(w io.Writer) error {
+	return errNoDevices
+}
@@ -0,0 +1,112 @@
+//go:build !oto
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// Starts playing audio through portaudio on device, a device number or name
+// from listDevices, or the default output device if device is empty. fill is
+// called from the audio callback to generate each buffer of stereo samples.
+// Returns a function that stops playback.
+func startAudio(hz int, device string, fill func(out []int16)) (func(), error) {
+	if err := portaudio.Initialize(); err != nil {
+		return nil, err
+	}
+
+	out, err := findDevice(device)
+	if err != nil {
+		portaudio.Terminate()
+		return nil, err
+	}
+	params := portaudio.HighLatencyParameters(nil, out)
+	params.Output.Channels = 2
+	params.SampleRate = float64(hz)
+	stream, err := portaudio.OpenStream(params, fill)
+	if err != nil {
+		portaudio.Terminate()
+		return nil, err
+	}
+	if err := stream.Start(); err != nil {
+		stream.Close()
+		portaudio.Terminate()
+		return nil, err
+	}
+
+	return func() {
+		stream.Stop()
+		stream.Close()
+		portaudio.Terminate()
+	}, nil
+}
+
+// Returns the output device numbered or named device, the default output
+// device if device is empty. A name matches any device whose name contains
+// it, ignoring case, as long as only one does.
+func findDevice(device string) (*portaudio.DeviceInfo, error) {
+	if device == "" {
+		return portaudio.DefaultOutputDevice()
+	}
+
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+	if n, err := strconv.Atoi(device); err == nil {
+		if n < 0 || n >= len(devices) || devices[n].MaxOutputChannels == 0 {
+			return nil, fmt.Errorf("invalid device %d, see -list-devices", n)
+		}
+		return devices[n], nil
+	}
+
+	var found []*portaudio.DeviceInfo
+	for _, d := range devices {
+		if d.MaxOutputChannels == 0 {
+			continue
+		}
+		if d.Name == device {
+			return d, nil
+		}
+		if strings.Contains(strings.ToLower(d.Name), strings.ToLower(device)) {
+			found = append(found, d)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no device named %q, see -list-devices", device)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%d devices named %q, pick one by number from -list-devices", len(found), device)
+}
+
+// Writes the number and name of each output device to w, marking the default.
+func listDevices(w io.Writer) error {
+	if err := portaudio.Initialize(); err != nil {
+		return err
+	}
+	defer portaudio.Terminate()
+
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return err
+	}
+	def, _ := portaudio.DefaultOutputDevice()
+	for i, d := range devices {
+		if d.MaxOutputChannels == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%3d %s (%s)", i, d.Name, d.HostApi.Name)
+		if d == def {
+			fmt.Fprint(w, " default")
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chriskillpack/modplayer"
+	"github.com/fatih/color"
+)
+
+// A theme is the colors of the parts of the display
+type theme struct {
+	label      *color.Color // names of the numbers on the title line and channel numbers
+	note       *color.Color
+	instrument *color.Color
+	volume     *color.Color
+	effect     *color.Color
+	param      *color.Color
+	meter      *color.Color
+}
+
+// The built in themes. The mono theme has no colors.
+var themes = map[string]theme{
+	"default": {
+		label:      color.New(color.FgHiBlue),
+		note:       color.New(color.FgWhite),
+		instrument: color.New(color.FgCyan),
+		volume:     color.New(color.FgGreen),
+		effect:     color.New(color.FgMagenta),
+		param:      color.New(color.FgYellow),
+		meter:      color.New(color.FgGreen),
+	},
+	// For terminals with a light background
+	"light": {
+		label:      color.New(color.FgBlue, color.Bold),
+		note:       color.New(color.FgBlack),
+		instrument: color.New(color.FgBlue),
+		volume:     color.New(color.FgGreen),
+		effect:     color.New(color.FgMagenta),
+		param:      color.New(color.FgRed),
+		meter:      color.New(color.FgGreen),
+	},
+	// Shades of green, like an old monitor
+	"phosphor": {
+		label:      color.New(color.FgHiGreen, color.Bold),
+		note:       color.New(color.FgHiGreen),
+		instrument: color.New(color.FgGreen),
+		volume:     color.New(color.FgGreen),
+		effect:     color.New(color.FgHiGreen),
+		param:      color.New(color.FgGreen),
+		meter:      color.New(color.FgHiGreen),
+	},
+	"mono": {
+		label:      color.New(),
+		note:       color.New(),
+		instrument: color.New(),
+		volume:     color.New(),
+		effect:     color.New(),
+		param:      color.New(),
+		meter:      color.New(),
+	},
+}
+
+// The colors of the display
+var colors = themes["default"]
+
+// Sets the colors of the display to the theme called name. Colors are turned
+// off for the mono theme, or if noColor is set.
+func setTheme(name string, noColor bool) error {
+	t, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unrecognized theme %q", name)
+	}
+	colors = t
+	if name == "mono" || noColor {
+		color.NoColor = true
+	}
+	return nil
+}
+
+// Returns the names of the themes in alphabetical order.
+func themeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const (
+	noteCellWidth    = 14 // width of a channel's note data
+	compactCellWidth = 6  // width of a channel's note data in the compact layout, the note and instrument
+	rowMarkerWidth   = 4  // width of the current row markers either side of the note data
+)
+
+// A layout is how the note data of the channels is laid out across the
+// display.
+type layout struct {
+	cell    int // width of each channel's column
+	columns int // columns that fit across the display
+}
+
+// Returns the layout of the note data of channels for a terminal width
+// characters wide. Channels that don't fit across the terminal are shown in
+// the compact layout, which only has the note and instrument.
+func newLayout(channels, width int) layout {
+	if full := fitColumns(width, noteCellWidth); channels <= full {
+		return layout{cell: noteCellWidth, columns: full}
+	}
+	return layout{cell: compactCellWidth, columns: fitColumns(width, compactCellWidth)}
+}
+
+// Returns the number of columns of cell characters with separators between
+// them that fit across width characters, with the row markers.
+func fitColumns(width, cell int) int {
+	return max((width-2*rowMarkerWidth+1)/(cell+1), 1)
+}
+
+// Writes the note data of the first few visible channels to b, for the rows
+// from around rows before the current row to around rows after it. The
+// current row is marked.
+func drawNoteRows(b *strings.Builder, player *modplayer.Player, state modplayer.PlayerState, visible []int, around int, lay layout) {
+	for i := -around; i <= around; i++ {
+		nd := player.NoteDataFor(state.Order, state.Row+i)
+		if nd == nil {
+			b.WriteString("\n")
+			continue
+		}
+
+		// If this is the currently playing row then highlight it
+		if i == 0 {
+			b.WriteString(">>> ")
+		} else {
+			b.WriteString("    ")
+		}
+
+		// Print out the first few visible channels of note data
+		for ni, ci := range visible {
+			if ni == lay.columns {
+				b.WriteString(" ...")
+				break
+			}
+
+			n := nd[ci]
+			fmt.Fprint(b, colors.note.Sprint(n.Note), " ", colors.instrument.Sprintf("%2X", n.Instrument))
+			if lay.cell == compactCellWidth {
+				if ni < min(len(visible), lay.columns)-1 {
+					b.WriteString("|")
+				}
+				continue
+			}
+			b.WriteString(" ")
+			if n.Volume != 0xFF {
+				b.WriteString(colors.volume.Sprintf("%02X", n.Volume))
+			} else {
+				b.WriteString(colors.volume.Sprint(".."))
+			}
+			fmt.Fprint(b, " ", colors.effect.Sprintf("%02X", n.Effect), colors.param.Sprintf("%02X", n.Param))
+
+			if ni < min(len(visible), lay.columns)-1 {
+				b.WriteString("|")
+			}
+		}
+		if i == 0 {
+			b.WriteString(" <<<")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// Cuts frame down to at most height lines, each at most width characters
+// long, so that the terminal doesn't wrap or scroll it. Escape sequences take
+// no space and are kept.
+func clipFrame(frame string, width, height int) string {
+	var b strings.Builder
+	col, lines := 0, 0
+	for i := 0; i < len(frame) && lines < height; {
+		switch frame[i] {
+		case '\n':
+			b.WriteByte('\n')
+			col, lines = 0, lines+1
+			i++
+		case '\x1b':
+			// Control sequences end with a byte from @ to ~
+			j := i + 1
+			if j < len(frame) && frame[j] == '[' {
+				for j++; j < len(frame) && (frame[j] < '@' || frame[j] > '~'); j++ {
+				}
+				j++
+			}
+			j = min(j, len(frame))
+			b.WriteString(frame[i:j])
+			i = j
+		default:
+			_, size := utf8.DecodeRuneInString(frame[i:])
+			if col < width {
+				b.WriteString(frame[i : i+size])
+			}
+			col++
+			i += size
+		}
+	}
+	return b.String()
+}
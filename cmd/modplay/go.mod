@@ -4,14 +4,23 @@ go 1.21
 
 require (
 	github.com/chriskillpack/modplayer v0.1.0
+	github.com/chriskillpack/modplayer/modoto v0.1.0
 	github.com/fatih/color v1.13.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/gordonklaus/portaudio v0.0.0-20230709114228-aafa478834f5
+	golang.org/x/sys v0.14.0
+	golang.org/x/term v0.14.0
 )
 
 require (
+	github.com/hajimehoshi/oto v0.7.1 // indirect
 	github.com/mattn/go-colorable v0.1.9 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
-	golang.org/x/sys v0.14.0 // indirect
+	golang.org/x/exp v0.0.0-20190306152737-a1d7652674e8 // indirect
+	golang.org/x/image v0.0.0-20190227222117-0694c2d4d067 // indirect
+	golang.org/x/mobile v0.0.0-20190415191353-3e0bab5405d6 // indirect
 )
 
 replace github.com/chriskillpack/modplayer v0.1.0 => ../../
+
+replace github.com/chriskillpack/modplayer/modoto v0.1.0 => ../../modoto
@@ -1,135 +1,444 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/chriskillpack/modplayer"
-	"github.com/chriskillpack/modplayer/cmd/internal/config"
-	"github.com/fatih/color"
-	"github.com/gordonklaus/portaudio"
+	"github.com/chriskillpack/modplayer/cmd/internal/cli"
+	"github.com/chriskillpack/modplayer/comb"
 )
 
 var (
-	flagHz       = flag.Int("hz", 44100, "output hz")
-	flagBoost    = flag.Int("boost", 1, "volume boost, an integer between 1 and 4")
-	flagStartOrd = flag.Int("start", 0, "starting order in the MOD, clamped to song max")
-	flagLenOrd   = flag.Int("maxpatterns", -1, "Maximum number of orders to play, useful for songs that loop forever")
-	flagReverb   = flag.String("reverb", "light", "choose from light, medium, silly or none")
-	flagMute     = flag.Uint("mute", 0, "bitmask of muted channels, channel 1 in LSB, set bit to mute channel")
+	flagHz         = flag.Int("hz", 44100, "output hz")
+	flagMixHz      = flag.Int("mixhz", 0, "mix at this hz and resample to -hz, 0 mixes at -hz")
+	flagBoost      = flag.Int("boost", 1, "volume boost, an integer between 1 and 4")
+	flagGain       = flag.Float64("gain", 1, "output gain applied after mixing, e.g. 0.5 halves the volume")
+	flagSoftClip   = flag.Bool("softclip", false, "saturate loud peaks smoothly instead of clipping them")
+	flagStartOrd   = flag.Int("start", 0, "starting order in the MOD, clamped to song max")
+	flagStartTime  = flag.String("start-time", "", "start this far into each song, as mm:ss, with the effects as if it had played from the start")
+	flagLenOrd     = flag.Int("maxpatterns", -1, "Maximum number of orders to play, useful for songs that loop forever")
+	flagReverb     = flag.String("reverb", "light", "choose from "+strings.Join(comb.Names(), ", ")+" or a custom reverb like decay=0.4,delay=300")
+	flagBass       = flag.Float64("bass", 0, "boost the bass by this many dB, 0 to 24")
+	flagExciter    = flag.Float64("exciter", 0, "amount of bass harmonics added with -bass, 0 to 1")
+	flagLowPass    = flag.Float64("lowpass", 0, "remove frequencies above this many Hz, 0 to disable")
+	flagHighPass   = flag.Float64("highpass", 0, "remove frequencies below this many Hz, 0 to disable")
+	flagBinaural   = flag.Bool("binaural", false, "place the channels around your head instead of panning them, for listening on headphones")
+	flagCrossfeed  = flag.Bool("crossfeed", false, "feed some of each channel into the other, for listening on headphones")
+	flagMute       = flag.Uint("mute", 0, "bitmask of muted channels, channel 1 in LSB, set bit to mute channel")
+	flagSeparation = flag.Int("separation", 100, "stereo separation percentage, 0 is mono")
+	flagTranspose  = flag.Float64("transpose", 0, "shift the pitch by this many semitones without changing the tempo")
+	flagTempo      = flag.Float64("tempo", 1, "tempo multiplier, 2 plays twice as fast")
+	flagClock      = flag.String("clock", "pal", "Amiga timing, pal or ntsc")
+	flagAmiga      = flag.Bool("amiga", false, "use the ProTracker period tables for MOD files")
+	flagInterp     = flag.String("interp", "none", "sample interpolation: none, linear, cubic, sinc (best quality, slowest) or blep (Amiga sound)")
+	flagLoops      = flag.Int("loops", 0, "number of times to repeat the song, -1 to repeat forever")
+	flagOnLoop     = flag.String("onloop", "loop", "what to do when the song jumps back to a row it already played: loop, stop or fade")
+	flagFade       = flag.Duration("fade", 10*time.Second, "how long to fade out for with -onloop fade or -max-duration")
+	flagMaxDur     = flag.Duration("max-duration", 0, "fade out and move on after playing a song for this long, e.g. 3m, 0 to disable")
+	flagSilence    = flag.Duration("silence", 0, "stop after this much silence, 0 to disable")
+	flagCollapse   = flag.Int("collapse", 16, "hide channels that have been silent for this many rows when they don't all fit, 0 to disable")
+	flagTheme      = flag.String("theme", "default", "display colors: "+strings.Join(themeNames(), ", "))
+	flagNoColor    = flag.Bool("nocolor", false, "turn off the display colors, as does setting NO_COLOR")
+	flagDevice     = flag.String("device", "", "play through this audio device, by number or name from -list-devices")
+	flagListDevs   = flag.Bool("list-devices", false, "list the audio output devices and exit")
+	flagRecord     = flag.String("record", "", "also write the audio played to this WAV file, including mutes and effects")
+	flagListen     = flag.String("listen", "", "serve an HTTP API to control playback on this address, e.g. localhost:8080")
+	flagMedia      = flag.Bool("media", true, "show the song in the media controls of the operating system and obey its media keys")
+	flagShuffle    = flag.Bool("shuffle", false, "play the songs in random order")
+	flagRepeat     = flag.String("repeat", "off", "repeat the songs: off, all or one to repeat the current song")
 )
 
 const (
 	escape     = "\x1b["
 	hideCursor = escape + "?25l"
 	showCursor = escape + "?25h"
+	clearToEnd = escape + "J"
+	clearAll   = escape + "H" + escape + "2J" // clear the screen and move the cursor to the top
+
+	maxNoteColumns = 4  // number of channels of note data that fit on an 80 column line
+	vuWidth        = 6  // width of a channel's level meter
+	pageRows       = 16 // rows moved by the up and down keys
+)
+
+// What to do when a song stops playing
+type action int
+
+const (
+	actionEnded action = iota // the song played to the end
+	actionNext
+	actionPrev
+	actionQuit
 )
 
+// What the display shows under the channel headers
+type displayMode int
+
+const (
+	displayNotes    displayMode = iota // note data around the current row
+	displayScope                       // an oscilloscope of each channel
+	displaySpectrum                    // a spectrum analyzer of the output
+	displayPattern                     // note data filling the terminal, without the channel list
+)
+
+// playback holds the settings shared by every song played.
+type playback struct {
+	opts      modplayer.PlayerOptions
+	mixHz     int
+	effects   *modplayer.Chain
+	startTime time.Duration // from -start-time
+
+	// The audio device plays whatever source generates, nil for silence
+	source atomic.Pointer[func(out []int16) int]
+
+	keys     <-chan key   // nil when keys can't be read
+	commands chan command // from the HTTP API and the media controls
+	media    mediaSession
+	out      io.Writer
+	mode     displayMode
+	spectrum *spectrum
+}
+
 func main() {
+	runMain(run)
+}
+
+func run() {
 	log.SetFlags(0)
 	log.SetPrefix("modplay: ")
 	flag.Parse()
 
+	if *flagListDevs {
+		if err := listDevices(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if len(flag.Args()) == 0 {
 		log.Fatal("Missing song filename")
 	}
-
-	songFName := flag.Arg(0)
-	songF, err := os.ReadFile(songFName)
+	tracks, err := expandArgs(flag.Args())
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	var song *modplayer.Song
-	switch strings.ToLower(filepath.Ext(songFName)) {
-	case ".mod":
-		song, err = modplayer.NewMODSongFromBytes(songF)
-	case ".s3m":
-		song, err = modplayer.NewS3MSongFromBytes(songF)
-	default:
-		err = fmt.Errorf("unsupported song %q", songFName)
+	if len(tracks) == 0 {
+		log.Fatal("no songs found")
 	}
+	repeat, err := parseRepeat(*flagRepeat)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	player, err := modplayer.NewPlayer(song, uint(*flagHz))
-	if err != nil {
+	pl := newPlaylist(tracks, *flagShuffle, repeat)
+	if err := setTheme(*flagTheme, *flagNoColor); err != nil {
 		log.Fatal(err)
 	}
-	if err := player.SetVolumeBoost(*flagBoost); err != nil {
-		log.Fatal(err)
+
+	pb := &playback{
+		opts:  playerOptions(),
+		mixHz: *flagHz,
 	}
-	player.Mute = *flagMute
-	if *flagStartOrd > 0 {
-		player.SeekTo(*flagStartOrd, 0)
+	if *flagMixHz > 0 {
+		pb.mixHz = *flagMixHz
+	}
+	if *flagStartTime != "" {
+		if *flagStartOrd > 0 {
+			log.Fatal("-start and -start-time can't be used together")
+		}
+		if pb.startTime, err = cli.ParseTime(*flagStartTime); err != nil {
+			log.Fatal(err)
+		}
 	}
-	player.PlayOrderLimit = *flagLenOrd
+	pb.effects = newEffects(uint(pb.mixHz))
+	pb.spectrum = newSpectrum(*flagHz)
 
-	initErr := portaudio.Initialize()
-	defer func() {
-		if initErr != nil {
-			portaudio.Terminate()
+	// Only the audio of the songs is recorded, not the silence while paused
+	// or between songs
+	var rec *recorder
+	if *flagRecord != "" {
+		if rec, err = newRecorder(*flagRecord, *flagHz); err != nil {
+			log.Fatal(err)
 		}
-	}()
+		defer func() {
+			if err := rec.close(); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	streamCB := func(out []int16) {
+		n := 0
+		if generate := pb.source.Load(); generate != nil {
+			n = (*generate)(out)
+		}
+		if rec != nil && n > 0 {
+			rec.write(out[:n*2])
+		}
+		clear(out[n*2:])
+		pb.spectrum.write(out)
+	}
+
+	pb.commands = make(chan command)
+	if *flagListen != "" {
+		ln, err := net.Listen("tcp", *flagListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := http.Serve(ln, newRemoteHandler(pb.commands)); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
 
-	rvb, err := config.ReverbFromFlag(*flagReverb, *flagHz)
+	// Playing on without the media controls is better than not playing
+	pb.media = noMediaSession{}
+	if *flagMedia {
+		if media, err := startMediaSession(pb.commands); err != nil {
+			log.Printf("no media controls: %v", err)
+		} else {
+			pb.media = media
+		}
+	}
+	defer pb.media.close()
+
+	stopAudio, err := startAudio(*flagHz, *flagDevice, streamCB)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer stopAudio()
 
-	scratch := make([]int16, 10*1024)
-	streamCB := func(out []int16) {
-		sc := scratch[:len(out)]
-		player.GenerateAudio(sc)
-		rvb.InputSamples(sc)
-		n := rvb.GetAudio(out)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if n == 0 {
-			player.Stop()
+	// Read keys as they are pressed. The terminal no longer starts a new line
+	// for a newline, so output goes through crlfWriter.
+	t := openTerminal()
+	defer t.restore()
+	pb.keys = t.keys
+	pb.out = crlfWriter{os.Stdout}
+	log.SetOutput(crlfWriter{os.Stderr})
+
+	// Hide the cursor
+	fmt.Fprint(pb.out, hideCursor)
+	defer fmt.Fprint(pb.out, clearToEnd+showCursor)
+
+	// Play the songs, skipping over any that can't be played
+	dir, failed := 1, 0
+	for {
+		act, err := pb.play(ctx, pl)
+		if err != nil {
+			log.Print(err)
+			if failed++; failed == len(tracks) {
+				return
+			}
+			if !pl.step(dir) {
+				// Skip forwards from a bad first song
+				if dir = 1; !pl.step(dir) {
+					return
+				}
+			}
+			continue
+		}
+		failed = 0
+
+		switch act {
+		case actionEnded:
+			if pl.repeat == repeatOne {
+				continue
+			}
+			fallthrough
+		case actionNext:
+			dir = 1
+			if !pl.step(dir) {
+				return
+			}
+		case actionPrev:
+			dir = -1
+			pl.step(dir) // the first song plays again
+		case actionQuit:
+			return
 		}
 	}
+}
 
-	stream, err := portaudio.OpenDefaultStream(0, 2, float64(*flagHz), int(portaudio.FramesPerBufferUnspecified), streamCB)
+// Returns the player options set by the flags.
+func playerOptions() modplayer.PlayerOptions {
+	opts := modplayer.DefaultPlayerOptions()
+	opts.VolumeBoost = *flagBoost
+	opts.MasterGain = *flagGain
+	if *flagSoftClip {
+		opts.Clipping = modplayer.ClipSoft
+	}
+	opts.Mute = *flagMute
+	opts.StereoSeparation = *flagSeparation
+	opts.PlayOrderLimit = *flagLenOrd
+	opts.LoopCount = *flagLoops
+	opts.SilenceStop = *flagSilence
+	var err error
+	if opts.LoopPolicy, err = cli.ParseLoopPolicy(*flagOnLoop); err != nil {
+		log.Fatal(err)
+	}
+	if opts.LoopPolicy == modplayer.LoopPolicyFade {
+		opts.LoopFade = *flagFade
+	}
+	if opts.Clock, err = cli.ParseClock(*flagClock); err != nil {
+		log.Fatal(err)
+	}
+	if *flagAmiga {
+		opts.PeriodMode = modplayer.PeriodAmiga
+	}
+	if *flagBinaural {
+		opts.Panning = modplayer.PanningBinaural
+	}
+	if opts.Interpolation, err = cli.ParseInterpolation(*flagInterp); err != nil {
+		log.Fatal(err)
+	}
+	return opts
+}
+
+// Returns the chain of effects set by the flags for audio at hz. The effects
+// carry on from one song into the next, so the reverb of a song's last notes
+// isn't cut off.
+func newEffects(hz uint) *modplayer.Chain {
+	rvb, err := comb.New(*flagReverb, int(hz))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer stream.Close()
+	effects := modplayer.NewChain()
+	addFilter(effects, hz, modplayer.HighPass, *flagHighPass)
+	addFilter(effects, hz, modplayer.LowPass, *flagLowPass)
+	if *flagBass > 0 {
+		bass, err := modplayer.NewBassBoost(hz, 150, *flagBass)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := bass.SetExciter(*flagExciter); err != nil {
+			log.Fatal(err)
+		}
+		effects.Add(bass)
+	}
+	effects.Add(comb.NewEffect(rvb))
+	if *flagCrossfeed {
+		cf, err := modplayer.NewCrossfeed(hz, modplayer.DefaultCrossfeedCutoff, modplayer.DefaultCrossfeedLevel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effects.Add(cf)
+	}
+	return effects
+}
+
+// Reads and parses the song in filename.
+func loadSong(filename string) (*modplayer.Song, error) {
+	songF, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
 
-	stream.Start()
-	defer stream.Stop()
+	var song *modplayer.Song
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mod":
+		song, err = modplayer.NewMODSongFromBytes(songF)
+	case ".s3m":
+		song, err = modplayer.NewS3MSongFromBytes(songF)
+	default:
+		return nil, fmt.Errorf("unsupported song %q", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return song, nil
+}
 
-	sigch := make(chan os.Signal, 1)
-	signal.Notify(sigch, syscall.SIGINT)
-	go func() {
-		<-sigch
-		player.Stop()
-		stream.Stop()
-		portaudio.Terminate()
+// Plays the current song of pl until it ends or a key is pressed to leave it,
+// showing its progress.
+func (pb *playback) play(ctx context.Context, pl *playlist) (action, error) {
+	song, err := loadSong(pl.current())
+	if err != nil {
+		return 0, err
+	}
 
-		fmt.Print(showCursor)
-		os.Exit(0)
-	}()
+	player, err := modplayer.NewPlayerWithOptions(song, uint(pb.mixHz), pb.opts)
+	if err != nil {
+		return 0, err
+	}
+	if err := player.SetTranspose(*flagTranspose); err != nil {
+		return 0, err
+	}
+	if err := player.SetTempoScale(*flagTempo); err != nil {
+		return 0, err
+	}
+	if *flagStartOrd > 0 {
+		// Orders that can't be reached by playing the song are jumped to
+		// directly
+		if err := player.FastForwardTo(*flagStartOrd, 0); err != nil {
+			player.SeekTo(*flagStartOrd, 0)
+		}
+	}
+	if pb.startTime > 0 {
+		if err := player.SeekToTime(pb.startTime); err != nil {
+			return 0, err
+		}
+	}
+	player.SetEffect(pb.effects)
+	if err := player.SetScopeLength(scopeLength); err != nil {
+		return 0, err
+	}
 
-	// Hide the cursor
-	fmt.Print(hideCursor)
+	var songEnded atomic.Bool
+	player.OnSongEnd = func() { songEnded.Store(true) }
 
-	white := color.New(color.FgWhite).SprintFunc()
-	cyan := color.New(color.FgCyan).SprintfFunc()
-	magenta := color.New(color.FgMagenta).SprintfFunc()
-	yellow := color.New(color.FgYellow).SprintfFunc()
-	blue := color.New(color.FgHiBlue).SprintFunc()
-	green := color.New(color.FgGreen).SprintfFunc()
+	// The audio device runs at -hz, the player's audio is resampled to it if
+	// the song is mixed at another rate
+	generate := player.GenerateAudio
+	if pb.mixHz != *flagHz {
+		rs, err := modplayer.NewResampler(player, uint(*flagHz))
+		if err != nil {
+			return 0, err
+		}
+		generate = rs.GenerateAudio
+	}
+	// -max-duration fades the song out so that it ends on time. The fade is
+	// started from the audio callback, which owns the player.
+	fadeAt, fade := int64(-1), min(*flagFade, *flagMaxDur)
+	if *flagMaxDur > 0 {
+		fadeAt = player.SamplesPlayed() + int64((*flagMaxDur-fade).Seconds()*float64(pb.mixHz))
+	}
+	source := func(out []int16) int {
+		n := generate(out)
+		if n == 0 {
+			player.Stop()
+		}
+		if fadeAt >= 0 && player.SamplesPlayed() >= fadeAt {
+			player.FadeOut(fade)
+			fadeAt = -1
+		}
+		return n
+	}
+	pb.source.Store(&source)
+	defer pb.source.Store(nil)
+	defer player.Stop()
+	pb.media.setSong(song.Title, pl.current())
+	defer pb.media.setPlaying(false)
 
 	// Print out some player preceeding 4 rows, current row and upcoming 4 rows
-	// <title> row 1A/3F pat 0A/73 speed 6 bpm 125
+	// track 2/9 shuffle <title> row 1A/3F pat 0A/73 speed 6 bpm 125
 	//
+	//     01             02             03             04
+	//     ████████   |   ███            ██████████     |
 	//          0 0000|     0 0C00|^^.  0 0000|     0 0000
 	//          0 0000|     0 0000|     0 0000|     0 0000
 	//     C#5  F 0000|G-5 14 0000|     0 0000|     0 0000
@@ -140,79 +449,266 @@ func main() {
 	//          0 0000|     0 0000|     0 0000|     0 0000
 	//     C#5  F 0000|     0 0000|     0 0000|     0 0000
 
+	// Poll for ticks often enough to catch every row of a fast song. The
+	// display is redrawn every tick so the VU meters move smoothly.
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
 	var lastState modplayer.PlayerState
-	for player.IsPlaying() {
+	var prompt orderPrompt
+	lastWidth, lastHeight := terminalSize()
+	var meters vuMeters
+	scopeBuf := make([]int16, scopeLength)
+	playing := false
+	for !songEnded.Load() {
+		select {
+		case <-ctx.Done():
+			return actionQuit, nil
+		case k := <-pb.keys:
+			if k == keyCtrlC {
+				return actionQuit, nil
+			}
+			if prompt.active {
+				if prompt.key(k) {
+					order, err := parseOrder(prompt.input)
+					if err == nil {
+						err = player.JumpToOrder(order)
+					}
+					prompt.err = err
+				}
+				lastState.Notes = nil
+				continue
+			}
+			if prompt.err != nil {
+				prompt.err = nil
+				lastState.Notes = nil
+			}
+
+			switch k {
+			case 'n':
+				return actionNext, nil
+			case 'p':
+				return actionPrev, nil
+			case 'q':
+				return actionQuit, nil
+			case ' ':
+				if player.IsPlaying() {
+					player.Stop()
+				} else {
+					player.Start()
+				}
+				lastState.Notes = nil
+			case 's':
+				pl.setShuffle(!pl.shuffle)
+				lastState.Notes = nil // redraw
+			case 'r':
+				pl.cycleRepeat()
+				lastState.Notes = nil
+			case keyLeft:
+				player.PrevOrder()
+			case keyRight:
+				player.NextOrder()
+			case keyUp, keyPageUp:
+				player.JumpRows(-pageRows)
+			case keyDown, keyPageDown:
+				player.JumpRows(pageRows)
+			case 'g':
+				prompt.open()
+				lastState.Notes = nil
+			case 'o':
+				pb.toggleMode(displayScope)
+				lastState.Notes = nil
+			case 'f':
+				pb.toggleMode(displaySpectrum)
+				lastState.Notes = nil
+			case 'v':
+				pb.toggleMode(displayPattern)
+				lastState.Notes = nil
+			}
+			continue
+		case c := <-pb.commands:
+			lastState.Notes = nil
+			if act, leave := pb.runCommand(c, player, song, pl); leave {
+				return act, nil
+			}
+			continue
+		case <-ticker.C:
+		}
+
 		state := player.State()
+		if player.IsPlaying() != playing {
+			playing = !playing
+			pb.media.setPlaying(playing)
+		}
 
-		if lastState.Notes != nil && lastState.Order == state.Order && lastState.Row == state.Row {
+		// A resized terminal rewraps the old display, so it is cleared and
+		// the display is laid out again to fit
+		width, height := terminalSize()
+		resized := width != lastWidth || height != lastHeight
+		lastWidth, lastHeight = width, height
+
+		if !resized && lastState.Notes != nil && lastState.Order == state.Order && lastState.Row == state.Row && lastState.Tick == state.Tick {
 			continue
 		}
+		meters.update(state, time.Now())
+
+		// The display is built up and written in one go, over the last one
+		var b strings.Builder
 
+		if len(pl.songs) > 1 {
+			fmt.Fprintf(&b, "%s %d/%d ", colors.label.Sprint("track"), pl.pos+1, len(pl.order))
+		}
+		if modes := pl.modes(); modes != "" {
+			b.WriteString(modes + " ")
+		}
+		if !player.IsPlaying() {
+			b.WriteString("paused ")
+		}
 		if len(song.Title) > 0 {
-			fmt.Print(song.Title + " ")
+			b.WriteString(song.Title + " ")
 		}
-		fmt.Printf("%s %02X/3F %s %02X/%02X %s %02d %s %3d\n", blue("row"), state.Row, blue("pat"), state.Order, len(song.Orders), blue("speed"), player.Speed, blue("bpm"), player.Tempo)
+		label := colors.label.Sprint
+		fmt.Fprintf(&b, "%s %02X/3F %s %02X/%02X %s %02d %s %3d\n", label("row"), state.Row, label("pat"), state.Order, len(song.Orders), label("speed"), state.Speed, label("bpm"), state.Tempo)
+
+		lay := newLayout(len(state.Channels), width)
+		if pb.mode == displayScope {
+			lay = layout{cell: noteCellWidth, columns: maxNoteColumns}
+		}
+		visible, hidden := visibleChannels(state, *flagCollapse, lay.columns)
 
 		// Print out some channel info
-		ncl := len(state.Channels) / 2
-		for i, ch := range state.Channels {
-			outs := fmt.Sprintf("%2d: ", i+1)
+		for i, ci := range visible {
+			if pb.mode == displayPattern {
+				break
+			}
+			outs := fmt.Sprintf("%2d: %-*s ", ci+1, vuWidth, vuBar(state.Channels[ci].Peak))
 
-			si := ch.Instrument
+			si := state.Channels[ci].Instrument
 			if si != -1 {
 				outs += song.Samples[si].Name
 			}
-			if len(outs) < 32 {
-				outs = fmt.Sprintf("%-32s", outs)
+			if len(outs) < 40 {
+				outs = fmt.Sprintf("%-40s", outs)
 			}
-			fmt.Print(outs)
+			b.WriteString(outs)
 			if i&1 == 1 {
-				fmt.Println()
+				b.WriteString("\n")
 			}
 		}
-		fmt.Println()
-
-		for i := -4; i <= 4; i++ {
-			nd := player.NoteDataFor(state.Order, state.Row+i)
-			if nd == nil {
-				fmt.Println()
-				continue
+		if pb.mode != displayPattern {
+			if len(visible)&1 == 1 {
+				b.WriteString("\n")
 			}
-
-			// If this is the currently playing row then highlight it
-			if i == 0 {
-				fmt.Print(">>> ")
-			} else {
-				fmt.Print("    ")
+			if hidden > 0 {
+				fmt.Fprintf(&b, "(%d silent channels hidden)\n", hidden)
 			}
+			b.WriteString("\n")
+		}
 
-			// Print out the first 4 channels of note data
-			for ni, n := range nd {
-				if ni < 4 {
-					fmt.Print(white(n.Note), " ", cyan("%2X", n.Instrument), " ")
-					if n.Volume != 0xFF {
-						fmt.Print(green("%02X", n.Volume))
-					} else {
-						fmt.Print(green(".."))
-					}
-					fmt.Print(" ", magenta("%02X", n.Effect), yellow("%02X", n.Param))
+		// Header with the channel number of each column of note data
+		b.WriteString("    ")
+		for ni, ci := range visible {
+			if ni == lay.columns {
+				break
+			}
+			b.WriteString(colors.label.Sprintf("%-*s", lay.cell, fmt.Sprintf("%02d", ci+1)))
+			if ni < min(len(visible), lay.columns)-1 {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
 
-					if ni < 3 {
-						fmt.Print("|")
-					}
-				} else if ni == 4 {
-					fmt.Print(" ...")
-					break
-				}
+		// VU meter of each column
+		b.WriteString("    ")
+		for ni, ci := range visible {
+			if ni == lay.columns {
+				break
 			}
-			if i == 0 {
-				fmt.Print(" <<<")
+			b.WriteString(colors.meter.Sprint(meters.bar(ci, state.Channels[ci].Peak, lay.cell)))
+			if ni < min(len(visible), lay.columns)-1 {
+				b.WriteString(" ")
 			}
-			fmt.Println()
 		}
-		fmt.Printf(escape+"%dF", 11+ncl) // move cursor to beginning of line 9 above
+		b.WriteString("\n")
+
+		switch pb.mode {
+		case displayScope:
+			drawScopes(&b, player, visible, scopeBuf)
+		case displaySpectrum:
+			pb.spectrum.update(time.Now())
+			pb.spectrum.draw(&b)
+		default:
+			// Fill the rest of the terminal in the pattern view, leaving
+			// room for the prompt
+			around := (height - strings.Count(b.String(), "\n") - 3) / 2
+			if pb.mode != displayPattern {
+				around = min(around, 4)
+			}
+			drawNoteRows(&b, player, state, visible, max(around, 0), lay)
+		}
+		if line := prompt.String(); line != "" {
+			b.WriteString(line + "\n")
+		}
+
+		frame := clipFrame(b.String(), width, height-1)
+		if resized {
+			fmt.Fprint(pb.out, clearAll)
+		} else {
+			fmt.Fprint(pb.out, clearToEnd)
+		}
+		fmt.Fprint(pb.out, frame)
+		if n := strings.Count(frame, "\n"); n > 0 {
+			fmt.Fprintf(pb.out, escape+"%dF", n) // move cursor back to the top of the display
+		}
+
+		lastState = state
 	}
 
-	// Show the cursor
-	fmt.Print(showCursor)
+	return actionEnded, nil
+}
+
+// Switches the display to mode, or back to the note data if it is showing
+// mode.
+func (pb *playback) toggleMode(mode displayMode) {
+	if pb.mode == mode {
+		pb.mode = displayNotes
+	} else {
+		pb.mode = mode
+	}
+}
+
+// visibleChannels returns the indices of the channels to display and the
+// number of channels that were hidden. When the song has more channels than
+// the columns that fit across the display, channels that have been silent for
+// at least collapseRows rows are hidden. A collapseRows of 0 disables hiding.
+func visibleChannels(state modplayer.PlayerState, collapseRows, columns int) ([]int, int) {
+	visible := make([]int, 0, len(state.Channels))
+	for i, ch := range state.Channels {
+		if collapseRows > 0 && len(state.Channels) > columns && ch.SilentRows >= collapseRows {
+			continue
+		}
+		visible = append(visible, i)
+	}
+
+	return visible, len(state.Channels) - len(visible)
+}
+
+// vuBar returns a level meter for a channel peak level, where 1 is a full
+// scale sample at full volume.
+func vuBar(peak float64) string {
+	n := int(math.Round(min(peak, 1) * vuWidth))
+	return strings.Repeat("=", n)
+}
+
+// addFilter adds a filter at cutoff Hz for audio at hz to effects, unless
+// cutoff is 0.
+func addFilter(effects *modplayer.Chain, hz uint, kind modplayer.FilterKind, cutoff float64) {
+	if cutoff == 0 {
+		return
+	}
+	f, err := modplayer.NewResonantFilter(kind, hz, cutoff, math.Sqrt2/2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	effects.Add(f)
 }
@@ -0,0 +1,9 @@
+//go:build !darwin || !cgo
+
+package main
+
+// Runs main. Only the macOS media controls need the main thread for
+// themselves.
+func runMain(main func()) {
+	main()
+}
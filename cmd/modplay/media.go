@@ -0,0 +1,18 @@
+package main
+
+// A mediaSession shows the song playing in the media controls of the
+// operating system. Their play, pause, next and previous buttons, and the
+// media keys of the keyboard, send commands to the song playing.
+type mediaSession interface {
+	setSong(title, file string)
+	setPlaying(playing bool)
+	close()
+}
+
+// noMediaSession is used where modplay can't join the media controls of the
+// operating system.
+type noMediaSession struct{}
+
+func (noMediaSession) setSong(title, file string) {}
+func (noMediaSession) setPlaying(playing bool)    {}
+func (noMediaSession) close()                     {}
@@ -0,0 +1,164 @@
+//go:build cgo
+
+package main
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework MediaPlayer
+
+#import <Foundation/Foundation.h>
+#import <MediaPlayer/MediaPlayer.h>
+#include <dispatch/dispatch.h>
+#include <stdlib.h>
+#include <unistd.h>
+
+// The commands handled, written as their index in nowPlayingCommands to the
+// pipe given to startNowPlaying.
+static void addCommand(MPRemoteCommand *command, int fd, char index) {
+	command.enabled = YES;
+	[command addTargetWithHandler:^MPRemoteCommandHandlerStatus(MPRemoteCommandEvent *event) {
+		write(fd, &index, 1);
+		return MPRemoteCommandHandlerStatusSuccess;
+	}];
+}
+
+static void startNowPlaying(int fd) {
+	dispatch_sync(dispatch_get_main_queue(), ^{
+		MPRemoteCommandCenter *center = [MPRemoteCommandCenter sharedCommandCenter];
+		addCommand(center.playCommand, fd, 0);
+		addCommand(center.pauseCommand, fd, 1);
+		addCommand(center.stopCommand, fd, 1);
+		addCommand(center.togglePlayPauseCommand, fd, 2);
+		addCommand(center.nextTrackCommand, fd, 3);
+		addCommand(center.previousTrackCommand, fd, 4);
+		[MPNowPlayingInfoCenter defaultCenter].playbackState = MPNowPlayingPlaybackStateStopped;
+	});
+}
+
+static void stopNowPlaying(void) {
+	dispatch_sync(dispatch_get_main_queue(), ^{
+		MPRemoteCommandCenter *center = [MPRemoteCommandCenter sharedCommandCenter];
+		for (MPRemoteCommand *command in @[center.playCommand, center.pauseCommand, center.stopCommand,
+		                                    center.togglePlayPauseCommand, center.nextTrackCommand,
+		                                    center.previousTrackCommand]) {
+			[command removeTarget:nil];
+			command.enabled = NO;
+		}
+		[MPNowPlayingInfoCenter defaultCenter].nowPlayingInfo = nil;
+		[MPNowPlayingInfoCenter defaultCenter].playbackState = MPNowPlayingPlaybackStateStopped;
+	});
+}
+
+static void setNowPlayingTitle(const char *title) {
+	@autoreleasepool {
+		NSString *t = [NSString stringWithUTF8String:title];
+		dispatch_async(dispatch_get_main_queue(), ^{
+			[MPNowPlayingInfoCenter defaultCenter].nowPlayingInfo = @{
+				MPMediaItemPropertyTitle: t,
+				MPNowPlayingInfoPropertyMediaType: @(MPNowPlayingInfoMediaTypeAudio),
+			};
+		});
+	}
+}
+
+static void setNowPlayingState(int playing) {
+	dispatch_async(dispatch_get_main_queue(), ^{
+		[MPNowPlayingInfoCenter defaultCenter].playbackState =
+			playing ? MPNowPlayingPlaybackStatePlaying : MPNowPlayingPlaybackStatePaused;
+	});
+}
+*/
+import "C"
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+func init() {
+	// The media controls run their handlers on the main thread, which
+	// runMain leaves to the main dispatch queue
+	runtime.LockOSThread()
+}
+
+// Runs main on another goroutine and serves the main dispatch queue on the
+// main thread, which never returns. modplay exits when main returns.
+func runMain(main func()) {
+	go func() {
+		main()
+		os.Exit(0)
+	}()
+	C.dispatch_main()
+}
+
+// The commands sent for the media controls, in the order of the indexes the
+// handlers of startNowPlaying write.
+var nowPlayingCommands = []string{"play", "pause", "toggle", "next", "prev"}
+
+// A nowPlayingSession joins the media controls of macOS through
+// MPRemoteCommandCenter and MPNowPlayingInfoCenter. The handlers of the
+// commands run on the main thread and pass them to modplay through a pipe.
+type nowPlayingSession struct {
+	commands chan<- command
+	done     chan struct{} // closed when the session is closed
+	r, w     *os.File      // the pipe the commands are written to
+}
+
+// Starts a media session, which sends the commands from the media controls to
+// commands.
+func startMediaSession(commands chan<- command) (mediaSession, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	s := &nowPlayingSession{commands: commands, done: make(chan struct{}), r: r, w: w}
+	C.startNowPlaying(C.int(w.Fd()))
+	go s.read()
+
+	return s, nil
+}
+
+// Sends the commands written to the pipe to the song playing, until the
+// session is closed.
+func (s *nowPlayingSession) read() {
+	buf := make([]byte, 1)
+	for {
+		if _, err := s.r.Read(buf); err != nil {
+			return
+		}
+		select {
+		case s.commands <- command{name: nowPlayingCommands[buf[0]], reply: make(chan commandReply, 1)}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Shows the song in the media controls.
+func (s *nowPlayingSession) setSong(title, file string) {
+	if title == "" {
+		title = filepath.Base(file)
+	}
+	t := C.CString(strings.ToValidUTF8(title, "\uFFFD"))
+	defer C.free(unsafe.Pointer(t))
+	C.setNowPlayingTitle(t)
+}
+
+func (s *nowPlayingSession) setPlaying(playing bool) {
+	state := C.int(0)
+	if playing {
+		state = 1
+	}
+	C.setNowPlayingState(state)
+}
+
+func (s *nowPlayingSession) close() {
+	close(s.done)
+	// No handler writes to the pipe once they are removed
+	C.stopNowPlaying()
+	s.w.Close()
+	s.r.Close()
+}
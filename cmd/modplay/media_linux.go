@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// The names of the MPRIS D-Bus interfaces, see
+// https://specifications.freedesktop.org/mpris-spec/latest/
+const (
+	mprisName   = "org.mpris.MediaPlayer2.modplay"
+	mprisPath   = "/org/mpris/MediaPlayer2"
+	mprisRoot   = "org.mpris.MediaPlayer2"
+	mprisPlayer = "org.mpris.MediaPlayer2.Player"
+)
+
+// An mprisSession joins the media controls of the Linux desktop through MPRIS
+// on the D-Bus session bus.
+type mprisSession struct {
+	conn     *dbus.Conn
+	props    *prop.Properties
+	commands chan<- command
+	done     chan struct{} // closed when the session is closed
+	track    int           // number of songs played, for the track IDs
+}
+
+// Starts a media session, which sends the commands from the media controls to
+// commands.
+func startMediaSession(commands chan<- command) (mediaSession, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	m := &mprisSession{conn: conn, commands: commands, done: make(chan struct{})}
+
+	// Each copy of modplay running needs a name of its own
+	name := mprisName
+	reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if err == nil && reply != dbus.RequestNameReplyPrimaryOwner {
+		name = fmt.Sprintf("%s.instance%d", mprisName, os.Getpid())
+		reply, err = conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	}
+	if err == nil && reply != dbus.RequestNameReplyPrimaryOwner {
+		err = fmt.Errorf("D-Bus name %s is taken", name)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	readOnly := func(v any) *prop.Prop {
+		return &prop.Prop{Value: v, Emit: prop.EmitTrue}
+	}
+	m.props, err = prop.Export(conn, mprisPath, prop.Map{
+		mprisRoot: {
+			"Identity":            readOnly("modplay"),
+			"CanQuit":             readOnly(true),
+			"CanRaise":            readOnly(false),
+			"HasTrackList":        readOnly(false),
+			"SupportedUriSchemes": readOnly([]string{}),
+			"SupportedMimeTypes":  readOnly([]string{}),
+		},
+		mprisPlayer: {
+			"PlaybackStatus": readOnly("Stopped"),
+			"Metadata":       readOnly(map[string]dbus.Variant{}),
+			"Rate":           readOnly(1.0),
+			"MinimumRate":    readOnly(1.0),
+			"MaximumRate":    readOnly(1.0),
+			"Volume":         readOnly(1.0),
+			"Position":       &prop.Prop{Value: int64(0), Emit: prop.EmitFalse},
+			"CanGoNext":      readOnly(true),
+			"CanGoPrevious":  readOnly(true),
+			"CanPlay":        readOnly(true),
+			"CanPause":       readOnly(true),
+			"CanSeek":        readOnly(false),
+			"CanControl":     readOnly(true),
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	root, player := mprisRootMethods{m}, mprisPlayerMethods{m}
+	if err := conn.Export(root, mprisPath, mprisRoot); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.ExportWithMap(player, mprisPlayerNames, mprisPath, mprisPlayer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	playerMethods := introspect.Methods(player)
+	for i, method := range playerMethods {
+		if name, ok := mprisPlayerNames[method.Name]; ok {
+			playerMethods[i].Name = name
+		}
+	}
+	node := &introspect.Node{
+		Name: mprisPath,
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{Name: mprisRoot, Methods: introspect.Methods(root), Properties: m.props.Introspection(mprisRoot)},
+			{Name: mprisPlayer, Methods: playerMethods, Properties: m.props.Introspection(mprisPlayer)},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(node), mprisPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Shows the song in the media controls.
+func (m *mprisSession) setSong(title, file string) {
+	m.track++
+	if title == "" {
+		title = filepath.Base(file)
+	}
+	metadata := map[string]dbus.Variant{
+		"mpris:trackid": dbus.MakeVariant(dbus.ObjectPath(fmt.Sprintf("/org/modplay/track/%d", m.track))),
+		"xesam:title":   dbus.MakeVariant(title),
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		u := url.URL{Scheme: "file", Path: abs}
+		metadata["xesam:url"] = dbus.MakeVariant(u.String())
+	}
+	m.props.SetMust(mprisPlayer, "Metadata", metadata)
+}
+
+func (m *mprisSession) setPlaying(playing bool) {
+	status := "Paused"
+	if playing {
+		status = "Playing"
+	}
+	m.props.SetMust(mprisPlayer, "PlaybackStatus", status)
+}
+
+func (m *mprisSession) close() {
+	close(m.done)
+	m.conn.Close()
+}
+
+// Sends the command name to the song playing, unless modplay is quitting. The
+// reply isn't waited for.
+func (m *mprisSession) send(name string) *dbus.Error {
+	select {
+	case m.commands <- command{name: name, reply: make(chan commandReply, 1)}:
+	case <-m.done:
+	}
+	return nil
+}
+
+// mprisRootMethods are the methods of the org.mpris.MediaPlayer2 interface.
+type mprisRootMethods struct{ m *mprisSession }
+
+func (r mprisRootMethods) Raise() *dbus.Error { return nil }
+func (r mprisRootMethods) Quit() *dbus.Error  { return r.m.send("quit") }
+
+// mprisPlayerMethods are the methods of the org.mpris.MediaPlayer2.Player
+// interface. Seeking isn't supported, CanSeek is false.
+type mprisPlayerMethods struct{ m *mprisSession }
+
+// The D-Bus names of the methods of mprisPlayerMethods whose Go names differ,
+// as a method named Seek has to look like io.Seeker's
+var mprisPlayerNames = map[string]string{"SeekBy": "Seek"}
+
+func (p mprisPlayerMethods) Next() *dbus.Error      { return p.m.send("next") }
+func (p mprisPlayerMethods) Previous() *dbus.Error  { return p.m.send("prev") }
+func (p mprisPlayerMethods) Pause() *dbus.Error     { return p.m.send("pause") }
+func (p mprisPlayerMethods) PlayPause() *dbus.Error { return p.m.send("toggle") }
+func (p mprisPlayerMethods) Stop() *dbus.Error      { return p.m.send("pause") }
+func (p mprisPlayerMethods) Play() *dbus.Error      { return p.m.send("play") }
+
+func (p mprisPlayerMethods) SeekBy(offset int64) *dbus.Error { return nil }
+
+func (p mprisPlayerMethods) SetPosition(track dbus.ObjectPath, position int64) *dbus.Error {
+	return nil
+}
+
+func (p mprisPlayerMethods) OpenUri(uri string) *dbus.Error { return nil }
@@ -0,0 +1,9 @@
+//go:build !linux && !windows && !(darwin && cgo)
+
+package main
+
+// Starts a media session. The media controls are supported on Linux, Windows
+// and macOS, and on macOS only with cgo, so elsewhere they are left alone.
+func startMediaSession(commands chan<- command) (mediaSession, error) {
+	return noMediaSession{}, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	combase                    = windows.NewLazySystemDLL("combase.dll")
+	procRoInitialize           = combase.NewProc("RoInitialize")
+	procRoGetActivationFactory = combase.NewProc("RoGetActivationFactory")
+	procWindowsCreateString    = combase.NewProc("WindowsCreateString")
+	procWindowsDeleteString    = combase.NewProc("WindowsDeleteString")
+
+	user32                 = windows.NewLazySystemDLL("user32.dll")
+	procCreateWindowExW    = user32.NewProc("CreateWindowExW")
+	procDestroyWindow      = user32.NewProc("DestroyWindow")
+	procGetMessageW        = user32.NewProc("GetMessageW")
+	procDispatchMessageW   = user32.NewProc("DispatchMessageW")
+	procPostThreadMessageW = user32.NewProc("PostThreadMessageW")
+)
+
+// The interfaces used, see the Windows.Media namespace of the Windows SDK
+var (
+	iidUnknown     = windows.GUID{Data1: 0x00000000, Data2: 0x0000, Data3: 0x0000, Data4: [8]byte{0xc0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x46}}
+	iidAgileObject = windows.GUID{Data1: 0x94ea2b94, Data2: 0xe9cc, Data3: 0x49e0, Data4: [8]byte{0xc0, 0xff, 0xee, 0x64, 0xca, 0x8f, 0x5b, 0x90}}
+	iidInterop     = windows.GUID{Data1: 0xddb0472d, Data2: 0xc911, Data3: 0x4a1f, Data4: [8]byte{0x86, 0xd9, 0xdc, 0x3d, 0x71, 0xa9, 0x5f, 0x5a}}
+	iidControls    = windows.GUID{Data1: 0x99fa3ff4, Data2: 0x1742, Data3: 0x42a6, Data4: [8]byte{0x90, 0x2e, 0x08, 0x7d, 0x41, 0xf9, 0x65, 0xec}}
+
+	// TypedEventHandler<SystemMediaTransportControls,
+	// SystemMediaTransportControlsButtonPressedEventArgs>
+	iidButtonHandler = windows.GUID{Data1: 0x0557e996, Data2: 0x7b23, Data3: 0x5bae, Data4: [8]byte{0xaa, 0x81, 0xea, 0x0d, 0x67, 0x11, 0x43, 0xa4}}
+)
+
+// The methods called, by their index in the interface's vtable. Every WinRT
+// interface starts with the 3 methods of IUnknown and 3 of IInspectable.
+const (
+	methodRelease = 2
+
+	// ISystemMediaTransportControlsInterop
+	interopGetForWindow = 6
+
+	// ISystemMediaTransportControls
+	controlsPutPlaybackStatus    = 7
+	controlsGetDisplayUpdater    = 8
+	controlsPutIsEnabled         = 11
+	controlsPutIsPlayEnabled     = 13
+	controlsPutIsStopEnabled     = 15
+	controlsPutIsPauseEnabled    = 17
+	controlsPutIsPreviousEnabled = 25
+	controlsPutIsNextEnabled     = 27
+	controlsAddButtonPressed     = 32
+	controlsRemoveButtonPressed  = 33
+
+	// ISystemMediaTransportControlsDisplayUpdater
+	updaterPutType             = 7
+	updaterGetMusicProperties  = 12
+	updaterUpdate              = 17
+	musicPutTitle              = 7 // IMusicDisplayProperties
+	buttonPressedArgsGetButton = 6 // ISystemMediaTransportControlsButtonPressedEventArgs
+)
+
+// Values of the MediaPlaybackStatus, MediaPlaybackType and
+// SystemMediaTransportControlsButton enums
+const (
+	statusStopped = 2
+	statusPlaying = 3
+	statusPaused  = 4
+
+	typeMusic = 1
+
+	buttonPlay     = 0
+	buttonPause    = 1
+	buttonStop     = 2
+	buttonNext     = 6
+	buttonPrevious = 7
+)
+
+const (
+	roInitMultithreaded = 1
+	wmQuit              = 0x0012
+	eNoInterface        = 0x80004002
+)
+
+// A comObject is a COM interface pointer, whose first word points to the
+// interface's vtable.
+type comObject struct {
+	vtbl *[64]uintptr
+}
+
+// Calls method of the interface with args, returning the failed HRESULT as an
+// error.
+//
+//go:uintptrescapes
+func (o *comObject) call(method int, args ...uintptr) error {
+	hr, _, _ := syscall.SyscallN(o.vtbl[method], append([]uintptr{uintptr(unsafe.Pointer(o))}, args...)...)
+	if int32(hr) < 0 {
+		return syscall.Errno(hr)
+	}
+	return nil
+}
+
+func (o *comObject) release() {
+	syscall.SyscallN(o.vtbl[methodRelease], uintptr(unsafe.Pointer(o)))
+}
+
+// Returns a WinRT string of s, which must be deleted with deleteHString.
+func newHString(s string) (uintptr, error) {
+	u := utf16.Encode([]rune(s))
+	var p *uint16
+	if len(u) > 0 {
+		p = &u[0]
+	}
+	var h uintptr
+	hr, _, _ := procWindowsCreateString.Call(uintptr(unsafe.Pointer(p)), uintptr(len(u)), uintptr(unsafe.Pointer(&h)))
+	if int32(hr) < 0 {
+		return 0, syscall.Errno(hr)
+	}
+	return h, nil
+}
+
+func deleteHString(h uintptr) {
+	procWindowsDeleteString.Call(h)
+}
+
+// A buttonHandler is the COM object that SMTC calls when a button is pressed.
+// It lives as long as its session, so it doesn't count references.
+type buttonHandler struct {
+	vtbl *[4]uintptr
+	s    *smtcSession
+}
+
+var buttonHandlerVtbl = [4]uintptr{
+	windows.NewCallback(func(h *buttonHandler, iid *windows.GUID, out **buttonHandler) uintptr {
+		if *iid != iidUnknown && *iid != iidAgileObject && *iid != iidButtonHandler {
+			*out = nil
+			return eNoInterface
+		}
+		*out = h
+		return 0
+	}),
+	windows.NewCallback(func(h *buttonHandler) uintptr { return 1 }), // AddRef
+	windows.NewCallback(func(h *buttonHandler) uintptr { return 1 }), // Release
+	windows.NewCallback(func(h *buttonHandler, sender, args *comObject) uintptr {
+		var button int32
+		if err := args.call(buttonPressedArgsGetButton, uintptr(unsafe.Pointer(&button))); err != nil {
+			return 0
+		}
+		switch button {
+		case buttonPlay:
+			h.s.send("play")
+		case buttonPause, buttonStop:
+			h.s.send("pause")
+		case buttonNext:
+			h.s.send("next")
+		case buttonPrevious:
+			h.s.send("prev")
+		}
+		return 0
+	}),
+}
+
+// An smtcSession joins the media controls of Windows through the System Media
+// Transport Controls. SMTC belongs to a window, so the session has a hidden
+// window of its own on a thread that handles its messages.
+type smtcSession struct {
+	commands chan<- command
+	done     chan struct{} // closed when the session is closed
+	thread   uint32        // ID of the window's thread
+
+	controls *comObject // ISystemMediaTransportControls
+	updater  *comObject // ISystemMediaTransportControlsDisplayUpdater
+	music    *comObject // IMusicDisplayProperties
+	handler  *buttonHandler
+	token    int64 // registration of handler with controls
+}
+
+// Starts a media session, which sends the commands from the media controls to
+// commands.
+func startMediaSession(commands chan<- command) (mediaSession, error) {
+	s := &smtcSession{commands: commands, done: make(chan struct{})}
+	s.handler = &buttonHandler{vtbl: &buttonHandlerVtbl, s: s}
+
+	ready := make(chan error)
+	go s.run(ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Creates the window and its media controls, reporting the result to ready,
+// then handles the window's messages until the session is closed.
+func (s *smtcSession) run(ready chan<- error) {
+	// The window belongs to this thread, which ends with the goroutine
+	runtime.LockOSThread()
+
+	if hr, _, _ := procRoInitialize.Call(roInitMultithreaded); int32(hr) < 0 {
+		ready <- syscall.Errno(hr)
+		return
+	}
+
+	class, _ := windows.UTF16PtrFromString("STATIC")
+	title, _ := windows.UTF16PtrFromString("modplay")
+	hwnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(class)), uintptr(unsafe.Pointer(title)), 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if hwnd == 0 {
+		ready <- err
+		return
+	}
+	defer procDestroyWindow.Call(hwnd)
+
+	s.thread = windows.GetCurrentThreadId()
+	if err := s.attach(hwnd); err != nil {
+		ready <- err
+		return
+	}
+	ready <- nil
+
+	var msg struct {
+		hwnd           uintptr
+		message        uint32
+		wParam, lParam uintptr
+		time           uint32
+		x, y           int32
+		private        uint32
+	}
+	for {
+		// GetMessage returns 0 for WM_QUIT, sent by close, and -1 for errors
+		r, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+		if int32(r) <= 0 {
+			return
+		}
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+	}
+}
+
+// Gets the media controls of the window hwnd and enables the buttons modplay
+// handles.
+func (s *smtcSession) attach(hwnd uintptr) error {
+	class, err := newHString("Windows.Media.SystemMediaTransportControls")
+	if err != nil {
+		return err
+	}
+	defer deleteHString(class)
+
+	var interop *comObject
+	hr, _, _ := procRoGetActivationFactory.Call(class, uintptr(unsafe.Pointer(&iidInterop)), uintptr(unsafe.Pointer(&interop)))
+	if int32(hr) < 0 {
+		return syscall.Errno(hr)
+	}
+	defer interop.release()
+	if err := interop.call(interopGetForWindow, hwnd, uintptr(unsafe.Pointer(&iidControls)), uintptr(unsafe.Pointer(&s.controls))); err != nil {
+		return err
+	}
+
+	c := s.controls
+	for _, method := range []int{controlsPutIsEnabled, controlsPutIsPlayEnabled, controlsPutIsPauseEnabled, controlsPutIsStopEnabled, controlsPutIsNextEnabled, controlsPutIsPreviousEnabled} {
+		if err := c.call(method, 1); err != nil {
+			c.release()
+			return err
+		}
+	}
+	if err := c.call(controlsGetDisplayUpdater, uintptr(unsafe.Pointer(&s.updater))); err != nil {
+		c.release()
+		return err
+	}
+	err = s.updater.call(updaterPutType, typeMusic)
+	if err == nil {
+		err = s.updater.call(updaterGetMusicProperties, uintptr(unsafe.Pointer(&s.music)))
+	}
+	if err != nil {
+		s.updater.release()
+		c.release()
+		return err
+	}
+	if err := c.call(controlsAddButtonPressed, uintptr(unsafe.Pointer(s.handler)), uintptr(unsafe.Pointer(&s.token))); err != nil {
+		s.music.release()
+		s.updater.release()
+		c.release()
+		return err
+	}
+	c.call(controlsPutPlaybackStatus, statusStopped)
+
+	return nil
+}
+
+// Shows the song in the media controls.
+func (s *smtcSession) setSong(title, file string) {
+	if title == "" {
+		title = filepath.Base(file)
+	}
+	h, err := newHString(title)
+	if err != nil {
+		return
+	}
+	defer deleteHString(h)
+
+	if s.music.call(musicPutTitle, h) == nil {
+		s.updater.call(updaterUpdate)
+	}
+}
+
+func (s *smtcSession) setPlaying(playing bool) {
+	status := statusPaused
+	if playing {
+		status = statusPlaying
+	}
+	s.controls.call(controlsPutPlaybackStatus, uintptr(status))
+}
+
+func (s *smtcSession) close() {
+	close(s.done)
+	// The token is passed by value, as two arguments on 32-bit Windows
+	token := []uintptr{uintptr(s.token)}
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		token = append(token, uintptr(s.token>>32))
+	}
+	s.controls.call(controlsRemoveButtonPressed, token...)
+	s.controls.call(controlsPutIsEnabled, 0)
+	s.music.release()
+	s.updater.release()
+	s.controls.release()
+	procPostThreadMessageW.Call(uintptr(s.thread), wmQuit, 0, 0)
+}
+
+// Sends the command name to the song playing, unless modplay is quitting. The
+// reply isn't waited for.
+func (s *smtcSession) send(name string) {
+	select {
+	case s.commands <- command{name: name, reply: make(chan commandReply, 1)}:
+	case <-s.done:
+	}
+}
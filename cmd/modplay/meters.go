@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+const (
+	meterRange = 36 // dB shown by a VU meter, from silence to full scale
+	meterFall  = 24 // dB per second that a VU meter falls by when the level drops
+)
+
+// vuMeters are the VU meters of the channels shown under the note data
+// headers. The bars rise with the level of a channel straight away and fall
+// back smoothly.
+type vuMeters struct {
+	levels []float64 // level shown for each channel in dB
+	last   time.Time // when the levels were last updated
+}
+
+// Updates the levels from the channel levels in state.
+func (m *vuMeters) update(state modplayer.PlayerState, now time.Time) {
+	if len(m.levels) != len(state.Channels) {
+		m.levels = make([]float64, len(state.Channels))
+		for i := range m.levels {
+			m.levels[i] = -meterRange
+		}
+		m.last = now
+	}
+
+	fall := now.Sub(m.last).Seconds() * meterFall
+	for i, ch := range state.Channels {
+		m.levels[i] = max(decibels(ch.RMS), m.levels[i]-fall, -meterRange)
+	}
+	m.last = now
+}
+
+// Returns the VU meter bar for channel ci, width characters wide, with a mark
+// at the channel's peak level.
+func (m *vuMeters) bar(ci int, peak float64, width int) string {
+	n := meterColumns(m.levels[ci], width)
+	p := meterColumns(decibels(peak), width)
+
+	bar := strings.Repeat("█", n)
+	if p > n {
+		bar += strings.Repeat(" ", p-n-1) + "|"
+	}
+	return bar + strings.Repeat(" ", width-max(n, p))
+}
+
+// Returns the number of columns of a meter width characters wide filled for a
+// level in dB.
+func meterColumns(db float64, width int) int {
+	return int(math.Round(max(db+meterRange, 0) / meterRange * float64(width)))
+}
+
+// Converts a channel level, where 1 is full scale, to dB.
+func decibels(level float64) float64 {
+	return 20 * math.Log10(min(level, 1))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns whether path has the extension of a song modplay can play.
+func isSong(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mod", ".s3m":
+		return true
+	}
+	return false
+}
+
+// Returns whether path has the extension of an M3U playlist.
+func isPlaylist(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		return true
+	}
+	return false
+}
+
+// Expands the command line arguments into the list of songs to play.
+// Directories are searched for songs, in name order, and M3U playlists are
+// replaced by the songs they list. Other arguments are played as they are, a
+// song that can't be read is skipped when it is reached.
+func expandArgs(args []string) ([]string, error) {
+	var songs []string
+	for _, arg := range args {
+		expanded, err := expandPath(arg)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, expanded...)
+	}
+	return songs, nil
+}
+
+func expandPath(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		// Reported when the song is played
+	case info.IsDir():
+		var songs []string
+		err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isSong(p) {
+				songs = append(songs, p)
+			}
+			return nil
+		})
+		return songs, err
+	case isPlaylist(path):
+		return readPlaylist(path)
+	}
+	return []string{path}, nil
+}
+
+// Returns the songs listed in an M3U playlist. Paths in the playlist are
+// relative to the playlist's directory, comment lines start with #.
+func readPlaylist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var songs []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), filepath.FromSlash(line))
+		}
+		expanded, err := expandPath(line)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, expanded...)
+	}
+	return songs, sc.Err()
+}
+
+// How a playlist repeats
+type repeatMode int
+
+const (
+	repeatOff repeatMode = iota
+	repeatAll            // start again from the first song after the last
+	repeatOne            // play the current song again when it ends
+)
+
+var repeatNames = [...]string{
+	repeatOff: "off",
+	repeatAll: "all",
+	repeatOne: "one",
+}
+
+func parseRepeat(s string) (repeatMode, error) {
+	for m, name := range repeatNames {
+		if s == name {
+			return repeatMode(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized repeat mode %q", s)
+}
+
+// A playlist is the songs being played and the order they are played in.
+type playlist struct {
+	songs   []string
+	order   []int // indices of songs in the order they are played
+	pos     int   // position in order of the current song
+	shuffle bool
+	repeat  repeatMode
+}
+
+func newPlaylist(songs []string, shuffle bool, repeat repeatMode) *playlist {
+	pl := &playlist{
+		songs:  songs,
+		order:  make([]int, len(songs)),
+		repeat: repeat,
+	}
+	for i := range pl.order {
+		pl.order[i] = i
+	}
+	if shuffle {
+		pl.shuffle = true
+		pl.reshuffle()
+	}
+	return pl
+}
+
+// Returns the filename of the current song.
+func (pl *playlist) current() string {
+	return pl.songs[pl.order[pl.pos]]
+}
+
+// Moves to the next song when dir is 1 or the previous song when dir is -1.
+// Returns false if there is no song to move to. When repeating all songs
+// the playlist wraps around, and is shuffled again after the last song.
+func (pl *playlist) step(dir int) bool {
+	pos := pl.pos + dir
+	if pos < 0 || pos >= len(pl.order) {
+		if pl.repeat != repeatAll {
+			return false
+		}
+		pos = (pos + len(pl.order)) % len(pl.order)
+		if pos == 0 && pl.shuffle {
+			pl.reshuffle()
+		}
+	}
+	pl.pos = pos
+	return true
+}
+
+// Turns shuffling on or off. The current song keeps playing, the songs after
+// it are played in random order when shuffling, or in their original order.
+func (pl *playlist) setShuffle(shuffle bool) {
+	if shuffle == pl.shuffle {
+		return
+	}
+	pl.shuffle = shuffle
+	song := pl.order[pl.pos]
+	if shuffle {
+		pl.reshuffle()
+		// Move the current song to the front
+		for i, s := range pl.order {
+			if s == song {
+				pl.order[0], pl.order[i] = pl.order[i], pl.order[0]
+				break
+			}
+		}
+		pl.pos = 0
+	} else {
+		for i := range pl.order {
+			pl.order[i] = i
+		}
+		pl.pos = song
+	}
+}
+
+// Switches to the next repeat mode, off, all then one.
+func (pl *playlist) cycleRepeat() {
+	pl.repeat = (pl.repeat + 1) % repeatMode(len(repeatNames))
+}
+
+func (pl *playlist) reshuffle() {
+	rand.Shuffle(len(pl.order), func(i, j int) {
+		pl.order[i], pl.order[j] = pl.order[j], pl.order[i]
+	})
+}
+
+// Returns the modes that are on, for the display.
+func (pl *playlist) modes() string {
+	var modes []string
+	if pl.shuffle {
+		modes = append(modes, "shuffle")
+	}
+	if pl.repeat != repeatOff {
+		modes = append(modes, "repeat "+repeatNames[pl.repeat])
+	}
+	return strings.Join(modes, " ")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Keys used by the prompt
+const (
+	keyBackspace key = 8
+	keyEnter     key = 13
+	keyDelete    key = 127
+)
+
+const maxPromptLen = 8
+
+// An orderPrompt reads the number of an order to jump to, typed in after
+// pressing g.
+type orderPrompt struct {
+	active bool
+	input  string
+	err    error // from the last order entered, shown until the next key
+}
+
+// Opens the prompt.
+func (op *orderPrompt) open() {
+	op.active, op.input, op.err = true, "", nil
+}
+
+// Handles k typed into the open prompt. Returns true when the order has been
+// entered.
+func (op *orderPrompt) key(k key) bool {
+	switch {
+	case k == keyEscape:
+		op.active = false
+	case k == keyEnter || k == '\n':
+		op.active = false
+		return op.input != ""
+	case k == keyBackspace || k == keyDelete:
+		if len(op.input) > 0 {
+			op.input = op.input[:len(op.input)-1]
+		}
+	case len(op.input) < maxPromptLen && strings.ContainsRune("0123456789abcdefABCDEFxX$", rune(k)):
+		op.input += string(rune(k))
+	}
+	return false
+}
+
+// Returns the line shown for the prompt, empty if there is nothing to show.
+func (op *orderPrompt) String() string {
+	switch {
+	case op.active:
+		return "go to order: " + op.input + "_"
+	case op.err != nil:
+		return op.err.Error()
+	}
+	return ""
+}
+
+// Parses an order number typed at the prompt. Numbers starting with $ or 0x,
+// or containing the digits A-F, are hex like the order numbers on the
+// display. Other numbers are decimal.
+func parseOrder(input string) (int, error) {
+	s := strings.ToLower(input)
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(s, "0x"):
+		s, base = s[2:], 16
+	case strings.ContainsAny(s, "abcdef"):
+		base = 16
+	}
+	n, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid order %q", input)
+	}
+	return int(n), nil
+}
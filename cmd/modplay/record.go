@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chriskillpack/modplayer/wav"
+)
+
+// How often the audio recorded is written to the file
+const recordInterval = 100 * time.Millisecond
+
+// A recorder writes the audio played to a WAV file. The audio is handed over
+// by the audio callback and written to the file from a goroutine of its own,
+// so the callback never waits for the disk.
+type recorder struct {
+	f *os.File
+	w *wav.Writer
+
+	mu      sync.Mutex
+	pending []int16 // audio waiting to be written
+	spare   []int16 // the buffer written last time, reused for pending
+	err     error   // from the first write that failed
+
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// Creates the WAV file path and starts recording the stereo audio at hz
+// written to the recorder.
+func newRecorder(path string, hz int) (*recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wav.NewWriter(f, hz)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &recorder{f: f, w: w, done: make(chan struct{}), finished: make(chan struct{})}
+	go r.run()
+	return r, nil
+}
+
+// Records the stereo audio in out. Called from the audio callback.
+func (r *recorder) write(out []int16) {
+	r.mu.Lock()
+	r.pending = append(r.pending, out...)
+	r.mu.Unlock()
+}
+
+// Writes the audio recorded to the file every recordInterval until the
+// recorder is closed.
+func (r *recorder) run() {
+	defer close(r.finished)
+
+	ticker := time.NewTicker(recordInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			r.flush()
+			return
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+// Writes the audio waiting to the file. After an error nothing more is
+// written.
+func (r *recorder) flush() {
+	r.mu.Lock()
+	buf := r.pending
+	r.pending, r.spare = r.spare[:0], nil
+	r.mu.Unlock()
+
+	if r.err == nil && len(buf) > 0 {
+		r.err = r.w.WriteFrame(buf)
+	}
+
+	r.mu.Lock()
+	r.spare = buf
+	r.mu.Unlock()
+}
+
+// Stops recording and finishes the file. The audio callback must not write
+// to the recorder any more. Returns the first error writing the file.
+func (r *recorder) close() error {
+	close(r.done)
+	<-r.finished
+
+	err := r.err
+	if _, ferr := r.w.Finish(); err == nil {
+		err = ferr
+	}
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+// A command is a request from the HTTP API or the media controls, carried out
+// by the song playing.
+type command struct {
+	name    string // play, pause, toggle, seek, mute, unmute, next, prev, quit or status
+	order   int    // for seek
+	row     int    // for seek
+	channel int    // for mute and unmute, from 1
+	reply   chan commandReply
+}
+
+type commandReply struct {
+	status remoteStatus // after the command
+	err    error
+}
+
+// remoteStatus is the JSON returned by every request to the HTTP API.
+type remoteStatus struct {
+	Track    int    `json:"track"` // position in the playlist, from 1
+	Tracks   int    `json:"tracks"`
+	File     string `json:"file"`
+	Title    string `json:"title"`
+	Playing  bool   `json:"playing"`
+	Order    int    `json:"order"`
+	Orders   int    `json:"orders"`
+	Pattern  int    `json:"pattern"`
+	Row      int    `json:"row"`
+	Speed    int    `json:"speed"`
+	Tempo    int    `json:"tempo"`
+	Channels int    `json:"channels"`
+	Muted    []int  `json:"muted"` // muted channels, from 1
+	Shuffle  bool   `json:"shuffle"`
+	Repeat   string `json:"repeat"`
+}
+
+// Returns the handler of the HTTP API, which sends the commands it receives
+// to commands. Every request returns the status of the player as JSON.
+//
+//	GET  /status
+//	POST /play
+//	POST /pause
+//	POST /seek?order=N&row=N   (row defaults to 0)
+//	POST /mute?channel=N       (channels from 1)
+//	POST /unmute?channel=N
+//	POST /next
+//	POST /prev
+func newRemoteHandler(commands chan<- command) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(name, method string, parse func(c *command, r *http.Request) error) {
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != method {
+				w.Header().Set("Allow", method)
+				writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use " + method})
+				return
+			}
+			c := command{name: name, reply: make(chan commandReply, 1)}
+			if parse != nil {
+				if err := parse(&c, r); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+			}
+
+			select {
+			case commands <- c:
+			case <-r.Context().Done():
+				return
+			}
+			reply := <-c.reply
+			if reply.err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": reply.err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, reply.status)
+		})
+	}
+
+	channel := func(c *command, r *http.Request) error {
+		var err error
+		c.channel, err = queryInt(r, "channel", -1)
+		return err
+	}
+	handle("status", http.MethodGet, nil)
+	handle("play", http.MethodPost, nil)
+	handle("pause", http.MethodPost, nil)
+	handle("seek", http.MethodPost, func(c *command, r *http.Request) error {
+		var err error
+		if c.order, err = queryInt(r, "order", -1); err != nil {
+			return err
+		}
+		c.row, err = queryInt(r, "row", 0)
+		return err
+	})
+	handle("mute", http.MethodPost, channel)
+	handle("unmute", http.MethodPost, channel)
+	handle("next", http.MethodPost, nil)
+	handle("prev", http.MethodPost, nil)
+
+	return mux
+}
+
+// Returns the integer query parameter name of r, or def if it is missing. A
+// def of -1 makes the parameter required.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		if def < 0 {
+			return 0, fmt.Errorf("missing %s", name)
+		}
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Carries out c on the song playing and replies with the status afterwards.
+// Returns the action to take and true if the command leaves the song.
+func (pb *playback) runCommand(c command, player *modplayer.Player, song *modplayer.Song, pl *playlist) (action, bool) {
+	var err error
+	act, leave := actionNext, false
+	switch c.name {
+	case "play":
+		player.Start()
+	case "pause":
+		player.Stop()
+	case "toggle":
+		if player.IsPlaying() {
+			player.Stop()
+		} else {
+			player.Start()
+		}
+	case "seek":
+		err = player.JumpTo(c.order, c.row)
+	case "mute":
+		err = player.MuteChannel(c.channel - 1)
+	case "unmute":
+		err = player.UnmuteChannel(c.channel - 1)
+	case "next":
+		leave = true
+	case "prev":
+		act, leave = actionPrev, true
+	case "quit":
+		act, leave = actionQuit, true
+	}
+
+	state := player.State()
+	status := remoteStatus{
+		Track:    pl.pos + 1,
+		Tracks:   len(pl.order),
+		File:     pl.current(),
+		Title:    song.Title,
+		Playing:  player.IsPlaying(),
+		Order:    state.Order,
+		Orders:   len(song.Orders),
+		Pattern:  int(song.Orders[state.Order]),
+		Row:      state.Row,
+		Speed:    state.Speed,
+		Tempo:    state.Tempo,
+		Channels: song.Channels,
+		Muted:    []int{},
+		Shuffle:  pl.shuffle,
+		Repeat:   repeatNames[pl.repeat],
+	}
+	for ci := 0; ci < song.Channels; ci++ {
+		if player.MuteMask()&(1<<ci) != 0 {
+			status.Muted = append(status.Muted, ci+1)
+		}
+	}
+	c.reply <- commandReply{status: status, err: err}
+
+	return act, leave
+}
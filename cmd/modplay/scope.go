@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+const (
+	scopeLength = 1024 // samples of each channel shown in its scope
+	scopeLines  = 9    // lines of the display a scope takes, the same as the note data
+	scopeFloor  = 2048 // smallest sample value that fills a scope, so quiet noise stays small
+)
+
+// Braille characters have 2 by 4 dots, used as the pixels of the scopes
+const (
+	brailleBase   = 0x2800
+	brailleWidth  = 2
+	brailleHeight = 4
+)
+
+// The bit of each dot of a braille character, by row then column
+var brailleDots = [brailleHeight][brailleWidth]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// Writes the scopes of the first few visible channels to b, in place of the
+// note data. buf holds the samples of a scope.
+func drawScopes(b *strings.Builder, player *modplayer.Player, visible []int, buf []int16) {
+	columns := visible[:min(len(visible), maxNoteColumns)]
+	scopes := make([][]string, len(columns))
+	for i, ci := range columns {
+		n := player.ChannelScope(ci, buf)
+		scopes[i] = renderScope(buf[:n], noteCellWidth, scopeLines)
+	}
+
+	for line := 0; line < scopeLines; line++ {
+		b.WriteString("    ")
+		for i := range scopes {
+			b.WriteString(scopes[i][line])
+			if i < len(scopes)-1 {
+				b.WriteString("|")
+			}
+		}
+		b.WriteString("\n")
+	}
+}
+
+// Draws samples as a waveform width characters wide and height lines high,
+// scaled to fit. Each column of dots spans the samples from the lowest to the
+// highest in that part of the waveform, joined to the column before.
+func renderScope(samples []int16, width, height int) []string {
+	dotsX, dotsY := width*brailleWidth, height*brailleHeight
+	cells := make([][]rune, height)
+	for i := range cells {
+		cells[i] = make([]rune, width)
+	}
+
+	scale := scopeFloor
+	for _, s := range samples {
+		scale = max(scale, int(s), -int(s))
+	}
+	// Returns the row of dots for a sample, 0 at the top
+	dotY := func(s int16) int {
+		y := (scale - int(s)) * (dotsY - 1) / (2 * scale)
+		return min(max(y, 0), dotsY-1)
+	}
+
+	prev := dotsY / 2
+	if len(samples) > 0 {
+		prev = dotY(samples[0])
+	}
+	for x := 0; x < dotsX; x++ {
+		lo, hi := prev, prev
+		start, end := x*len(samples)/dotsX, (x+1)*len(samples)/dotsX
+		for _, s := range samples[start:end] {
+			y := dotY(s)
+			lo, hi = min(lo, y), max(hi, y)
+			prev = y
+		}
+		for y := lo; y <= hi; y++ {
+			cells[y/brailleHeight][x/brailleWidth] |= brailleDots[y%brailleHeight][x%brailleWidth]
+		}
+	}
+
+	lines := make([]string, height)
+	for i, row := range cells {
+		for x := range row {
+			row[x] += brailleBase
+		}
+		lines[i] = string(row)
+	}
+	return lines
+}
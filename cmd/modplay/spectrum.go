@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spectrumSize  = 2048                                              // samples transformed, a power of 2
+	spectrumWidth = maxNoteColumns*noteCellWidth + maxNoteColumns - 1 // bands shown, the width of the note data
+	spectrumLines = 9                                                 // lines of the display the spectrum takes
+	spectrumRange = 60                                                // dB shown, from the bottom to full scale
+	spectrumFall  = 48                                                // dB per second that a band falls by
+	spectrumMinHz = 40                                                // frequency of the lowest band
+	spectrumMaxHz = 16000                                             // frequency of the highest band
+)
+
+// Block characters with 1 to 8 eighths filled from the bottom
+var spectrumBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// A spectrum is a spectrum analyzer of the audio sent to the audio device.
+// The audio is written from the audio callback and analyzed by the display.
+type spectrum struct {
+	hz int
+
+	mu   sync.Mutex
+	ring []int16 // the most recent audio, mono
+	pos  int     // position in ring of the next sample
+
+	samples []complex128 // the audio being transformed
+	window  []float64    // Hann window applied to the audio
+	levels  []float64    // level shown for each band in dB
+	last    time.Time    // when the levels were last updated
+}
+
+func newSpectrum(hz int) *spectrum {
+	s := &spectrum{
+		hz:      hz,
+		ring:    make([]int16, spectrumSize),
+		samples: make([]complex128, spectrumSize),
+		window:  make([]float64, spectrumSize),
+		levels:  make([]float64, spectrumWidth),
+	}
+	for i := range s.window {
+		s.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/spectrumSize)
+	}
+	for i := range s.levels {
+		s.levels[i] = -spectrumRange
+	}
+	return s
+}
+
+// Records the stereo audio in out.
+func (s *spectrum) write(out []int16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i+1 < len(out); i += 2 {
+		s.ring[s.pos] = int16((int(out[i]) + int(out[i+1])) / 2)
+		s.pos = (s.pos + 1) % len(s.ring)
+	}
+}
+
+// Analyzes the most recent audio and updates the levels of the bands. The
+// levels rise straight away and fall back smoothly.
+func (s *spectrum) update(now time.Time) {
+	s.mu.Lock()
+	for i := range s.samples {
+		v := float64(s.ring[(s.pos+i)%len(s.ring)]) / 32768
+		s.samples[i] = complex(v*s.window[i], 0)
+	}
+	s.mu.Unlock()
+
+	fft(s.samples)
+
+	fall := now.Sub(s.last).Seconds() * spectrumFall
+	s.last = now
+	maxHz := min(spectrumMaxHz, float64(s.hz)/2)
+	for band := range s.levels {
+		// The bands are spaced evenly on a log scale, like musical notes
+		lo := spectrumMinHz * math.Pow(maxHz/spectrumMinHz, float64(band)/spectrumWidth)
+		hi := spectrumMinHz * math.Pow(maxHz/spectrumMinHz, float64(band+1)/spectrumWidth)
+		first := int(math.Round(lo * spectrumSize / float64(s.hz)))
+		last := max(int(math.Round(hi*spectrumSize/float64(s.hz)))-1, first)
+
+		peak := 0.0
+		for _, x := range s.samples[first : last+1] {
+			peak = max(peak, cmplx.Abs(x))
+		}
+		// A full scale sine wave peaks at a quarter of the size with the
+		// Hann window
+		db := 20 * math.Log10(peak*4/spectrumSize)
+		s.levels[band] = max(db, s.levels[band]-fall, -spectrumRange)
+	}
+}
+
+// Writes the spectrum to b as bars of block characters.
+func (s *spectrum) draw(b *strings.Builder) {
+	eighths := make([]int, len(s.levels))
+	for band, db := range s.levels {
+		eighths[band] = int(math.Round((db + spectrumRange) / spectrumRange * spectrumLines * 8))
+	}
+
+	line := make([]rune, len(s.levels))
+	for l := spectrumLines - 1; l >= 0; l-- {
+		for band, e := range eighths {
+			switch fill := e - l*8; {
+			case fill <= 0:
+				line[band] = ' '
+			case fill >= 8:
+				line[band] = spectrumBlocks[7]
+			default:
+				line[band] = spectrumBlocks[fill-1]
+			}
+		}
+		b.WriteString("    " + string(line) + "\n")
+	}
+}
+
+// Replaces x with its discrete Fourier transform, using the radix 2 fast
+// Fourier transform. The length of x must be a power of 2.
+func fft(x []complex128) {
+	n := len(x)
+
+	// Put the samples in bit reversed order
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// A key is a key press read from the terminal, the character typed or one of
+// the special keys below.
+type key rune
+
+// Size of the terminal assumed when its size is unknown
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// Control keys
+const (
+	keyCtrlC  key = 3
+	keyEscape key = 27
+)
+
+// Special keys, in the Unicode private use area so they can't be confused
+// with typed characters
+const (
+	keyUp key = 0xE000 + iota
+	keyDown
+	keyRight
+	keyLeft
+	keyPageUp
+	keyPageDown
+)
+
+// The escape sequences sent by special keys
+var keySequences = map[string]key{
+	"\x1b[A":  keyUp,
+	"\x1b[B":  keyDown,
+	"\x1b[C":  keyRight,
+	"\x1b[D":  keyLeft,
+	"\x1bOA":  keyUp,
+	"\x1bOB":  keyDown,
+	"\x1bOC":  keyRight,
+	"\x1bOD":  keyLeft,
+	"\x1b[5~": keyPageUp,
+	"\x1b[6~": keyPageDown,
+}
+
+// A terminal reads single key presses from stdin while modplay is running.
+// Output written through it has its newlines translated for the raw mode
+// terminal.
+type terminal struct {
+	keys    chan key // nil when stdin isn't a terminal
+	restore func()
+}
+
+// Puts stdin into raw mode so that keys can be read as they are pressed. If
+// stdin isn't a terminal no keys are read.
+func openTerminal() *terminal {
+	t := &terminal{restore: func() {}}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return t
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return t
+	}
+	t.restore = func() { term.Restore(fd, state) }
+
+	t.keys = make(chan key, 16)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, k := range parseKeys(buf[:n]) {
+				t.keys <- k
+			}
+		}
+	}()
+
+	return t
+}
+
+// Returns the keys in the bytes read from the terminal. The escape sequence
+// of a special key arrives in a single read.
+func parseKeys(b []byte) []key {
+	var keys []key
+	for len(b) > 0 {
+		if b[0] == byte(keyEscape) {
+			found := false
+			for seq, k := range keySequences {
+				if bytes.HasPrefix(b, []byte(seq)) {
+					keys = append(keys, k)
+					b = b[len(seq):]
+					found = true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+		}
+		keys = append(keys, key(b[0]))
+		b = b[1:]
+	}
+	return keys
+}
+
+// Returns the number of characters across and the number of lines of the
+// terminal showing the display.
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return defaultWidth, defaultHeight
+	}
+	return width, height
+}
+
+// A crlfWriter writes to w with each newline preceded by a carriage return,
+// because a terminal in raw mode only moves the cursor down for a newline.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if _, err := c.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
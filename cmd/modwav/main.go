@@ -4,26 +4,66 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/chriskillpack/modplayer"
-	"github.com/chriskillpack/modplayer/cmd/internal/config"
-	"github.com/chriskillpack/modplayer/cmd/modwav/wav"
+	"github.com/chriskillpack/modplayer/aiff"
+	"github.com/chriskillpack/modplayer/cmd/internal/cli"
+	"github.com/chriskillpack/modplayer/comb"
+	"github.com/chriskillpack/modplayer/flac"
+	"github.com/chriskillpack/modplayer/wav"
 )
 
 var (
-	flagWAVOut   = flag.String("wav", "", "output location for WAV file")
-	flagHz       = flag.Int("hz", 44100, "output hz")
-	flagBoost    = flag.Int("boost", 1, "volume boost, an integer between 1 and 4")
-	flagStartOrd = flag.Int("start", 0, "starting order in the MOD, clamped to song max")
-	flagLenOrd   = flag.Int("maxpatterns", -1, "Maximum number of orders to play, useful for songs that loop forever")
-	flagReverb   = flag.String("reverb", "light", "choose from light, medium, silly or none")
-	flagMute     = flag.Uint("mute", 0, "bitmask of muted channels, channel 1 in LSB, set bit to mute channel")
+	flagWAVOut     = flag.String("wav", "", "output location for WAV file, an .aif or .aiff extension writes an AIFF file, .flac a FLAC file and .raw or .pcm headerless 16-bit little-endian PCM, - writes PCM to stdout")
+	flagHz         = flag.Int("hz", 44100, "output hz")
+	flagBoost      = flag.Int("boost", 1, "volume boost, an integer between 1 and 4")
+	flagGain       = flag.Float64("gain", 1, "output gain applied after mixing, e.g. 0.5 halves the volume")
+	flagNormalize  = flag.Float64("normalize", 0, "adjust the gain so the song plays at this loudness in LUFS, e.g. -16, 0 to disable")
+	flagSoftClip   = flag.Bool("softclip", false, "saturate loud peaks smoothly instead of clipping them")
+	flagStartOrd   = flag.Int("start", 0, "starting order in the MOD, clamped to song max")
+	flagStartTime  = flag.String("start-time", "", "start this far into the song, as mm:ss, with the effects as if it had played from the start")
+	flagLenOrd     = flag.Int("maxpatterns", -1, "Maximum number of orders to play, useful for songs that loop forever")
+	flagReverb     = flag.String("reverb", "light", "choose from "+strings.Join(comb.Names(), ", ")+" or a custom reverb like decay=0.4,delay=300")
+	flagBass       = flag.Float64("bass", 0, "boost the bass by this many dB, 0 to 24")
+	flagExciter    = flag.Float64("exciter", 0, "amount of bass harmonics added with -bass, 0 to 1")
+	flagCompress   = flag.Float64("compress", 0, "compress audio louder than this many dB below full scale, 0 to disable")
+	flagRatio      = flag.Float64("ratio", 4, "compression ratio for -compress")
+	flagAttack     = flag.Duration("attack", 5*time.Millisecond, "compressor attack time")
+	flagRelease    = flag.Duration("release", 200*time.Millisecond, "compressor release time")
+	flagMakeup     = flag.Float64("makeup", 0, "gain in dB applied after compression")
+	flagLowPass    = flag.Float64("lowpass", 0, "remove frequencies above this many Hz, 0 to disable")
+	flagHighPass   = flag.Float64("highpass", 0, "remove frequencies below this many Hz, 0 to disable")
+	flagBinaural   = flag.Bool("binaural", false, "place the channels around your head instead of panning them, for listening on headphones")
+	flagCues       = flag.Bool("cues", false, "mark the start of every order with a cue point in the WAV file")
+	flagSplit      = flag.String("split", "", "write a separate file for each order or pattern of the song, order or pattern, named after -wav with the number added")
+	flagMono       = flag.Bool("mono", false, "write a mono file, half the size of a stereo one")
+	flagSurround   = flag.Bool("surround", false, "write a 5.1 surround file, the effects flags are ignored")
+	flagCrossfeed  = flag.Bool("crossfeed", false, "feed some of each channel into the other, for listening on headphones")
+	flagMute       = flag.Uint("mute", 0, "bitmask of muted channels, channel 1 in LSB, set bit to mute channel")
+	flagSeparation = flag.Int("separation", 100, "stereo separation percentage, 0 is mono")
+	flagTranspose  = flag.Float64("transpose", 0, "shift the pitch by this many semitones without changing the tempo")
+	flagTempo      = flag.Float64("tempo", 1, "tempo multiplier, 2 plays twice as fast")
+	flagClock      = flag.String("clock", "pal", "Amiga timing, pal or ntsc")
+	flagAmiga      = flag.Bool("amiga", false, "use the ProTracker period tables for MOD files")
+	flagInterp     = flag.String("interp", "sinc", "sample interpolation: none, linear, cubic, sinc (best quality, slowest) or blep (Amiga sound)")
+	flagFloat      = flag.Bool("float", false, "use floating point output stages with dither")
+	flagMixer      = flag.String("mixer", "auto", "mixer backend, auto picks the fastest the CPU supports")
+	flagLoops      = flag.Int("loops", 0, "number of times to repeat the song")
+	flagOnLoop     = flag.String("onloop", "loop", "what to do when the song jumps back to a row it already played: loop, stop or fade")
+	flagFade       = flag.Duration("fade", 10*time.Second, "how long to fade out for with -onloop fade")
+	flagSilence    = flag.Duration("silence", 0, "stop after this much silence, 0 to disable")
 )
 
 func main() {
@@ -64,49 +104,424 @@ func main() {
 		log.Fatal(err)
 	}
 
-	player, err := modplayer.NewPlayer(song, uint(*flagHz))
-	if err != nil {
+	opts := modplayer.DefaultPlayerOptions()
+	opts.VolumeBoost = *flagBoost
+	opts.MasterGain = *flagGain
+	if *flagSoftClip {
+		opts.Clipping = modplayer.ClipSoft
+	}
+	opts.Mute = *flagMute
+	opts.StereoSeparation = *flagSeparation
+	opts.PlayOrderLimit = *flagLenOrd
+	if *flagLoops < 0 {
+		log.Fatal("loops cannot be negative")
+	}
+	opts.LoopCount = *flagLoops
+	opts.SilenceStop = *flagSilence
+	if opts.LoopPolicy, err = cli.ParseLoopPolicy(*flagOnLoop); err != nil {
 		log.Fatal(err)
 	}
-	if err := player.SetVolumeBoost(*flagBoost); err != nil {
+	if opts.LoopPolicy == modplayer.LoopPolicyFade {
+		opts.LoopFade = *flagFade
+	}
+	if opts.Clock, err = cli.ParseClock(*flagClock); err != nil {
 		log.Fatal(err)
 	}
-
-	player.Mute = *flagMute
-	if *flagStartOrd > 0 {
-		player.SeekTo(*flagStartOrd, 0)
+	if *flagAmiga {
+		opts.PeriodMode = modplayer.PeriodAmiga
 	}
-	player.PlayOrderLimit = *flagLenOrd
-
-	wavF, err := os.Create(*flagWAVOut)
-	if err != nil {
+	if *flagBinaural {
+		opts.Panning = modplayer.PanningBinaural
+	}
+	if opts.Interpolation, err = cli.ParseInterpolation(*flagInterp); err != nil {
 		log.Fatal(err)
 	}
-	defer wavF.Close()
+	if *flagFloat {
+		opts.MixFormat = modplayer.MixFloat32
+	}
+	if opts.Mixer = parseMixer(*flagMixer); opts.Mixer < 0 {
+		log.Fatalf("unrecognized or unsupported mixer %q", *flagMixer)
+	}
 
-	wavW, err := wav.NewWriter(wavF, *flagHz)
+	player, err := modplayer.NewPlayerWithOptions(song, uint(*flagHz), opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer wavW.Finish()
+	if err := player.SetTranspose(*flagTranspose); err != nil {
+		log.Fatal(err)
+	}
+	if err := player.SetTempoScale(*flagTempo); err != nil {
+		log.Fatal(err)
+	}
+	if *flagStartOrd > 0 {
+		// Orders that can't be reached by playing the song are jumped to
+		// directly
+		if err := player.FastForwardTo(*flagStartOrd, 0); err != nil {
+			player.SeekTo(*flagStartOrd, 0)
+		}
+	}
+	if *flagStartTime != "" {
+		if *flagStartOrd > 0 {
+			log.Fatal("-start and -start-time can't be used together")
+		}
+		d, err := cli.ParseTime(*flagStartTime)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := player.SeekToTime(d); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *flagMono && *flagSurround {
+		log.Fatal("-mono and -surround can't be used together")
+	}
+	channels := 2
+	switch {
+	case *flagMono:
+		channels = 1
+	case *flagSurround:
+		channels = modplayer.SurroundChannels
+	}
+
+	switch *flagSplit {
+	case "":
+	case "order", "pattern":
+		if *flagWAVOut == "-" || *flagCues {
+			log.Fatal("-split can't be used with -wav - or -cues")
+		}
+	default:
+		log.Fatalf("unrecognized split %q", *flagSplit)
+	}
 
-	rvb, err := config.ReverbFromFlag(*flagReverb, *flagHz)
+	rvb, err := comb.New(*flagReverb, *flagHz)
 	if err != nil {
 		log.Fatal(err)
 	}
+	effects := modplayer.NewChain()
+	addFilter(effects, modplayer.HighPass, *flagHighPass)
+	addFilter(effects, modplayer.LowPass, *flagLowPass)
+	if *flagBass > 0 {
+		bass, err := modplayer.NewBassBoost(uint(*flagHz), 150, *flagBass)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := bass.SetExciter(*flagExciter); err != nil {
+			log.Fatal(err)
+		}
+		effects.Add(bass)
+	}
+	effects.Add(comb.NewEffect(rvb))
+	if *flagCrossfeed {
+		cf, err := modplayer.NewCrossfeed(uint(*flagHz), modplayer.DefaultCrossfeedCutoff, modplayer.DefaultCrossfeedLevel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		effects.Add(cf)
+	}
+	if *flagCompress != 0 {
+		comp, err := modplayer.NewCompressor(uint(*flagHz), -*flagCompress, *flagRatio, *flagAttack, *flagRelease)
+		if err != nil {
+			log.Fatal(err)
+		}
+		comp.SetMakeup(*flagMakeup)
+		effects.Add(comp)
+	}
+	player.SetEffect(effects)
 
-	scratch := make([]int16, 2048)
-	audioOut := make([]int16, 2048)
+	// Interrupting stops rendering early but still leaves a valid WAV file
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	for player.IsPlaying() {
-		n := player.GenerateAudio(scratch) * 2
-		rvb.InputSamples(scratch[:n])
-		n = rvb.GetAudio(audioOut)
-		if err = wavW.WriteFrame(audioOut[:n]); err != nil {
+	if *flagNormalize != 0 {
+		loudness, err := player.AnalyzeLoudness(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gain := loudness.Gain(*flagNormalize)
+		if err := player.SetMasterGain(*flagGain * math.Pow(10, gain/20)); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%.1f LUFS, peak %.1fdB, gain adjusted by %+.1fdB", loudness.Integrated, loudness.Peak, gain)
+	}
+
+	// With -split the files are created as the song plays
+	var wavF *os.File
+	var wavW modplayer.Encoder
+	if *flagSplit == "" {
+		wavF = os.Stdout
+		if *flagWAVOut != "-" {
+			wavF, err = os.Create(*flagWAVOut)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		wavW, err = newWriter(wavF, *flagWAVOut, *flagHz, channels)
+		if err != nil {
 			wavF.Close()
 			log.Fatal(err)
 		}
 	}
+	// The writers only complete the file's header when closed
+	closeOutput := func() error {
+		if wavW == nil {
+			return nil
+		}
+		err := wavW.Close()
+		if cerr := wavF.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	fatal := func(v any) {
+		closeOutput()
+		log.Fatal(v)
+	}
+
+	// Songs that loop forever rendered from the beginning get the loop
+	// marked in WAV files, for seamless looping in game engines and samplers
+	start := player.SamplesPlayed()
+	loop := modplayer.SongDuration{LoopStart: -1}
+	if _, ok := wavW.(*wav.Writer); ok && *flagStartOrd == 0 && *flagStartTime == "" {
+		if loop, err = player.DurationContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			fatal(err)
+		}
+	}
+
+	if *flagCues {
+		w, ok := wavW.(*wav.Writer)
+		if !ok {
+			fatal("-cues needs a WAV file")
+		}
+		player.OnOrderChange = func(order int) {
+			w.AddCue(player.SamplesPlayed()-start, fmt.Sprintf("Order %02X / Pattern %02X", order, song.Orders[order]))
+		}
+	}
+
+	switch {
+	case *flagSplit != "":
+		section := func(order int) int {
+			if *flagSplit == "pattern" {
+				return int(song.Orders[order])
+			}
+			return order
+		}
+		err = renderSplit(ctx, player, channels, section, splitName(*flagWAVOut, *flagSplit))
+	case *flagMono:
+		mono := make([]int16, 0, 4096)
+		err = player.RenderAll(ctx, func(stereo []int16) error {
+			mono = downmix(mono[:0], stereo)
+			return wavW.WriteFrames(mono)
+		})
+	case *flagSurround:
+		err = renderSurround(ctx, player, wavW)
+	default:
+		err = player.RenderAll(ctx, wavW.WriteFrames)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatal(err)
+	}
 
 	player.Stop()
+
+	if w, ok := wavW.(*wav.Writer); ok && loop.LoopStart >= 0 && player.SamplesPlayed()-start >= loop.Samples {
+		if err := w.SetLoop(loop.LoopStart, loop.Samples); err != nil {
+			fatal(err)
+		}
+	}
+	if err := closeOutput(); err != nil {
+		log.Fatal(err)
+	}
+
+	if stats := player.Stats(); stats.Clipped > 0 {
+		log.Printf("%d of %d samples clipped, peak %.1fdB over full scale, try a lower -boost or -softclip", stats.Clipped, stats.Samples, -stats.Headroom())
+	}
+}
+
+// newWriter returns an encoder of 16-bit audio with channels per frame to
+// ws. It writes raw PCM if path is - or has a raw extension, an AIFF or FLAC
+// file if path has their extension and a WAV file otherwise.
+func newWriter(ws io.WriteSeeker, path string, rate, channels int) (modplayer.Encoder, error) {
+	if path == "-" {
+		return modplayer.NewRawEncoder(ws), nil
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".aif", ".aiff":
+		return aiff.NewFormatWriter(ws, aiff.Format{SampleRate: rate, Channels: channels, BitsPerSample: 16})
+	case ".flac":
+		return flac.NewWriter(ws, rate, channels)
+	case ".raw", ".pcm":
+		return modplayer.NewRawEncoder(ws), nil
+	}
+
+	switch channels {
+	case 1:
+		return wav.NewMonoWriter(ws, rate)
+	case modplayer.SurroundChannels:
+		return wav.NewSurroundWriter(ws, rate)
+	}
+	return wav.NewWriter(ws, rate)
+}
+
+// downmix appends the average of each left and right sample of stereo to mono
+// and returns the result.
+func downmix(mono, stereo []int16) []int16 {
+	for i := 0; i+1 < len(stereo); i += 2 {
+		mono = append(mono, int16((int(stereo[i])+int(stereo[i+1]))/2))
+	}
+	return mono
+}
+
+// renderSurround renders the rest of the song in 5.1 surround to w, stopping
+// early if ctx is cancelled.
+func renderSurround(ctx context.Context, player *modplayer.Player, w modplayer.Encoder) error {
+	buf := make([]int16, 4096*modplayer.SurroundChannels)
+	for player.IsPlaying() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := player.GenerateSurround(buf)
+		if err := w.WriteFrames(buf[:n*modplayer.SurroundChannels]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitName returns a function that names the file of a section of the song
+// played with -split, by adding the kind of section and its number to path,
+// e.g. song-order003.wav.
+func splitName(path, kind string) func(section int) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	return func(section int) string {
+		return fmt.Sprintf("%s-%s%03d%s", stem, kind, section, ext)
+	}
+}
+
+// renderSplit renders the rest of the song with channels per frame to a file
+// for each section of the song, stopping early if ctx is cancelled. section
+// returns the section an order belongs to and name the file of a section. A
+// section is written the first time it plays, later plays of it are skipped.
+func renderSplit(ctx context.Context, player *modplayer.Player, channels int, section func(order int) int, name func(section int) string) error {
+	type cut struct {
+		frame   int64 // position in the player's audio
+		section int
+	}
+	var cuts []cut
+	player.OnOrderChange = func(order int) {
+		cuts = append(cuts, cut{player.SamplesPlayed(), section(order)})
+	}
+	defer func() { player.OnOrderChange = nil }()
+
+	var (
+		f       *os.File
+		w       modplayer.Encoder
+		written = make(map[int]bool)
+	)
+	closeFile := func() error {
+		if w == nil {
+			return nil
+		}
+		err := w.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		f, w = nil, nil
+		return err
+	}
+	defer closeFile()
+
+	generate := frameGenerator(player, channels)
+	buf := make([]int16, 4096*channels)
+	for player.IsPlaying() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := player.SamplesPlayed()
+		cuts = cuts[:0]
+		n := generate(buf)
+
+		// Write the frames up to each cut to the file that was being
+		// written, then start the file of the next section
+		pos := 0
+		for _, c := range append(cuts, cut{start + int64(n), -1}) {
+			end := int(c.frame-start) * channels
+			if w != nil {
+				if err := w.WriteFrames(buf[pos:end]); err != nil {
+					return err
+				}
+			}
+			pos = end
+			if c.section == -1 {
+				break
+			}
+
+			if err := closeFile(); err != nil {
+				return err
+			}
+			if written[c.section] {
+				continue
+			}
+			written[c.section] = true
+			var err error
+			if f, err = os.Create(name(c.section)); err != nil {
+				return err
+			}
+			if w, err = newWriter(f, f.Name(), int(player.SampleRate()), channels); err != nil {
+				f.Close()
+				f = nil
+				return err
+			}
+		}
+	}
+
+	return closeFile()
+}
+
+// frameGenerator returns a function that fills buf with frames of channels
+// samples, mono, stereo or 5.1 surround, and returns the number of frames
+// generated.
+func frameGenerator(player *modplayer.Player, channels int) func(buf []int16) int {
+	switch channels {
+	case 1:
+		var stereo []int16
+		return func(buf []int16) int {
+			if len(stereo) < len(buf)*2 {
+				stereo = make([]int16, len(buf)*2)
+			}
+			n := player.GenerateAudio(stereo[:len(buf)*2])
+			downmix(buf[:0], stereo[:n*2])
+			return n
+		}
+	case modplayer.SurroundChannels:
+		return player.GenerateSurround
+	}
+	return player.GenerateAudio
+}
+
+// Returns the mixer backend called name, or -1 if there is no such backend or
+// it isn't supported.
+func parseMixer(name string) modplayer.MixerBackend {
+	if name == modplayer.MixerAuto.String() {
+		return modplayer.MixerAuto
+	}
+	for _, b := range modplayer.MixerBackends() {
+		if b.String() == name {
+			return b
+		}
+	}
+	return -1
+}
+
+// addFilter adds a filter at cutoff Hz to effects, unless cutoff is 0.
+func addFilter(effects *modplayer.Chain, kind modplayer.FilterKind, cutoff float64) {
+	if cutoff == 0 {
+		return
+	}
+	f, err := modplayer.NewResonantFilter(kind, uint(*flagHz), cutoff, math.Sqrt2/2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	effects.Add(f)
 }
@@ -0,0 +1,33 @@
+package comb
+
+// Most samples Effect passes to the Reverber at a time. CombFixed's output
+// depends on how its input is split, this matches how modwav always fed it.
+const effectChunk = 2048
+
+// Effect adapts a Reverber to the Process method of modplayer.Effect, so that
+// reverb can be part of an effect chain.
+type Effect struct {
+	r Reverber
+}
+
+// NewEffect returns an Effect that applies r.
+func NewEffect(r Reverber) *Effect {
+	return &Effect{r: r}
+}
+
+// Process applies the reverb to in and writes the result to out, which must
+// be the same length. in and out may be the same slice. Audio the reverb is
+// still holding back is silent.
+func (e *Effect) Process(in, out []int16) {
+	written := 0
+	for len(in) > 0 && written < len(out) {
+		n := e.r.InputSamples(in[:min(len(in), effectChunk)])
+		in = in[n:]
+		m := e.r.GetAudio(out[written:min(len(out), written+effectChunk)])
+		written += m
+		if n == 0 && m == 0 {
+			break // the reverb is stuck
+		}
+	}
+	clear(out[written:])
+}
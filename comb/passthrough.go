@@ -1,31 +1,24 @@
-package config
+package comb
 
-import (
-	"fmt"
-
-	"github.com/chriskillpack/modplayer/internal/comb"
-)
-
-// ReverbPassThrough implements comb.Reverber but does nothing to do the audio
-// data.
-type ReverbPassThrough struct {
+// PassThrough implements Reverber but does nothing to the audio data.
+type PassThrough struct {
 	audio             []int16
 	bufSize           int
 	readPos, writePos int
 	n                 int
 }
 
-var _ comb.Reverber = &ReverbPassThrough{}
+var _ Reverber = &PassThrough{}
 
-// NewPassThrough creates a new instance of ReverbPassThrough
-func NewPassThrough(bufferSize int) *ReverbPassThrough {
-	return &ReverbPassThrough{
+// NewPassThrough creates a new instance of PassThrough
+func NewPassThrough(bufferSize int) *PassThrough {
+	return &PassThrough{
 		audio:   make([]int16, bufferSize),
 		bufSize: bufferSize,
 	}
 }
 
-func (r *ReverbPassThrough) InputSamples(in []int16) int {
+func (r *PassThrough) InputSamples(in []int16) int {
 	// How much can the buffer take?
 	free := r.bufSize - r.n
 	n := len(in)
@@ -54,7 +47,7 @@ func (r *ReverbPassThrough) InputSamples(in []int16) int {
 	return n
 }
 
-func (r *ReverbPassThrough) GetAudio(out []int16) int {
+func (r *PassThrough) GetAudio(out []int16) int {
 	n := len(out)
 	if n > r.n {
 		n = r.n
@@ -81,32 +74,3 @@ func (r *ReverbPassThrough) GetAudio(out []int16) int {
 
 	return n
 }
-
-// ReverbFromFlag initializes an instance of comb.Reverber according to the
-// command line flag value.
-func ReverbFromFlag(reverb string, sampleRate int) (r comb.Reverber, err error) {
-	rf := float32(0.2)
-	rd := 150
-	switch reverb {
-	case "medium":
-		rf = 0.3
-		rd = 250
-	case "silly":
-		rf = 0.5
-		rd = 2500
-	case "none":
-		rd = 0.0
-		rf = 0
-	case "light":
-	default:
-		err = fmt.Errorf("unrecognized reverb setting %q", reverb)
-	}
-
-	if rf == 0 {
-		r = NewPassThrough(10 * 1024)
-	} else {
-		r = comb.NewCombFixed(10*1024, rf, rd, sampleRate)
-	}
-
-	return r, err
-}
@@ -0,0 +1,148 @@
+package comb
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bufferSize is the amount of audio, in samples, the built-in presets can
+// hold on top of their reverb delay.
+const bufferSize = 10 * 1024
+
+// Constructor creates a Reverber for audio at the given sample rate.
+type Constructor func(sampleRate int) Reverber
+
+// Preset is the parameters of a CombFixed reverb.
+type Preset struct {
+	Decay   float32 // level of each echo relative to the last, 0 to 1
+	DelayMs int     // time between echoes in milliseconds
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Constructor{
+		"none": func(sampleRate int) Reverber { return NewPassThrough(bufferSize) },
+	}
+	presets = map[string]Preset{}
+)
+
+func init() {
+	RegisterPreset("light", Preset{Decay: 0.2, DelayMs: 150})
+	RegisterPreset("medium", Preset{Decay: 0.3, DelayMs: 250})
+	RegisterPreset("silly", Preset{Decay: 0.5, DelayMs: 2500})
+}
+
+// Constructor returns a Constructor for the preset's reverb.
+func (p Preset) Constructor() Constructor {
+	return func(sampleRate int) Reverber {
+		return NewCombFixed(bufferSize, p.Decay, p.DelayMs, sampleRate)
+	}
+}
+
+// String returns the preset in the format read by ParsePreset.
+func (p Preset) String() string {
+	return fmt.Sprintf("decay=%g,delay=%d", p.Decay, p.DelayMs)
+}
+
+// ParsePreset reads a preset from a config string of comma separated
+// key=value settings, decay and delay in milliseconds, e.g.
+// "decay=0.4,delay=300". Both settings are required.
+func ParsePreset(s string) (Preset, error) {
+	var p Preset
+	var haveDecay, haveDelay bool
+	for _, setting := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return Preset{}, fmt.Errorf("invalid reverb setting %q, expected key=value", setting)
+		}
+		switch key {
+		case "decay":
+			d, err := strconv.ParseFloat(value, 32)
+			if err != nil || d < 0 || d >= 1 {
+				return Preset{}, fmt.Errorf("invalid reverb decay %q", value)
+			}
+			p.Decay, haveDecay = float32(d), true
+		case "delay":
+			d, err := strconv.Atoi(value)
+			if err != nil || d <= 0 {
+				return Preset{}, fmt.Errorf("invalid reverb delay %q", value)
+			}
+			p.DelayMs, haveDelay = d, true
+		default:
+			return Preset{}, fmt.Errorf("unrecognized reverb setting %q", key)
+		}
+	}
+	if !haveDecay || !haveDelay {
+		return Preset{}, fmt.Errorf("invalid reverb %q, decay and delay are required", s)
+	}
+
+	return p, nil
+}
+
+// RegisterPreset makes a named reverb preset available to New and
+// LookupPreset. Registering an existing name replaces it.
+func RegisterPreset(name string, p Preset) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[name] = p.Constructor()
+	presets[name] = p
+}
+
+// LookupPreset returns the parameters of the named preset, or false if name
+// is not a preset registered with RegisterPreset.
+func LookupPreset(name string) (Preset, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	p, ok := presets[name]
+	return p, ok
+}
+
+// Register makes a named reverb available to New. Registering an existing
+// name replaces it.
+func Register(name string, c Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[name] = c
+	delete(presets, name)
+}
+
+// New creates an instance of the named reverb. The built-in names are none,
+// light, medium and silly. A name that isn't registered but contains an = is
+// read as a custom preset with ParsePreset.
+func New(name string, sampleRate int) (Reverber, error) {
+	registryMu.RLock()
+	c, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		if !strings.Contains(name, "=") {
+			return nil, fmt.Errorf("unrecognized reverb setting %q", name)
+		}
+		p, err := ParsePreset(name)
+		if err != nil {
+			return nil, err
+		}
+		c = p.Constructor()
+	}
+	return c(sampleRate), nil
+}
+
+// Names returns the names of all the registered reverbs in sorted order.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
@@ -0,0 +1,85 @@
+package modplayer
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Compressor is an Effect that evens out the loudness of the audio, turning
+// down the parts that are louder than a threshold. It's useful for songs with
+// very quiet and very loud sections. Both stereo channels are turned down
+// together so the stereo image doesn't move.
+type Compressor struct {
+	threshold float64 // level compression starts at, 1 is full scale
+	slope     float64 // 1/ratio - 1, the power the level over the threshold is raised to
+	attack    float64 // envelope coefficient when the level rises
+	release   float64 // envelope coefficient when the level falls
+	makeup    float64 // gain applied after compression
+	env       float64 // smoothed peak level of the audio
+}
+
+// NewCompressor returns a Compressor for audio at sampleRate. Audio louder
+// than threshold dB, which is 0 or below, has its level over the threshold
+// divided by ratio. A ratio of 1 leaves the audio alone, math.Inf(1) makes the
+// compressor a limiter. attack and release are how quickly the compressor
+// reacts to the audio getting louder and quieter.
+func NewCompressor(sampleRate uint, threshold, ratio float64, attack, release time.Duration) (*Compressor, error) {
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if threshold > 0 || math.IsNaN(threshold) {
+		return nil, fmt.Errorf("invalid compressor threshold %gdB", threshold)
+	}
+	if ratio < 1 || math.IsNaN(ratio) {
+		return nil, fmt.Errorf("invalid compressor ratio %g", ratio)
+	}
+	if attack < 0 || release < 0 {
+		return nil, fmt.Errorf("invalid compressor attack %s or release %s", attack, release)
+	}
+
+	return &Compressor{
+		threshold: math.Pow(10, threshold/20),
+		slope:     1/ratio - 1,
+		attack:    envelopeCoeff(attack, sampleRate),
+		release:   envelopeCoeff(release, sampleRate),
+		makeup:    1,
+	}, nil
+}
+
+// Returns the coefficient of a one pole smoother that takes d to move most of
+// the way to a new level
+func envelopeCoeff(d time.Duration, sampleRate uint) float64 {
+	if d == 0 {
+		return 1
+	}
+	return 1 - math.Exp(-1/(d.Seconds()*float64(sampleRate)))
+}
+
+// SetMakeup sets the gain in dB applied after compression, to bring the
+// quieter audio back up. The default is 0. Samples that are pushed past full
+// scale are clamped.
+func (c *Compressor) SetMakeup(gain float64) {
+	c.makeup = math.Pow(10, gain/20)
+}
+
+// Process compresses in and writes the result to out.
+func (c *Compressor) Process(in, out []int16) {
+	for i := 0; i+1 < len(in); i += 2 {
+		l, r := float64(in[i])/32768, float64(in[i+1])/32768
+
+		peak := max(math.Abs(l), math.Abs(r))
+		if peak > c.env {
+			c.env += (peak - c.env) * c.attack
+		} else {
+			c.env += (peak - c.env) * c.release
+		}
+
+		gain := c.makeup
+		if c.env > c.threshold {
+			gain *= math.Pow(c.env/c.threshold, c.slope)
+		}
+		out[i] = int16(clamp(math.Round(l*gain*32768), -32768, 32767))
+		out[i+1] = int16(clamp(math.Round(r*gain*32768), -32768, 32767))
+	}
+}
@@ -0,0 +1,173 @@
+package modplayer
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// FadeCurve selects how the volumes of the two players change during a
+// crossfade, see Crossfader.
+type FadeCurve int
+
+const (
+	FadeLinear     FadeCurve = iota // Volumes change linearly, there is a dip in loudness halfway through
+	FadeEqualPower                  // Volumes follow a quarter sine wave, the loudness stays constant
+)
+
+// A Crossfader mixes the output of two Players to fade from one to the other,
+// for transitions between songs in a playlist or between pieces of
+// interactive music. Either Player can be nil, which fades in from or out to
+// silence. The Players must share the Crossfader's sample rate and should be
+// started before they are faded to. Only the Crossfader generates audio for
+// the Players it is fading, GenerateAudio must not be called on them directly.
+//
+// It is safe to call FadeTo from a different goroutine to the one generating
+// audio.
+type Crossfader struct {
+	sampleRate uint
+
+	mu     sync.Mutex
+	from   *Player // player being faded out, or the only player when not fading
+	to     *Player // player being faded in
+	curve  FadeCurve
+	length int // length of the fade in samples
+	pos    int // progress through the fade in samples, equal to length when not fading
+
+	scratch []int16
+}
+
+// NewCrossfader returns a Crossfader that plays p, which may be nil for
+// silence, at sampleRate.
+func NewCrossfader(p *Player, sampleRate uint) (*Crossfader, error) {
+	if p != nil && p.SampleRate() != sampleRate {
+		return nil, fmt.Errorf("player sample rate %d does not match %d", p.SampleRate(), sampleRate)
+	}
+
+	return &Crossfader{sampleRate: sampleRate, from: p}, nil
+}
+
+// FadeTo fades from the Player currently playing to p, which may be nil to
+// fade out to silence, over d using curve. A d of 0 switches immediately. If
+// a fade is already in progress it is finished immediately, so that the new
+// fade starts from the Player that was being faded in.
+//
+// The Player faded out is no longer used by the Crossfader once the fade
+// finishes, it is not stopped.
+func (c *Crossfader) FadeTo(p *Player, d time.Duration, curve FadeCurve) error {
+	if p != nil && p.SampleRate() != c.sampleRate {
+		return fmt.Errorf("player sample rate %d does not match %d", p.SampleRate(), c.sampleRate)
+	}
+	if d < 0 {
+		return fmt.Errorf("invalid fade duration %v", d)
+	}
+	if curve != FadeLinear && curve != FadeEqualPower {
+		return fmt.Errorf("invalid fade curve %d", curve)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pos < c.length {
+		c.from = c.to
+	}
+	c.to = p
+	c.curve = curve
+	c.length = int(math.Round(d.Seconds() * float64(c.sampleRate)))
+	c.pos = 0
+	if c.length == 0 {
+		c.from, c.to = p, nil
+	}
+	return nil
+}
+
+// Current returns the Player being faded in, or the Player playing if no fade
+// is in progress. It returns nil when playing silence.
+func (c *Crossfader) Current() *Player {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pos < c.length {
+		return c.to
+	}
+	return c.from
+}
+
+// Fading reports whether a fade is in progress.
+func (c *Crossfader) Fading() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pos < c.length
+}
+
+// GenerateAudio fills out with stereo sample data (LRLRLR...) mixed from the
+// Players and returns the number of stereo samples that either Player
+// generated. The rest of out is filled with silence, a return value of 0
+// means that neither Player is playing.
+func (c *Crossfader) GenerateAudio(out []int16) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	nSamples := len(out) / 2
+	clear(out)
+
+	generated := 0
+	if c.pos >= c.length {
+		if c.from != nil {
+			generated = c.from.GenerateAudio(out)
+		}
+		return generated
+	}
+
+	// Fade the outgoing player out and the incoming player in. Only as much
+	// as the fade needs is mixed, the rest of out plays the incoming player.
+	n := min(nSamples, c.length-c.pos)
+	if cap(c.scratch) < n*2 {
+		c.scratch = make([]int16, n*2)
+	}
+	pos := c.pos
+	if c.from != nil {
+		generated = c.mixFade(out[:n*2], c.from, pos, false)
+	}
+	if c.to != nil {
+		generated = max(generated, c.mixFade(out[:n*2], c.to, pos, true))
+	}
+	c.pos += n
+
+	if c.pos == c.length {
+		c.from, c.to = c.to, nil
+		if n < nSamples && c.from != nil {
+			generated = n + c.from.GenerateAudio(out[n*2:])
+		}
+	}
+	return generated
+}
+
+// Generates audio from p and adds it to out at the volume of the fade at pos,
+// where in selects the incoming volume. Returns the number of stereo samples
+// p generated.
+func (c *Crossfader) mixFade(out []int16, p *Player, pos int, in bool) int {
+	buf := c.scratch[:len(out)]
+	n := p.GenerateAudio(buf)
+	for i := 0; i < n; i++ {
+		g := c.gain(pos+i, in)
+		out[i*2] = clampSample(int(out[i*2]) + int(float64(buf[i*2])*g))
+		out[i*2+1] = clampSample(int(out[i*2+1]) + int(float64(buf[i*2+1])*g))
+	}
+	return n
+}
+
+// Returns the volume, 0 to 1, of the incoming (in is true) or outgoing player
+// at pos samples into the fade.
+func (c *Crossfader) gain(pos int, in bool) float64 {
+	t := float64(pos) / float64(c.length)
+	if !in {
+		t = 1 - t
+	}
+	if c.curve == FadeEqualPower {
+		return math.Sin(t * math.Pi / 2)
+	}
+	return t
+}
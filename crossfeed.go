@@ -0,0 +1,89 @@
+package modplayer
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	DefaultCrossfeedCutoff = 700 // Hz
+	DefaultCrossfeedLevel  = 4.5 // dB
+
+	crossfeedDelay = 250 * time.Microsecond // time for sound to reach the far ear
+)
+
+// Crossfeed is an Effect that makes hard panned songs, like most 4 channel
+// MODs, comfortable to listen to on headphones. Through speakers each ear
+// hears both speakers, the far one a little later and with its high
+// frequencies shadowed by the head. Crossfeed recreates that by mixing a
+// delayed and lowpass filtered copy of each channel into the other, in the
+// style of Bauer's stereophonic-to-binaural filter (bs2b).
+type Crossfeed struct {
+	// Lowpass filter of the copy fed to the other channel
+	loA0, loB1 float64
+	// High boost of each channel, to compensate for the bass the crossfeed
+	// adds
+	hiA0, hiA1, hiB1 float64
+	gain             float64 // keeps the overall level the same
+
+	// Filter state of each stereo channel
+	lo, hi, last [2]float64
+	delay        [2][]float64 // ring of recent lowpass filter output
+	head         int          // index in delay of the oldest output
+}
+
+// NewCrossfeed returns a Crossfeed for audio at sampleRate. Frequencies below
+// cutoff Hz, 300 to 2000, are fed to the other channel at level dB, 1 to 15,
+// below the channel. Higher levels narrow the stereo image more.
+// DefaultCrossfeedCutoff and DefaultCrossfeedLevel suit most songs.
+func NewCrossfeed(sampleRate uint, cutoff, level float64) (*Crossfeed, error) {
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if cutoff < 300 || cutoff > 2000 || cutoff >= float64(sampleRate)/2 || math.IsNaN(cutoff) {
+		return nil, fmt.Errorf("invalid crossfeed cutoff %gHz", cutoff)
+	}
+	if level < 1 || level > 15 || math.IsNaN(level) {
+		return nil, fmt.Errorf("invalid crossfeed level %gdB", level)
+	}
+
+	// Filter gains and the high boost cutoff from bs2b
+	gainLo := math.Pow(10, (-5*level/6-3)/20)
+	gainHi := 1 - math.Pow(10, (level/6-3)/20)
+	cutoffHi := cutoff * math.Pow(2, (-5*level/6-3-20*math.Log10(gainHi))/12)
+
+	xLo := math.Exp(-2 * math.Pi * cutoff / float64(sampleRate))
+	xHi := math.Exp(-2 * math.Pi * cutoffHi / float64(sampleRate))
+	n := max(int(math.Round(crossfeedDelay.Seconds()*float64(sampleRate))), 1)
+	return &Crossfeed{
+		loA0:  gainLo * (1 - xLo),
+		loB1:  xLo,
+		hiA0:  1 - gainHi*(1-xHi),
+		hiA1:  -xHi,
+		hiB1:  xHi,
+		gain:  1 / (1 - gainHi + gainLo),
+		delay: [2][]float64{make([]float64, n), make([]float64, n)},
+	}, nil
+}
+
+// Process crossfeeds in and writes the result to out.
+func (f *Crossfeed) Process(in, out []int16) {
+	for i := 0; i+1 < len(in); i += 2 {
+		for c := 0; c < 2; c++ {
+			x := float64(in[i+c]) / 32768
+			f.lo[c] = f.loA0*x + f.loB1*f.lo[c]
+			f.hi[c] = f.hiA0*x + f.hiA1*f.last[c] + f.hiB1*f.hi[c]
+			f.last[c] = x
+		}
+
+		// Each channel hears the other after the delay
+		l := f.hi[0] + f.delay[1][f.head]
+		r := f.hi[1] + f.delay[0][f.head]
+		f.delay[0][f.head], f.delay[1][f.head] = f.lo[0], f.lo[1]
+		f.head = (f.head + 1) % len(f.delay[0])
+
+		out[i] = int16(clamp(math.Round(l*f.gain*32768), -32768, 32767))
+		out[i+1] = int16(clamp(math.Round(r*f.gain*32768), -32768, 32767))
+	}
+}
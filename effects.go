@@ -0,0 +1,64 @@
+package modplayer
+
+// Effect processes stereo audio (LRLRLR...), such as a reverb, EQ or limiter.
+// Effects are stacked with a Chain and attached to a Player with SetEffect.
+type Effect interface {
+	// Process writes the processed audio of in to out, which is the same
+	// length. in and out may be the same slice. Process is called with the
+	// audio in order, so effects can keep state from one call to the next.
+	Process(in, out []int16)
+}
+
+// EffectFunc adapts an ordinary function to an Effect.
+type EffectFunc func(in, out []int16)
+
+// Process calls f(in, out).
+func (f EffectFunc) Process(in, out []int16) {
+	f(in, out)
+}
+
+// Chain is an Effect that runs a list of effects one after another, the output
+// of each effect feeding the next. An empty Chain passes the audio through
+// unchanged.
+type Chain struct {
+	effects []Effect
+}
+
+// NewChain returns a Chain of effects, run in the order given.
+func NewChain(effects ...Effect) *Chain {
+	return &Chain{effects: effects}
+}
+
+// Add appends e to the end of the chain.
+func (c *Chain) Add(e Effect) {
+	c.effects = append(c.effects, e)
+}
+
+// Len returns the number of effects in the chain.
+func (c *Chain) Len() int {
+	return len(c.effects)
+}
+
+// Process runs the effects of the chain over in and writes the result to out.
+func (c *Chain) Process(in, out []int16) {
+	if len(c.effects) == 0 {
+		copy(out, in)
+		return
+	}
+
+	c.effects[0].Process(in, out)
+	for _, e := range c.effects[1:] {
+		e.Process(out, out)
+	}
+}
+
+// SetEffect attaches an effect to the player's output, use a Chain to apply
+// several. All the GenerateAudio functions, and the functions built on them
+// like RenderAll, pass the audio they generate through e. nil removes the
+// effect.
+func (p *Player) SetEffect(e Effect) {
+	p.effect = e
+	if e != nil && len(p.effectbuffer) != len(p.mixbuffer) {
+		p.effectbuffer = make([]int16, len(p.mixbuffer))
+	}
+}
@@ -0,0 +1,39 @@
+package modplayer
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Encoder writes generated audio to a file or stream in some format. The
+// writers of the wav, aiff and flac packages are Encoders, and so is the
+// writer returned by NewRawEncoder. An Encoder's WriteFrames can be passed
+// straight to Player.RenderAll.
+type Encoder interface {
+	// WriteFrames writes interleaved samples, the number of channels the
+	// encoder was created for in each frame.
+	WriteFrames(samples []int16) error
+
+	// Close writes anything that is left, e.g. the sizes in a file header.
+	// It doesn't close the underlying file.
+	Close() error
+}
+
+// rawEncoder writes headerless 16-bit little-endian PCM.
+type rawEncoder struct {
+	w io.Writer
+}
+
+// NewRawEncoder returns an Encoder that writes headerless 16-bit
+// little-endian PCM to w, which can be a pipe to another program.
+func NewRawEncoder(w io.Writer) Encoder {
+	return rawEncoder{w}
+}
+
+func (r rawEncoder) WriteFrames(samples []int16) error {
+	return binary.Write(r.w, binary.LittleEndian, samples)
+}
+
+func (r rawEncoder) Close() error {
+	return nil
+}
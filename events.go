@@ -0,0 +1,87 @@
+package modplayer
+
+import "sync"
+
+// Event describes the pattern data of a channel as its row starts playing,
+// see Player.Subscribe.
+type Event struct {
+	Sample  int64 // Position in the generated audio where the row starts, in samples
+	Order   int
+	Row     int
+	Channel int
+	ChannelNoteData
+}
+
+// Subscribers to the event stream
+type subscribers struct {
+	mu    sync.Mutex
+	chans []chan Event
+}
+
+// Subscribe returns a channel that receives an Event for every non-empty
+// pattern cell as it is played. Events are sent as the audio is generated, and
+// Event.Sample gives the exact position in the generated audio so that it can
+// be matched to the audio that is output.
+//
+// The channel has room for n events. GenerateAudio never blocks on a
+// subscriber, events that don't fit are dropped. Events are not sent while
+// the player is seeking. Call Unsubscribe to stop receiving events.
+//
+// It is safe to call Subscribe and Unsubscribe from a different goroutine to
+// the one generating audio.
+func (p *Player) Subscribe(n int) <-chan Event {
+	ch := make(chan Event, n)
+
+	p.subscribers.mu.Lock()
+	p.subscribers.chans = append(p.subscribers.chans, ch)
+	p.subscribers.mu.Unlock()
+
+	return ch
+}
+
+// Unsubscribe stops events being sent to a channel returned by Subscribe and
+// closes it.
+func (p *Player) Unsubscribe(ch <-chan Event) {
+	p.subscribers.mu.Lock()
+	defer p.subscribers.mu.Unlock()
+
+	for i, c := range p.subscribers.chans {
+		if c == ch {
+			p.subscribers.chans = append(p.subscribers.chans[:i], p.subscribers.chans[i+1:]...)
+			close(c)
+			return
+		}
+	}
+}
+
+// Sends the events for the row that was just processed to the subscribers.
+func (p *Player) sendEvents(order, row int) {
+	p.subscribers.mu.Lock()
+	defer p.subscribers.mu.Unlock()
+
+	if len(p.subscribers.chans) == 0 || p.replaying {
+		return
+	}
+
+	pattern := p.Song.patterns[p.Orders[order]]
+	for ci := 0; ci < p.Song.Channels; ci++ {
+		n := &pattern[row*p.Song.Channels+ci]
+		if n.Pitch == 0 && n.Sample == 0 && n.Volume == noNoteVolume && n.Effect == 0 {
+			continue
+		}
+
+		ev := Event{
+			Sample:          p.samplesPlayed,
+			Order:           order,
+			Row:             row,
+			Channel:         ci,
+			ChannelNoteData: n.noteData(),
+		}
+		for _, c := range p.subscribers.chans {
+			select {
+			case c <- ev:
+			default:
+			}
+		}
+	}
+}
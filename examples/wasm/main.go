@@ -0,0 +1,93 @@
+//go:build js && wasm
+
+// Plays MOD and S3M files in the browser. The player runs on the page's main
+// thread and an AudioWorklet pulls the audio from it, see index.html.
+//
+// Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o modplayer.wasm ./examples/wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" examples/wasm
+//
+// and serve the examples/wasm directory with any web server.
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"syscall/js"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+var (
+	player      *modplayer.Player
+	left, right []float32
+	scratch     []byte
+)
+
+func main() {
+	js.Global().Set("modLoad", js.FuncOf(load))
+	js.Global().Set("modFill", js.FuncOf(fill))
+
+	select {} // keep the functions available to JavaScript
+}
+
+// modLoad(name, bytes, sampleRate) loads a song from a Uint8Array and starts
+// playing it. Returns an error message, or null on success.
+func load(this js.Value, args []js.Value) any {
+	data := make([]byte, args[1].Length())
+	js.CopyBytesToGo(data, args[1])
+
+	var song *modplayer.Song
+	var err error
+	if name := args[0].String(); len(name) > 4 && name[len(name)-4:] == ".s3m" {
+		song, err = modplayer.NewS3MSongFromBytes(data)
+	} else {
+		song, err = modplayer.NewMODSongFromBytes(data)
+	}
+	if err != nil {
+		return err.Error()
+	}
+
+	player, err = modplayer.NewPlayer(song, uint(args[2].Int()))
+	if err != nil {
+		return err.Error()
+	}
+	return nil
+}
+
+// modFill(left, right) fills two Float32Arrays, a multiple of 128 samples
+// long, with the next samples of the song. Returns the number of samples
+// generated, which is 0 when the song has ended.
+func fill(this js.Value, args []js.Value) any {
+	if player == nil {
+		return 0
+	}
+
+	n := args[0].Length()
+	if len(left) < n {
+		left, right = make([]float32, n), make([]float32, n)
+		scratch = make([]byte, n*4)
+	}
+
+	generated := 0
+	for generated < n && player.IsPlaying() {
+		end := min(generated+modplayer.RenderQuantum, n)
+		generated += player.GenerateAudioFloat32(left[generated:end], right[generated:end])
+	}
+
+	copyFloats(args[0], left[:generated])
+	copyFloats(args[1], right[:generated])
+	return generated
+}
+
+// Copies samples into the Float32Array dst. syscall/js can only copy bytes, so
+// the samples are copied through a byte view of the array.
+func copyFloats(dst js.Value, samples []float32) {
+	buf := scratch[:len(samples)*4]
+	for i, s := range samples {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(s))
+	}
+	view := js.Global().Get("Uint8Array").New(dst.Get("buffer"), dst.Get("byteOffset"), len(buf))
+	js.CopyBytesToJS(view, buf)
+}
@@ -0,0 +1,44 @@
+// Plays the audio generated by the Go player. The main thread posts chunks of
+// samples to the worklet and the worklet asks for more when it runs low.
+class ModPlayerProcessor extends AudioWorkletProcessor {
+  constructor() {
+    super();
+    this.chunks = [];
+    this.offset = 0;
+    this.queued = 0;
+    this.requested = false;
+    this.port.onmessage = (e) => {
+      this.requested = false;
+      if (e.data.left.length > 0) {
+        this.chunks.push(e.data);
+        this.queued += e.data.left.length;
+      }
+    };
+  }
+
+  process(inputs, outputs) {
+    const [left, right] = outputs[0];
+    let i = 0;
+    while (i < left.length && this.chunks.length > 0) {
+      const chunk = this.chunks[0];
+      const n = Math.min(left.length - i, chunk.left.length - this.offset);
+      left.set(chunk.left.subarray(this.offset, this.offset + n), i);
+      right.set(chunk.right.subarray(this.offset, this.offset + n), i);
+      i += n;
+      this.offset += n;
+      this.queued -= n;
+      if (this.offset === chunk.left.length) {
+        this.chunks.shift();
+        this.offset = 0;
+      }
+    }
+
+    if (this.queued < 4096 && !this.requested) {
+      this.requested = true;
+      this.port.postMessage("more");
+    }
+    return true;
+  }
+}
+
+registerProcessor("modplayer", ModPlayerProcessor);
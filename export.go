@@ -0,0 +1,71 @@
+package modplayer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/chriskillpack/modplayer/wav"
+)
+
+// WriteWAV writes the sample data as a 16-bit mono WAV file to ws, at the
+// sample's middle C rate, C4Speed. A looping sample has its loop written to
+// the file's smpl chunk so that samplers loop it.
+func (s *Sample) WriteWAV(ws io.WriteSeeker) error {
+	if s.C4Speed <= 0 {
+		return fmt.Errorf("invalid sample rate %d", s.C4Speed)
+	}
+	w, err := wav.NewMonoWriter(ws, s.C4Speed)
+	if err != nil {
+		return err
+	}
+	if s.LoopLen > 0 {
+		if err := w.SetLoop(int64(s.LoopStart), int64(s.LoopStart+s.LoopLen)); err != nil {
+			return err
+		}
+	}
+
+	data := make([]int16, len(s.Data))
+	for i, sd := range s.Data {
+		data[i] = int16(sd) << 8
+	}
+	if err := w.WriteFrame(data); err != nil {
+		return err
+	}
+	_, err = w.Finish()
+	return err
+}
+
+// ExportSamples writes each sample of the song that has data to dir as a WAV
+// file, see Sample.WriteWAV. The files are named after the sample numbers,
+// 01.wav onwards.
+func (s *Song) ExportSamples(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for i := range s.Samples {
+		sample := &s.Samples[i]
+		if len(sample.Data) == 0 || sample.C4Speed <= 0 {
+			continue
+		}
+		if err := writeSampleFile(sample, filepath.Join(dir, fmt.Sprintf("%02d.wav", i+1))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSampleFile(sample *Sample, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := sample.WriteWAV(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,121 @@
+package modplayer
+
+import (
+	"fmt"
+	"math"
+)
+
+// FilterKind is the frequencies a Filter lets through.
+type FilterKind int
+
+const (
+	LowPass  FilterKind = iota // pass frequencies below the cutoff
+	HighPass                   // pass frequencies above the cutoff
+)
+
+func (k FilterKind) String() string {
+	switch k {
+	case LowPass:
+		return "lowpass"
+	case HighPass:
+		return "highpass"
+	}
+	return fmt.Sprintf("FilterKind(%d)", int(k))
+}
+
+// Filter is an Effect that removes the frequencies above or below a cutoff,
+// to shape the tone of the audio.
+type Filter struct {
+	biquad
+}
+
+// NewFilter returns a first order Filter for audio at sampleRate, which rolls
+// off gently at 6dB per octave past cutoff Hz.
+func NewFilter(kind FilterKind, sampleRate uint, cutoff float64) (*Filter, error) {
+	if err := checkFilter(kind, sampleRate, cutoff); err != nil {
+		return nil, err
+	}
+
+	// Bilinear transform of a one pole filter
+	k := math.Tan(math.Pi * cutoff / float64(sampleRate))
+	f := &Filter{}
+	f.a1 = (k - 1) / (k + 1)
+	if kind == LowPass {
+		f.b0 = k / (k + 1)
+		f.b1 = f.b0
+	} else {
+		f.b0 = 1 / (k + 1)
+		f.b1 = -f.b0
+	}
+	return f, nil
+}
+
+// NewResonantFilter returns a second order Filter for audio at sampleRate,
+// which rolls off at 12dB per octave past cutoff Hz. resonance boosts the
+// frequencies around the cutoff, math.Sqrt2/2 gives the flattest response
+// and higher values make the filter ring.
+func NewResonantFilter(kind FilterKind, sampleRate uint, cutoff, resonance float64) (*Filter, error) {
+	if err := checkFilter(kind, sampleRate, cutoff); err != nil {
+		return nil, err
+	}
+	if resonance <= 0 || math.IsNaN(resonance) {
+		return nil, fmt.Errorf("invalid filter resonance %g", resonance)
+	}
+
+	// Low and high pass filters from the Audio EQ Cookbook
+	w := 2 * math.Pi * cutoff / float64(sampleRate)
+	cos := math.Cos(w)
+	alpha := math.Sin(w) / (2 * resonance)
+	a0 := 1 + alpha
+
+	f := &Filter{}
+	if kind == LowPass {
+		f.b0 = (1 - cos) / 2 / a0
+		f.b1 = (1 - cos) / a0
+	} else {
+		f.b0 = (1 + cos) / 2 / a0
+		f.b1 = -(1 + cos) / a0
+	}
+	f.b2 = f.b0
+	f.a1 = -2 * cos / a0
+	f.a2 = (1 - alpha) / a0
+	return f, nil
+}
+
+func checkFilter(kind FilterKind, sampleRate uint, cutoff float64) error {
+	if kind != LowPass && kind != HighPass {
+		return fmt.Errorf("invalid filter kind %s", kind)
+	}
+	if sampleRate == 0 {
+		return fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if cutoff <= 0 || cutoff >= float64(sampleRate)/2 || math.IsNaN(cutoff) {
+		return fmt.Errorf("invalid filter cutoff %gHz", cutoff)
+	}
+	return nil
+}
+
+// Process filters in and writes the result to out.
+func (f *Filter) Process(in, out []int16) {
+	for i, s := range in {
+		y := f.process(float64(s)/32768, i&1)
+		out[i] = int16(clamp(math.Round(y*32768), -32768, 32767))
+	}
+}
+
+// A biquad filter applied to each stereo channel, the building block of the
+// filter effects
+type biquad struct {
+	b0, b1, b2, a1, a2 float64 // coefficients, normalized by a0
+
+	// Filter state of each stereo channel
+	x1, x2, y1, y2 [2]float64
+}
+
+// Filters x, the next sample of channel c, and returns the result.
+func (f *biquad) process(x float64, c int) float64 {
+	y := f.b0*x + f.b1*f.x1[c] + f.b2*f.x2[c] - f.a1*f.y1[c] - f.a2*f.y2[c]
+	f.x2[c], f.x1[c] = f.x1[c], x
+	f.y2[c], f.y1[c] = f.y1[c], y
+	return y
+}
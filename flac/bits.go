@@ -0,0 +1,100 @@
+package flac
+
+// Writes a FLAC frame one bit field at a time, most significant bit first.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64 // bits not yet in buf, in the low n bits
+	nacc uint
+}
+
+func (b *bitWriter) reset() {
+	b.buf, b.acc, b.nacc = b.buf[:0], 0, 0
+}
+
+// Writes the low n bits of v, n at most 32.
+func (b *bitWriter) write(v uint64, n uint) {
+	b.acc = b.acc<<n | v&(1<<n-1)
+	b.nacc += n
+	for b.nacc >= 8 {
+		b.nacc -= 8
+		b.buf = append(b.buf, byte(b.acc>>b.nacc))
+	}
+}
+
+// Writes v as an n bit two's complement number.
+func (b *bitWriter) writeSigned(v int32, n int) {
+	b.write(uint64(uint32(v)), uint(n))
+}
+
+// Writes r Rice coded with parameter k: the high bits of the zigzagged value
+// in unary, then its k low bits.
+func (b *bitWriter) writeRice(r int32, k int) {
+	u := zigzag(r)
+	for q := u >> k; q > 0; {
+		n := min(q, 32)
+		b.write(0, uint(n))
+		q -= n
+	}
+	b.write(1, 1)
+	b.write(uint64(u), uint(k))
+}
+
+// Writes v in the extended UTF-8 coding of frame numbers.
+func (b *bitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		b.write(v, 8)
+		return
+	}
+	n := 2 // bytes
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	b.write(uint64(0xFF00>>n)&0xFF|v>>(6*(n-1)), 8)
+	for i := n - 2; i >= 0; i-- {
+		b.write(0x80|(v>>(6*i))&0x3F, 8)
+	}
+}
+
+// Pads the last byte with zero bits.
+func (b *bitWriter) align() {
+	if b.nacc > 0 {
+		b.write(0, 8-b.nacc)
+	}
+}
+
+// Returns the complete bytes written.
+func (b *bitWriter) bytes() []byte {
+	return b.buf
+}
+
+// CRC-8 of a frame header, polynomial x^8 + x^2 + x + 1.
+func crc8(data []byte) byte {
+	var crc byte
+	for _, d := range data {
+		crc ^= d
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// CRC-16 of a frame, polynomial x^16 + x^15 + x^2 + 1.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, d := range data {
+		crc ^= uint16(d) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
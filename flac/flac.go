@@ -0,0 +1,381 @@
+// Package flac is a simple FLAC file writer, for lossless audio that takes
+// around half the space of a WAV file. Each block of audio is encoded with
+// the fixed predictor that suits it best and Rice coded residuals, and stereo
+// audio is encoded as mid and side channels when that is smaller. It doesn't
+// compress as well as the reference encoder, but it is fast.
+// See https://xiph.org/flac/format.html for format documentation.
+package flac
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+)
+
+const (
+	blockSize     = 4096 // frames in each FLAC frame
+	bitsPerSample = 16
+
+	streamInfoPos = 8 // offset of the STREAMINFO block, after the marker and block header
+
+	maxPartitionOrder = 4
+	maxRiceParam      = 14 // largest Rice parameter of the 4-bit parameter coding method
+)
+
+// Channel assignments of a frame
+const (
+	assignLeftSide  = 8
+	assignRightSide = 9
+	assignMidSide   = 10
+)
+
+// Subframe types
+const (
+	subframeConstant = 0
+	subframeVerbatim = 1
+	subframeFixed    = 8 // plus the predictor order
+)
+
+// A Writer writes a 16-bit FLAC file into WS
+type Writer struct {
+	WS io.WriteSeeker
+
+	sampleRate int
+	channels   int
+	block      [][]int32 // samples of the frame being filled, by channel
+	n          int       // frames in block
+	frames     uint64    // frames written to the file
+	number     uint64    // number of the next FLAC frame
+	minFrame   int       // size of the smallest FLAC frame in bytes
+	maxFrame   int       // size of the largest FLAC frame in bytes
+	md5        hash.Hash // of the audio, as the decoder will output it
+	bw         bitWriter
+	residual   []int32
+}
+
+// NewWriter returns a Writer that writes a 16-bit FLAC file of audio with
+// channels per frame at sampleRate to ws.
+func NewWriter(ws io.WriteSeeker, sampleRate, channels int) (*Writer, error) {
+	if sampleRate <= 0 || sampleRate >= 1<<20 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if channels < 1 || channels > 8 {
+		return nil, fmt.Errorf("invalid number of channels %d", channels)
+	}
+
+	w := &Writer{
+		WS:         ws,
+		sampleRate: sampleRate,
+		channels:   channels,
+		block:      make([][]int32, channels),
+		md5:        md5.New(),
+		residual:   make([]int32, blockSize),
+	}
+	for c := range w.block {
+		w.block[c] = make([]int32, blockSize)
+	}
+
+	// The stream information is filled in by Close
+	if _, err := ws.Write([]byte("fLaC")); err != nil {
+		return nil, err
+	}
+	if err := w.writeStreamInfo(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// WriteFrames writes the provided interleaved samples to w, channels per
+// frame.
+func (w *Writer) WriteFrames(samples []int16) error {
+	var le [2]byte
+	for i := 0; i+w.channels <= len(samples); i += w.channels {
+		for c := 0; c < w.channels; c++ {
+			w.block[c][w.n] = int32(samples[i+c])
+			binary.LittleEndian.PutUint16(le[:], uint16(samples[i+c]))
+			w.md5.Write(le[:])
+		}
+		if w.n++; w.n == blockSize {
+			if err := w.writeFrame(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes the last of the audio and fills in the stream information at
+// the start of the file. It doesn't close WS.
+func (w *Writer) Close() error {
+	if w.n > 0 {
+		if err := w.writeFrame(); err != nil {
+			return err
+		}
+	}
+
+	end, err := w.WS.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.WS.Seek(streamInfoPos-4, io.SeekStart); err != nil {
+		return err
+	}
+	if err := w.writeStreamInfo(); err != nil {
+		return err
+	}
+	_, err = w.WS.Seek(end, io.SeekStart)
+	return err
+}
+
+// Writes the STREAMINFO metadata block with its header.
+func (w *Writer) writeStreamInfo() error {
+	b := make([]byte, 4+34)
+	b[0] = 0x80 // the last metadata block, STREAMINFO
+	b[3] = 34
+	binary.BigEndian.PutUint16(b[4:], blockSize)
+	binary.BigEndian.PutUint16(b[6:], blockSize)
+	b[8], b[9], b[10] = byte(w.minFrame>>16), byte(w.minFrame>>8), byte(w.minFrame)
+	b[11], b[12], b[13] = byte(w.maxFrame>>16), byte(w.maxFrame>>8), byte(w.maxFrame)
+	binary.BigEndian.PutUint64(b[14:], uint64(w.sampleRate)<<44|uint64(w.channels-1)<<41|(bitsPerSample-1)<<36|w.frames&(1<<36-1))
+	if w.frames > 0 {
+		w.md5.Sum(b[22:22])
+	}
+	_, err := w.WS.Write(b)
+	return err
+}
+
+// Encodes the frames in block as a FLAC frame and writes it.
+func (w *Writer) writeFrame() error {
+	n := w.n
+	bw := &w.bw
+	bw.reset()
+
+	// Pick the smallest encoding of the channels
+	assignment := w.channels - 1
+	subframes := make([][]int32, w.channels)
+	sampleBits := make([]int, w.channels)
+	for c := range subframes {
+		subframes[c], sampleBits[c] = w.block[c][:n], bitsPerSample
+	}
+	if w.channels == 2 {
+		left, right := subframes[0], subframes[1]
+		mid, side := make([]int32, n), make([]int32, n)
+		for i := range mid {
+			mid[i], side[i] = (left[i]+right[i])>>1, left[i]-right[i]
+		}
+		l, r := w.subframeCost(left, bitsPerSample), w.subframeCost(right, bitsPerSample)
+		m, s := w.subframeCost(mid, bitsPerSample), w.subframeCost(side, bitsPerSample+1)
+		switch min(l+r, l+s, r+s, m+s) {
+		case l + s:
+			assignment = assignLeftSide
+			subframes[1], sampleBits[1] = side, bitsPerSample+1
+		case r + s:
+			assignment = assignRightSide
+			subframes[0], sampleBits[0] = side, bitsPerSample+1
+		case m + s:
+			assignment = assignMidSide
+			subframes[0], subframes[1], sampleBits[1] = mid, side, bitsPerSample+1
+		}
+	}
+
+	// Frame header
+	bw.write(0xFFF8, 16) // sync code, fixed block size
+	sizeCode := uint64(12)
+	if n != blockSize {
+		sizeCode = 7 // 16-bit block size follows
+	}
+	bw.write(sizeCode<<4|rateCode(w.sampleRate), 8)
+	bw.write(uint64(assignment)<<4|4<<1, 8) // 16 bits per sample
+	bw.writeUTF8(w.number)
+	if n != blockSize {
+		bw.write(uint64(n-1), 16)
+	}
+	bw.write(uint64(crc8(bw.bytes())), 8)
+
+	for c, sub := range subframes {
+		w.writeSubframe(sub, sampleBits[c])
+	}
+
+	bw.align()
+	bw.write(uint64(crc16(bw.bytes())), 16)
+	frame := bw.bytes()
+	if _, err := w.WS.Write(frame); err != nil {
+		return err
+	}
+
+	if w.number == 0 || len(frame) < w.minFrame {
+		w.minFrame = len(frame)
+	}
+	w.maxFrame = max(w.maxFrame, len(frame))
+	w.frames += uint64(n)
+	w.number++
+	w.n = 0
+	return nil
+}
+
+// Returns the sample rate code of the frame header for rate, 0 to use the
+// rate in the stream information.
+func rateCode(rate int) uint64 {
+	switch rate {
+	case 8000:
+		return 4
+	case 16000:
+		return 5
+	case 22050:
+		return 6
+	case 24000:
+		return 7
+	case 32000:
+		return 8
+	case 44100:
+		return 9
+	case 48000:
+		return 10
+	case 96000:
+		return 11
+	}
+	return 0
+}
+
+// Returns the size in bits of the subframe that writeSubframe would write for
+// samples, which have sampleBits bits each.
+func (w *Writer) subframeCost(samples []int32, sampleBits int) int {
+	_, cost := w.bestFixed(samples, sampleBits)
+	return cost
+}
+
+// Returns the fixed predictor order best suited to samples and the size of
+// the subframe in bits. Order -1 means a verbatim subframe is smaller.
+func (w *Writer) bestFixed(samples []int32, sampleBits int) (int, int) {
+	bestOrder, bestCost := -1, len(samples)*sampleBits
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		residual := fixedResidual(w.residual, samples, order)
+		_, cost := riceParams(residual, order, len(samples))
+		if cost += order * sampleBits; cost < bestCost {
+			bestOrder, bestCost = order, cost
+		}
+	}
+	return bestOrder, 8 + bestCost
+}
+
+// Writes a subframe of samples, which have sampleBits bits each.
+func (w *Writer) writeSubframe(samples []int32, sampleBits int) {
+	bw := &w.bw
+
+	constant := true
+	for _, s := range samples[1:] {
+		if s != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.write(subframeConstant<<1, 8)
+		bw.writeSigned(samples[0], sampleBits)
+		return
+	}
+
+	order, _ := w.bestFixed(samples, sampleBits)
+	if order < 0 {
+		bw.write(subframeVerbatim<<1, 8)
+		for _, s := range samples {
+			bw.writeSigned(s, sampleBits)
+		}
+		return
+	}
+
+	bw.write(uint64(subframeFixed+order)<<1, 8)
+	for _, s := range samples[:order] {
+		bw.writeSigned(s, sampleBits)
+	}
+	residual := fixedResidual(w.residual, samples, order)
+	partitionOrder, _ := riceParams(residual, order, len(samples))
+	bw.write(0, 2) // Rice coding with 4-bit parameters
+	bw.write(uint64(partitionOrder), 4)
+	parts := 1 << partitionOrder
+	partLen := len(samples) >> partitionOrder
+	start := 0
+	for p := 0; p < parts; p++ {
+		end := (p+1)*partLen - order // the warm-up samples have no residual
+		part := residual[start:end]
+		k, _ := riceParam(part)
+		bw.write(uint64(k), 4)
+		for _, r := range part {
+			bw.writeRice(r, k)
+		}
+		start = end
+	}
+}
+
+// Computes the residual of samples with the fixed predictor of order into
+// buf and returns it. There is no residual for the first order samples.
+func fixedResidual(buf, samples []int32, order int) []int32 {
+	r := buf[:len(samples)-order]
+	for i := order; i < len(samples); i++ {
+		x := samples[i]
+		switch order {
+		case 0:
+			r[i] = x
+		case 1:
+			r[i-1] = x - samples[i-1]
+		case 2:
+			r[i-2] = x - 2*samples[i-1] + samples[i-2]
+		case 3:
+			r[i-3] = x - 3*samples[i-1] + 3*samples[i-2] - samples[i-3]
+		case 4:
+			r[i-4] = x - 4*samples[i-1] + 6*samples[i-2] - 4*samples[i-3] + samples[i-4]
+		}
+	}
+	return r
+}
+
+// Returns the partition order that codes residual, the residual of a block
+// of n samples after order warm-up samples, in the fewest bits and the
+// number of bits.
+func riceParams(residual []int32, order, n int) (int, int) {
+	bestOrder, bestCost := 0, -1
+	for po := 0; po <= maxPartitionOrder; po++ {
+		partLen := n >> po
+		if n%(1<<po) != 0 || partLen <= order {
+			break
+		}
+		cost := 6 // coding method and partition order
+		start := 0
+		for p := 0; p < 1<<po; p++ {
+			end := (p+1)*partLen - order
+			_, c := riceParam(residual[start:end])
+			cost += 4 + c
+			start = end
+		}
+		if bestCost < 0 || cost < bestCost {
+			bestOrder, bestCost = po, cost
+		}
+	}
+	return bestOrder, bestCost
+}
+
+// Returns the Rice parameter for a partition of the residual and an estimate
+// of the number of bits it codes the partition in.
+func riceParam(part []int32) (int, int) {
+	if len(part) == 0 {
+		return 0, 0
+	}
+	var sum uint64
+	for _, r := range part {
+		sum += uint64(zigzag(r))
+	}
+	k := 0
+	if mean := sum / uint64(len(part)); mean > 0 {
+		k = min(bits.Len64(mean)-1, maxRiceParam)
+	}
+	return k, len(part)*(k+1) + int(sum>>k)
+}
+
+// Maps signed residuals to unsigned, 0, -1, 1, -2... to 0, 1, 2, 3...
+func zigzag(r int32) uint32 {
+	return uint32(r<<1) ^ uint32(r>>31)
+}
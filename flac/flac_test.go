@@ -0,0 +1,425 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// An in-memory file for the writer.
+type memFile struct {
+	buf []byte
+	pos int64
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if end := int(f.pos) + len(p); end > len(f.buf) {
+		f.buf = append(f.buf, make([]byte, end-len(f.buf))...)
+	}
+	copy(f.buf[f.pos:], p)
+	f.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.buf))
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Reads a FLAC stream one bit field at a time, most significant bit first.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *bitReader) read(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		v = v<<1 | uint64(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) readSigned(n int) int32 {
+	return int32(int64(r.read(n)<<(64-n)) >> (64 - n))
+}
+
+func (r *bitReader) readRice(k int) int32 {
+	q := uint64(0)
+	for r.read(1) == 0 {
+		q++
+	}
+	u := q<<k | r.read(k)
+	return int32(u>>1) ^ -int32(u&1)
+}
+
+func (r *bitReader) readUTF8() uint64 {
+	b := r.read(8)
+	n := 0
+	for b&(0x80>>n) != 0 {
+		n++
+	}
+	if n == 0 {
+		return b
+	}
+	v := b & (0x7F >> n)
+	for i := 1; i < n; i++ {
+		v = v<<6 | r.read(8)&0x3F
+	}
+	return v
+}
+
+type streamInfo struct {
+	minBlock, maxBlock int
+	minFrame, maxFrame int
+	sampleRate         int
+	channels           int
+	bitsPerSample      int
+	frames             uint64
+	md5                [16]byte
+}
+
+// Decodes a FLAC file written by Writer, checking the CRCs of every frame,
+// and returns its stream information and interleaved samples.
+func decode(t *testing.T, data []byte) (streamInfo, []int16) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("fLaC")) {
+		t.Fatal("Missing fLaC marker")
+	}
+	if data[4] != 0x80 || data[7] != 34 {
+		t.Fatalf("Expected a last STREAMINFO block of 34 bytes, got header % x", data[4:8])
+	}
+	r := &bitReader{data: data, pos: streamInfoPos * 8}
+	var info streamInfo
+	info.minBlock, info.maxBlock = int(r.read(16)), int(r.read(16))
+	info.minFrame, info.maxFrame = int(r.read(24)), int(r.read(24))
+	info.sampleRate = int(r.read(20))
+	info.channels = int(r.read(3)) + 1
+	info.bitsPerSample = int(r.read(5)) + 1
+	info.frames = r.read(36)
+	copy(info.md5[:], data[r.pos/8:])
+	r.pos += 128
+
+	var samples []int16
+	for number := uint64(0); r.pos/8 < len(data); number++ {
+		start := r.pos / 8
+		if sync := r.read(16); sync != 0xFFF8 {
+			t.Fatalf("Frame %d: expected sync code fff8, got %x", number, sync)
+		}
+		sizeCode, rate := r.read(4), r.read(4)
+		assignment, sizeBits := int(r.read(4)), r.read(4)
+		if sizeBits != 4<<1 {
+			t.Fatalf("Frame %d: expected 16 bits per sample, got code %d", number, sizeBits)
+		}
+		if got := r.readUTF8(); got != number {
+			t.Fatalf("Frame %d: got frame number %d", number, got)
+		}
+		n := 0
+		switch sizeCode {
+		case 12:
+			n = blockSize
+		case 7:
+			n = int(r.read(16)) + 1
+		default:
+			t.Fatalf("Frame %d: unexpected block size code %d", number, sizeCode)
+		}
+		if rate != rateCode(info.sampleRate) {
+			t.Fatalf("Frame %d: expected sample rate code %d, got %d", number, rateCode(info.sampleRate), rate)
+		}
+		if crc := byte(r.read(8)); crc != crc8(data[start:r.pos/8-1]) {
+			t.Fatalf("Frame %d: header CRC mismatch", number)
+		}
+
+		subframes := make([][]int32, info.channels)
+		for c := range subframes {
+			bits := 16
+			switch {
+			case assignment == assignLeftSide && c == 1,
+				assignment == assignRightSide && c == 0,
+				assignment == assignMidSide && c == 1:
+				bits++
+			}
+			subframes[c] = decodeSubframe(t, r, n, bits)
+		}
+		switch assignment {
+		case assignLeftSide:
+			for i := range subframes[1] {
+				subframes[1][i] = subframes[0][i] - subframes[1][i]
+			}
+		case assignRightSide:
+			for i := range subframes[0] {
+				subframes[0][i] += subframes[1][i]
+			}
+		case assignMidSide:
+			for i := range subframes[0] {
+				mid, side := subframes[0][i]<<1|subframes[1][i]&1, subframes[1][i]
+				subframes[0][i], subframes[1][i] = (mid+side)>>1, (mid-side)>>1
+			}
+		default:
+			if assignment != info.channels-1 {
+				t.Fatalf("Frame %d: unexpected channel assignment %d", number, assignment)
+			}
+		}
+
+		if r.pos%8 != 0 {
+			r.pos += 8 - r.pos%8
+		}
+		end := r.pos / 8
+		if crc := uint16(r.read(16)); crc != crc16(data[start:end]) {
+			t.Fatalf("Frame %d: frame CRC mismatch", number)
+		}
+		for i := 0; i < n; i++ {
+			for c := range subframes {
+				samples = append(samples, int16(subframes[c][i]))
+			}
+		}
+	}
+	return info, samples
+}
+
+// Decodes a subframe of n samples, which have bits bits each.
+func decodeSubframe(t *testing.T, r *bitReader, n, bits int) []int32 {
+	t.Helper()
+	out := make([]int32, n)
+	if r.read(1) != 0 {
+		t.Fatal("Subframe padding bit set")
+	}
+	kind := int(r.read(6))
+	if r.read(1) != 0 {
+		t.Fatal("Unexpected wasted bits")
+	}
+	switch {
+	case kind == subframeConstant:
+		v := r.readSigned(bits)
+		for i := range out {
+			out[i] = v
+		}
+	case kind == subframeVerbatim:
+		for i := range out {
+			out[i] = r.readSigned(bits)
+		}
+	case kind >= subframeFixed && kind <= subframeFixed+4:
+		order := kind - subframeFixed
+		for i := 0; i < order; i++ {
+			out[i] = r.readSigned(bits)
+		}
+		if method := r.read(2); method != 0 {
+			t.Fatalf("Unexpected residual coding method %d", method)
+		}
+		partitionOrder := int(r.read(4))
+		i := order
+		for p := 0; p < 1<<partitionOrder; p++ {
+			k := int(r.read(4))
+			if k == 15 {
+				t.Fatal("Unexpected escaped partition")
+			}
+			for end := (p + 1) * (n >> partitionOrder); i < end; i++ {
+				out[i] = r.readRice(k)
+			}
+		}
+		for i := order; i < n; i++ {
+			switch order {
+			case 1:
+				out[i] += out[i-1]
+			case 2:
+				out[i] += 2*out[i-1] - out[i-2]
+			case 3:
+				out[i] += 3*out[i-1] - 3*out[i-2] + out[i-3]
+			case 4:
+				out[i] += 4*out[i-1] - 6*out[i-2] + 4*out[i-3] - out[i-4]
+			}
+		}
+	default:
+		t.Fatalf("Unexpected subframe type %d", kind)
+	}
+	return out
+}
+
+// Returns the MD5 of samples as little-endian 16-bit values.
+func samplesMD5(samples []int16) [16]byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return md5.Sum(buf.Bytes())
+}
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cases := []struct {
+		name     string
+		rate     int
+		channels int
+		frames   int
+		sample   func(i, c int) int16
+	}{
+		{"Sine", 44100, 2, blockSize*2 + 1000, func(i, c int) int16 {
+			return int16(20000 * math.Sin(float64(i)*(0.01+0.003*float64(c))))
+		}},
+		{"Same channels", 48000, 2, blockSize, func(i, c int) int16 {
+			return int16(i*37%2000 - 1000)
+		}},
+		{"Correlated channels", 32000, 2, blockSize, func(i, c int) int16 {
+			// Noise added to one channel and taken from the other leaves
+			// a smooth mid channel, and the odd sums test its rounding
+			n := int16(i*7919%101 - 50)
+			if c == 1 {
+				n = -n + int16(i&1)
+			}
+			return int16(15000*math.Sin(float64(i)*0.02)) + n
+		}},
+		{"Left only", 22050, 2, 3000, func(i, c int) int16 {
+			if c == 1 {
+				return 0
+			}
+			return int16(10000 * math.Sin(float64(i)*0.05))
+		}},
+		{"Silence", 8000, 1, blockSize + 1, func(i, c int) int16 { return 0 }},
+		{"Full scale noise", 11025, 1, 2000, func(i, c int) int16 {
+			return int16(rng.Intn(1<<16) - 1<<15)
+		}},
+		{"Extremes", 96000, 6, 500, func(i, c int) int16 {
+			if (i+c)%2 == 0 {
+				return math.MaxInt16
+			}
+			return math.MinInt16
+		}},
+	}
+	for _, tc := range cases {
+		samples := make([]int16, tc.frames*tc.channels)
+		for i := 0; i < tc.frames; i++ {
+			for c := 0; c < tc.channels; c++ {
+				samples[i*tc.channels+c] = tc.sample(i, c)
+			}
+		}
+
+		f := &memFile{}
+		w, err := NewWriter(f, tc.rate, tc.channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Uneven writes that don't line up with the blocks
+		for rest := samples; len(rest) > 0; {
+			n := min(len(rest), 999*tc.channels)
+			if err := w.WriteFrames(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if f.pos != int64(len(f.buf)) {
+			t.Errorf("%s: Close left the file at %d, expected the end %d", tc.name, f.pos, len(f.buf))
+		}
+
+		info, got := decode(t, f.buf)
+		if !slices.Equal(got, samples) {
+			t.Errorf("%s: decoded samples differ", tc.name)
+		}
+		want := streamInfo{
+			minBlock: blockSize, maxBlock: blockSize,
+			minFrame: w.minFrame, maxFrame: w.maxFrame,
+			sampleRate: tc.rate, channels: tc.channels, bitsPerSample: 16,
+			frames: uint64(tc.frames), md5: samplesMD5(samples),
+		}
+		if info != want {
+			t.Errorf("%s: expected stream info %+v, got %+v", tc.name, want, info)
+		}
+		if info.minFrame <= 0 || info.minFrame > info.maxFrame {
+			t.Errorf("%s: bad frame sizes %d-%d", tc.name, info.minFrame, info.maxFrame)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	f := &memFile{}
+	w, err := NewWriter(f, 44100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.buf) != 4+4+34 {
+		t.Errorf("Expected only the marker and stream information, got %d bytes", len(f.buf))
+	}
+	info, samples := decode(t, f.buf)
+	if info.frames != 0 || len(samples) != 0 || info.md5 != [16]byte{} {
+		t.Errorf("Expected no audio and no MD5, got %+v", info)
+	}
+}
+
+func TestNewWriterErrors(t *testing.T) {
+	for _, c := range []struct{ rate, channels int }{{0, 2}, {1 << 20, 2}, {44100, 0}, {44100, 9}} {
+		if _, err := NewWriter(&memFile{}, c.rate, c.channels); err == nil {
+			t.Errorf("Rate %d, %d channels: expected an error", c.rate, c.channels)
+		}
+	}
+}
+
+func TestCRC(t *testing.T) {
+	// The check values of CRC-8 and CRC-16/UMTS
+	check := []byte("123456789")
+	if got := crc8(check); got != 0xF4 {
+		t.Errorf("CRC-8: expected f4, got %02x", got)
+	}
+	if got := crc16(check); got != 0xFEE8 {
+		t.Errorf("CRC-16: expected fee8, got %04x", got)
+	}
+}
+
+func TestWriteUTF8(t *testing.T) {
+	cases := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0xC2, 0x80}},
+		{0x7FF, []byte{0xDF, 0xBF}},
+		{0x800, []byte{0xE0, 0xA0, 0x80}},
+		{0x10000, []byte{0xF0, 0x90, 0x80, 0x80}},
+		{1<<36 - 1, []byte{0xFE, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF}},
+	}
+	var b bitWriter
+	for _, c := range cases {
+		b.reset()
+		b.writeUTF8(c.v)
+		if !bytes.Equal(b.bytes(), c.want) {
+			t.Errorf("%#x: expected % x, got % x", c.v, c.want, b.bytes())
+		}
+		r := &bitReader{data: b.bytes()}
+		if got := r.readUTF8(); got != c.v {
+			t.Errorf("%#x: read back %#x", c.v, got)
+		}
+	}
+}
+
+func TestWriteRice(t *testing.T) {
+	var b bitWriter
+	values := []int32{0, -1, 1, 100, -100, 40000, -40000}
+	for _, k := range []int{0, 3, 14} {
+		b.reset()
+		for _, v := range values {
+			b.writeRice(v, k)
+		}
+		b.align()
+		r := &bitReader{data: b.bytes()}
+		for _, v := range values {
+			if got := r.readRice(k); got != v {
+				t.Errorf("k=%d: expected %d, got %d", k, v, got)
+			}
+		}
+	}
+}
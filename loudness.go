@@ -0,0 +1,184 @@
+package modplayer
+
+import (
+	"context"
+	"math"
+)
+
+const (
+	loudnessBlock    = 400 // gating block length in ms
+	loudnessSubBlock = 100 // ms between the starts of gating blocks
+	loudnessAbsGate  = -70 // LUFS, blocks quieter than this are ignored
+	loudnessRelGate  = -10 // LU below the ungated loudness that blocks are ignored at
+)
+
+// Loudness is the loudness of a piece of audio measured by a LoudnessMeter.
+type Loudness struct {
+	Integrated float64 // EBU R128 integrated loudness in LUFS, -Inf for silence
+	Peak       float64 // largest sample in dB relative to full scale, -Inf for silence
+}
+
+// Gain returns the gain in dB that brings the audio to target LUFS, e.g. -23
+// for EBU R128 or -18 for ReplayGain 2. The gain is limited so that the peak
+// doesn't go over full scale.
+func (l Loudness) Gain(target float64) float64 {
+	if math.IsInf(l.Integrated, -1) {
+		return 0 // silence stays silent
+	}
+	return min(target-l.Integrated, -l.Peak)
+}
+
+// LoudnessMeter is an Effect that measures the loudness of the audio passing
+// through it, following EBU R128 and ITU-R BS.1770. The audio is unchanged.
+type LoudnessMeter struct {
+	preFilter, rlbFilter biquad // K-weighting filters
+
+	subLen int                                       // samples in a sub-block
+	n      int                                       // samples in the current sub-block
+	sum    float64                                   // sum of squares of the current sub-block
+	recent [loudnessBlock / loudnessSubBlock]float64 // sums of squares of the last sub-blocks, a gating block
+	subs   int                                       // sub-blocks completed
+	blocks []float64                                 // mean square of each gating block
+	peak   int                                       // largest sample magnitude
+}
+
+// NewLoudnessMeter returns a LoudnessMeter for stereo audio at sampleRate.
+func NewLoudnessMeter(sampleRate uint) *LoudnessMeter {
+	fs := float64(sampleRate)
+	m := &LoudnessMeter{subLen: max(int(sampleRate*loudnessSubBlock/1000), 1)}
+
+	// High shelf modelling the acoustic effect of the head, with the
+	// parameters of libebur128 so that the filter matches BS.1770 at any
+	// sample rate
+	const (
+		shelfFreq = 1681.974450955533
+		shelfGain = 3.999843853973347
+		shelfQ    = 0.7071752369554196
+	)
+	k := math.Tan(math.Pi * shelfFreq / fs)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	m.preFilter = biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	// Revised low frequency B-weighting highpass
+	const (
+		rlbFreq = 38.13547087602444
+		rlbQ    = 0.5003270373238773
+	)
+	k = math.Tan(math.Pi * rlbFreq / fs)
+	a0 = 1 + k/rlbQ + k*k
+	m.rlbFilter = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/rlbQ + k*k) / a0,
+	}
+
+	return m
+}
+
+// Process measures in and copies it to out.
+func (m *LoudnessMeter) Process(in, out []int16) {
+	for i := 0; i+1 < len(in); i += 2 {
+		for c := 0; c < 2; c++ {
+			s := int(in[i+c])
+			m.peak = max(m.peak, s, -s)
+			y := m.rlbFilter.process(m.preFilter.process(float64(s)/32768, c), c)
+			m.sum += y * y
+		}
+
+		if m.n++; m.n == m.subLen {
+			m.recent[m.subs%len(m.recent)] = m.sum
+			m.subs++
+			if m.subs >= len(m.recent) {
+				block := 0.0
+				for _, sum := range m.recent {
+					block += sum
+				}
+				m.blocks = append(m.blocks, block/float64(len(m.recent)*m.subLen))
+			}
+			m.n, m.sum = 0, 0
+		}
+	}
+	copy(out, in)
+}
+
+// Loudness returns the loudness of the audio measured so far. The integrated
+// loudness needs at least 400ms of audio.
+func (m *LoudnessMeter) Loudness() Loudness {
+	l := Loudness{
+		Integrated: math.Inf(-1),
+		Peak:       20 * math.Log10(float64(m.peak)/32768),
+	}
+
+	// Mean of the blocks louder than gate
+	gated := func(gate float64) (float64, int) {
+		sum, n := 0.0, 0
+		for _, b := range m.blocks {
+			if blockLoudness(b) > gate {
+				sum += b
+				n++
+			}
+		}
+		return sum / float64(n), n
+	}
+
+	mean, n := gated(loudnessAbsGate)
+	if n == 0 {
+		return l
+	}
+	mean, n = gated(blockLoudness(mean) + loudnessRelGate)
+	if n > 0 {
+		l.Integrated = blockLoudness(mean)
+	}
+	return l
+}
+
+// Returns the loudness in LUFS of a mean square of K-weighted audio, summed
+// over the channels.
+func blockLoudness(meanSq float64) float64 {
+	return -0.691 + 10*math.Log10(meanSq)
+}
+
+// AnalyzeLoudness measures the loudness of the song played from the beginning
+// with the player's settings, up to where the player would stop. Songs that
+// loop forever are measured up to the point where they start repeating. The
+// effect set with SetEffect is not applied. AnalyzeLoudness returns ctx.Err()
+// if ctx is cancelled before the song has been measured.
+//
+// Use Loudness.Gain to find the master gain that gives songs consistent
+// loudness.
+func (p *Player) AnalyzeLoudness(ctx context.Context) (Loudness, error) {
+	s, err := p.scratchPlayer()
+	if err != nil {
+		return Loudness{}, err
+	}
+	s.PlayOrderLimit = p.PlayOrderLimit
+	// Songs that would play forever are measured up to where they repeat
+	if p.loopPolicy == LoopPolicyLoop {
+		s.SetLoopPolicy(LoopPolicyStop, 0)
+	}
+	if p.loopCount == LoopForever {
+		s.SetLoopCount(0)
+	}
+
+	m := NewLoudnessMeter(p.samplingFrequency)
+	buf := make([]int16, len(s.mixbuffer))
+	for s.IsPlaying() {
+		if err := ctx.Err(); err != nil {
+			return Loudness{}, err
+		}
+		n := s.GenerateAudio(buf)
+		m.Process(buf[:n*2], buf[:n*2])
+	}
+
+	return m.Loudness(), nil
+}
@@ -47,7 +47,7 @@ do
   WAV_OUT="$OUTDIR/${SONG_NO_EXT}_golden.wav"
 
   echo "Generating $WAV_OUT"
-  go run ./cmd/modwav -reverb none -wav "$WAV_OUT" "$SONG_FILENAME" > /dev/null
+  go run ./cmd/modwav -reverb none -interp none -wav "$WAV_OUT" "$SONG_FILENAME" > /dev/null
 
   retVal=$?
   if [ $retVal -ne 0 ]; then
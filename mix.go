@@ -0,0 +1,80 @@
+package modplayer
+
+import "fmt"
+
+// MixerBackend selects the code that mixes the channels, see
+// Player.SetMixerBackend. Every backend produces the same audio, they differ
+// in speed. The number format of the output stages is set separately, see
+// MixFormat.
+type MixerBackend int
+
+const (
+	MixerAuto    MixerBackend = iota // The fastest backend the CPU supports, the default
+	MixerGeneric                     // Portable Go, supported everywhere
+	MixerSSE2                        // SSE2 instructions, amd64 only
+	MixerAVX2                        // AVX2 instructions, amd64 CPUs that support them only
+)
+
+// String returns the name of the backend, e.g. sse2.
+func (b MixerBackend) String() string {
+	switch b {
+	case MixerAuto:
+		return "auto"
+	case MixerGeneric:
+		return "generic"
+	case MixerSSE2:
+		return "sse2"
+	case MixerAVX2:
+		return "avx2"
+	}
+	return fmt.Sprintf("MixerBackend(%d)", int(b))
+}
+
+// A stereo mixer of one of the backends, see mixStereoGeneric
+type stereoMixer func(mix []int, data []int8, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint
+
+// Mixes sample data into mix, which holds interleaved stereo samples, one
+// frame of data for each stereo sample. The first frame is read from the
+// 16.16 fixed point position pos and each following frame dr further on,
+// every frame read must be inside data. The frames are mixed at volumes lvol
+// and rvol. The squares of the frames are added to sumSq and peakSq is raised
+// to the largest of them. Returns the position after the last frame.
+//
+// This is the portable version, some architectures have faster versions.
+func mixStereoGeneric(mix []int, data []int8, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint {
+	sum, peak := *sumSq, *peakSq
+	for cur := 0; cur < len(mix); cur += 2 {
+		// WARNING: no clamping when mixing into mixbuffer. Clamping will be applied when the final audio is returned
+		// to the caller.
+		sd := int(data[pos>>16])
+		mix[cur+0] += sd * lvol
+		mix[cur+1] += sd * rvol
+		sq := sd * sd
+		sum += sq
+		peak = max(peak, sq)
+
+		pos += dr
+	}
+	*sumSq, *peakSq = sum, peak
+	return pos
+}
+
+// Same as mixStereoGeneric for samples widened to 16 bits, see
+// Song.WidenSamples. The frames have 8 bits of fraction, which are dropped
+// after scaling by the volumes.
+func mixStereo16(mix []int, data []int16, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint {
+	sum, peak := *sumSq, *peakSq
+	for cur := 0; cur < len(mix); cur += 2 {
+		v := int(data[pos>>16])
+		mix[cur+0] += (v * lvol) >> 8
+		mix[cur+1] += (v * rvol) >> 8
+		sd := v >> 8
+		sq := sd * sd
+		sum += sq
+		peak = max(peak, sq)
+
+		pos += dr
+	}
+	*sumSq, *peakSq = sum, peak
+	return pos
+}
@@ -0,0 +1,78 @@
+//go:build !purego
+
+package modplayer
+
+// The SSE2 mixer multiplies frames by volumes in 16 bits, so volumes above
+// this are mixed by mixStereoGeneric. Mixer volumes never exceed it.
+const maxSSE2Volume = 255
+
+// Set if the CPU and operating system support AVX2
+var useAVX2 = hasAVX2()
+
+// MixerBackends returns the mixer backends supported by the CPU and build,
+// the fastest last.
+func MixerBackends() []MixerBackend {
+	backends := []MixerBackend{MixerGeneric, MixerSSE2}
+	if useAVX2 {
+		backends = append(backends, MixerAVX2)
+	}
+	return backends
+}
+
+// Returns the stereo mixer of backend b, which must be supported. The SSE2 and
+// AVX2 mixers read the frames one at a time but scale and add them to the mix
+// buffer 4 (SSE2) or 8 (AVX2) at a time.
+func backendMixer(b MixerBackend) stereoMixer {
+	switch b {
+	case MixerSSE2:
+		return limitVolumes(mixStereoSSE2)
+	case MixerAVX2:
+		return limitVolumes(mixStereoAVX2)
+	}
+	return mixStereoGeneric
+}
+
+// Returns a mixer that uses mix for volumes up to maxSSE2Volume and
+// mixStereoGeneric for others.
+func limitVolumes(mix stereoMixer) stereoMixer {
+	return func(buf []int, data []int8, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint {
+		if lvol < 0 || rvol < 0 || lvol > maxSSE2Volume || rvol > maxSSE2Volume {
+			return mixStereoGeneric(buf, data, pos, dr, lvol, rvol, sumSq, peakSq)
+		}
+		return mix(buf, data, pos, dr, lvol, rvol, sumSq, peakSq)
+	}
+}
+
+//go:noescape
+func mixStereoSSE2(mix []int, data []int8, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint
+
+//go:noescape
+func mixStereoAVX2(mix []int, data []int8, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+// Reports whether the CPU supports AVX2 and the operating system saves the
+// YMM registers.
+func hasAVX2() bool {
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return false
+	}
+
+	const (
+		osxsave = 1 << 27
+		avx     = 1 << 28
+		avx2    = 1 << 5
+	)
+	_, _, ecx1, _ := cpuid(1, 0)
+	if ecx1&osxsave == 0 || ecx1&avx == 0 {
+		return false
+	}
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 { // XMM and YMM state
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	return ebx7&avx2 != 0
+}
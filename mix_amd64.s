@@ -0,0 +1,212 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// Register use by both mixers
+//	DI	mix buffer
+//	CX	frames left to mix
+//	SI	sample data
+//	BX	16.16 sample position
+//	DX	sample position step
+//	R8	sum of squares
+//	R9	peak square
+//	R10	left volume
+//	R11	right volume
+
+#define SETUP \
+	MOVQ mix_base+0(FP), DI   \
+	MOVQ mix_len+8(FP), CX    \
+	SHRQ $1, CX               \
+	MOVQ data_base+24(FP), SI \
+	MOVQ pos+48(FP), BX       \
+	MOVQ dr+56(FP), DX        \
+	MOVQ lvol+64(FP), R10     \
+	MOVQ rvol+72(FP), R11     \
+	MOVQ sumSq+80(FP), AX     \
+	MOVQ (AX), R8             \
+	MOVQ peakSq+88(FP), AX    \
+	MOVQ (AX), R9
+
+#define FINISH \
+	MOVQ sumSq+80(FP), AX  \
+	MOVQ R8, (AX)          \
+	MOVQ peakSq+88(FP), AX \
+	MOVQ R9, (AX)
+
+// Reads the next frame into AX and adds its square to the meter readings
+#define FRAME \
+	MOVQ    BX, AX           \
+	SHRQ    $16, AX          \
+	MOVBQSX (SI)(AX*1), AX   \
+	ADDQ    DX, BX           \
+	MOVQ    AX, R12          \
+	IMULQ   R12, R12         \
+	ADDQ    R12, R8          \
+	CMPQ    R12, R9          \
+	CMOVQGT R12, R9
+
+// Mixes the remaining frames one at a time
+#define TAIL(label, done) \
+label:                  \
+	TESTQ CX, CX          \
+	JEQ   done            \
+	FRAME                 \
+	MOVQ  AX, R12         \
+	IMULQ R10, R12        \
+	ADDQ  R12, (DI)       \
+	IMULQ R11, AX         \
+	ADDQ  AX, 8(DI)       \
+	ADDQ  $16, DI         \
+	DECQ  CX              \
+	JMP   label
+
+// Sign extends the 4 dwords in x to qwords and adds them to the 4 qwords at
+// off(DI), using X3-X5
+#define ADD4SSE2(x, off) \
+	PXOR      X3, X3        \
+	PCMPGTL   x, X3         \
+	MOVOU     x, X4         \
+	PUNPCKLLQ X3, X4        \
+	PUNPCKHLQ X3, x         \
+	MOVOU     off(DI), X5   \
+	PADDQ     X4, X5        \
+	MOVOU     X5, off(DI)   \
+	MOVOU     off+16(DI), X5 \
+	PADDQ     x, X5         \
+	MOVOU     X5, off+16(DI)
+
+// func mixStereoSSE2(mix []int, data []int8, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint
+TEXT ·mixStereoSSE2(SB), NOSPLIT, $0-104
+	SETUP
+
+	// X7 holds the left and right volumes in alternate words
+	MOVQ   R11, AX
+	SHLQ   $16, AX
+	ORQ    R10, AX
+	MOVQ   AX, X7
+	PSHUFD $0, X7, X7
+
+sse2loop:
+	CMPQ CX, $4
+	JLT  sse2tail
+
+	FRAME
+	PINSRW $0, AX, X0
+	FRAME
+	PINSRW $1, AX, X0
+	FRAME
+	PINSRW $2, AX, X0
+	FRAME
+	PINSRW $3, AX, X0
+
+	// Scale each frame by the left and right volumes, the products fit in
+	// 16 bits
+	PUNPCKLWL X0, X0
+	PMULLW    X7, X0
+
+	// Sign extend the products to dwords
+	MOVOU     X0, X1
+	PUNPCKLWL X1, X1
+	PSRAL     $16, X1
+	MOVOU     X0, X2
+	PUNPCKHWL X2, X2
+	PSRAL     $16, X2
+
+	ADD4SSE2(X1, 0)
+	ADD4SSE2(X2, 32)
+
+	ADDQ $64, DI
+	SUBQ $4, CX
+	JMP  sse2loop
+
+	TAIL(sse2tail, sse2done)
+
+sse2done:
+	FINISH
+	MOVQ BX, ret+96(FP)
+	RET
+
+// func mixStereoAVX2(mix []int, data []int8, pos, dr uint, lvol, rvol int, sumSq, peakSq *int) uint
+TEXT ·mixStereoAVX2(SB), NOSPLIT, $0-104
+	SETUP
+
+	// Y6 and Y7 hold the left and right volumes in every dword
+	MOVQ         R10, X6
+	VPBROADCASTD X6, Y6
+	MOVQ         R11, X7
+	VPBROADCASTD X7, Y7
+
+avx2loop:
+	CMPQ CX, $8
+	JLT  avx2tail
+
+	FRAME
+	VPINSRW $0, AX, X0, X0
+	FRAME
+	VPINSRW $1, AX, X0, X0
+	FRAME
+	VPINSRW $2, AX, X0, X0
+	FRAME
+	VPINSRW $3, AX, X0, X0
+	FRAME
+	VPINSRW $4, AX, X0, X0
+	FRAME
+	VPINSRW $5, AX, X0, X0
+	FRAME
+	VPINSRW $6, AX, X0, X0
+	FRAME
+	VPINSRW $7, AX, X0, X0
+
+	// Scale the frames by the left and right volumes and interleave them,
+	// Y3 holds frames 0, 1, 4, 5 and Y4 frames 2, 3, 6, 7
+	VPMOVSXWD  X0, Y0
+	VPMULLD    Y6, Y0, Y1
+	VPMULLD    Y7, Y0, Y2
+	VPUNPCKLDQ Y2, Y1, Y3
+	VPUNPCKHDQ Y2, Y1, Y4
+
+	VPMOVSXDQ    X3, Y5
+	VPADDQ       (DI), Y5, Y5
+	VMOVDQU      Y5, (DI)
+	VPMOVSXDQ    X4, Y5
+	VPADDQ       32(DI), Y5, Y5
+	VMOVDQU      Y5, 32(DI)
+	VEXTRACTI128 $1, Y3, X3
+	VPMOVSXDQ    X3, Y5
+	VPADDQ       64(DI), Y5, Y5
+	VMOVDQU      Y5, 64(DI)
+	VEXTRACTI128 $1, Y4, X4
+	VPMOVSXDQ    X4, Y5
+	VPADDQ       96(DI), Y5, Y5
+	VMOVDQU      Y5, 96(DI)
+
+	ADDQ $128, DI
+	SUBQ $8, CX
+	JMP  avx2loop
+
+	TAIL(avx2tail, avx2done)
+
+avx2done:
+	VZEROUPPER
+	FINISH
+	MOVQ BX, ret+96(FP)
+	RET
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
@@ -0,0 +1,39 @@
+//go:build !purego
+
+package modplayer
+
+import (
+	"slices"
+	"testing"
+)
+
+// Test the kernels directly, without the volume check of limitVolumes
+func TestMixStereoAMD64(t *testing.T) {
+	mixers := map[string]func([]int, []int8, uint, uint, int, int, *int, *int) uint{
+		"SSE2": mixStereoSSE2,
+	}
+	if useAVX2 {
+		mixers["AVX2"] = mixStereoAVX2
+	}
+
+	data := make([]int8, 256)
+	for i := range data {
+		data[i] = int8(i) // every value, including -128
+	}
+	for name, mix := range mixers {
+		t.Run(name, func(t *testing.T) {
+			for n := 0; n < 20; n++ {
+				want := make([]int, n*2)
+				got := make([]int, n*2)
+				wantSum, wantPeak := 0, 0
+				gotSum, gotPeak := 0, 0
+
+				wantPos := mixStereoGeneric(want, data, 100<<16, 5<<16+999, maxSSE2Volume, 1, &wantSum, &wantPeak)
+				gotPos := mix(got, data, 100<<16, 5<<16+999, maxSSE2Volume, 1, &gotSum, &gotPeak)
+				if gotPos != wantPos || gotSum != wantSum || gotPeak != wantPeak || !slices.Equal(got, want) {
+					t.Errorf("%d frames: got %v position %X meters %d/%d, expected %v %X %d/%d", n, got, gotPos, gotSum, gotPeak, want, wantPos, wantSum, wantPeak)
+				}
+			}
+		})
+	}
+}
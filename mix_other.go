@@ -0,0 +1,14 @@
+//go:build !amd64 || purego
+
+package modplayer
+
+// MixerBackends returns the mixer backends supported by the CPU and build,
+// the fastest last.
+func MixerBackends() []MixerBackend {
+	return []MixerBackend{MixerGeneric}
+}
+
+// Returns the stereo mixer of backend b, which must be supported.
+func backendMixer(b MixerBackend) stereoMixer {
+	return mixStereoGeneric
+}
@@ -16,6 +16,95 @@ var fineTuning = []int{
 	7895, 7941, 7985, 8046, 8107, 8169, 8232, 8280,
 }
 
+// ProTracker period tables, one per finetune value from -8 to 7. Each table
+// covers the three octaves C-1 to B-3 that ProTracker can play. These are
+// not exactly derivable from a formula, so they are reproduced verbatim.
+var amigaPeriods = [16][36]int{
+	{ // -8
+		907, 856, 808, 762, 720, 678, 640, 604, 570, 538, 508, 480,
+		453, 428, 404, 381, 360, 339, 320, 302, 285, 269, 254, 240,
+		226, 214, 202, 190, 180, 170, 160, 151, 143, 135, 127, 120,
+	},
+	{ // -7
+		900, 850, 802, 757, 715, 675, 636, 601, 567, 535, 505, 477,
+		450, 425, 401, 379, 357, 337, 318, 300, 284, 268, 253, 238,
+		225, 212, 200, 189, 179, 169, 159, 150, 142, 134, 126, 119,
+	},
+	{ // -6
+		894, 844, 796, 752, 709, 670, 632, 597, 563, 532, 502, 474,
+		447, 422, 398, 376, 355, 335, 316, 298, 282, 266, 251, 237,
+		223, 211, 199, 188, 177, 167, 158, 149, 141, 133, 125, 118,
+	},
+	{ // -5
+		887, 838, 791, 746, 704, 665, 628, 592, 559, 528, 498, 470,
+		444, 419, 395, 373, 352, 332, 314, 296, 280, 264, 249, 235,
+		222, 209, 198, 187, 176, 166, 157, 148, 140, 132, 125, 118,
+	},
+	{ // -4
+		881, 832, 785, 741, 699, 660, 623, 588, 555, 524, 494, 467,
+		441, 416, 392, 370, 350, 330, 312, 294, 278, 262, 247, 233,
+		220, 208, 196, 185, 175, 165, 156, 147, 139, 131, 123, 117,
+	},
+	{ // -3
+		875, 826, 779, 736, 694, 655, 619, 584, 551, 520, 491, 463,
+		437, 413, 390, 368, 347, 328, 309, 292, 276, 260, 245, 232,
+		219, 206, 195, 184, 174, 164, 155, 146, 138, 130, 123, 116,
+	},
+	{ // -2
+		868, 820, 774, 730, 689, 651, 614, 580, 547, 516, 487, 460,
+		434, 410, 387, 365, 345, 325, 307, 290, 274, 258, 244, 230,
+		217, 205, 193, 183, 172, 163, 154, 145, 137, 129, 122, 115,
+	},
+	{ // -1
+		862, 814, 768, 725, 684, 646, 610, 575, 543, 513, 484, 457,
+		431, 407, 384, 363, 342, 323, 305, 288, 272, 256, 242, 228,
+		216, 203, 192, 181, 171, 161, 152, 144, 136, 128, 121, 114,
+	},
+	{ // 0
+		856, 808, 762, 720, 678, 640, 604, 570, 538, 508, 480, 453,
+		428, 404, 381, 360, 339, 320, 302, 285, 269, 254, 240, 226,
+		214, 202, 190, 180, 170, 160, 151, 143, 135, 127, 120, 113,
+	},
+	{ // 1
+		850, 802, 757, 715, 674, 637, 601, 567, 535, 505, 477, 450,
+		425, 401, 379, 357, 337, 318, 300, 284, 268, 253, 239, 225,
+		213, 201, 189, 179, 169, 159, 150, 142, 134, 126, 119, 113,
+	},
+	{ // 2
+		844, 796, 752, 709, 670, 632, 597, 563, 532, 502, 474, 447,
+		422, 398, 376, 355, 335, 316, 298, 282, 266, 251, 237, 224,
+		211, 199, 188, 177, 167, 158, 149, 141, 133, 125, 118, 112,
+	},
+	{ // 3
+		838, 791, 746, 704, 665, 628, 592, 559, 528, 498, 470, 444,
+		419, 395, 373, 352, 332, 314, 296, 280, 264, 249, 235, 222,
+		209, 198, 187, 176, 166, 157, 148, 140, 132, 125, 118, 111,
+	},
+	{ // 4
+		832, 785, 741, 699, 660, 623, 588, 555, 524, 495, 467, 441,
+		416, 392, 370, 350, 330, 312, 294, 278, 262, 247, 233, 220,
+		208, 196, 185, 175, 165, 156, 147, 139, 131, 124, 117, 110,
+	},
+	{ // 5
+		826, 779, 736, 694, 655, 619, 584, 551, 520, 491, 463, 437,
+		413, 390, 368, 347, 328, 309, 292, 276, 260, 245, 232, 219,
+		206, 195, 184, 174, 164, 155, 146, 138, 130, 123, 116, 109,
+	},
+	{ // 6
+		820, 774, 730, 689, 651, 614, 580, 547, 516, 487, 460, 434,
+		410, 387, 365, 345, 325, 307, 290, 274, 258, 244, 230, 217,
+		205, 193, 183, 172, 163, 154, 145, 137, 129, 122, 115, 109,
+	},
+	{ // 7
+		814, 768, 725, 684, 646, 610, 575, 543, 513, 484, 457, 431,
+		407, 384, 363, 342, 323, 305, 288, 272, 256, 242, 228, 216,
+		204, 192, 181, 171, 161, 152, 144, 136, 128, 121, 114, 108,
+	},
+}
+
+// The player note of C-1, the first note in the ProTracker period tables
+const amigaNoteBase = 48
+
 // NewMODSongFromBytes parses a MOD file into a Song.
 //
 // This means reading out instrument data, sample data, order
@@ -27,6 +116,7 @@ func NewMODSongFromBytes(songBytes []byte) (*Song, error) {
 		GlobalVolume: maxVolume,
 		Samples:      make([]Sample, 31),
 		Type:         SongTypeMOD,
+		Clock:        ClockPAL, // MOD files do not record the Amiga model
 	}
 
 	buf := bytes.NewReader(songBytes)
@@ -75,17 +165,22 @@ func NewMODSongFromBytes(songBytes []byte) (*Song, error) {
 	switch string(x[2:]) {
 	case "K.": // M.K.
 		song.Channels = 4
+		song.TrackerName = "ProTracker"
 	case "HN": // xCHN, x = number of channels
 		song.Channels = (int(x[0]) - 48)
+		song.TrackerName = "FastTracker"
 	case "CH": // xxCH, xx = number of channels as two digit decimal
 		song.Channels = (int(x[0])-48)*10 + (int(x[1] - 48))
+		song.TrackerName = "FastTracker"
 	default:
 		return nil, fmt.Errorf("unrecognized MOD format %s", string(x))
 	}
+	song.FileFormat = string(x)
 	dumpf("Title:\t\t%s\n", song.Title)
 	dumpf("Channels:\t%d\n", song.Channels)
 	dumpf("Speed:\t\t%d\n", song.Speed)
 	dumpf("Tempo:\t\t%d\n", song.Tempo)
+	dumpf("Format:\t\t%s (%s)\n", song.FileFormat, song.TrackerName)
 	dumpf("Patterns:\t%d\n", patterns)
 	dumpf("Orders:\t\t%d %v\n", len(song.Orders), song.Orders)
 	dumpf("\n")
@@ -180,10 +275,17 @@ func readMODSampleInfo(r *bytes.Reader, si int) (*Sample, error) {
 	}
 	dumpf("Sample %d x%02X\n", si, si)
 
+	// The finetune is a signed 4-bit value
+	fineTune := int(data.FineTune & 0xF)
+	if fineTune > 7 {
+		fineTune -= 16
+	}
+
 	smp := &Sample{
 		Name:      cleanName(string(data.Name[:])),
 		Length:    int(data.Length) * 2,
-		C4Speed:   fineTuning[data.FineTune],
+		C4Speed:   fineTuning[data.FineTune&0xF],
+		FineTune:  fineTune,
 		Volume:    int(data.Volume),
 		LoopStart: int(data.LoopStart) * 2,
 		LoopLen:   int(data.LoopLen) * 2,
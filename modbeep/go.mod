@@ -0,0 +1,10 @@
+module github.com/chriskillpack/modplayer/modbeep
+
+go 1.21
+
+require (
+	github.com/chriskillpack/modplayer v0.1.0
+	github.com/faiface/beep v1.1.0
+)
+
+replace github.com/chriskillpack/modplayer v0.1.0 => ../
@@ -0,0 +1,63 @@
+// Package modbeep plays songs through github.com/faiface/beep by adapting a
+// modplayer.Player to a beep.Streamer.
+package modbeep
+
+import (
+	"github.com/chriskillpack/modplayer"
+	"github.com/faiface/beep"
+)
+
+// Most stereo samples generated by the player at a time, fewer if the
+// player's mix buffer is smaller
+const bufferLen = 1024
+
+// Streamer is a beep.Streamer that plays a song with a Player.
+type Streamer struct {
+	player *modplayer.Player
+	buf    []int16
+}
+
+// New returns a Streamer that generates audio with player. The player must be
+// started, the stream is drained once the song ends or the player is
+// stopped. The player's other methods can be used to control playback while
+// it is streaming, if they are called from the goroutine that streams.
+func New(player *modplayer.Player) *Streamer {
+	return &Streamer{
+		player: player,
+		buf:    make([]int16, min(bufferLen, player.MixBufferSize())*2),
+	}
+}
+
+// Format returns the format of the audio generated by the player.
+func (s *Streamer) Format() beep.Format {
+	return beep.Format{
+		SampleRate:  beep.SampleRate(s.player.SampleRate()),
+		NumChannels: 2,
+		Precision:   2,
+	}
+}
+
+// Stream fills samples with audio from the player, see beep.Streamer.
+func (s *Streamer) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) {
+		want := min(len(samples)-n, len(s.buf)/2)
+		got := s.player.GenerateAudio(s.buf[:want*2])
+		for i := 0; i < got; i++ {
+			samples[n+i][0] = float64(s.buf[i*2+0]) / 32768
+			samples[n+i][1] = float64(s.buf[i*2+1]) / 32768
+		}
+		n += got
+
+		if got < want {
+			// The song ended or the player was stopped
+			return n, n > 0
+		}
+	}
+
+	return n, true
+}
+
+// Err always returns nil, a Player does not fail while generating audio.
+func (s *Streamer) Err() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+// Package modmidi turns the notes played by a modplayer.Player into MIDI
+// messages so that a song can drive external synthesizers.
+//
+// Connect a Bridge to the player's note callbacks:
+//
+//	b := modmidi.New(out)
+//	player.OnNoteTrigger = b.NoteTrigger
+//	player.OnNoteStop = b.NoteStop
+//
+// The callbacks are invoked as GenerateAudio sequences the song, so audio must
+// still be generated to drive the player. Mute every channel with
+// player.SetMuteMask(^uint(0)) to hear only the synthesizers. Messages are sent
+// when a buffer is generated rather than when it is heard, so keep the buffers
+// small to keep the MIDI in time with any audio.
+package modmidi
+
+import (
+	"sync"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+// Sender sends a MIDI message to a device. The output ports of the gomidi
+// drivers (gitlab.com/gomidi/midi/v2/drivers.Out) satisfy Sender.
+type Sender interface {
+	Send(msg []byte) error
+}
+
+// MIDI status bytes, the low nibble is the MIDI channel
+const (
+	noteOff       = 0x80
+	noteOn        = 0x90
+	programChange = 0xC0
+)
+
+// A Bridge sends note-on, note-off and program change messages for the
+// notes played by a Player. Tracker channel n plays on MIDI channel n%16 and
+// sample n selects program n%128.
+type Bridge struct {
+	out Sender
+
+	mu      sync.Mutex
+	notes   map[int]int // MIDI note playing on each tracker channel
+	program [16]int     // last program selected on each MIDI channel, -1 for none
+	err     error       // first error returned by out
+}
+
+// New returns a Bridge that sends its messages to out.
+func New(out Sender) *Bridge {
+	b := &Bridge{out: out, notes: make(map[int]int)}
+	for i := range b.program {
+		b.program[i] = -1
+	}
+	return b
+}
+
+// NoteTrigger sends the messages for a note starting, it is intended to be
+// used as Player.OnNoteTrigger. Any note still playing on the event's channel
+// is released first.
+func (b *Bridge) NoteTrigger(e modplayer.NoteEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.release(e.Channel)
+	if e.Volume == 0 || e.Note < 0 || e.Note > 127 {
+		return
+	}
+
+	mc := byte(e.Channel % 16)
+	if prog := e.Instrument % 128; b.program[mc] != prog {
+		b.program[mc] = prog
+		b.send(programChange|mc, byte(prog))
+	}
+	b.send(noteOn|mc, byte(e.Note), byte(min(e.Volume*2, 127)))
+	b.notes[e.Channel] = e.Note
+}
+
+// NoteStop releases the note playing on a tracker channel, it is intended to
+// be used as Player.OnNoteStop.
+func (b *Bridge) NoteStop(channel int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.release(channel)
+}
+
+// NotesOff releases every playing note. Call it after stopping, seeking or
+// changing songs so that no notes are left hanging, and before closing the
+// MIDI port. It returns the first error encountered sending a message.
+func (b *Bridge) NotesOff() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.notes {
+		b.release(ch)
+	}
+	return b.err
+}
+
+// Err returns the first error encountered sending a message. Errors don't
+// stop the Bridge, later messages are still sent.
+func (b *Bridge) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.err
+}
+
+// Sends a note-off for the note playing on tracker channel ch, if any.
+func (b *Bridge) release(ch int) {
+	note, ok := b.notes[ch]
+	if !ok {
+		return
+	}
+	delete(b.notes, ch)
+	b.send(noteOff|byte(ch%16), byte(note), 0)
+}
+
+func (b *Bridge) send(msg ...byte) {
+	if err := b.out.Send(msg); err != nil && b.err == nil {
+		b.err = err
+	}
+}
@@ -0,0 +1,157 @@
+package modmidi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/chriskillpack/modplayer"
+)
+
+// Records the messages sent, as hex strings, and fails the first err sends.
+type fakeSender struct {
+	msgs []string
+	err  error
+}
+
+func (f *fakeSender) Send(msg []byte) error {
+	f.msgs = append(f.msgs, fmt.Sprintf("% x", msg))
+	return f.err
+}
+
+// Returns the messages sent since the last call.
+func (f *fakeSender) take() []string {
+	msgs := f.msgs
+	f.msgs = nil
+	return msgs
+}
+
+func TestBridge(t *testing.T) {
+	out := &fakeSender{}
+	b := New(out)
+
+	steps := []struct {
+		name string
+		do   func()
+		want []string
+	}{
+		{"First note", func() {
+			b.NoteTrigger(modplayer.NoteEvent{Channel: 0, Instrument: 1, Note: 60, Volume: 64})
+		}, []string{"c0 01", "90 3c 7f"}},
+		{"Same instrument", func() {
+			b.NoteTrigger(modplayer.NoteEvent{Channel: 0, Instrument: 1, Note: 62, Volume: 10})
+		}, []string{"80 3c 00", "90 3e 14"}},
+		{"Channel and program wrap", func() {
+			b.NoteTrigger(modplayer.NoteEvent{Channel: 17, Instrument: 130, Note: 48, Volume: 32})
+		}, []string{"c1 02", "91 30 40"}},
+		{"Stop", func() {
+			b.NoteStop(0)
+		}, []string{"80 3e 00"}},
+		{"Stop again", func() {
+			b.NoteStop(0)
+		}, nil},
+		{"Silent note", func() {
+			b.NoteTrigger(modplayer.NoteEvent{Channel: 17, Instrument: 130, Note: 50, Volume: 0})
+		}, []string{"81 30 00"}},
+		{"Note out of range", func() {
+			b.NoteTrigger(modplayer.NoteEvent{Channel: 3, Instrument: 0, Note: 128, Volume: 64})
+		}, nil},
+		{"Program kept", func() {
+			b.NoteTrigger(modplayer.NoteEvent{Channel: 1, Instrument: 2, Note: 40, Volume: 64})
+		}, []string{"91 28 7f"}},
+	}
+	for _, s := range steps {
+		s.do()
+		if got := out.take(); !slices.Equal(got, s.want) {
+			t.Errorf("%s: expected messages %q, got %q", s.name, s.want, got)
+		}
+	}
+
+	b.NoteTrigger(modplayer.NoteEvent{Channel: 5, Instrument: 0, Note: 70, Volume: 64})
+	out.take()
+	if err := b.NotesOff(); err != nil {
+		t.Fatal(err)
+	}
+	got := out.take()
+	slices.Sort(got)
+	if want := []string{"81 28 00", "85 46 00"}; !slices.Equal(got, want) {
+		t.Errorf("NotesOff: expected messages %q, got %q", want, got)
+	}
+	if err := b.NotesOff(); err != nil || len(out.take()) != 0 {
+		t.Errorf("Expected nothing left to release")
+	}
+}
+
+func TestBridgeErrors(t *testing.T) {
+	errSend := errors.New("send failed")
+	out := &fakeSender{err: errSend}
+	b := New(out)
+
+	b.NoteTrigger(modplayer.NoteEvent{Channel: 0, Instrument: 0, Note: 60, Volume: 64})
+	if err := b.Err(); err != errSend {
+		t.Errorf("Expected the send error, got %v", err)
+	}
+	// Later messages are still sent
+	out.err = nil
+	b.NoteStop(0)
+	if got, want := out.take(), []string{"c0 00", "90 3c 7f", "80 3c 00"}; !slices.Equal(got, want) {
+		t.Errorf("Expected messages %q, got %q", want, got)
+	}
+	if err := b.NotesOff(); err != errSend {
+		t.Errorf("Expected NotesOff to return the first error, got %v", err)
+	}
+}
+
+func TestBridgeSong(t *testing.T) {
+	s3m, err := os.ReadFile("../mods/caero.s3m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song, err := modplayer.NewS3MSongFromBytes(s3m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr, err := modplayer.NewPlayer(song, 11025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr.SetLoopPolicy(modplayer.LoopPolicyStop, 0)
+
+	out := &fakeSender{}
+	b := New(out)
+	plr.OnNoteTrigger = b.NoteTrigger
+	plr.OnNoteStop = b.NoteStop
+	buf := make([]int16, 4096)
+	for plr.IsPlaying() {
+		plr.GenerateAudio(buf)
+	}
+	if err := b.NotesOff(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every note-on is matched by a note-off of the same note
+	playing := make(map[byte]byte)
+	ons := 0
+	for _, m := range out.msgs {
+		var status, data1, data2 byte
+		fmt.Sscanf(m, "%x %x %x", &status, &data1, &data2)
+		switch ch := status & 0x0F; status & 0xF0 {
+		case noteOn:
+			if _, ok := playing[ch]; ok {
+				t.Fatalf("Note-on on MIDI channel %d with a note still playing", ch)
+			}
+			playing[ch] = data1
+			ons++
+		case noteOff:
+			if note, ok := playing[ch]; !ok || note != data1 {
+				t.Fatalf("Note-off of note %d on MIDI channel %d, which isn't playing it", data1, ch)
+			}
+			delete(playing, ch)
+		}
+	}
+	if ons == 0 || len(playing) != 0 {
+		t.Errorf("Expected notes that all end, got %d note-ons and %d left playing", ons, len(playing))
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/chriskillpack/modplayer/modoto
+
+go 1.21
+
+require (
+	github.com/chriskillpack/modplayer v0.1.0
+	github.com/hajimehoshi/oto v0.7.1
+)
+
+require (
+	golang.org/x/exp v0.0.0-20190306152737-a1d7652674e8 // indirect
+	golang.org/x/image v0.0.0-20190227222117-0694c2d4d067 // indirect
+	golang.org/x/mobile v0.0.0-20190415191353-3e0bab5405d6 // indirect
+	golang.org/x/sys v0.0.0-20190429190828-d89cdac9e872 // indirect
+)
+
+replace github.com/chriskillpack/modplayer v0.1.0 => ../
@@ -0,0 +1,82 @@
+// Package modoto plays audio through github.com/hajimehoshi/oto, an
+// alternative to portaudio that needs no extra libraries on Windows and
+// macOS. On Linux oto uses ALSA, which needs the ALSA development headers to
+// build.
+package modoto
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/chriskillpack/modplayer"
+	"github.com/hajimehoshi/oto"
+)
+
+const (
+	bufferLen  = 2048 // most stereo samples generated at a time by Play
+	latencyDiv = 10   // the device buffer holds 1/latencyDiv seconds of audio
+)
+
+// Output plays 16-bit stereo audio through the default audio device.
+type Output struct {
+	ctx    *oto.Context
+	player *oto.Player
+	buf    []byte
+}
+
+// NewOutput opens the default audio device to play audio at sampleRate. oto
+// only allows one Output to be open at a time.
+func NewOutput(sampleRate int) (*Output, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+
+	ctx, err := oto.NewContext(sampleRate, 2, 2, sampleRate/latencyDiv*4)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Output{ctx: ctx, player: ctx.NewPlayer()}, nil
+}
+
+// Write plays stereo sample data (LRLRLR...). It blocks until the audio
+// device has room for the samples.
+func (o *Output) Write(samples []int16) error {
+	if cap(o.buf) < len(samples)*2 {
+		o.buf = make([]byte, len(samples)*2)
+	}
+	buf := o.buf[:len(samples)*2]
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(s))
+	}
+
+	_, err := o.player.Write(buf)
+	return err
+}
+
+// Close stops playback and closes the audio device.
+func (o *Output) Close() error {
+	if err := o.player.Close(); err != nil {
+		o.ctx.Close()
+		return err
+	}
+	return o.ctx.Close()
+}
+
+// Play plays player through the default audio device until the song ends,
+// the player is stopped or ctx is cancelled, see Player.PlayUntilDone.
+func Play(ctx context.Context, player *modplayer.Player) error {
+	out, err := NewOutput(int(player.SampleRate()))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]int16, min(bufferLen, player.MixBufferSize())*2)
+	err = player.PlayUntilDone(ctx, buf, out.Write)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
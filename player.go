@@ -4,13 +4,20 @@ package modplayer
 
 import (
 	"cmp"
+	"context"
 	"fmt"
 	"io"
 	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	retracePALHz = 14187578.4 // Amiga PAL vertical retrace timing
+	retracePALHz  = 14187578.4 // Amiga PAL vertical retrace timing
+	retraceNTSCHz = 14318181.0 // Amiga NTSC vertical retrace timing
 
 	rowsPerPattern = 64
 	noteKeyOff     = 254
@@ -20,6 +27,18 @@ const (
 	maxPeriod      = 65535
 	mixBufferLen   = 8192 // samples per channel
 	noNoteVolume   = 255  // note data does not have a volume set
+	maxVoices      = 64   // maximum number of background (virtual) voices
+	maxFadeVolume  = 1024 // fade volume of a voice that has not started fading
+	maxZeroSearch  = 64   // how far to look for a zero crossing when declicking
+	rowsPerBeat    = 4    // rows in a beat, used by the modern tempo mode
+	noPanOverride  = -1   // channel pan is not overridden
+	meterFullScale = 8192 // meter level of a full scale sample at maxVolume
+	meterPeakFall  = 1.5  // how far a meter peak falls per second
+
+	parallelMixChannels  = 16 // fewest channels that are mixed on several goroutines
+	minChannelsPerWorker = 4  // fewest channels each mixing goroutine mixes
+
+	periodTableNotes = 11 * 12 // notes in a period table, C-(-1) to B-9
 
 	// MOD note effects
 	effectPortamentoUp        = 0x1
@@ -45,6 +64,7 @@ const (
 	effectS3MPortamentoUp    = 0x23
 	effectS3MGlobalVolume    = 0x24
 	effectNoteRetrigVolSlide = 0x25
+	effectGlobalVolumeSlide  = 0x26
 
 	// Extended effects (Exy), x = effect, y effect param
 	effectExtendedVibratoWaveform  = 0x4
@@ -60,14 +80,31 @@ const (
 // see NewPlayer().
 type Player struct {
 	*Song
+	opts              PlayerOptions // the current settings, kept up to date by the setters
 	samplingFrequency uint
 	globalVolume      uint
+	memGlobalVolSlide byte // saved global volume slide parameter
 	volBoost          uint
+	masterGain        int     // output gain in 16.16 fixed point, see SetMasterGain
+	separation        int     // stereo separation percentage
+	pitchRatio        float64 // playback frequency multiplier, see SetPitchRatio
+	declick           Declick
+	interpolation     Interpolation
+	panning           Panning
+	clipping          Clipping
+	clock             AmigaClock
+	clockHz           float32
+	clockFromSong     bool // the clock follows the song, see ClockSong
+	periodMode        PeriodMode
+	periodSteps       []uint32 // 16.16 fixed point sample step of each period, see updatePeriodSteps
 
 	// song configuration
 	Tempo          int
 	Speed          int
 	samplesPerTick int
+	tempoMode      TempoMode
+	tickRemainder  int     // carried over fraction of a tick in modern tempo mode
+	tempoScale     float64 // tempo multiplier, see SetTempoScale
 
 	// These next fields track player position in the song
 	tickSamplePos int // the number of samples in the tick
@@ -75,20 +112,107 @@ type Player struct {
 	row           int // which row in the order
 	order         int // current order of the song
 	ordersplayed  int // number of orders played
-	playing       bool
 
-	// Bitmask of muted channels, channel 1 in LSB. To mute a channel set
-	// its bit to 1.
-	Mute uint
+	// Position of the next row to play. Pattern jumps, breaks and loops only
+	// change these, the current row and order move once per row.
+	nextRow, nextOrder int
+	playing            atomic.Bool
+	pendingJump        atomic.Int32 // position requested by JumpTo, order*rowsPerPattern+row, -1 for none
+
+	samplesPlayed int64         // number of samples generated since the player was created
+	rateChange    int64         // value of samplesPlayed when the sample rate last changed
+	rateChangeAt  time.Duration // playing time when the sample rate last changed
+	tempoHistory  []TempoChange // every tempo or speed change during playback
+
+	// Snapshot of the player state published for State, which can be called
+	// from other goroutines
+	state atomic.Pointer[PlayerState]
+
+	meters []meter // output levels of each channel, see ChannelState.Peak
+
+	// Clipping statistics of the output, see Stats
+	stats struct {
+		samples, clipped, peak atomic.Int64
+	}
+	scopes atomic.Pointer[scopes] // recent output of each channel, nil if disabled
+
+	surround *surroundMix // see GenerateSurround, nil until it is first called
+
+	subscribers subscribers // see Subscribe
+	sfx         sfx         // sound effect voices, see PlayNote
+	replaying   bool        // the song is being replayed silently, see replay
+
+	queueMu sync.Mutex
+	queue   []*Song // songs to play after the current one, see Queue
+
+	// Bitmask of muted channels, channel 1 in LSB, see SetMuteMask. It is
+	// atomic as it is changed from other goroutines during playback.
+	mute atomic.Uint64
 
 	PlayOrderLimit int // maximum number of orders to play, -1 to disable limit
 
+	loopCount   int // number of times to restart the song, or LoopForever
+	loopsPlayed int // number of times the song has restarted
+
+	loopPolicy    LoopPolicy
+	loopFade      time.Duration // how long LoopPolicyFade takes to fade out
+	playedRows    []uint64      // bitmask of the rows played in each order
+	fadeTotal     int           // length of the fade out in samples, 0 if not fading
+	fadeRemaining int           // samples until the fade out completes
+	fadeStops     bool          // the fade out was started by FadeOut
+	silenceStop   time.Duration // how much silence ends the song, 0 to disable
+	silentSamples int           // length of the current run of silent output
+
+	// Optional callbacks invoked as the song is played. They are called from
+	// GenerateAudio, so they should return quickly and must not call back
+	// into the Player, except for SamplesPlayed.
+	OnOrderChange func(order int)      // playback moved to a new order
+	OnRow         func(order, row int) // a new row started playing
+	OnNoteTrigger func(NoteEvent)      // a note started playing on a channel
+	OnNoteStop    func(channel int)    // the note on a channel was cut, faded out or ended
+	OnSongEnd     func()               // the end of the song or PlayOrderLimit was reached and playback stopped
+	OnSongChange  func(song *Song)     // a queued song started playing, see Queue
+
+	eventOrder int          // last order reported to OnOrderChange, -1 for none
+	stepNotes  *[]NoteEvent // collects the notes triggered during Step
+
 	loop     []loopinfo
 	channels []channel
 
+	// Period of every note for each C4 speed used by the song's samples, see
+	// notePeriod
+	periodTables map[int]*[periodTableNotes]int
+
+	// Sticky pan position of each channel set by SetChannelPan, or
+	// noPanOverride
+	panOverride []int
+
+	// Background voices, these are notes that continue to play after a new
+	// note was triggered on their channel. See NewNoteAction.
+	voices []channel
+
 	// Internal buffer the audio is mixed into. This is done to allow loud
 	// sounds without clipping.
 	mixbuffer []int
+
+	// Floating point copy of the mix buffer used by MixFloat32, see
+	// SetMixFormat
+	mixFormat  MixFormat
+	fmixbuffer []float32
+	dither     uint32 // state of the dither noise generator
+
+	// Most goroutines the channels are mixed on, see SetMixWorkers, and the
+	// mix buffers of all but the first
+	mixWorkers    int
+	workerBuffers [][]int
+
+	mixer     MixerBackend // see SetMixerBackend, never MixerAuto
+	stereoMix stereoMixer  // stereo mixer of the backend
+
+	// Applied to the output of the GenerateAudio functions, see SetEffect,
+	// and the 16-bit buffer the other formats are processed in
+	effect       Effect
+	effectbuffer []int16
 }
 
 // ChannelNoteData represents the note data for a channel
@@ -109,6 +233,35 @@ func (c *ChannelNoteData) String() string {
 type ChannelState struct {
 	Instrument         int // -1 if no instrument playing
 	TrigOrder, TrigRow int // The order and row the instrument was triggered (played)
+	SilentRows         int // Number of rows since the channel last made a sound
+
+	Period         int // Period being played, including vibrato
+	Frequency      int // Sample playback rate in Hz, 0 if no instrument playing
+	Volume         int // 0-64, including tremolo and global volume
+	Pan            int // 0=Full Left, 127=Full Right
+	SamplePosition int // Position in the sample, in sample frames
+	Effect         int // Effect on the current row, same encoding as ChannelNoteData
+	Param          int
+
+	// Pitch being played as a MIDI note number, including vibrato and
+	// transposition. The fractional part is the offset from the note in
+	// hundredths of a semitone (cents). The sample played at its C4 speed is
+	// 60, C-4. 0 if no instrument playing.
+	Pitch float64
+
+	// Output levels of the channel, where 1 is a full scale sample at full
+	// volume. Stereo separation, pan and the volume boost are not included.
+	Peak float64 // Peak level, falling back slowly after each peak
+	RMS  float64 // RMS level over the most recent tick
+}
+
+// Tracks the output level of a channel between ticks
+type meter struct {
+	peak     float64 // decaying peak level
+	rms      float64 // RMS level over the previous tick
+	tickPeak float64 // peak level since the last tick
+	sumSq    float64 // sum of the squared levels since the last tick
+	samples  int     // number of samples mixed since the last tick
 }
 
 // PlayerState holds player position and channel state
@@ -116,16 +269,96 @@ type PlayerState struct {
 	Order   int
 	Pattern int
 	Row     int
+	Tick    int
+
+	Speed        int // ticks per row
+	Tempo        int // in beats per minute
+	GlobalVolume int // 0-64
 
 	Notes    []ChannelNoteData
 	Channels []ChannelState
 }
 
+// TempoChange records a change to the song tempo or speed during playback
+type TempoChange struct {
+	Sample int64         // Position in the generated audio, in samples
+	Time   time.Duration // Position in the generated audio
+	Order  int
+	Row    int
+	Tempo  int
+	Speed  int
+}
+
+// Stats describes the audio generated by the player, see Player.Stats.
+type Stats struct {
+	Samples int64 // Number of samples output, counting left and right separately
+	Clipped int64 // Number of samples that were out of range and clamped
+	Peak    int   // Largest absolute sample value before clamping
+}
+
+// Headroom returns how far Peak is below full scale in decibels. A negative
+// headroom means the output clipped.
+func (s Stats) Headroom() float64 {
+	return 20 * math.Log10(32767/float64(s.Peak))
+}
+
+// SongDuration describes how long a song plays for, see Player.Duration.
+type SongDuration struct {
+	Total   time.Duration
+	Samples int64 // Total length in samples
+
+	// The time each order first starts playing, indexed by order. Orders that
+	// are never played are -1.
+	OrderStarts []time.Duration
+
+	// Position in samples of the row a looping song jumps back to when it
+	// starts repeating, so the song loops seamlessly from LoopStart to
+	// Samples. -1 if the song doesn't loop.
+	LoopStart int64
+}
+
+// NoteEvent describes a note that started playing, see Player.OnNoteTrigger.
+type NoteEvent struct {
+	Channel    int // tracker channel the note was played on
+	Order      int
+	Row        int
+	Tick       int
+	Instrument int // index of the sample being played
+	Period     int // playback period, 4x the Amiga period
+	Note       int // pitch as a MIDI note number, 60 is C-4
+	Volume     int // channel volume, 0-64
+}
+
+// TickEvent describes a tick of the song played by Player.Step.
+type TickEvent struct {
+	Order int
+	Row   int
+	Tick  int // 0 is the first tick of the row
+	Speed int // ticks per row
+	Tempo int // in beats per minute
+
+	Time  time.Duration // when the tick starts, measured from the start of playback
+	Notes []NoteEvent   // notes triggered during the tick
+}
+
 // playerNote defines a note pitch as octave*12+semitone
 // There are 12 semitones in an octave. This encoding is very similar to how
 // MIDI defines pitch values.
 type playerNote int
 
+// Parses a note in the name-octave form returned by String, e.g. C-4, A#2.
+func parseNote(s string) (playerNote, error) {
+	if len(s) != 3 || s[2] < '0' || s[2] > '9' {
+		return 0, fmt.Errorf("invalid note %q", s)
+	}
+	ni := slices.Index(notes, s[0:2])
+	if ni == -1 {
+		return 0, fmt.Errorf("invalid note %q", s)
+	}
+
+	return playerNote((int(s[2]-'0')+1)*12 + ni), nil
+}
+
 // String returns the note pitch in name-octave form, e.g. C-4, A#2.
 // Returns three spaces if the note is not recognized.
 func (note playerNote) String() string {
@@ -156,6 +389,17 @@ type note struct {
 	Param  byte
 }
 
+// Returns the public representation of the note
+func (n *note) noteData() ChannelNoteData {
+	return ChannelNoteData{
+		Note:       n.Pitch.String(),
+		Instrument: n.Sample,
+		Volume:     n.Volume,
+		Effect:     int(n.Effect),
+		Param:      int(n.Param),
+	}
+}
+
 type channel struct {
 	sample         int // sample that is being played (or -1 if no sample)
 	sampleToPlay   int // sample _to be played_, used for Note Delay effect
@@ -166,6 +410,7 @@ type channel struct {
 	volume         int
 	volumeToPlay   int // volume _to be played_, used for Note Delay effect
 	pan            int // Pan position, 0=Full Left, 127=Full Right
+	songPan        int // Pan position set by the song, ignoring any override
 	samplePosition uint
 
 	tremoloDepth    int
@@ -192,13 +437,143 @@ type channel struct {
 	trigOrder int
 	trigRow   int
 	trigTick  int
+
+	silentRows int // number of rows the channel has been silent for
+
+	triggered bool // note was triggered and has not been mixed yet
+	newNote   bool // note was triggered and has not been reported to OnNoteTrigger
+	sounding  bool // a note reported to OnNoteTrigger has not stopped playing
+	rampPos   int  // progress through the declick attack ramp, in samples
+
+	// Background voice state
+	owner      int  // index of the tracker channel that played the note
+	fading     bool // true if the voice is fading out
+	fadeVolume int  // 0 (silent) to maxFadeVolume
+
+	sfx bool // sound effect voice, see PlayNote
+
+	blep     blepState     // see InterpolationBLEP
+	binaural binauralState // see PanningBinaural
 }
 
+// Declick selects how the player suppresses clicks when a note is triggered.
+// Notes that start on a non-zero sample value at a high volume produce an
+// audible click.
+type Declick int
+
+const (
+	DeclickOff          Declick = iota // Notes start immediately, the default
+	DeclickZeroCrossing                // Notes start at the nearest zero crossing in the sample
+	DeclickRamp                        // Notes fade in over 1ms
+)
+
+// Panning selects how the channels are placed in the stereo output.
+type Panning int
+
+const (
+	PanningStereo   Panning = iota // Channels are mixed into the left and right outputs by their pan position, the default
+	PanningBinaural                // Channels are placed around the listener's head, for headphones
+)
+
+// MixFormat selects the number format of the output stages of the mixer, which
+// apply the master gain and convert the mix to the output format. The
+// channels are always mixed with integers.
+type MixFormat int
+
+const (
+	MixInt     MixFormat = iota // Integer output stages, the default and the cheapest
+	MixFloat32                  // Floating point output stages, 16-bit output is dithered
+)
+
+// Clipping selects what happens to mixed samples that are louder than the
+// output can represent, which happens with loud songs and high volume boosts.
+type Clipping int
+
+const (
+	ClipHard Clipping = iota // Samples are clamped to the output range, the default. Clipped peaks sound harsh
+	ClipSoft                 // Samples above 3/4 of full scale are smoothly saturated, peaks distort gently
+)
+
+// Fraction of full scale above which ClipSoft saturates samples
+const softClipKnee = 0.75
+
+// Interpolation selects how the player reads sample data between sample
+// frames when a sample is played at a different rate to the output.
+type Interpolation int
+
+const (
+	InterpolationNone   Interpolation = iota // Nearest frame, the crunchy sound of the original players and the default
+	InterpolationLinear                      // Straight line between the two nearest frames
+	InterpolationCubic                       // Catmull-Rom spline through the four nearest frames
+	InterpolationSinc                        // Windowed sinc through the 16 nearest frames, the best quality but the slowest
+	InterpolationBLEP                        // Frames held like the Amiga's Paula chip, with band-limited steps between them
+)
+
+// TempoMode selects how the song tempo is converted into tick durations.
+type TempoMode int
+
+const (
+	// TempoModeClassic uses the ProTracker timing where each tick lasts
+	// 2.5/tempo seconds, so the length of a row depends on the speed.
+	TempoModeClassic TempoMode = iota
+
+	// TempoModeModern treats the tempo as beats per minute of 4 rows, so
+	// every row lasts exactly 15000/tempo milliseconds regardless of speed.
+	TempoModeModern
+)
+
+// PeriodMode selects how notes in MOD files are converted into periods.
+type PeriodMode int
+
+const (
+	// PeriodLinear converts notes into periods with a tuning formula, the
+	// same as S3M files. This is the default.
+	PeriodLinear PeriodMode = iota
+
+	// PeriodAmiga looks up note periods in the ProTracker period tables,
+	// which finetuned samples need to sound exactly as they did on an Amiga.
+	// Notes outside the three ProTracker octaves fall back to PeriodLinear.
+	PeriodAmiga
+)
+
+// LoopPolicy selects what the player does when the song jumps back to a row
+// it has already played.
+type LoopPolicy int
+
+const (
+	LoopPolicyLoop LoopPolicy = iota // Keep playing, the default
+	LoopPolicyStop                   // Stop as if the end of the song was reached
+	LoopPolicyFade                   // Keep playing while fading out, then stop
+)
+
 type loopinfo struct {
 	start int
 	count int
 }
 
+// NewNoteAction controls what happens to a playing note when a new note is
+// triggered on the same channel.
+type NewNoteAction int
+
+const (
+	NNACut      NewNoteAction = iota // Stop the playing note, MOD and S3M behavior
+	NNAContinue                      // Keep playing the note in the background
+	NNANoteOff                       // Release the note, it fades out in the background
+	NNANoteFade                      // Fade out the note in the background
+)
+
+// AmigaClock selects the Amiga hardware timing used to convert note periods
+// into playback frequencies. Songs written on NTSC machines play slightly flat
+// with PAL timing.
+type AmigaClock int
+
+const (
+	ClockPAL  AmigaClock = iota // European Amiga timing, the default
+	ClockNTSC                   // North American Amiga timing
+
+	ClockSong AmigaClock = -1 // The song's default timing, see Song.Clock
+)
+
 // Song represents a MOD or S3M file
 type Song struct {
 	Title        string
@@ -208,6 +583,12 @@ type Song struct {
 	Speed        int // number of tempo ticks before advancing to the next row
 	GlobalVolume int
 	Type         SongType
+	Clock        AmigaClock // Default timing for the song, see Player.SetClock
+
+	Message     string // Song message, empty if the format doesn't have one
+	TrackerName string // Name and version of the tracker that saved the song, if known
+	FileFormat  string // Format signature in the file, e.g. "M.K." or "SCRM"
+	Flags       int    // Format specific song flags, the S3M header flags or 0
 
 	Samples  []Sample
 	patterns [][]note
@@ -229,7 +610,14 @@ type Sample struct {
 	LoopStart int
 	LoopLen   int
 	C4Speed   int
+	FineTune  int // MOD finetune, -8 to 7. Only used by PeriodAmiga
 	Data      []int8
+	Data16    []int16 // Data widened to 16 bits, nil unless Song.WidenSamples was called
+
+	// The MOD and S3M loaders leave these at NNACut and 0, for formats with
+	// new note actions they have to be set by hand
+	NNA     NewNoteAction // What happens to the sample when a new note is played
+	FadeOut int           // Fade speed for NNANoteOff & NNANoteFade, 0-1024 per tick, 0 cuts the note
 }
 
 func (s Sample) String() string {
@@ -311,24 +699,121 @@ func (c *channel) volumeSlide(param byte) {
 
 func SetDumpWriter(w io.Writer) { dumpW = w }
 
+// PlayerOptions holds the settings for a new Player, see NewPlayerWithOptions.
+// Start from DefaultPlayerOptions and change the settings that are needed.
+type PlayerOptions struct {
+	Stopped          bool          // the Player is stopped until Start is called
+	VolumeBoost      int           // see Player.SetVolumeBoost
+	MasterGain       float64       // see Player.SetMasterGain
+	Clipping         Clipping      // see Player.SetClipping
+	StereoSeparation int           // see Player.SetStereoSeparation
+	Mute             uint          // see Player.SetMuteMask
+	PitchRatio       float64       // see Player.SetPitchRatio
+	TempoScale       float64       // see Player.SetTempoScale
+	Clock            AmigaClock    // see Player.SetClock
+	TempoMode        TempoMode     // see Player.SetTempoMode
+	PeriodMode       PeriodMode    // see Player.SetPeriodMode
+	Declick          Declick       // see Player.SetDeclick
+	Interpolation    Interpolation // see Player.SetInterpolation
+	Panning          Panning       // see Player.SetPanning
+	MixFormat        MixFormat     // see Player.SetMixFormat
+	MixWorkers       int           // see Player.SetMixWorkers
+	Mixer            MixerBackend  // see Player.SetMixerBackend
+	LoopPolicy       LoopPolicy    // see Player.SetLoopPolicy
+	LoopFade         time.Duration // fade out time for LoopPolicyFade
+	LoopCount        int           // see Player.SetLoopCount
+	SilenceStop      time.Duration // see Player.SetSilenceStop
+	PlayOrderLimit   int           // initial value of Player.PlayOrderLimit
+	MixBufferSize    int           // most stereo samples one GenerateAudio call can generate
+}
+
+// DefaultPlayerOptions returns the settings used by NewPlayer.
+func DefaultPlayerOptions() PlayerOptions {
+	return PlayerOptions{
+		VolumeBoost:      1,
+		MasterGain:       1,
+		StereoSeparation: 100,
+		PitchRatio:       1,
+		TempoScale:       1,
+		Clock:            ClockSong,
+		PlayOrderLimit:   -1,
+		MixBufferSize:    mixBufferLen,
+	}
+}
+
 // NewPlayer returns a new Player for the given song. The Player is already
 // started.
 func NewPlayer(song *Song, samplingFrequency uint) (*Player, error) {
+	return NewPlayerWithOptions(song, samplingFrequency, DefaultPlayerOptions())
+}
+
+// NewStoppedPlayer returns a new Player for the given song like NewPlayer, but
+// the Player is stopped until Start is called.
+func NewStoppedPlayer(song *Song, samplingFrequency uint) (*Player, error) {
+	opts := DefaultPlayerOptions()
+	opts.Stopped = true
+
+	return NewPlayerWithOptions(song, samplingFrequency, opts)
+}
+
+// NewPlayerWithOptions returns a new Player for the given song with the
+// settings in opts. Returns an error if any of the settings are invalid.
+func NewPlayerWithOptions(song *Song, samplingFrequency uint, opts PlayerOptions) (*Player, error) {
+	if opts.MixBufferSize < 1 {
+		return nil, fmt.Errorf("invalid mix buffer size %d", opts.MixBufferSize)
+	}
+
 	player := &Player{
+		opts:              opts,
 		samplingFrequency: samplingFrequency,
-		volBoost:          1,
+		pitchRatio:        1,
+		tempoScale:        1,
 		globalVolume:      uint(song.GlobalVolume),
 		Song:              song,
 		Speed:             6,
-		PlayOrderLimit:    -1,
+		PlayOrderLimit:    opts.PlayOrderLimit,
+		tempoMode:         opts.TempoMode,
+		periodMode:        opts.PeriodMode,
+		declick:           opts.Declick,
+		panning:           opts.Panning,
+		clipping:          opts.Clipping,
+	}
+	if err := player.SetVolumeBoost(opts.VolumeBoost); err != nil {
+		return nil, err
+	}
+	if err := player.SetMasterGain(opts.MasterGain); err != nil {
+		return nil, err
+	}
+	if err := player.SetStereoSeparation(opts.StereoSeparation); err != nil {
+		return nil, err
+	}
+	if err := player.SetMixerBackend(opts.Mixer); err != nil {
+		return nil, err
+	}
+	player.SetClock(opts.Clock)
+	player.SetInterpolation(opts.Interpolation)
+	player.SetMixWorkers(opts.MixWorkers)
+	player.SetLoopPolicy(opts.LoopPolicy, opts.LoopFade)
+	player.SetLoopCount(opts.LoopCount)
+	player.SetSilenceStop(opts.SilenceStop)
+	player.SetMuteMask(opts.Mute)
+
+	player.setSong(song)
+	player.voices = make([]channel, 0, maxVoices)
+	player.mixbuffer = make([]int, opts.MixBufferSize*2)
+	player.SetMixFormat(opts.MixFormat)
+	if err := player.SetPitchRatio(opts.PitchRatio); err != nil {
+		return nil, err
 	}
-
-	player.loop = make([]loopinfo, song.Channels)
-	player.channels = make([]channel, song.Channels)
-	player.mixbuffer = make([]int, mixBufferLen*2)
 
 	player.reset()
-	player.Start()
+	if err := player.SetTempoScale(opts.TempoScale); err != nil {
+		return nil, err
+	}
+	player.recordTempo()
+	if !opts.Stopped {
+		player.Start()
+	}
 
 	return player, nil
 }
@@ -336,7 +821,7 @@ func NewPlayer(song *Song, samplingFrequency uint) (*Player, error) {
 // Start tells the player to start playing. Calls to GenerateAudio will advance
 // the song position and generate audio samples.
 func (p *Player) Start() {
-	p.playing = true
+	p.playing.Store(true)
 }
 
 // Stop tells the player to stop playing. Calls to GenerateAudio will not
@@ -344,39 +829,57 @@ func (p *Player) Start() {
 // preserves state and a subsequent call to Start carries on where the player
 // left off.
 func (p *Player) Stop() {
-	p.playing = false
+	p.playing.Store(false)
+}
+
+// Reset stops the player and rewinds it to the start of the song. Channel,
+// tempo and effect state are returned to how they were when the player was
+// created, while settings such as the volume boost, loop count and channel pan
+// overrides are kept.
+func (p *Player) Reset() {
+	p.reset()
+	p.ordersplayed = 0
+	p.loopsPlayed = 0
+	p.recordTempo()
+}
+
+// Restart rewinds the player to the start of the song like Reset and starts
+// playing.
+func (p *Player) Restart() {
+	p.Reset()
+	p.Start()
 }
 
 // IsPlaying returns if the song is being played
 func (p *Player) IsPlaying() bool {
-	return p.playing
+	return p.playing.Load()
 }
 
-// State returns the current state of the player (song position, channel state, etc.)
+// State returns the current state of the player (song position, channel
+// state, etc.) as of the most recently played tick. It is safe to call State
+// from a different goroutine to the one generating audio.
 func (p *Player) State() PlayerState {
-	rc := p.row
-	if rc < 0 {
-		rc = 0
-	}
-	state := PlayerState{Order: p.order, Pattern: int(p.Song.Orders[p.order]), Row: rc}
-	state.Notes = make([]ChannelNoteData, p.Channels)
-	state.Channels = make([]ChannelState, p.Channels)
-
-	pattern := int(p.Song.Orders[p.order])
-	rowDataIdx := p.rowDataIndex()
-
-	for i := range state.Notes {
-		patnote := &p.Song.patterns[pattern][rowDataIdx]
+	state := *p.state.Load()
+	state.Notes = slices.Clone(state.Notes)
+	state.Channels = slices.Clone(state.Channels)
 
-		note := &state.Notes[i]
-		note.Note = patnote.Pitch.String()
-		note.Instrument = patnote.Sample
-		note.Volume = patnote.Volume
-		note.Effect = int(patnote.Effect)
-		note.Param = int(patnote.Param)
+	return state
+}
 
-		rowDataIdx++
+// Builds a snapshot of the player state at the given position and publishes
+// it for State.
+func (p *Player) publishState(order, row, tick int) {
+	state := &PlayerState{
+		Order:        order,
+		Pattern:      int(p.Song.Orders[order]),
+		Row:          row,
+		Tick:         tick,
+		Speed:        p.Speed,
+		Tempo:        p.Tempo,
+		GlobalVolume: int(p.globalVolume),
 	}
+	state.Notes = p.NoteDataFor(order, row)
+	state.Channels = make([]ChannelState, p.Channels)
 
 	for i := range p.channels {
 		state.Channels[i].Instrument = p.channels[i].sample
@@ -387,9 +890,29 @@ func (p *Player) State() PlayerState {
 			state.Channels[i].TrigOrder = -1
 			state.Channels[i].TrigRow = -1
 		}
+		state.Channels[i].SilentRows = p.channels[i].silentRows
+		p.channelState(&p.channels[i], &state.Channels[i])
+		state.Channels[i].Peak = p.meters[i].peak
+		state.Channels[i].RMS = p.meters[i].rms
 	}
 
-	return state
+	p.state.Store(state)
+}
+
+// Fills in the live playback fields of cs from channel c.
+func (p *Player) channelState(c *channel, cs *ChannelState) {
+	cs.Pan = c.pan
+	cs.Effect = int(c.effect)
+	cs.Param = int(c.param)
+	if c.sample == -1 || c.period == 0 {
+		return
+	}
+
+	cs.Period = c.period + c.vibratoAdjust*4
+	cs.Frequency = p.playbackHz(c)
+	cs.Pitch = p.playbackPitch(c)
+	cs.Volume = max(p.channelVolume(c), 0)
+	cs.SamplePosition = int(c.samplePosition >> 16)
 }
 
 // SeekTo sets the player's current position. If the position is off the end of
@@ -397,155 +920,935 @@ func (p *Player) State() PlayerState {
 // attempt is made to reset the player internals.
 func (p *Player) SeekTo(order, row int) {
 	p.order = clamp(order, 0, len(p.Orders)-1)
-	p.row = clamp(row, 0, 63) - 1
+	p.row = clamp(row, 0, 63)
+	p.nextOrder, p.nextRow = p.order, p.row
 	p.tick = p.Speed - 1
+	p.publishState(p.order, p.row, 0)
 }
 
-// SetVolumeBoost sets the volume boost factor to a value between 1 (no boost,
-// default and 4 (4x volume).
-func (p *Player) SetVolumeBoost(boost int) error {
-	if boost < 1 || boost > 4 {
-		return fmt.Errorf("invalid volume boost")
+// SeekToTime moves playback to d from the start of the song. The song is
+// played silently up to that point so that tempo, volume and effect state are
+// the same as if it had been played normally. Returns an error if the song
+// ends before d, in which case the player is left at the start of the song.
+func (p *Player) SeekToTime(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid seek time %s", d)
+	}
+	target := int64(d) * int64(p.samplingFrequency) / int64(time.Second)
+
+	reached := p.replay(func() bool {
+		for {
+			if p.tickSamplePos >= p.samplesPerTick {
+				if p.sequenceTick() {
+					return false
+				}
+				p.tickSamplePos = 0
+			}
+
+			n := min(int64(p.samplesPerTick-p.tickSamplePos), target-p.samplesPlayed)
+			if n <= 0 {
+				return true
+			}
+			p.skipChannels(int(n))
+			p.tickSamplePos += int(n)
+			p.samplesPlayed += n
+		}
+	})
+	if !reached {
+		return fmt.Errorf("seek time %s is past the end of the song", d)
 	}
-	p.volBoost = uint(boost)
 
 	return nil
 }
 
-// NoteDataFor returns the note data for a specific order and row, or nil if
-// the requested position is invalid.
-func (p *Player) NoteDataFor(order, row int) []ChannelNoteData {
-	if order < 0 || row < 0 || order >= len(p.Orders) || row >= 64 {
-		return nil
+// FastForwardTo moves playback to the start of a row like SeekTo, but plays
+// the song silently from the beginning up to that row so that tempo, speed,
+// effect memory and channel state are the same as if it had been played
+// normally. Returns an error if the row is never played, in which case the
+// player is left at the start of the song.
+func (p *Player) FastForwardTo(order, row int) error {
+	if order < 0 || order >= len(p.Orders) || row < 0 || row >= 64 {
+		return fmt.Errorf("invalid position %d:%d", order, row)
 	}
-	nd := make([]ChannelNoteData, p.Channels)
 
-	pattern := p.Orders[order]
-	rowDataIdx := row * p.Song.Channels
-	for i := 0; i < p.Channels; i++ {
-		patnote := &p.Song.patterns[pattern][rowDataIdx]
+	if !p.replay(func() bool { return p.runTo(order, row) }) {
+		return fmt.Errorf("row %d of order %d is never played", row, order)
+	}
+
+	return nil
+}
+
+// JumpToOrder moves playback to the start of order n when the current row
+// finishes, so the tick being played is not cut short. Jumping back to an
+// order that has already played is not treated as the song looping. Returns
+// an error if n is not a valid order.
+//
+// It is safe to call JumpToOrder from a different goroutine to the one
+// generating audio.
+func (p *Player) JumpToOrder(n int) error {
+	if n < 0 || n >= len(p.Orders) {
+		return fmt.Errorf("invalid order %d", n)
+	}
+	p.pendingJump.Store(int32(n * rowsPerPattern))
 
-		note := &nd[i]
-		note.Note = patnote.Pitch.String()
-		note.Instrument = patnote.Sample
-		note.Volume = patnote.Volume
-		note.Effect = int(patnote.Effect)
-		note.Param = int(patnote.Param)
+	return nil
+}
 
-		rowDataIdx++
+// JumpTo moves playback to a row of an order when the current row finishes,
+// like JumpToOrder. Returns an error if the position is not in the song.
+//
+// It is safe to call JumpTo from a different goroutine to the one generating
+// audio.
+func (p *Player) JumpTo(order, row int) error {
+	if order < 0 || order >= len(p.Orders) || row < 0 || row >= rowsPerPattern {
+		return fmt.Errorf("invalid position %d:%d", order, row)
 	}
+	p.pendingJump.Store(int32(order*rowsPerPattern + row))
 
-	return nd
+	return nil
 }
 
-func (p *Player) reset() {
-	p.Stop()
-	p.setTempo(p.Song.Tempo)
-	p.Speed = p.Song.Speed
-	p.order = 0
+// JumpRows moves playback n rows forwards, or backwards if n is negative,
+// when the current row finishes, see JumpTo. Moving past the end of a pattern
+// continues into the next order, ignoring pattern breaks. Repeated calls
+// before the row finishes move further. Jumps stop at the first row of the
+// first order and the last row of the last order.
+//
+// It is safe to call JumpRows from a different goroutine to the one
+// generating audio.
+func (p *Player) JumpRows(n int) {
+	order, row := p.navigationPosition()
+	pos := clamp(order*rowsPerPattern+row+n, 0, len(p.Orders)*rowsPerPattern-1)
+	p.pendingJump.Store(int32(pos))
+}
 
-	// Setup counters so that the first "tick" of the player executes the
-	// first row immediately.
-	p.tick = p.Speed - 1
-	p.row = -1
-	p.tickSamplePos = p.samplesPerTick
+// NextOrder moves playback to the start of the next order when the current
+// row finishes, see JumpToOrder. Repeated calls before the row finishes skip
+// further ahead. Returns an error if playback is on the last order.
+func (p *Player) NextOrder() error {
+	order, _ := p.navigationPosition()
+	return p.JumpToOrder(order + 1)
+}
 
-	for i := 0; i < p.Song.Channels; i++ {
-		channel := &p.channels[i]
-		channel.sample = -1
-		channel.sampleToPlay = -1
-		channel.volume = 0
-		channel.volumeToPlay = 0
-		channel.period = 0
-		channel.periodToPlay = 0
-		channel.tremoloDepth = 0
-		channel.tremoloSpeed = 0
-		channel.tremoloPhase = 0
-		channel.tremoloAdjust = 0
-		channel.vibratoDepth = 0
-		channel.vibratoSpeed = 0
-		channel.vibratoPhase = 0
-		channel.vibratoAdjust = 0
-		channel.vibratoWaveform = vibratoSine
-		channel.pan = int(p.Song.pan[i])
-		channel.memVolSlide = 0
-		channel.memPortamento = 0
-		channel.memRetrig = 0
+// PrevOrder moves playback to the start of the previous order when the
+// current row finishes, see JumpToOrder. Returns an error if playback is on
+// the first order.
+func (p *Player) PrevOrder() error {
+	order, _ := p.navigationPosition()
+	return p.JumpToOrder(order - 1)
+}
+
+// Returns the order and row that NextOrder, PrevOrder and JumpRows move
+// relative to, the pending jump if there is one, otherwise the row being
+// played.
+func (p *Player) navigationPosition() (int, int) {
+	if pos := p.pendingJump.Load(); pos >= 0 {
+		return int(pos) / rowsPerPattern, int(pos) % rowsPerPattern
 	}
+	state := p.State()
+	return state.Order, state.Row
 }
 
-func (p *Player) setTempo(tempo int) {
-	// TODO: What to do if new samplesPerTick value is now < tickSamplePos?
-	p.samplesPerTick = int((p.samplingFrequency<<1)+(p.samplingFrequency>>1)) / tempo
-	p.Tempo = tempo
+// Queue adds song to the songs to play after the current one. When the
+// current song ends, including any repeats from SetLoopCount, the next song
+// in the queue starts playing immediately, within the same call to
+// GenerateAudio, and OnSongChange is called. The player's settings carry over
+// to the queued song, except for channel pan positions set by SetChannelPan.
+// Stopping a song with FadeOut does not start the next one.
+//
+// It is safe to call Queue from a different goroutine to the one generating
+// audio.
+func (p *Player) Queue(song *Song) {
+	p.queueMu.Lock()
+	p.queue = append(p.queue, song)
+	p.queueMu.Unlock()
 }
 
-func (p *Player) setSpeed(speed int) {
-	p.Speed = speed
-	p.tick = p.Speed - 1 // TODO - is setting the tick like this appropriate?
+// Removes and returns the first song in the queue, or nil if it is empty.
+func (p *Player) dequeue() *Song {
+	p.queueMu.Lock()
+	defer p.queueMu.Unlock()
+
+	if len(p.queue) == 0 {
+		return nil
+	}
+	song := p.queue[0]
+	p.queue = p.queue[1:]
+
+	return song
 }
 
-func (p *Player) channelTick(c *channel, ci, tick int) {
-	c.effectCounter++
+// Resets the player to the start of the song and calls run to silently move
+// it forward, returning what run returns. If run returns false the player is
+// reset to the start of the song again. Replaying the song is not reported to
+// the callbacks, counted as generated audio or limited by PlayOrderLimit, and
+// the player keeps its playing state.
+func (p *Player) replay(run func() bool) bool {
+	playing, limit := p.playing.Load(), p.PlayOrderLimit
+	samplesPlayed, history := p.samplesPlayed, p.tempoHistory
+	onOrderChange, onRow, onNoteTrigger, onNoteStop, onSongEnd := p.OnOrderChange, p.OnRow, p.OnNoteTrigger, p.OnNoteStop, p.OnSongEnd
+	p.OnOrderChange, p.OnRow, p.OnNoteTrigger, p.OnNoteStop, p.OnSongEnd = nil, nil, nil, nil, nil
+	p.replaying = true
+	defer func() {
+		p.OnOrderChange, p.OnRow, p.OnNoteTrigger, p.OnNoteStop, p.OnSongEnd = onOrderChange, onRow, onNoteTrigger, onNoteStop, onSongEnd
+		p.replaying = false
+		p.samplesPlayed, p.tempoHistory = samplesPlayed, history
+		p.eventOrder = -1
+		p.recordTempo()
+		p.playing.Store(playing)
+		p.publishState(p.order, p.row, p.tick)
+
+		// PlayOrderLimit counts from the new position
+		p.PlayOrderLimit = limit
+		p.ordersplayed = 0
+	}()
+
+	p.reset()
+	p.PlayOrderLimit = -1
+	p.samplesPlayed = 0
+
+	if !run() {
+		// Wherever the song stopped is no use to the caller
+		p.reset()
+		return false
+	}
+	return true
+}
 
-	switch c.effect {
-	case effectPortamentoUp:
-		c.period = max(c.period-int(c.param)*4, minPeriod)
-	case effectPortamentoDown:
-		c.period = min(c.period+int(c.param)*4, maxPeriod)
-	case effectPortaToNote:
-		c.portaToNote()
-	case effectVibrato:
-		c.vibrato()
-		c.vibratoPhase = (c.vibratoPhase + c.vibratoSpeed) & 63
-	case effectPortaToNoteVolSlide:
-		c.portaToNote()
-		if p.Song.Type == SongTypeS3M {
-			c.volumeSlide(c.memVolSlide)
-		} else {
-			c.volumeSlide(c.param)
-		}
-	case effectVibratoVolSlide:
-		c.vibrato()
-		c.vibratoPhase = (c.vibratoPhase + c.vibratoSpeed) & 63
-		if p.Song.Type == SongTypeS3M {
-			c.volumeSlide(c.memVolSlide)
-		} else {
-			c.volumeSlide(c.param)
-		}
-	case effectTremolo:
-		c.tremolo()
-		c.tremoloPhase = (c.tremoloPhase + c.tremoloSpeed) & 63
-	case effectVolumeSlide:
-		c.volumeSlide(c.param)
-	case effectS3MVolumeSlide:
-		// Fine slides are not applied on in between ticks
-		x := c.memVolSlide >> 4
-		y := c.memVolSlide & 0xF
-		if x == 0xF || y == 0xF {
-			break
-		}
+// LoopForever can be passed to SetLoopCount to restart the song indefinitely.
+const LoopForever = -1
 
-		// Dxy
-		// Strictly speaking these values should be either Dx0 or D0y. When they
-		// are Dxy then y takes precedence and x is ignored.
-		if y > 0 {
-			// slide the volume down by y units
-			c.volume = max(c.volume-int(y), minVolume)
-			break
-		}
-		// slide the volume up by x units
-		c.volume = min(c.volume+int(x), maxVolume)
-	case effectS3MPortamentoDown:
-		// Dxy
-		// Fine and extra fine slides are not applied on in between ticks
-		if c.memPortamento >= 0xE0 {
-			break
-		}
-		c.period = min(c.period+int(c.memPortamento)*4, maxPeriod)
-	case effectS3MPortamentoUp:
-		// Dxy
-		// Fine and extra fine slides are not applied on in between ticks
+// SetLoopCount sets how many times the song restarts from the beginning when
+// it ends or reaches PlayOrderLimit, or LoopForever to never stop. The default
+// is 0, where the player stops at the end of the song.
+func (p *Player) SetLoopCount(n int) {
+	p.loopCount = max(n, LoopForever)
+	p.loopsPlayed = 0
+	p.opts.LoopCount = p.loopCount
+}
+
+// SetLoopPolicy sets what happens when the song jumps back to a row it has
+// already played, which is how many songs loop forever. For LoopPolicyFade the
+// song fades out over fade.
+func (p *Player) SetLoopPolicy(policy LoopPolicy, fade time.Duration) {
+	p.loopPolicy = policy
+	p.loopFade = fade
+	p.opts.LoopPolicy, p.opts.LoopFade = policy, fade
+}
+
+// SetSilenceStop ends the song once the output has been digitally silent for
+// d, as if the end of the song had been reached. This stops songs that
+// carry on through empty orders after the music has finished. Muted channels
+// count as silent. The default is 0, which disables it.
+func (p *Player) SetSilenceStop(d time.Duration) {
+	p.silenceStop = max(d, 0)
+	p.silentSamples = 0
+	p.opts.SilenceStop = p.silenceStop
+}
+
+// FadeOut fades the output to silence over d and then stops the player, as if
+// the end of the song had been reached. The song does not restart, regardless
+// of SetLoopCount.
+func (p *Player) FadeOut(d time.Duration) {
+	p.startFade(d)
+	p.fadeStops = true
+}
+
+// SetChannelPan overrides the pan position of channel ci (0 is the first
+// channel) to pan, from 0 (full left) to 127 (full right). If sticky is true
+// the override stays in place through pan effects in the song and song
+// restarts, otherwise the next pan effect replaces it.
+func (p *Player) SetChannelPan(ci, pan int, sticky bool) error {
+	if ci < 0 || ci >= p.Song.Channels {
+		return fmt.Errorf("invalid channel %d", ci)
+	}
+	if pan < 0 || pan > 127 {
+		return fmt.Errorf("invalid pan position %d", pan)
+	}
+
+	p.channels[ci].pan = pan
+	if sticky {
+		p.panOverride[ci] = pan
+	} else {
+		p.panOverride[ci] = noPanOverride
+	}
+
+	return nil
+}
+
+// ResetChannelPan removes any pan override from channel ci, restoring the pan
+// position set by the song.
+func (p *Player) ResetChannelPan(ci int) error {
+	if ci < 0 || ci >= p.Song.Channels {
+		return fmt.Errorf("invalid channel %d", ci)
+	}
+
+	p.panOverride[ci] = noPanOverride
+	p.channels[ci].pan = p.channels[ci].songPan
+
+	return nil
+}
+
+// SetMuteMask sets which channels are muted from a bitmask, channel 1 in the
+// LSB. Set a channel's bit to 1 to mute it. The default is 0, no channels
+// muted.
+//
+// It is safe to call SetMuteMask, MuteChannel and UnmuteChannel from a
+// different goroutine to the one generating audio.
+func (p *Player) SetMuteMask(mask uint) {
+	p.mute.Store(uint64(mask))
+}
+
+// MuteMask returns the bitmask of muted channels, see SetMuteMask.
+func (p *Player) MuteMask() uint {
+	return uint(p.mute.Load())
+}
+
+// MuteChannel silences channel ci. The channel carries on playing so that it
+// is in step with the song when it is unmuted.
+func (p *Player) MuteChannel(ci int) error {
+	if ci < 0 || ci >= p.Song.Channels {
+		return fmt.Errorf("invalid channel %d", ci)
+	}
+	p.updateMute(func(mask uint64) uint64 { return mask | 1<<ci })
+
+	return nil
+}
+
+// UnmuteChannel makes channel ci audible again after MuteChannel.
+func (p *Player) UnmuteChannel(ci int) error {
+	if ci < 0 || ci >= p.Song.Channels {
+		return fmt.Errorf("invalid channel %d", ci)
+	}
+	p.updateMute(func(mask uint64) uint64 { return mask &^ (1 << ci) })
+
+	return nil
+}
+
+// Atomically replaces the mute mask with the result of fn.
+func (p *Player) updateMute(fn func(uint64) uint64) {
+	for {
+		old := p.mute.Load()
+		if p.mute.CompareAndSwap(old, fn(old)) {
+			return
+		}
+	}
+}
+
+// SetStereoSeparation sets how far apart channels are panned, from 0 (mono)
+// to 100 (the song's panning, default). Values in between blend each channel
+// toward the center.
+func (p *Player) SetStereoSeparation(pct int) error {
+	if pct < 0 || pct > 100 {
+		return fmt.Errorf("invalid stereo separation")
+	}
+	p.separation = pct
+	p.opts.StereoSeparation = pct
+
+	return nil
+}
+
+// SetPitchRatio multiplies the playback frequency of every note by ratio
+// without changing the tempo, e.g. 2 plays the song an octave higher. The
+// default is 1.
+func (p *Player) SetPitchRatio(ratio float64) error {
+	if ratio <= 0 || math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+		return fmt.Errorf("invalid pitch ratio")
+	}
+	p.pitchRatio = ratio
+	p.opts.PitchRatio = ratio
+	p.updatePeriodSteps()
+
+	return nil
+}
+
+// SetTranspose shifts the pitch of every note by semitones without changing
+// the tempo. Fractional semitones are allowed. This is the same as calling
+// SetPitchRatio with 2^(semitones/12).
+func (p *Player) SetTranspose(semitones float64) error {
+	return p.SetPitchRatio(math.Pow(2, semitones/12))
+}
+
+// SetVolumeBoost sets the volume boost factor to a value between 1 (no boost,
+// default and 4 (4x volume).
+func (p *Player) SetVolumeBoost(boost int) error {
+	if boost < 1 || boost > 4 {
+		return fmt.Errorf("invalid volume boost")
+	}
+	p.volBoost = uint(boost)
+	p.opts.VolumeBoost = boost
+
+	return nil
+}
+
+// Master gain that leaves the output unchanged
+const unityGain = 1 << 16
+
+// SetMasterGain multiplies the output by gain after the channels are mixed,
+// for finer control of the volume than SetVolumeBoost, e.g. 0.5 halves the
+// volume. The gain is applied before the output is clamped and is included in
+// Stats. The default is 1.
+func (p *Player) SetMasterGain(gain float64) error {
+	if gain <= 0 || math.IsInf(gain, 0) || math.IsNaN(gain) || gain > 256 {
+		return fmt.Errorf("invalid master gain")
+	}
+	p.masterGain = int(math.Round(gain * unityGain))
+	p.opts.MasterGain = gain
+
+	return nil
+}
+
+// SamplesPlayed returns the number of stereo samples generated since the
+// player was created, the position in the generated audio that Event.Sample
+// and TempoChange.Sample are measured in. Called from OnOrderChange or OnRow
+// it returns the position where the order or row starts.
+func (p *Player) SamplesPlayed() int64 {
+	return p.samplesPlayed
+}
+
+// TempoHistory returns every tempo and speed change that happened while
+// generating audio, in the order they happened. The first entry is the
+// song's initial tempo and speed.
+func (p *Player) TempoHistory() []TempoChange {
+	return slices.Clone(p.tempoHistory)
+}
+
+// SetClock sets the Amiga timing used to compute note playback frequencies,
+// overriding the song's default. ClockSong restores the song's default.
+func (p *Player) SetClock(clock AmigaClock) {
+	p.opts.Clock = clock
+	p.clockFromSong = clock == ClockSong
+	if p.clockFromSong {
+		clock = p.Song.Clock
+	}
+	p.clock = clock
+	switch clock {
+	case ClockNTSC:
+		p.clockHz = retraceNTSCHz
+	default:
+		p.clockHz = retracePALHz
+	}
+	p.updatePeriodSteps()
+}
+
+// Clock returns the Amiga timing the player is using.
+func (p *Player) Clock() AmigaClock {
+	return p.clock
+}
+
+// SetTempoMode sets how the song tempo is converted into row and tick
+// durations, see TempoMode.
+func (p *Player) SetTempoMode(mode TempoMode) {
+	atTickEnd := p.tickSamplePos >= p.samplesPerTick
+
+	p.tempoMode = mode
+	p.opts.TempoMode = mode
+	p.tickRemainder = 0
+	p.updateSamplesPerTick()
+
+	// Keep a player that is waiting to start the next tick waiting
+	if atTickEnd {
+		p.tickSamplePos = p.samplesPerTick
+	}
+}
+
+// SetPeriodMode sets how MOD notes are converted into periods, see
+// PeriodMode. It has no effect on S3M files.
+func (p *Player) SetPeriodMode(mode PeriodMode) {
+	p.periodMode = mode
+	p.opts.PeriodMode = mode
+}
+
+// SetTempoScale plays the song faster or slower by multiplying its tempo by
+// scale, e.g. 2 plays the song twice as fast. The scale applies on top of any
+// tempo changes in the song. The pitch is not affected. The default is 1.
+func (p *Player) SetTempoScale(scale float64) error {
+	if scale <= 0 || math.IsInf(scale, 0) || math.IsNaN(scale) {
+		return fmt.Errorf("invalid tempo scale")
+	}
+	atTickEnd := p.tickSamplePos >= p.samplesPerTick
+
+	p.tempoScale = scale
+	p.opts.TempoScale = scale
+	p.tickRemainder = 0
+	p.updateSamplesPerTick()
+
+	// Keep a player that is waiting to start the next tick waiting
+	if atTickEnd {
+		p.tickSamplePos = p.samplesPerTick
+	}
+
+	return nil
+}
+
+// SetSampleRate changes the sampling frequency of the generated audio, e.g.
+// when the output device changes. The song carries on from the same point,
+// including part way through a tick or a fade out. Sample positions reported
+// by the player, such as TempoChange.Sample, continue to count samples
+// generated at the earlier rate.
+func (p *Player) SetSampleRate(hz uint) error {
+	if hz == 0 {
+		return fmt.Errorf("invalid sample rate %d", hz)
+	}
+	if hz == p.samplingFrequency {
+		return nil
+	}
+
+	p.rateChangeAt += p.samplesToDuration(p.samplesPlayed - p.rateChange)
+	p.rateChange = p.samplesPlayed
+
+	old, oldTick := p.samplingFrequency, p.samplesPerTick
+	p.samplingFrequency = hz
+	p.tickRemainder = 0
+	p.updateSamplesPerTick()
+	p.updatePeriodSteps()
+
+	scale := func(n int) int { return int(int64(n) * int64(hz) / int64(old)) }
+	p.tickSamplePos = int(int64(p.tickSamplePos) * int64(p.samplesPerTick) / int64(oldTick))
+	if p.fadeTotal > 0 {
+		p.fadeTotal = max(scale(p.fadeTotal), 1)
+		p.fadeRemaining = scale(p.fadeRemaining)
+	}
+	p.silentSamples = scale(p.silentSamples)
+
+	return nil
+}
+
+// SampleRate returns the sampling frequency of the generated audio, see
+// SetSampleRate.
+func (p *Player) SampleRate() uint {
+	return p.samplingFrequency
+}
+
+// MixBufferSize returns the most stereo samples one call to GenerateAudio, or
+// the other GenerateAudio functions, can generate, see
+// PlayerOptions.MixBufferSize.
+func (p *Player) MixBufferSize() int {
+	return len(p.mixbuffer) / 2
+}
+
+// SetDeclick sets how clicks at note trigger are suppressed, see Declick.
+func (p *Player) SetDeclick(d Declick) {
+	p.declick = d
+	p.opts.Declick = d
+}
+
+// SetPanning sets how the channels are placed in the stereo output, see
+// Panning.
+func (p *Player) SetPanning(m Panning) {
+	p.panning = m
+	p.opts.Panning = m
+}
+
+// SetClipping sets how samples louder than the output range are handled, see
+// Clipping. Stats counts the samples that were over full scale before they
+// were clipped.
+func (p *Player) SetClipping(c Clipping) {
+	p.clipping = c
+	p.opts.Clipping = c
+}
+
+// SetMixWorkers sets the most goroutines the channels of a song are mixed on.
+// Songs with many channels, such as 16 or 32 channel S3Ms, are mixed faster
+// by sharing their channels between several CPU cores. Songs with fewer
+// channels are always mixed on the goroutine calling GenerateAudio, as are
+// all songs when n is 1. If n is 0 or less one goroutine per CPU is used, see
+// runtime.GOMAXPROCS. The audio is identical whatever the setting.
+func (p *Player) SetMixWorkers(n int) {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	p.mixWorkers = n
+	p.opts.MixWorkers = n
+}
+
+// SetMixerBackend sets the code that mixes the channels, see MixerBackend.
+// MixerAuto picks the fastest backend, the others are useful for benchmarking
+// or ruling out a backend when tracking down a difference in the audio.
+// Returns an error if the backend isn't supported, see MixerBackends.
+func (p *Player) SetMixerBackend(b MixerBackend) error {
+	backends := MixerBackends()
+	if b == MixerAuto {
+		b = backends[len(backends)-1]
+	} else if !slices.Contains(backends, b) {
+		return fmt.Errorf("unsupported mixer backend %v", b)
+	}
+	p.mixer = b
+	p.stereoMix = backendMixer(b)
+	p.opts.Mixer = b
+
+	return nil
+}
+
+// MixerBackend returns the backend mixing the channels, see SetMixerBackend.
+// It is never MixerAuto.
+func (p *Player) MixerBackend() MixerBackend {
+	return p.mixer
+}
+
+// SetMixFormat sets the number format of the mixer's output stages, see
+// MixFormat. With MixFloat32 the master gain is exact, GenerateAudioFloat32
+// returns samples with more than 16 bits of precision and GenerateAudio adds
+// triangular dither noise before rounding to 16 bits, which turns the
+// distortion of quiet passages into a faint hiss.
+func (p *Player) SetMixFormat(f MixFormat) {
+	p.mixFormat = f
+	p.opts.MixFormat = f
+	if f == MixFloat32 && len(p.fmixbuffer) != len(p.mixbuffer) {
+		p.fmixbuffer = make([]float32, len(p.mixbuffer))
+		p.dither = 1
+	}
+}
+
+// SetInterpolation sets how sample data is interpolated, see Interpolation.
+// InterpolationSinc is intended for rendering to a file, it costs several times
+// more than InterpolationCubic. InterpolationBLEP is the closest to how MODs
+// sound on an Amiga, the output is delayed by 8 samples.
+func (p *Player) SetInterpolation(i Interpolation) {
+	switch i {
+	case InterpolationSinc:
+		initSinc()
+	case InterpolationBLEP:
+		initBLEP()
+	}
+	p.interpolation = i
+	p.opts.Interpolation = i
+}
+
+// NoteDataFor returns the note data for a specific order and row, or nil if
+// the requested position is invalid.
+func (p *Player) NoteDataFor(order, row int) []ChannelNoteData {
+	if order < 0 || row < 0 || order >= len(p.Orders) || row >= 64 {
+		return nil
+	}
+	nd := make([]ChannelNoteData, p.Channels)
+
+	pattern := p.Orders[order]
+	rowDataIdx := row * p.Song.Channels
+	for i := 0; i < p.Channels; i++ {
+		patnote := &p.Song.patterns[pattern][rowDataIdx]
+
+		nd[i] = patnote.noteData()
+
+		rowDataIdx++
+	}
+
+	return nd
+}
+
+// RenderRange renders rows rowStart to rowEnd (inclusive) of an order into out
+// as stereo sample data (LRLRLR...) and returns the number of stereo samples
+// generated. The song is played silently from the beginning up to rowStart so
+// that tempo, volume and effect state match normal playback. Rendering stops
+// at the end of rowEnd, when playback leaves the range or when out is full.
+// The player's settings are used, except for the effect set with SetEffect.
+//
+// The player's own position and state are not affected.
+func (p *Player) RenderRange(order, rowStart, rowEnd int, out []int16) (int, error) {
+	if order < 0 || order >= len(p.Orders) {
+		return 0, fmt.Errorf("invalid order %d", order)
+	}
+	if rowStart < 0 || rowEnd >= 64 || rowStart > rowEnd {
+		return 0, fmt.Errorf("invalid row range %d-%d", rowStart, rowEnd)
+	}
+
+	s, err := p.scratchPlayer()
+	if err != nil {
+		return 0, err
+	}
+	if !s.runTo(order, rowStart) {
+		return 0, fmt.Errorf("row %d of order %d is never played", rowStart, order)
+	}
+
+	generated := 0
+	for generated < len(out)/2 {
+		if s.tickSamplePos >= s.samplesPerTick {
+			if s.sequenceTick() {
+				break // song finished
+			}
+			s.tickSamplePos = 0
+			if s.tick == 0 && (s.order != order || s.row < rowStart || s.row > rowEnd) {
+				break // left the range
+			}
+		}
+
+		n := min(s.samplesPerTick-s.tickSamplePos, len(out)/2-generated, len(s.mixbuffer)/2)
+		clear(s.mixbuffer[0 : n*2])
+		s.mixChannels(n, 0)
+		s.downsample(out[generated*2:], n*2)
+
+		s.tickSamplePos += n
+		s.samplesPlayed += int64(n)
+		generated += n
+	}
+
+	return generated, nil
+}
+
+// Duration returns how long one pass of the song lasts from the beginning,
+// stopping at the end of the song or where it starts repeating. PlayOrderLimit
+// and the settings that change timing, like SetTempoScale and SetClock, are
+// taken into account. SetLoopCount, SetLoopPolicy and SetSilenceStop are not,
+// so the player itself may play for longer or shorter than this.
+//
+// The player's own position and state are not affected.
+func (p *Player) Duration() (SongDuration, error) {
+	return p.DurationContext(context.Background())
+}
+
+// DurationContext is the same as Duration, except the scan stops early and
+// returns ctx.Err() if ctx is cancelled.
+func (p *Player) DurationContext(ctx context.Context) (SongDuration, error) {
+	s, err := p.scratchPlayer()
+	if err != nil {
+		return SongDuration{}, err
+	}
+	s.PlayOrderLimit = p.PlayOrderLimit
+	s.SetLoopCount(0)
+	s.SetLoopPolicy(LoopPolicyLoop, 0)
+
+	d := SongDuration{OrderStarts: make([]time.Duration, len(p.Orders)), LoopStart: -1}
+	for i := range d.OrderStarts {
+		d.OrderStarts[i] = -1
+	}
+	rowStarts := make(map[[2]int]int64)
+	s.simulate(func() bool {
+		if d.OrderStarts[s.order] == -1 {
+			d.OrderStarts[s.order] = s.samplesToDuration(s.samplesPlayed)
+		}
+		if _, ok := rowStarts[[2]int{s.order, s.row}]; !ok {
+			rowStarts[[2]int{s.order, s.row}] = s.samplesPlayed
+		}
+		return ctx.Err() == nil
+	})
+	if err := ctx.Err(); err != nil {
+		return SongDuration{}, err
+	}
+	d.Samples = s.samplesPlayed
+	if start, ok := rowStarts[[2]int{s.order, s.row}]; ok && s.IsPlaying() && start < d.Samples {
+		d.LoopStart = start
+	}
+	d.Total = s.samplesToDuration(s.samplesPlayed)
+
+	return d, nil
+}
+
+// Duration returns how long the song plays for at the given sampling frequency
+// with the default player settings, see Player.Duration.
+func (s *Song) Duration(samplingFrequency uint) (SongDuration, error) {
+	p, err := NewPlayer(s, samplingFrequency)
+	if err != nil {
+		return SongDuration{}, err
+	}
+
+	return p.Duration()
+}
+
+// WidenSamples converts the sample data of every sample to 16 bits, stored in
+// Sample.Data16, which the mixer reads instead of Data. This saves widening
+// each frame as it is mixed at the cost of three times the sample memory, as
+// Data is kept. The audio is unchanged. Call it after loading the song and
+// before any Player starts playing it.
+func (s *Song) WidenSamples() {
+	for i := range s.Samples {
+		sample := &s.Samples[i]
+		sample.Data16 = make([]int16, len(sample.Data))
+		for j, sd := range sample.Data {
+			sample.Data16[j] = int16(sd) << 8
+		}
+	}
+}
+
+// Returns a new player for the song with the same settings as p, positioned
+// at the start of the song and without a PlayOrderLimit. The effect set with
+// SetEffect is not copied, as effects keep state from the audio they have
+// processed.
+func (p *Player) scratchPlayer() (*Player, error) {
+	opts := p.opts
+	opts.Stopped = false
+	opts.Mute = p.MuteMask()
+	opts.PlayOrderLimit = -1
+	s, err := NewPlayerWithOptions(p.Song, p.samplingFrequency, opts)
+	if err != nil {
+		return nil, err
+	}
+	for ci, pan := range p.panOverride {
+		if pan != noPanOverride {
+			s.SetChannelPan(ci, pan, true)
+		}
+	}
+
+	return s, nil
+}
+
+// Switches the player to song and allocates the per-channel state. The
+// player must be reset afterwards.
+func (p *Player) setSong(song *Song) {
+	p.Song = song
+	if p.clockFromSong {
+		p.SetClock(ClockSong)
+	}
+
+	p.loop = make([]loopinfo, song.Channels)
+	p.channels = make([]channel, song.Channels)
+	p.meters = make([]meter, song.Channels)
+	p.panOverride = make([]int, song.Channels)
+	for i := range p.panOverride {
+		p.panOverride[i] = noPanOverride
+	}
+	p.playedRows = nil
+	p.periodTables = make(map[int]*[periodTableNotes]int)
+	for _, sample := range song.Samples {
+		if _, ok := p.periodTables[sample.C4Speed]; !ok && sample.C4Speed > 0 {
+			p.periodTables[sample.C4Speed] = notePeriodTable(sample.C4Speed)
+		}
+	}
+	p.stopSFX() // the samples belong to the old song
+	if s := p.scopes.Load(); s != nil {
+		p.SetScopeLength(s.length)
+	}
+}
+
+func (p *Player) reset() {
+	p.Stop()
+	p.Speed = p.Song.Speed
+	p.tickRemainder = 0
+	p.setTempo(p.Song.Tempo)
+	p.updateSamplesPerTick()
+	p.order, p.row = 0, 0
+	p.nextOrder, p.nextRow = 0, 0
+	p.pendingJump.Store(-1)
+
+	// Setup counters so that the first "tick" of the player executes the
+	// first row immediately.
+	p.tick = p.Speed - 1
+	p.tickSamplePos = p.samplesPerTick
+	p.voices = p.voices[:0]
+	p.eventOrder = -1
+	p.fadeTotal = 0
+	p.fadeRemaining = 0
+	p.fadeStops = false
+	p.silentSamples = 0
+	clear(p.playedRows)
+	p.globalVolume = uint(p.Song.GlobalVolume)
+	p.memGlobalVolSlide = 0
+
+	clear(p.loop)
+	clear(p.meters)
+	for i := 0; i < p.Song.Channels; i++ {
+		pan := int(p.Song.pan[i])
+		if p.panOverride[i] != noPanOverride {
+			pan = p.panOverride[i]
+		}
+		p.channels[i] = channel{
+			sample:          -1,
+			sampleToPlay:    -1,
+			vibratoWaveform: vibratoSine,
+			tremoloWaveform: vibratoSine,
+			songPan:         int(p.Song.pan[i]),
+			pan:             pan,
+		}
+	}
+	p.publishState(0, 0, 0)
+}
+
+func (p *Player) setTempo(tempo int) {
+	p.Tempo = tempo
+	if p.tempoMode == TempoModeClassic {
+		p.updateSamplesPerTick()
+	}
+}
+
+// Computes the duration of the next tick
+func (p *Player) updateSamplesPerTick() {
+	switch p.tempoMode {
+	case TempoModeModern:
+		// A row lasts 60/(tempo*rowsPerBeat) seconds and is evenly divided
+		// into ticks. The remainder is carried over to the next tick so that
+		// the row durations are exact.
+		perMinute := int(p.samplingFrequency) * 60
+		if p.tempoScale != 1 {
+			perMinute = int(math.Round(float64(perMinute) / p.tempoScale))
+		}
+		num := perMinute + p.tickRemainder
+		den := p.Tempo * rowsPerBeat * max(p.Speed, 1)
+		p.samplesPerTick = num / den
+		p.tickRemainder = num % den
+	default:
+		p.samplesPerTick = int((p.samplingFrequency<<1)+(p.samplingFrequency>>1)) / p.Tempo
+		if p.tempoScale != 1 {
+			p.samplesPerTick = int(float64(p.samplingFrequency) * 2.5 / (float64(p.Tempo) * p.tempoScale))
+		}
+	}
+	p.samplesPerTick = max(p.samplesPerTick, 1)
+}
+
+func (p *Player) setSpeed(speed int) {
+	p.Speed = speed
+	p.tick = p.Speed - 1 // TODO - is setting the tick like this appropriate?
+}
+
+func (p *Player) channelTick(c *channel, ci, tick int) {
+	c.effectCounter++
+
+	switch c.effect {
+	case effectPortamentoUp:
+		c.period = max(c.period-int(c.param)*4, minPeriod)
+	case effectPortamentoDown:
+		c.period = min(c.period+int(c.param)*4, maxPeriod)
+	case effectPortaToNote:
+		c.portaToNote()
+	case effectVibrato:
+		c.vibrato()
+		c.vibratoPhase = (c.vibratoPhase + c.vibratoSpeed) & 63
+	case effectPortaToNoteVolSlide:
+		c.portaToNote()
+		if p.Song.Type == SongTypeS3M {
+			c.volumeSlide(c.memVolSlide)
+		} else {
+			c.volumeSlide(c.param)
+		}
+	case effectVibratoVolSlide:
+		c.vibrato()
+		c.vibratoPhase = (c.vibratoPhase + c.vibratoSpeed) & 63
+		if p.Song.Type == SongTypeS3M {
+			c.volumeSlide(c.memVolSlide)
+		} else {
+			c.volumeSlide(c.param)
+		}
+	case effectTremolo:
+		c.tremolo()
+		c.tremoloPhase = (c.tremoloPhase + c.tremoloSpeed) & 63
+	case effectVolumeSlide:
+		c.volumeSlide(c.param)
+	case effectS3MVolumeSlide:
+		// Fine slides are not applied on in between ticks
+		x := c.memVolSlide >> 4
+		y := c.memVolSlide & 0xF
+		if x == 0xF || y == 0xF {
+			break
+		}
+
+		// Dxy
+		// Strictly speaking these values should be either Dx0 or D0y. When they
+		// are Dxy then y takes precedence and x is ignored.
+		if y > 0 {
+			// slide the volume down by y units
+			c.volume = max(c.volume-int(y), minVolume)
+			break
+		}
+		// slide the volume up by x units
+		c.volume = min(c.volume+int(x), maxVolume)
+	case effectS3MPortamentoDown:
+		// Dxy
+		// Fine and extra fine slides are not applied on in between ticks
+		if c.memPortamento >= 0xE0 {
+			break
+		}
+		c.period = min(c.period+int(c.memPortamento)*4, maxPeriod)
+	case effectS3MPortamentoUp:
+		// Dxy
+		// Fine and extra fine slides are not applied on in between ticks
 		if c.memPortamento >= 0xE0 {
 			break
 		}
@@ -559,6 +1862,18 @@ func (p *Player) channelTick(c *channel, ci, tick int) {
 			c.volume = retrigVolume(int(c.memRetrig>>4), c.volume)
 			c.effectCounter = 0
 		}
+	case effectGlobalVolumeSlide:
+		// Fine slides are only applied on the first tick
+		x := p.memGlobalVolSlide >> 4
+		y := p.memGlobalVolSlide & 0xF
+		if x == 0xF || y == 0xF {
+			break
+		}
+		if y > 0 {
+			p.globalVolume -= min(uint(y), p.globalVolume)
+		} else {
+			p.globalVolume = min(p.globalVolume+uint(x), maxVolume)
+		}
 	case effectExtended:
 		switch c.param >> 4 {
 		case effectExtendedNoteCut:
@@ -576,24 +1891,40 @@ func (p *Player) channelTick(c *channel, ci, tick int) {
 
 // Returns if the end of the song was reached
 func (p *Player) sequenceTick() bool {
-	finished := false
-
 	p.tick++
 	if p.tick >= p.Speed {
 		p.tick = 0
 
-		p.row++
-		if p.row >= 64 {
-			p.row = 0
-			p.order++
+		// Navigating to another position is not a loop in the song
+		if pos := p.pendingJump.Swap(-1); pos >= 0 {
+			p.nextOrder, p.nextRow = int(pos)/rowsPerPattern, int(pos)%rowsPerPattern
+			clear(p.playedRows)
+		}
+
+		if p.nextOrder != p.order {
 			p.ordersplayed++
 
-			endOfSong := p.order >= len(p.Song.Orders)
+			endOfSong := p.nextOrder >= len(p.Song.Orders)
 			playLimitReached := p.PlayOrderLimit != -1 && p.ordersplayed >= p.PlayOrderLimit
-			if endOfSong || playLimitReached {
-				// End of the song reached, reset player state and stop
-				finished = true
-				p.reset()
+			if (endOfSong || playLimitReached) && p.songEnded(true) {
+				return true
+			}
+		}
+		p.order, p.row = p.nextOrder, p.nextRow
+
+		// Detect the song jumping back to a row it has already played.
+		// Rows are revisited legitimately inside a pattern loop.
+		if p.markRowPlayed() && !p.inPatternLoop() {
+			switch p.loopPolicy {
+			case LoopPolicyStop:
+				if p.songEnded(true) {
+					return true
+				}
+				p.markRowPlayed() // the song restarted
+			case LoopPolicyFade:
+				if p.fadeTotal == 0 {
+					p.startFade(p.loopFade)
+				}
 			}
 		}
 
@@ -601,6 +1932,8 @@ func (p *Player) sequenceTick() bool {
 		rowDataIdx := p.rowDataIndex()
 
 		loopChannel := -1 // Which channel index has an active loop, -1=no channel
+		jumpOrder := -1   // Order to jump to, -1=no jump
+		breakRow := -1    // Row to break to in the next pattern, -1=no break
 
 		for i := 0; i < p.Song.Channels; i++ {
 			channel := &p.channels[i]
@@ -680,7 +2013,7 @@ func (p *Player) sequenceTick() bool {
 				// Convert the pitch to a period
 				var period int
 				if channel.sampleToPlay >= 0 {
-					period = periodFromPlayerNote(pitch, p.Song.Samples[channel.sampleToPlay].C4Speed)
+					period = p.notePeriod(pitch, &p.Song.Samples[channel.sampleToPlay])
 				}
 
 				// ... save it away as the porta to note destination
@@ -695,6 +2028,10 @@ func (p *Player) sequenceTick() bool {
 						// We should never get this because S3M loader remapped to 0
 					}
 
+					// ... move any playing note to the background if its
+					// instrument requests it
+					p.newNoteAction(channel, i)
+
 					// ... assign the new instrument if one was provided
 					channel.triggerNote(period, channel.sampleToPlay, p.order, p.row, p.tick)
 				} else {
@@ -760,50 +2097,22 @@ func (p *Player) sequenceTick() bool {
 				if param > 0x80 {
 					param = 0x80
 				}
-				channel.pan = int(param)
+				channel.songPan = int(param)
+				if p.panOverride[i] == noPanOverride {
+					channel.pan = channel.songPan
+				}
 			case effectSampleOffset:
 				// TODO: clamp samplePosition to end of sample
 				channel.samplePosition = uint(param) << 24
 			case effectJumpToPattern:
-				// TODO - this effect currently activates on tick 0 and before
-				// the rest of the channels have been processed. Experimentation
-				// shows that this should activate on the last intermediate tick
-				// and probably after all channels have been processed.
-				p.order = int(param)
-				if p.order >= len(p.Orders) {
-					p.order = len(p.Orders) - 1
-				}
-				// TODO - what to do with p.tick here?
-				p.row = 0
+				// Takes effect when the row finishes, see nextPosition
+				jumpOrder = min(int(param), len(p.Orders)-1)
 			case effectPatternBrk:
-				// TODO - this effect currently activates on tick 0 and before
-				// the rest of the channels have been processed. Experimentation
-				// shows that this should activate on the last intermediate tick
-				// and probably after all channels have been processed.
-
-				// TODO - handle edge cases of multiple pattern break effects in
-				// a row (only advance one time) and case of both Jump to
-				// Pattern and Pattern Break in same row
-
-				// Advance to the next pattern in the order unless we are on the
-				// last pattern, in which case we stay on this pattern. This
-				// behavior matches MilkyTracker.
-				p.order++
-				if p.order == len(p.Orders) {
-					p.order = len(p.Orders) - 1
-				}
-
-				// This code can race, we subtract 1 to offset the row counter
-				// increment after effect processing. If the player position is
-				// read (e.g. generating audio) after processing this effect and
-				// incrementing the row counter below then an invalid row will
-				// be used. Other code that uses the row clamps to 0 but it
-				// would be ideal to find a way to eliminate the race.
-				p.row = int((param>>4)*10+param&0xF) - 1
-				if p.row >= 64 {
-					p.row = -1
+				// Takes effect when the row finishes, see nextPosition
+				breakRow = int((param>>4)*10 + param&0xF)
+				if breakRow >= 64 {
+					breakRow = 0
 				}
-				// TODO - what to do with p.tick here?
 			case effectPatternLoop:
 				if param == 0 {
 					p.loop[i].start = p.row
@@ -923,138 +2232,854 @@ func (p *Player) sequenceTick() bool {
 				channel.period = max(channel.period, minPeriod)
 			case effectS3MGlobalVolume:
 				p.globalVolume = min(uint(param), uint(maxVolume))
+			case effectGlobalVolumeSlide:
+				if param > 0 {
+					p.memGlobalVolSlide = param
+				}
+
+				// Wxy
+				// WFy - on tick 0, fine slide down by y units
+				// WxF - on tick 0, fine slide up by x units
+				x := p.memGlobalVolSlide >> 4
+				y := p.memGlobalVolSlide & 0xF
+				if x == 0xF && y != 0xF && y != 0 {
+					p.globalVolume -= min(uint(y), p.globalVolume)
+				} else if y == 0xF && x != 0 {
+					p.globalVolume = min(p.globalVolume+uint(x), maxVolume)
+				}
 			}
 			rowDataIdx++
 		}
 
-		if loopChannel >= 0 {
-			p.row = p.loop[loopChannel].start - 1 // -1 for the ++ below
-		}
-	} else {
-		// channel tick
-		for i := 0; i < p.Song.Channels; i++ {
-			p.channelTick(&p.channels[i], i, p.tick)
+		loopRow := -1
+		if loopChannel >= 0 {
+			loopRow = p.loop[loopChannel].start
+		}
+		p.nextOrder, p.nextRow = p.nextPosition(jumpOrder, breakRow, loopRow)
+
+		p.updateActivity()
+		p.recordTempo()
+		p.rowEvents(p.order, p.row)
+	} else {
+		// channel tick
+		for i := 0; i < p.Song.Channels; i++ {
+			p.channelTick(&p.channels[i], i, p.tick)
+		}
+	}
+	p.noteEvents()
+	p.updateVoices()
+	if p.tempoMode == TempoModeModern {
+		p.updateSamplesPerTick()
+	}
+	p.updateMeters()
+	p.publishState(p.order, p.row, p.tick)
+
+	return false
+}
+
+// Returns the position of the row that follows the current one. jumpOrder,
+// breakRow and loopRow are the targets of any position jump, pattern break or
+// pattern loop on the current row, -1 if there was none. A jump and a break on
+// the same row go to the break row of the jump order, and either takes
+// priority over a pattern loop. The returned order is past the end of the
+// song when the song has finished.
+func (p *Player) nextPosition(jumpOrder, breakRow, loopRow int) (order, row int) {
+	order, row = p.order, p.row+1
+	if loopRow >= 0 {
+		row = loopRow
+	}
+
+	if jumpOrder >= 0 {
+		order, row = jumpOrder, 0
+	}
+	if breakRow >= 0 {
+		if jumpOrder < 0 {
+			// Advance to the next pattern in the order unless we are on the
+			// last pattern, in which case we stay on this pattern. This
+			// behavior matches MilkyTracker.
+			order = min(p.order+1, len(p.Orders)-1)
+		}
+		row = breakRow
+	}
+
+	if row >= 64 {
+		order, row = order+1, 0
+	}
+	return order, row
+}
+
+// Called when the song reaches its end. Resets the player state and either
+// restarts the song, if allowed and SetLoopCount has loops remaining, or
+// stops. Returns true if the player stopped. A restarted song is positioned on
+// the first tick of the first row.
+func (p *Player) songEnded(restart bool) bool {
+	p.reset()
+	p.ordersplayed = 0
+	if restart && (p.loopCount == LoopForever || p.loopsPlayed < p.loopCount) {
+		p.loopsPlayed++
+		p.tick = 0
+		p.Start()
+		return false
+	}
+	if restart && !p.replaying {
+		if next := p.dequeue(); next != nil {
+			p.setSong(next)
+			p.reset()
+			p.loopsPlayed = 0
+			p.recordTempo()
+			p.tick = 0
+			p.Start()
+			if p.OnSongChange != nil {
+				p.OnSongChange(next)
+			}
+			return false
+		}
+	}
+
+	if p.OnSongEnd != nil {
+		p.OnSongEnd()
+	}
+	return true
+}
+
+// Records that the current row has been played and returns true if it had
+// already been played.
+func (p *Player) markRowPlayed() bool {
+	if p.order >= len(p.playedRows) {
+		p.playedRows = append(p.playedRows, make([]uint64, len(p.Orders)-len(p.playedRows))...)
+	}
+
+	bit := uint64(1) << p.row
+	played := p.playedRows[p.order]&bit != 0
+	p.playedRows[p.order] |= bit
+
+	return played
+}
+
+// Starts fading the output to silence over d. A fade that is already in
+// progress continues from its current level.
+func (p *Player) startFade(d time.Duration) {
+	n := max(int(int64(d)*int64(p.samplingFrequency)/int64(time.Second)), 1)
+	if p.fadeTotal > 0 && p.fadeRemaining > 0 {
+		p.fadeTotal = max(n*p.fadeTotal/p.fadeRemaining, n)
+	} else {
+		p.fadeTotal = n
+	}
+	p.fadeRemaining = n
+}
+
+// Extends the run of silent output by nSamples if the nSamples of the mix
+// buffers starting at offset are all zero, otherwise ends it.
+func (p *Player) trackSilence(nSamples, offset int) {
+	for _, buf := range p.mixBuffers() {
+		for _, s := range buf[offset*2 : (offset+nSamples)*2] {
+			if s != 0 {
+				p.silentSamples = 0
+				return
+			}
+		}
+	}
+	p.silentSamples += nSamples
+}
+
+// Fades the nSamples of the mix buffers starting at offset towards silence,
+// see LoopPolicyFade.
+func (p *Player) applyFade(nSamples, offset int) {
+	for _, buf := range p.mixBuffers() {
+		remaining := p.fadeRemaining
+		for i := offset * 2; i < (offset+nSamples)*2; i += 2 {
+			buf[i+0] = int(int64(buf[i+0]) * int64(remaining) / int64(p.fadeTotal))
+			buf[i+1] = int(int64(buf[i+1]) * int64(remaining) / int64(p.fadeTotal))
+			remaining--
+		}
+	}
+	p.fadeRemaining -= nSamples
+}
+
+// Invokes the row callbacks for the row that was just processed.
+func (p *Player) rowEvents(order, row int) {
+	if order != p.eventOrder {
+		p.eventOrder = order
+		if p.OnOrderChange != nil {
+			p.OnOrderChange(order)
+		}
+	}
+	if p.OnRow != nil {
+		p.OnRow(order, row)
+	}
+	p.sendEvents(order, row)
+}
+
+// Reports notes triggered during the tick to OnNoteTrigger and notes that
+// have stopped to OnNoteStop. This is done at the end of the tick so that the
+// event has the final volume of the note. A note stops when it is cut, its
+// volume reaches 0 or a sample without a loop runs out. Replacing a note with
+// a new one is only reported to OnNoteTrigger.
+func (p *Player) noteEvents() {
+	for ci := range p.channels {
+		c := &p.channels[ci]
+		if !c.newNote {
+			if c.sounding && (c.sample == -1 || c.volume == 0) {
+				c.sounding = false
+				if p.OnNoteStop != nil {
+					p.OnNoteStop(ci)
+				}
+			}
+			continue
+		}
+		c.newNote = false
+		if c.sample == -1 {
+			// There is no instrument to report, the note is silent
+			continue
+		}
+		c.sounding = true
+
+		if p.OnNoteTrigger == nil && p.stepNotes == nil {
+			continue
+		}
+		e := NoteEvent{
+			Channel:    ci,
+			Order:      c.trigOrder,
+			Row:        c.trigRow,
+			Tick:       c.trigTick,
+			Instrument: c.sample,
+			Period:     c.period,
+			Note:       int(periodNote(c.period, p.Song.Samples[c.sample].C4Speed)),
+			Volume:     c.volume,
+		}
+		if p.stepNotes != nil {
+			*p.stepNotes = append(*p.stepNotes, e)
+		}
+		if p.OnNoteTrigger != nil {
+			p.OnNoteTrigger(e)
+		}
+	}
+}
+
+// Applies the New Note Action of the note playing on channel c (channel index
+// ci) before a new note is triggered on it.
+func (p *Player) newNoteAction(c *channel, ci int) {
+	if c.sample == -1 || c.volume == 0 {
+		return
+	}
+
+	sample := &p.Song.Samples[c.sample]
+	nna := sample.NNA
+	fading := nna == NNANoteOff || nna == NNANoteFade
+	// Without a fade speed a released note on a looping sample would hold a
+	// voice forever, so it is cut instead
+	if nna == NNACut || (fading && sample.FadeOut <= 0) {
+		return
+	}
+
+	if len(p.voices) == maxVoices {
+		// No free voices, steal the quietest one
+		quietest := 0
+		for i := range p.voices {
+			if p.voices[i].volume*p.voices[i].fadeVolume < p.voices[quietest].volume*p.voices[quietest].fadeVolume {
+				quietest = i
+			}
+		}
+		p.voices = append(p.voices[:quietest], p.voices[quietest+1:]...)
+	}
+
+	v := *c
+	v.owner = ci
+	v.effect = 0
+	v.param = 0
+	v.vibratoAdjust = 0
+	v.tremoloAdjust = 0
+	v.fadeVolume = maxFadeVolume
+	v.fading = fading
+	p.voices = append(p.voices, v)
+	c.blep = blepState{}         // the voice carries on the band-limited output
+	c.binaural = binauralState{} // and the binaural filters
+}
+
+// Advances the fade of background voices and discards the voices that have
+// finished playing.
+func (p *Player) updateVoices() {
+	n := 0
+	for i := range p.voices {
+		v := &p.voices[i]
+		if v.fading {
+			v.fadeVolume -= p.Song.Samples[v.sample].FadeOut
+		}
+		if v.sample == -1 || v.fadeVolume <= 0 {
+			continue
+		}
+		p.voices[n] = *v
+		n++
+	}
+	p.voices = p.voices[:n]
+}
+
+// Adds an entry to the tempo history if the tempo or speed has changed.
+func (p *Player) recordTempo() {
+	if n := len(p.tempoHistory); n > 0 {
+		last := &p.tempoHistory[n-1]
+		if last.Tempo == p.Tempo && last.Speed == p.Speed {
+			return
+		}
+	}
+
+	p.tempoHistory = append(p.tempoHistory, TempoChange{
+		Sample: p.samplesPlayed,
+		Time:   p.playTime(),
+		Order:  p.order,
+		Row:    p.row,
+		Tempo:  p.Tempo,
+		Speed:  p.Speed,
+	})
+}
+
+// Returns how long the player has been playing for.
+func (p *Player) playTime() time.Duration {
+	return p.rateChangeAt + p.samplesToDuration(p.samplesPlayed-p.rateChange)
+}
+
+// Tracks how long each channel has been silent for. A channel is considered
+// active if it has a sample playing at a non-zero volume.
+func (p *Player) updateActivity() {
+	for i := range p.channels {
+		c := &p.channels[i]
+		if c.sample != -1 && c.volume > 0 {
+			c.silentRows = 0
+		} else {
+			c.silentRows++
+		}
+	}
+}
+
+func (c *channel) triggerNote(period, sample, order, row, tick int) {
+	c.period = period
+	c.sample = sample
+	c.samplePosition = 0
+	c.tremoloPhase = 0
+	c.vibratoPhase = 0
+	c.vibratoAdjust = 0
+	c.tremoloAdjust = 0
+	c.trigOrder = order
+	c.trigRow = row
+	c.trigTick = tick
+	c.triggered = true
+	// A note without an instrument is silent, there is nothing to report
+	c.newNote = sample != -1
+}
+
+func (p *Player) mixChannels(nSamples, offset int) {
+	if p.surround != nil && p.surround.active {
+		p.mixChannelsSurround(nSamples, offset)
+		return
+	}
+	if s := p.scopes.Load(); s != nil {
+		p.mixChannelsScoped(s, nSamples, offset)
+		return
+	}
+	if workers := p.channelWorkers(); workers > 1 {
+		p.mixChannelsParallel(workers, nSamples, offset)
+		return
+	}
+
+	for ci := range p.channels {
+		p.meters[ci].samples += nSamples
+		p.mixChannel(&p.channels[ci], ci, nSamples, offset)
+	}
+	for vi := range p.voices {
+		p.mixChannel(&p.voices[vi], p.voices[vi].owner, nSamples, offset)
+	}
+}
+
+// Returns how many goroutines to mix the tracker channels with.
+func (p *Player) channelWorkers() int {
+	if len(p.channels) < parallelMixChannels {
+		return 1
+	}
+	return min(p.mixWorkers, len(p.channels)/minChannelsPerWorker)
+}
+
+// Mixes the tracker channels like mixChannels but shares them between workers
+// goroutines. Each worker mixes a run of channels into its own buffer and the
+// buffers are summed into the mix buffer, which gives the same result as
+// mixing the channels one after another. Background voices are mixed last by
+// the calling goroutine because they share meters with the tracker channels.
+func (p *Player) mixChannelsParallel(workers, nSamples, offset int) {
+	if len(p.workerBuffers) < workers-1 {
+		p.workerBuffers = make([][]int, workers-1)
+	}
+	for w := range p.workerBuffers[:workers-1] {
+		if len(p.workerBuffers[w]) < nSamples*2 {
+			p.workerBuffers[w] = make([]int, len(p.mixbuffer))
+		}
+	}
+
+	perWorker := (len(p.channels) + workers - 1) / workers
+	mixRun := func(mix []int, first, offset int) {
+		last := min(first+perWorker, len(p.channels))
+		for ci := first; ci < last; ci++ {
+			p.meters[ci].samples += nSamples
+			p.mixChannelInto(mix, &p.channels[ci], ci, nSamples, offset)
+		}
+	}
+
+	// The first run of channels is mixed straight into the mix buffer by
+	// this goroutine while the workers mix the rest
+	var wg sync.WaitGroup
+	for w := 1; w < workers; w++ {
+		mix := p.workerBuffers[w-1][:nSamples*2]
+		clear(mix)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			mixRun(mix, w*perWorker, 0)
+		}(w)
+	}
+	mixRun(p.mixbuffer, 0, offset)
+	wg.Wait()
+
+	out := p.mixbuffer[offset*2 : (offset+nSamples)*2]
+	for _, buf := range p.workerBuffers[:workers-1] {
+		for i, s := range buf[:nSamples*2] {
+			out[i] += s
+		}
+	}
+
+	for vi := range p.voices {
+		p.mixChannel(&p.voices[vi], p.voices[vi].owner, nSamples, offset)
+	}
+}
+
+// Plays the song from the current position without generating audio until
+// the first tick of the given row has been processed. Returns false if the
+// song ends or starts repeating before the row is reached.
+func (p *Player) runTo(order, row int) bool {
+	return p.simulate(func() bool {
+		return p.order != order || p.row != row
+	})
+}
+
+// Step advances the song by one tick without generating audio and returns
+// what happened during the tick. Mixing is skipped, so stepping through a song
+// is much cheaper than generating its audio, which suits tools that analyze
+// songs or test the sequencer. The callbacks are invoked as they are by
+// GenerateAudio. Any part of the current tick that has not been generated is
+// skipped first, and a following GenerateAudio generates the audio of the
+// stepped tick. Fades and SilenceStop are ignored.
+//
+// Step returns false, and a zero TickEvent, if the player isn't playing or the
+// song ended.
+func (p *Player) Step() (TickEvent, bool) {
+	if !p.playing.Load() {
+		return TickEvent{}, false
+	}
+
+	p.skipTick()
+
+	var notes []NoteEvent
+	p.stepNotes = &notes
+	ended := p.sequenceTick()
+	p.stepNotes = nil
+	if ended {
+		return TickEvent{}, false
+	}
+	p.tickSamplePos = 0
+
+	return TickEvent{
+		Order: p.order,
+		Row:   p.row,
+		Tick:  p.tick,
+		Speed: p.Speed,
+		Tempo: p.Tempo,
+		Time:  p.playTime(),
+		Notes: notes,
+	}, true
+}
+
+// Plays the song from the current position without generating audio. visit
+// is called after the first tick of every row and simulation stops when it
+// returns false. Returns false if the song ends or starts repeating first.
+func (p *Player) simulate(visit func() bool) bool {
+	visited := make(map[[2]int]bool)
+	for {
+		p.skipTick()
+		if p.sequenceTick() {
+			return false
+		}
+		p.tickSamplePos = 0
+		if p.tick != 0 {
+			continue
+		}
+		if !visit() {
+			return true
+		}
+
+		// Rows are revisited legitimately inside a pattern loop
+		pos := [2]int{p.order, p.row}
+		if visited[pos] && !p.inPatternLoop() {
+			return false
+		}
+		visited[pos] = true
+	}
+}
+
+// Moves the channels through the rest of the current tick without mixing them.
+func (p *Player) skipTick() {
+	if p.tickSamplePos < p.samplesPerTick {
+		n := p.samplesPerTick - p.tickSamplePos
+		p.skipChannels(n)
+		p.samplesPlayed += int64(n)
+		p.tickSamplePos = p.samplesPerTick
+	}
+}
+
+// Converts a number of samples into a duration at the player's sampling
+// frequency.
+func (p *Player) samplesToDuration(n int64) time.Duration {
+	return time.Duration(n * int64(time.Second) / int64(p.samplingFrequency))
+}
+
+// Returns true if any channel is part way through a pattern loop.
+func (p *Player) inPatternLoop() bool {
+	for _, l := range p.loop {
+		if l.count > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Advances every channel and voice by nSamples without mixing them.
+func (p *Player) skipChannels(nSamples int) {
+	for ci := range p.channels {
+		p.skipChannel(&p.channels[ci], nSamples)
+	}
+	for vi := range p.voices {
+		p.skipChannel(&p.voices[vi], nSamples)
+	}
+}
+
+func (p *Player) skipChannel(channel *channel, nSamples int) {
+	if channel.sample == -1 {
+		return
+	}
+
+	sample := &p.Song.Samples[channel.sample]
+	if sample.Length == 0 {
+		return
+	}
+	channel.triggered = false
+
+	pos := channel.samplePosition + p.sampleStep(channel)*uint(nSamples)
+	if sample.LoopLen > 0 {
+		if pos >= uint(sample.LoopStart+sample.LoopLen)<<16 {
+			pos = loopWrap(pos, sample)
+		}
+	} else if pos >= uint(sample.Length)<<16 {
+		channel.sample = -1 // turn off the channel
+	}
+	channel.samplePosition = pos
+}
+
+// Returns the 16.16 fixed point amount the sample position of channel
+// advances by for each output sample.
+func (p *Player) sampleStep(channel *channel) uint {
+	return uint(p.periodSteps[playbackPeriod(channel)])
+}
+
+// Returns the rate in Hz that the channel's sample is played at.
+func (p *Player) playbackHz(channel *channel) int {
+	return int(p.periodHz(playbackPeriod(channel)))
+}
+
+// Returns the period the channel is played at, including vibrato, limited to
+// the range of periods the player supports.
+func playbackPeriod(channel *channel) int {
+	return clamp(channel.period+(channel.vibratoAdjust*4), minPeriod, maxPeriod)
+}
+
+// Returns the rate in Hz that a note of period is played at. The clock divided
+// by the period is the same as the Amiga's whole number of Hz.
+func (p *Player) periodHz(period int) uint64 {
+	hz := uint64(p.clockHz) / uint64(period)
+	if p.pitchRatio != 1 {
+		hz = uint64(float64(hz) * p.pitchRatio)
+	}
+	return hz
+}
+
+// Fills the table of sample steps for each period, so that mixing needs no
+// division or floating point. Called whenever the clock, pitch ratio or
+// sample rate change.
+func (p *Player) updatePeriodSteps() {
+	if p.periodSteps == nil {
+		p.periodSteps = make([]uint32, maxPeriod+1)
+	}
+	for period := minPeriod; period <= maxPeriod; period++ {
+		step := p.periodHz(period) << 16 / uint64(p.samplingFrequency)
+		p.periodSteps[period] = uint32(min(step, math.MaxUint32))
+	}
+}
+
+// Returns the pitch the channel is playing at as a MIDI note number, where the
+// sample played at its C4 speed is C-4.
+func (p *Player) playbackPitch(channel *channel) float64 {
+	c4speed := p.Song.Samples[channel.sample].C4Speed
+	if c4speed <= 0 {
+		return 0
+	}
+
+	period := channel.period + (channel.vibratoAdjust * 4)
+	hz := float64(p.clockHz) / float64(period) * p.pitchRatio
+	return 60 + 12*math.Log2(hz/float64(c4speed))
+}
+
+// Returns the channel volume after tremolo, global volume and any voice fade
+// out have been applied.
+func (p *Player) channelVolume(channel *channel) int {
+	if channel.sfx {
+		return channel.volume // not affected by the song
+	}
+	vol := channel.volume + channel.tremoloAdjust
+	vol = (vol * int(p.globalVolume)) >> 6
+	vol = min(vol, maxVolume)
+	if channel.fading {
+		vol = (vol * channel.fadeVolume) / maxFadeVolume
+	}
+	return vol
+}
+
+// Turns the levels mixed since the last tick into the channel meter readings.
+func (p *Player) updateMeters() {
+	for i := range p.meters {
+		m := &p.meters[i]
+		fall := float64(m.samples) * meterPeakFall / float64(p.samplingFrequency)
+		m.peak = max(m.tickPeak, m.peak-fall)
+		m.rms = 0
+		if m.samples > 0 {
+			m.rms = math.Sqrt(m.sumSq / float64(m.samples))
 		}
+		m.tickPeak, m.sumSq, m.samples = 0, 0, 0
 	}
+}
 
-	return finished
+// Mixes nSamples of channel (tracker channel index ci) into the mix buffer
+// starting at offset.
+func (p *Player) mixChannel(channel *channel, ci, nSamples, offset int) {
+	p.mixChannelInto(p.mixbuffer, channel, ci, nSamples, offset)
 }
 
-func (c *channel) triggerNote(period, sample, order, row, tick int) {
-	c.period = period
-	c.sample = sample
-	c.samplePosition = 0
-	c.tremoloPhase = 0
-	c.vibratoPhase = 0
-	c.vibratoAdjust = 0
-	c.tremoloAdjust = 0
-	c.trigOrder = order
-	c.trigRow = row
-	c.trigTick = tick
+// Mixes nSamples of channel (tracker channel index ci) into mix starting at
+// offset. Channels can be mixed into different buffers concurrently.
+func (p *Player) mixChannelInto(mix []int, channel *channel, ci, nSamples, offset int) {
+	if p.panning == PanningBinaural {
+		p.mixBinaural(mix, channel, ci, nSamples, offset)
+		return
+	}
+	p.mixChannelAt(mix, channel, ci, nSamples, offset, p.stereoPan(channel))
 }
 
-func (p *Player) mixChannels(nSamples, offset int) {
-	for ci := range p.channels {
-		channel := &p.channels[ci]
+// Returns the pan position of channel blended toward the center by the stereo
+// separation. This is done at double resolution, from 0 (full left) to 254
+// (full right), so that the center is exact.
+func (p *Player) stereoPan(channel *channel) int {
+	pan := channel.pan * 2
+	if p.separation != 100 {
+		pan = 127 + (pan-127)*p.separation/100
+	}
+	return pan
+}
 
-		if channel.sample == -1 {
-			continue
-		}
+// Mixes channel like mixChannelInto at pan, a double resolution pan position
+// from stereoPan.
+func (p *Player) mixChannelAt(mix []int, channel *channel, ci, nSamples, offset, pan int) {
+	if channel.sample == -1 {
+		return
+	}
 
-		sample := &p.Song.Samples[channel.sample]
-		if sample.Length == 0 {
-			continue
+	sample := &p.Song.Samples[channel.sample]
+	if sample.Length == 0 {
+		return
+	}
+
+	if channel.triggered {
+		channel.triggered = false
+		switch p.declick {
+		case DeclickZeroCrossing:
+			channel.samplePosition = zeroCrossing(sample, channel.samplePosition)
+		case DeclickRamp:
+			channel.rampPos = 0
 		}
+	}
+	rampLen := 0
+	if p.declick == DeclickRamp {
+		rampLen = int(p.samplingFrequency / 1000)
+	}
 
-		period := channel.period + (channel.vibratoAdjust * 4)
-		playbackHz := int(retracePALHz / float32(period))
-		dr := uint(playbackHz<<16) / p.samplingFrequency
-		pos := channel.samplePosition
-		vol := channel.volume + channel.tremoloAdjust
-		vol = (vol * p.GlobalVolume) >> 6
-		vol = min(vol, maxVolume)
+	dr := p.sampleStep(channel)
+	pos := channel.samplePosition
+	vol := p.channelVolume(channel)
 
-		// If the volume is off or the channel muted
-		if vol <= 0 || (p.Mute&(1<<ci)) != 0 {
-			channel.samplePosition = pos + dr*uint(nSamples)
-			continue
-		}
-		vol *= int(p.volBoost)
+	// If the volume is off or the channel muted
+	if vol <= 0 || (ci >= 0 && p.mute.Load()&(1<<ci) != 0) {
+		channel.samplePosition = pos + dr*uint(nSamples)
+		channel.rampPos = rampLen
+		return
+	}
 
-		lvol := ((127 - channel.pan) * vol) >> 7
-		rvol := (channel.pan * vol) >> 7
-		if lvol == 0 && rvol == 0 {
-			// lvol and rvol can end up 0 for very quiet volumes due to
-			// precision issues, so skip the mix loop.
-			// TODO: Eliminate the two separate volume checks
-			channel.samplePosition = pos + dr*uint(nSamples)
-			continue
+	// The peak and sum of squares of the sample data mixed, for the meters
+	peakSq, sumSq := 0, 0
+	if ci >= 0 {
+		defer func(vol int) {
+			m := &p.meters[ci]
+			m.tickPeak = max(m.tickPeak, math.Sqrt(float64(peakSq))*float64(vol)/meterFullScale)
+			m.sumSq += float64(sumSq) * float64(vol*vol) / (meterFullScale * meterFullScale)
+		}(vol)
+	}
+	vol *= int(p.volBoost)
+
+	lvol := ((254 - pan) * vol) >> 8
+	rvol := (pan * vol) >> 8
+	if lvol == 0 && rvol == 0 {
+		// lvol and rvol can end up 0 for very quiet volumes due to
+		// precision issues, so skip the mix loop.
+		// TODO: Eliminate the two separate volume checks
+		channel.samplePosition = pos + dr*uint(nSamples)
+		return
+	}
+
+	var sampEnd uint
+	if sample.LoopLen > 0 {
+		sampEnd = uint(sample.LoopStart+sample.LoopLen) << 16
+	} else {
+		sampEnd = uint(sample.Length) << 16
+	}
+
+	cur := offset * 2
+	end := (offset + nSamples) * 2
+
+	if p.interpolation == InterpolationBLEP {
+		var ended bool
+		pos, ended = p.mixBLEP(mix, channel, sample, pos, dr, sampEnd, cur, end, lvol, rvol, rampLen, &sumSq, &peakSq)
+		if ended {
+			channel.sample = -1 // turn off the channel
 		}
+		channel.samplePosition = pos
+		return
+	}
 
-		var sampEnd uint
-		if sample.LoopLen > 0 {
-			sampEnd = uint(sample.LoopStart+sample.LoopLen) << 16
-		} else {
-			sampEnd = uint(sample.Length) << 16
+	// Fade in the start of the note one sample at a time
+	for cur < end && channel.rampPos < rampLen {
+		if pos >= sampEnd {
+			if sample.LoopLen == 0 {
+				channel.sample = -1 // turn off the channel
+				channel.samplePosition = pos
+				return
+			}
+			pos = loopWrap(pos, sample)
 		}
 
-		cur := offset * 2
-		end := (offset + nSamples) * 2
+		v := p.interpolate(sample, pos, sampEnd)
+		mix[cur+0] += ((v * lvol) >> 8 * channel.rampPos) / rampLen
+		mix[cur+1] += ((v * rvol) >> 8 * channel.rampPos) / rampLen
+
+		channel.rampPos++
+		pos += dr
+		cur += 2
+	}
 
+	if p.interpolation != InterpolationNone {
 		for cur < end {
-			// Compute the position in the sample by end
-			epos := pos + uint((end-cur)/2)*dr
-			// If the sample ends before the end of this loop iteration only run to that
-			if epos >= sampEnd {
-				epos = sampEnd
+			if pos >= sampEnd {
+				if sample.LoopLen == 0 {
+					channel.sample = -1 // turn off the channel
+					break
+				}
+				pos = loopWrap(pos, sample)
 			}
 
-			// lvol rvol | case
-			//   0    0  |  skip, nothing to mix in. already handled above
-			//  127   0  |  mono mix left side
-			//   0   127 |  mono mix right side
-			//   N    N  |  stereo mix
-			if lvol != 0 && rvol == 0 || lvol == 0 && rvol != 0 {
-				if lvol != 0 {
-					vol = lvol
-				} else {
-					vol = rvol
-					cur++
-				}
+			v := p.interpolate(sample, pos, sampEnd)
+			mix[cur+0] += (v * lvol) >> 8
+			mix[cur+1] += (v * rvol) >> 8
+			sd := v >> 8
+			sq := sd * sd
+			sumSq += sq
+			peakSq = max(peakSq, sq)
+
+			pos += dr
+			cur += 2
+		}
+		channel.samplePosition = pos
+		return
+	}
+
+	for cur < end {
+		// Compute the position in the sample by end
+		epos := pos + uint((end-cur)/2)*dr
+		// If the sample ends before the end of this loop iteration only run to that
+		if epos >= sampEnd {
+			epos = sampEnd
+		}
+
+		// lvol rvol | case
+		//   0    0  |  skip, nothing to mix in. already handled above
+		//  127   0  |  mono mix left side
+		//   0   127 |  mono mix right side
+		//   N    N  |  stereo mix
+		if lvol != 0 && rvol == 0 || lvol == 0 && rvol != 0 {
+			if lvol != 0 {
+				vol = lvol
+			} else {
+				vol = rvol
+				cur++
+			}
+			if sample.Data16 != nil {
 				for pos < epos {
-					sd := int(sample.Data[pos>>16])
-					p.mixbuffer[cur] += sd * vol
+					v := int(sample.Data16[pos>>16])
+					mix[cur] += (v * vol) >> 8
+					sd := v >> 8
+					sq := sd * sd
+					sumSq += sq
+					peakSq = max(peakSq, sq)
 
 					pos += dr
 					cur += 2
 				}
-				// Now snap cursor to the correct position
-				if rvol != 0 {
-					cur--
-				}
 			} else {
 				for pos < epos {
-					// WARNING: no clamping when mixing into mixbuffer. Clamping will be applied when the final audio is returned
-					// to the caller.
 					sd := int(sample.Data[pos>>16])
-					p.mixbuffer[cur+0] += sd * lvol
-					p.mixbuffer[cur+1] += sd * rvol
+					mix[cur] += sd * vol
+					sq := sd * sd
+					sumSq += sq
+					peakSq = max(peakSq, sq)
 
 					pos += dr
 					cur += 2
 				}
 			}
-			if pos >= sampEnd {
-				if sample.LoopLen > 0 {
-					pos = uint(sample.LoopStart) << 16
-				} else {
-					channel.sample = -1 // turn off the channel
-					break
-				}
+			// Now snap cursor to the correct position
+			if rvol != 0 {
+				cur--
+			}
+		} else if pos < epos {
+			n := int((epos - pos + dr - 1) / dr) // frames before epos
+			if sample.Data16 != nil {
+				pos = mixStereo16(mix[cur:cur+n*2], sample.Data16, pos, dr, lvol, rvol, &sumSq, &peakSq)
+			} else {
+				pos = p.stereoMix(mix[cur:cur+n*2], sample.Data, pos, dr, lvol, rvol, &sumSq, &peakSq)
+			}
+			cur += n * 2
+		}
+		if pos >= sampEnd {
+			if sample.LoopLen > 0 {
+				pos = loopWrap(pos, sample)
+			} else {
+				channel.sample = -1 // turn off the channel
+				break
 			}
 		}
-		channel.samplePosition = pos
 	}
+	channel.samplePosition = pos
 }
 
 // GenerateAudio fills out with stereo sample data (LRLRLR...) and returns the
@@ -1064,24 +3089,241 @@ func (p *Player) mixChannels(nSamples, offset int) {
 // paused it will generate 0 samples. In the case that the player reaches the
 // end of the song it may generate less samples than the buffer can hold.
 func (p *Player) GenerateAudio(out []int16) int {
-	if !p.playing {
+	// L&R samples are interleaved, so out length 2 is asking for one stereo sample
+	generated := p.generate(len(out) / 2)
+
+	// Downsample the mix buffer into the output buffer
+	p.downsample(out, generated*2)
+	if p.effect != nil && generated > 0 {
+		p.effect.Process(out[:generated*2], out[:generated*2])
+	}
+
+	return generated
+}
+
+// PlayUntilDone repeatedly fills buf with audio using GenerateAudio and
+// passes the generated samples to write, until the player stops or reaches
+// the end of the song. It returns ctx.Err() if ctx is cancelled first or the
+// error from write if it fails. The player must be started and buf must hold
+// at least one stereo sample.
+func (p *Player) PlayUntilDone(ctx context.Context, buf []int16, write func([]int16) error) error {
+	if len(buf) < 2 {
+		return fmt.Errorf("buffer too small, %d samples", len(buf))
+	}
+
+	for p.IsPlaying() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n := p.GenerateAudio(buf)
+		if n == 0 {
+			continue // stopped during generation
+		}
+		if err := write(buf[:n*2]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RenderAll renders the rest of the song as fast as possible, like
+// PlayUntilDone, for offline conversion. The audio is generated in blocks as
+// large as the mix buffer, see PlayerOptions.MixBufferSize, and write is called
+// on another goroutine so that the next block is rendered while the previous
+// one is written. write is called once at a time, in order, and must not keep
+// the slice it is given. Songs with many channels are also mixed on several
+// goroutines, see SetMixWorkers.
+//
+// RenderAll returns ctx.Err() if ctx is cancelled first or the error from
+// write if it fails, in which case no more audio is rendered. The player must
+// be started.
+func (p *Player) RenderAll(ctx context.Context, write func([]int16) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Two blocks, one being rendered while the other is written
+	blocks := make(chan []int16)
+	free := make(chan []int16, 2)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]int16, len(p.mixbuffer))
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		var err error
+		for block := range blocks {
+			if err == nil {
+				if err = write(block); err != nil {
+					cancel() // stop rendering
+				}
+			}
+			free <- block[:cap(block)]
+		}
+		writeErr <- err
+	}()
+
+	var err error
+	for p.IsPlaying() {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		buf := <-free
+		n := p.GenerateAudio(buf)
+		if n == 0 {
+			free <- buf
+			continue // stopped during generation
+		}
+		blocks <- buf[:n*2]
+	}
+	close(blocks)
+
+	if werr := <-writeErr; werr != nil {
+		return werr
+	}
+	return err
+}
+
+// GenerateAudioPlanar is the same as GenerateAudio, except the left and right
+// channels are written to separate buffers. The number of samples generated
+// is limited by the shorter of the two.
+func (p *Player) GenerateAudioPlanar(left, right []int16) int {
+	generated := p.generate(min(len(left), len(right)))
+
+	if p.effect != nil && generated > 0 {
+		out := p.applyEffect(generated)
+		for i := 0; i < generated; i++ {
+			left[i] = out[i*2+0]
+			right[i] = out[i*2+1]
+		}
+		return generated
+	}
+	p.downsamplePlanar(left, right, generated)
+
+	return generated
+}
+
+// Downsamples generated stereo samples from the mix buffer and passes them
+// through the effect, for the output formats that are not interleaved 16-bit
+// samples. Returns the processed samples.
+func (p *Player) applyEffect(generated int) []int16 {
+	out := p.effectbuffer[0 : generated*2]
+	p.downsample(out, generated*2)
+	p.effect.Process(out, out)
+	return out
+}
+
+// GenerateAudio32 is the same as GenerateAudio, except the samples are the
+// mixer's output before it is clamped to 16 bits, so loud passages keep their
+// full shape. Full scale is the same as the 16-bit output, which leaves the
+// caller headroom for its own mastering, such as normalizing or limiting. The
+// master gain is applied but the mix format and clipping settings are not.
+// Stats reports the peak of the samples, none are counted as clipped. Effects
+// process 16-bit audio, so with an effect set, see SetEffect, the samples are
+// those of GenerateAudio instead and have no headroom.
+func (p *Player) GenerateAudio32(out []int32) int {
+	generated := p.generate(len(out) / 2)
+
+	if p.effect != nil && generated > 0 {
+		for i, s := range p.applyEffect(generated) {
+			out[i] = int32(s)
+		}
+		return generated
+	}
+
+	mix := p.mixbuffer[0 : generated*2]
+	p.applyGain(mix)
+	peak := 0
+	for i, s := range mix {
+		out[i] = int32(clamp(s, math.MinInt32, math.MaxInt32))
+		if s < 0 {
+			s = -s
+		}
+		peak = max(peak, s)
+	}
+	p.recordStats(len(mix), 0, peak)
+
+	return generated
+}
+
+// RenderQuantum is the number of samples in a Web Audio render quantum, the
+// amount of audio an AudioWorklet processes at a time. It is a convenient
+// chunk size for GenerateAudioFloat32 when playing in a browser.
+const RenderQuantum = 128
+
+// GenerateAudioFloat32 is the same as GenerateAudioPlanar, except the samples
+// are floating point values from -1 to 1, the format used by Web Audio. With an
+// effect set, see SetEffect, the samples have the 16-bit precision of the
+// audio the effect processes.
+func (p *Player) GenerateAudioFloat32(left, right []float32) int {
+	generated := p.generate(min(len(left), len(right)))
+
+	if p.effect != nil && generated > 0 {
+		out := p.applyEffect(generated)
+		for i := 0; i < generated; i++ {
+			left[i] = float32(out[i*2+0]) / 32768
+			right[i] = float32(out[i*2+1]) / 32768
+		}
+		return generated
+	}
+
+	if p.mixFormat == MixFloat32 {
+		mix := p.floatMix(generated * 2)
+		for i := 0; i < generated; i++ {
+			left[i] = clamp(mix[i*2+0], -1, 1)
+			right[i] = clamp(mix[i*2+1], -1, 1)
+		}
+		return generated
+	}
+
+	p.applyGain(p.mixbuffer[0 : generated*2])
+	p.updateStats(p.mixbuffer[0 : generated*2])
+	p.applySoftClip(p.mixbuffer[0 : generated*2])
+	for i := 0; i < generated; i++ {
+		left[i] = float32(clampSample(p.mixbuffer[i*2+0])) / 32768
+		right[i] = float32(clampSample(p.mixbuffer[i*2+1])) / 32768
+	}
+
+	return generated
+}
+
+// Mixes up to count stereo samples into the mix buffer, advancing the player
+// through the song. Returns the number of stereo samples mixed.
+func (p *Player) generate(count int) int {
+	if !p.playing.Load() {
 		return 0
 	}
 
-	if len(out) > len(p.mixbuffer) {
+	if count*2 > len(p.mixbuffer) {
 		// TODO - better handling of this error condition, e.g. resizing the mix buffer
-		panic(fmt.Sprintf("Mixbuffer too small %d wanted %d size", len(out), len(p.mixbuffer)))
+		panic(fmt.Sprintf("Mixbuffer too small %d wanted %d size", count*2, len(p.mixbuffer)))
 	}
 
 	// Zero out the portion of the mixbuffer that will be written to.
-	clear(p.mixbuffer[0:len(out)])
+	clear(p.mixbuffer[0 : count*2])
+	p.updateSFX()
 
-	count := len(out) / 2 // L&R samples are interleaved, so out length 2 is asking for one stereo sample
 	offset := 0
 	generated := 0
 
 	for count > 0 {
-		if p.tickSamplePos == p.samplesPerTick {
+		if p.fadeTotal > 0 && p.fadeRemaining == 0 {
+			// The fade out has finished, FadeOut always stops the player
+			if p.songEnded(!p.fadeStops) {
+				break
+			}
+		}
+
+		if p.silenceStop > 0 && p.samplesToDuration(int64(p.silentSamples)) >= p.silenceStop {
+			// The song has gone quiet
+			if p.songEnded(true) {
+				break
+			}
+		}
+
+		if p.tickSamplePos >= p.samplesPerTick {
 			if p.sequenceTick() {
 				break // song finished, exit
 			}
@@ -1092,41 +3334,211 @@ func (p *Player) GenerateAudio(out []int16) int {
 		if remain > count {
 			remain = count
 		}
+		if p.fadeTotal > 0 {
+			remain = min(remain, p.fadeRemaining)
+		}
 		p.mixChannels(remain, offset)
+		if p.fadeTotal > 0 {
+			p.applyFade(remain, offset)
+		}
+		if p.silenceStop > 0 {
+			p.trackSilence(remain, offset)
+		}
+		p.mixSFX(remain, offset)
 
 		p.tickSamplePos += remain
+		p.samplesPlayed += int64(remain)
 		offset += remain
 		generated += remain
 		count -= remain
 	}
 
-	// Downsample the mix buffer into the output buffer
-	p.downsample(out, generated*2)
-
 	return generated
 }
 
+// Stats returns statistics about the clipping of the audio generated since the
+// player was created or ResetStats was called. Use it to choose a volume boost
+// that makes good use of the output range without clipping.
+//
+// It is safe to call Stats from a different goroutine to the one generating
+// audio.
+func (p *Player) Stats() Stats {
+	return Stats{
+		Samples: p.stats.samples.Load(),
+		Clipped: p.stats.clipped.Load(),
+		Peak:    int(p.stats.peak.Load()),
+	}
+}
+
+// ResetStats clears the statistics returned by Stats.
+func (p *Player) ResetStats() {
+	p.stats.samples.Store(0)
+	p.stats.clipped.Store(0)
+	p.stats.peak.Store(0)
+}
+
+// Records the clipping statistics of mixed samples that are about to be
+// output.
+func (p *Player) updateStats(samples []int) {
+	peak, clipped := 0, int64(0)
+	for _, s := range samples {
+		if s > 32767 || s < -32768 {
+			clipped++
+		}
+		if s < 0 {
+			s = -s
+		}
+		peak = max(peak, s)
+	}
+
+	p.recordStats(len(samples), clipped, peak)
+}
+
+// Records the clipping statistics of floating point samples that are about to
+// be output.
+func (p *Player) updateStatsFloat(samples []float32) {
+	peak, clipped := float32(0), int64(0)
+	for _, s := range samples {
+		if s < 0 {
+			s = -s
+		}
+		if s > 32767.0/32768 {
+			clipped++
+		}
+		peak = max(peak, s)
+	}
+
+	p.recordStats(len(samples), clipped, int(peak*32768))
+}
+
+func (p *Player) recordStats(samples int, clipped int64, peak int) {
+	p.stats.samples.Add(int64(samples))
+	p.stats.clipped.Add(clipped)
+	if int64(peak) > p.stats.peak.Load() {
+		p.stats.peak.Store(int64(peak))
+	}
+}
+
+// Applies the master gain to mixed samples that are about to be output.
+func (p *Player) applyGain(samples []int) {
+	if p.masterGain == unityGain {
+		return
+	}
+	for i, s := range samples {
+		samples[i] = (s * p.masterGain) >> 16
+	}
+}
+
+// Converts the first n values of the mix buffer to floating point, -1 to 1
+// full scale, for the MixFloat32 output stages. The master gain is applied and
+// the clipping statistics recorded.
+func (p *Player) floatMix(n int) []float32 {
+	mix := p.fmixbuffer[0:n]
+	gain := float32(p.masterGain) / unityGain / 32768
+	for i, s := range p.mixbuffer[0:n] {
+		mix[i] = float32(s) * gain
+	}
+	p.updateStatsFloat(mix)
+	if p.clipping == ClipSoft {
+		for i, s := range mix {
+			if s > softClipKnee || s < -softClipKnee {
+				mix[i] = float32(softClip(float64(s)))
+			}
+		}
+	}
+	return mix
+}
+
+// Rounds a floating point sample to 16 bits with triangular (TPDF) dither.
+func (p *Player) ditherSample(s float32) int16 {
+	noise := float32(p.ditherNoise()-p.ditherNoise()) / (1 << 24)
+	v := math.Round(float64(s*32768 + noise))
+	return int16(clamp(v, -32768, 32767))
+}
+
+// Returns a uniform random value from 0 to 2^24-1 from an xorshift generator.
+func (p *Player) ditherNoise() int32 {
+	p.dither ^= p.dither << 13
+	p.dither ^= p.dither >> 17
+	p.dither ^= p.dither << 5
+	return int32(p.dither >> 8)
+}
+
 func (p *Player) downsample(out []int16, generated int) {
+	if p.mixFormat == MixFloat32 {
+		for i, s := range p.floatMix(generated) {
+			out[i] = p.ditherSample(s)
+		}
+		return
+	}
+
+	p.applyGain(p.mixbuffer[0:generated])
+	p.updateStats(p.mixbuffer[0:generated])
+	p.applySoftClip(p.mixbuffer[0:generated])
 	for i, s := range p.mixbuffer[0:generated] {
-		if s > 32767 {
-			s = 32767
-		} else if s < -32768 {
-			s = -32768
+		out[i] = clampSample(s)
+	}
+}
+
+// Downsamples generated stereo samples from the mix buffer into separate
+// left and right buffers.
+func (p *Player) downsamplePlanar(left, right []int16, generated int) {
+	if p.mixFormat == MixFloat32 {
+		mix := p.floatMix(generated * 2)
+		for i := 0; i < generated; i++ {
+			left[i] = p.ditherSample(mix[i*2+0])
+			right[i] = p.ditherSample(mix[i*2+1])
+		}
+		return
+	}
+
+	p.applyGain(p.mixbuffer[0 : generated*2])
+	p.updateStats(p.mixbuffer[0 : generated*2])
+	p.applySoftClip(p.mixbuffer[0 : generated*2])
+	for i := 0; i < generated; i++ {
+		left[i] = clampSample(p.mixbuffer[i*2+0])
+		right[i] = clampSample(p.mixbuffer[i*2+1])
+	}
+}
+
+// Saturates mixed samples that are about to be output with ClipSoft.
+func (p *Player) applySoftClip(samples []int) {
+	if p.clipping != ClipSoft {
+		return
+	}
+	const knee = int(softClipKnee * 32768)
+	for i, s := range samples {
+		if s > knee || s < -knee {
+			samples[i] = int(math.Round(softClip(float64(s)/32768) * 32768))
 		}
-		out[i] = int16(s)
 	}
 }
 
-// There is a race condition where the row counter can be set to -1 and then
-// used resulting in invalid offsets. This function protects against that
-// issue but it would be ideal to eliminate the race condition.
-func (p *Player) rowDataIndex() int {
-	rc := p.row
-	if rc < 0 {
-		rc = 0
+// Saturates a sample, where 1 is full scale. Samples below softClipKnee are
+// unchanged, louder samples follow a tanh curve that approaches full scale.
+// The curve has a slope of 1 at the knee so there is no sudden change in tone.
+func softClip(s float64) float64 {
+	a := math.Abs(s)
+	if a <= softClipKnee {
+		return s
+	}
+	const headroom = 1 - softClipKnee
+	return math.Copysign(softClipKnee+headroom*math.Tanh((a-softClipKnee)/headroom), s)
+}
+
+// Clamps a mix buffer value to the 16-bit output range.
+func clampSample(s int) int16 {
+	if s > 32767 {
+		s = 32767
+	} else if s < -32768 {
+		s = -32768
 	}
+	return int16(s)
+}
 
-	return rc * p.Song.Channels
+// Returns the index of the current row's first note in the pattern data
+func (p *Player) rowDataIndex() int {
+	return p.row * p.Song.Channels
 }
 
 // Allocate and initialize a new pattern of notes
@@ -1147,6 +3559,31 @@ func periodFromS3MNoteOld(note byte) int {
 	return s3mperiod
 }
 
+// Returns the period to play note with sample at, taking into account the
+// period mode.
+func (p *Player) notePeriod(note playerNote, sample *Sample) int {
+	if p.periodMode == PeriodAmiga && p.Song.Type == SongTypeMOD {
+		if i := int(note) - amigaNoteBase; i >= 0 && i < len(amigaPeriods[0]) {
+			return amigaPeriods[sample.FineTune+8][i] * 4
+		}
+	}
+
+	if t := p.periodTables[sample.C4Speed]; t != nil && note >= 0 && int(note) < len(t) {
+		return t[note]
+	}
+	return periodFromPlayerNote(note, sample.C4Speed)
+}
+
+// Returns the period of every note up to B-9 for a sample with the given C4
+// speed, so that triggering notes doesn't need math.Pow.
+func notePeriodTable(c4speed int) *[periodTableNotes]int {
+	var t [periodTableNotes]int
+	for note := range t {
+		t[note] = periodFromPlayerNote(playerNote(note), c4speed)
+	}
+	return &t
+}
+
 // Converts an player internal note representation into an Amiga MOD period.
 // This code is inspired by libxmp.
 func periodFromPlayerNote(note playerNote, c4speed int) int {
@@ -1156,6 +3593,91 @@ func periodFromPlayerNote(note playerNote, c4speed int) int {
 	return int(period) * 4
 }
 
+// Returns the note played by a sample at period, the inverse of
+// periodFromPlayerNote.
+func periodNote(period, c4speed int) playerNote {
+	// Scale to the Amiga period of a sample with the standard C4 speed
+	return periodToPlayerNote((period*c4speed + 2*8363) / (4 * 8363))
+}
+
+// Returns the value of sample at the 16.16 fixed point position pos, with 8
+// bits of fraction, using the player's interpolation. end is the 16.16
+// position that the sample ends or loops at.
+func (p *Player) interpolate(sample *Sample, pos, end uint) int {
+	i := int(pos >> 16)
+	s0 := int64(frame16(sample, i))
+	if p.interpolation == InterpolationNone {
+		return int(s0)
+	}
+
+	if p.interpolation == InterpolationSinc {
+		return sincInterpolate(sample, pos, int(end>>16))
+	}
+
+	f := int64(pos & 0xFFFF)
+	s1 := int64(sampleFrame(sample, i+1, int(end>>16)))
+	if p.interpolation == InterpolationLinear {
+		return int(s0 + ((s1-s0)*f)>>16)
+	}
+
+	// Catmull-Rom spline evaluated with Horner's method
+	sm1 := int64(sampleFrame(sample, i-1, int(end>>16)))
+	s2 := int64(sampleFrame(sample, i+2, int(end>>16)))
+	a := 3*(s0-s1) + s2 - sm1
+	b := 2*sm1 - 5*s0 + 4*s1 - s2 + (a*f)>>16
+	c := s1 - sm1 + (b*f)>>16
+	return int(s0 + (c*f)>>17)
+}
+
+// Returns frame i of sample with 8 bits of fraction, from the widened data if
+// there is any.
+func frame16(sample *Sample, i int) int {
+	if sample.Data16 != nil {
+		return int(sample.Data16[i])
+	}
+	return int(sample.Data[i]) << 8
+}
+
+// Returns frame i of sample with 8 bits of fraction, where end is the frame
+// the sample ends or loops at. Frames before the start are silent, and frames
+// past the end follow the loop or are silent if there is no loop.
+func sampleFrame(sample *Sample, i, end int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= end {
+		if sample.LoopLen == 0 {
+			return 0
+		}
+		i = sample.LoopStart + (i-end)%sample.LoopLen
+	}
+	return frame16(sample, i)
+}
+
+// Wraps a sample position that has run off the end of the sample loop back
+// into the loop. The overshoot is preserved, which matters for tiny loops
+// where a single mixer step can be longer than the loop itself.
+func loopWrap(pos uint, sample *Sample) uint {
+	loopStart := uint(sample.LoopStart) << 16
+	loopLen := uint(sample.LoopLen) << 16
+
+	return loopStart + (pos-loopStart)%loopLen
+}
+
+// Returns the sample position of the nearest zero crossing at or after pos.
+// If no zero crossing is found nearby then pos is returned unchanged.
+func zeroCrossing(sample *Sample, pos uint) uint {
+	i := int(pos >> 16)
+	end := min(i+maxZeroSearch, sample.Length, len(sample.Data))
+	for j := i; j < end; j++ {
+		if sample.Data[j] == 0 || (j > i && (sample.Data[j] < 0) != (sample.Data[j-1] < 0)) {
+			return uint(j) << 16
+		}
+	}
+
+	return pos
+}
+
 func (c *channel) vibrato() {
 	c.vibratoAdjust = (vibratoTremoloWaveFn(c.vibratoWaveform, c.vibratoPhase) * c.vibratoDepth) >> 7
 }
@@ -2,8 +2,15 @@ package modplayer
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"slices"
 	"testing"
+	"time"
 )
 
 var mixBuffer = make([]int16, 10*1024*2)
@@ -36,6 +43,30 @@ func TestLoadMODSong(t *testing.T) {
 	if !bytes.Equal(song.Orders[0:3], []byte{1, 2, 3}) || song.Orders[41] != 0x28 {
 		t.Errorf("Order data is wrong")
 	}
+	if song.FileFormat != "M.K." || song.TrackerName != "ProTracker" {
+		t.Errorf("Expected a ProTracker M.K. song, got %q %q", song.TrackerName, song.FileFormat)
+	}
+}
+
+func TestLoadS3MSong(t *testing.T) {
+	s3m, err := os.ReadFile("mods/caero.s3m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song, err := NewS3MSongFromBytes(s3m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if song.Type != SongTypeS3M {
+		t.Errorf("Expected an S3M song, got type %d", song.Type)
+	}
+	if song.FileFormat != "SCRM" || song.TrackerName != "Scream Tracker 3.20" {
+		t.Errorf("Expected a Scream Tracker 3.20 SCRM song, got %q %q", song.TrackerName, song.FileFormat)
+	}
+	if song.Message != "" || song.Flags != 0 {
+		t.Errorf("Expected no message or flags, got %q %d", song.Message, song.Flags)
+	}
 }
 
 func TestNoteDataFor(t *testing.T) {
@@ -97,8 +128,8 @@ func TestPlayerInitialState(t *testing.T) {
 	if player.order != 0 {
 		t.Errorf("Expected player on order 0, got %d\n", player.order)
 	}
-	if player.row != -1 {
-		t.Errorf("Expected player on row -1, got %d\n", player.row)
+	if player.row != 0 {
+		t.Errorf("Expected player on row 0, got %d\n", player.row)
 	}
 	if player.volBoost != 1 {
 		t.Errorf("Expected volume boost of 1, got %d\n", player.volBoost)
@@ -158,6 +189,134 @@ func TestSeekTo(t *testing.T) {
 	}
 }
 
+func TestJumpToOrder(t *testing.T) {
+	plr := newPlayerWithJumpPattern([][]string{{""}}, t)
+	plr.Orders = []byte{0, 0, 0}
+	plr.SetLoopPolicy(LoopPolicyStop, 0)
+
+	plr.sequenceTick()
+	advanceToNextRow(plr)
+
+	// Repeated calls skip further ahead and wait for the row to finish
+	if err := plr.NextOrder(); err != nil {
+		t.Fatal(err)
+	}
+	if err := plr.NextOrder(); err != nil {
+		t.Fatal(err)
+	}
+	plr.sequenceTick()
+	if plr.order != 0 || plr.row != 1 {
+		t.Errorf("Expected to be on order 0 row 1, got order %d row %d", plr.order, plr.row)
+	}
+	advanceToNextRow(plr)
+	if plr.order != 2 || plr.row != 0 {
+		t.Errorf("Expected to move to order 2 row 0, got order %d row %d", plr.order, plr.row)
+	}
+	if err := plr.NextOrder(); err == nil {
+		t.Errorf("Expected an error moving past the last order")
+	}
+
+	// Going back is not a loop in the song
+	advanceToNextRow(plr)
+	if err := plr.JumpToOrder(0); err != nil {
+		t.Fatal(err)
+	}
+	advanceToNextRow(plr)
+	if plr.order != 0 || plr.row != 0 || !plr.IsPlaying() {
+		t.Errorf("Expected to be playing order 0 row 0, got order %d row %d", plr.order, plr.row)
+	}
+	if err := plr.PrevOrder(); err == nil {
+		t.Errorf("Expected an error moving before the first order")
+	}
+	if err := plr.JumpToOrder(3); err == nil {
+		t.Errorf("Expected an error for an invalid order")
+	}
+}
+
+func TestJumpRows(t *testing.T) {
+	plr := newPlayerWithJumpPattern([][]string{{""}}, t)
+	plr.Orders = []byte{0, 0, 0}
+	plr.SetLoopPolicy(LoopPolicyStop, 0)
+
+	plr.sequenceTick()
+	advanceToNextRow(plr)
+
+	// Repeated calls add up and carry on into the next order
+	plr.JumpRows(40)
+	plr.JumpRows(40)
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 17 {
+		t.Errorf("Expected to move to order 1 row 17, got order %d row %d", plr.order, plr.row)
+	}
+
+	plr.JumpRows(-20)
+	advanceToNextRow(plr)
+	if plr.order != 0 || plr.row != 61 || !plr.IsPlaying() {
+		t.Errorf("Expected to be playing order 0 row 61, got order %d row %d", plr.order, plr.row)
+	}
+
+	// Jumps stop at the ends of the song
+	plr.JumpRows(-100)
+	advanceToNextRow(plr)
+	if plr.order != 0 || plr.row != 0 {
+		t.Errorf("Expected to move to order 0 row 0, got order %d row %d", plr.order, plr.row)
+	}
+	plr.JumpRows(1000)
+	advanceToNextRow(plr)
+	if plr.order != 2 || plr.row != 63 {
+		t.Errorf("Expected to move to order 2 row 63, got order %d row %d", plr.order, plr.row)
+	}
+
+	if err := plr.JumpTo(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 5 {
+		t.Errorf("Expected to move to order 1 row 5, got order %d row %d", plr.order, plr.row)
+	}
+	if err := plr.JumpTo(1, 64); err == nil {
+		t.Errorf("Expected an error for an invalid row")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	newPlayer := func(channels int) *Player {
+		pattern := make([][]string, 64)
+		for i := range pattern {
+			pattern[i] = make([]string, channels)
+		}
+		return newPlayerWithTestPattern(pattern, t)
+	}
+	plr := newPlayer(1)
+	next := newPlayer(2).Song
+	next.Tempo = 150
+	plr.Queue(next)
+
+	var changed *Song
+	plr.OnSongChange = func(song *Song) { changed = song }
+	ended := false
+	plr.OnSongEnd = func() { ended = true }
+
+	// The queued song starts within the same call
+	plr.SeekTo(0, 63)
+	out := make([]int16, 4000*2)
+	if n := plr.GenerateAudio(out); n != 4000 {
+		t.Errorf("Expected 4000 samples, got %d", n)
+	}
+	if plr.Song != next || changed != next || ended {
+		t.Fatalf("Expected the queued song to be playing")
+	}
+	if len(plr.channels) != 2 || plr.Tempo != 150 || plr.order != 0 || plr.row != 1 {
+		t.Errorf("Expected to be playing row 1 of the queued song at tempo 150, got row %d at tempo %d", plr.row, plr.Tempo)
+	}
+
+	// The player stops at the end of the queue
+	plr.PlayUntilDone(context.Background(), out, func([]int16) error { return nil })
+	if !ended {
+		t.Errorf("Expected the song to end")
+	}
+}
+
 func TestSetVolumeBoost(t *testing.T) {
 	plr, err := NewPlayer(&testSong, 44100)
 	if err != nil {
@@ -459,12 +618,91 @@ func TestEffectSetSpeed(t *testing.T) {
 	}
 }
 
+// Returns a player for a 64 row pattern played twice, with the given rows at
+// the start of the pattern.
+func newPlayerWithJumpPattern(rows [][]string, t *testing.T) *Player {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = make([]string, len(rows[0]))
+	}
+	copy(pattern, rows)
+
+	plr := newPlayerWithTestPattern(pattern, t)
+	plr.Orders = []byte{0, 0}
+	return plr
+}
+
 func TestEffectPatternJump(t *testing.T) {
-	t.Skip("TODO")
+	plr := newPlayerWithJumpPattern([][]string{
+		{""},
+		{"... .. .. B01"},
+	}, t)
+
+	advanceToNextRow(plr)
+	// The jump waits for the row to finish
+	if plr.order != 0 || plr.row != 1 {
+		t.Errorf("Expected to be on order 0 row 1, got order %d row %d", plr.order, plr.row)
+	}
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 0 {
+		t.Errorf("Expected to jump to order 1 row 0, got order %d row %d", plr.order, plr.row)
+	}
+	if s := plr.State(); s.Order != 1 || s.Row != 0 {
+		t.Errorf("Expected state at order 1 row 0, got order %d row %d", s.Order, s.Row)
+	}
+
+	// Jumps past the end of the song go to the last order
+	plr = newPlayerWithJumpPattern([][]string{{""}, {"... .. .. B09"}}, t)
+	advanceToNextRow(plr)
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 0 {
+		t.Errorf("Expected to jump to order 1 row 0, got order %d row %d", plr.order, plr.row)
+	}
+
+	// A jump and a break on the same row go to the break row of the jump
+	// order, whichever channel they are in
+	for _, row := range [][]string{
+		{"... .. .. B01", "... .. .. C05"},
+		{"... .. .. C05", "... .. .. B01"},
+	} {
+		plr = newPlayerWithJumpPattern([][]string{row}, t)
+		advanceToNextRow(plr)
+		if plr.order != 1 || plr.row != 5 {
+			t.Errorf("%v: expected to jump to order 1 row 5, got order %d row %d", row, plr.order, plr.row)
+		}
+	}
 }
 
 func TestEffectPatternBreak(t *testing.T) {
-	t.Skip("TODO")
+	plr := newPlayerWithJumpPattern([][]string{{"... .. .. C12"}}, t)
+
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 12 {
+		t.Errorf("Expected to break to order 1 row 12, got order %d row %d", plr.order, plr.row)
+	}
+
+	// Breaking on the last order stays on the order
+	plr.SeekTo(1, 0)
+	plr.sequenceTick()
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 12 {
+		t.Errorf("Expected to break to order 1 row 12, got order %d row %d", plr.order, plr.row)
+	}
+
+	// Multiple breaks on a row only advance one order
+	plr = newPlayerWithJumpPattern([][]string{{"... .. .. C03", "... .. .. C07"}}, t)
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 7 {
+		t.Errorf("Expected to break to order 1 row 7, got order %d row %d", plr.order, plr.row)
+	}
+
+	// Rows past the end of the pattern break to the first row
+	plr = newPlayerWithJumpPattern([][]string{{""}, {"... .. .. C70"}}, t)
+	advanceToNextRow(plr)
+	advanceToNextRow(plr)
+	if plr.order != 1 || plr.row != 0 {
+		t.Errorf("Expected to break to order 1 row 0, got order %d row %d", plr.order, plr.row)
+	}
 }
 
 func TestEffectMODSetVolume(t *testing.T) {
@@ -933,3 +1171,3052 @@ func BenchmarkMixChannels(b *testing.B) {
 		player.GenerateAudio(out) // internally this calls MixChannels
 	}
 }
+
+func TestChannelSilentRows(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 .. ...", ""},
+		{"... .. 00 ...", ""},
+		{"", ""},
+	}, t)
+
+	expected := [][]int{{0, 1}, {1, 2}, {2, 3}}
+	for row, ex := range expected {
+		if row == 0 {
+			plr.sequenceTick()
+		} else {
+			advanceToNextRow(plr)
+		}
+		state := plr.State()
+		for ci, silent := range ex {
+			if state.Channels[ci].SilentRows != silent {
+				t.Errorf("Row %d channel %d, expected %d silent rows, got %d", row, ci, silent, state.Channels[ci].SilentRows)
+			}
+		}
+	}
+}
+
+func TestNewNoteAction(t *testing.T) {
+	cases := []struct {
+		Name    string
+		NNA     NewNoteAction
+		FadeOut int
+		Voices  []int // expected number of background voices after each tick
+	}{
+		{"Cut", NNACut, 512, []int{0, 0, 0, 0}},
+		{"Continue", NNAContinue, 512, []int{0, 0, 1, 1}},
+		{"Fade", NNANoteFade, 512, []int{0, 0, 1, 0}},
+		{"Note off", NNANoteOff, 512, []int{0, 0, 1, 0}},
+		{"Note off without fade", NNANoteOff, 0, []int{0, 0, 0, 0}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			plr := newPlayerWithTestPattern([][]string{
+				{"A-4  1 .. ..."},
+				{"B-4  1 .. ..."},
+			}, t)
+			plr.Song.Samples[0].NNA = tc.NNA
+			plr.Song.Samples[0].FadeOut = tc.FadeOut
+
+			for i, n := range tc.Voices {
+				plr.sequenceTick()
+				if len(plr.voices) != n {
+					t.Fatalf("On tick %d expected %d background voices, got %d", i, n, len(plr.voices))
+				}
+			}
+			if len(plr.voices) > 0 {
+				v := &plr.voices[0]
+				if v.period != periodA4 || v.owner != 0 {
+					t.Errorf("Expected background voice to be playing A-4 from channel 0, got period %d channel %d", v.period, v.owner)
+				}
+			}
+			validateChan(&plr.channels[0], 0, periodB4, 60, t)
+		})
+	}
+}
+
+func TestDeclick(t *testing.T) {
+	t.Run("Ramp", func(t *testing.T) {
+		plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = 100
+		}
+		plr.SetDeclick(DeclickRamp)
+
+		const rampLen = 44100 / 1000
+		out := make([]int16, (rampLen+4)*2)
+		plr.GenerateAudio(out)
+		if out[0] != 0 {
+			t.Errorf("Expected the note to start silent, got %d", out[0])
+		}
+		for i := 1; i < rampLen; i++ {
+			if out[i*2] <= out[(i-1)*2] {
+				t.Fatalf("Expected sample %d to be louder than the previous sample, got %d then %d", i, out[(i-1)*2], out[i*2])
+			}
+		}
+		if out[rampLen*2] != out[(rampLen+1)*2] {
+			t.Errorf("Expected the ramp to have finished by sample %d", rampLen)
+		}
+	})
+
+	t.Run("Zero crossing", func(t *testing.T) {
+		plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+		copy(plr.Song.Samples[0].Data, []int8{50, 50, 50, -20, -30})
+		plr.SetDeclick(DeclickZeroCrossing)
+
+		plr.GenerateAudio(make([]int16, 2))
+		if pos := plr.channels[0].samplePosition >> 16; pos != 3 {
+			t.Errorf("Expected the note to start from the zero crossing at 3, got %d", pos)
+		}
+	})
+}
+
+func TestInterpolation(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+
+	ramp := &Sample{Length: 5, Data: []int8{0, 10, 20, 30, 40}}
+	spike := &Sample{Length: 5, Data: []int8{0, 0, 64, 0, 0}}
+	looped := &Sample{Length: 5, LoopStart: 1, LoopLen: 4, Data: []int8{0, 64, 0, 0, 0}}
+	flat := &Sample{Length: 40, Data: make([]int8, 40)}
+	for i := range flat.Data {
+		flat.Data[i] = 32
+	}
+	half := uint(1<<16 + 1<<15)
+	cases := []struct {
+		name   string
+		interp Interpolation
+		sample *Sample
+		pos    uint
+		want   int
+	}{
+		{"None", InterpolationNone, ramp, half, 10 << 8},
+		{"Linear", InterpolationLinear, ramp, half, 15 << 8},
+		{"CubicLine", InterpolationCubic, ramp, half, 15 << 8},
+		{"Cubic", InterpolationCubic, spike, half, 36 << 8},
+		{"LinearEnd", InterpolationLinear, ramp, 4<<16 + 1<<15, 20 << 8},
+		{"LinearLoop", InterpolationLinear, looped, 4<<16 + 1<<15, 32 << 8},
+		{"SincFrame", InterpolationSinc, ramp, 2 << 16, 20 << 8},
+		{"SincFlat", InterpolationSinc, flat, 20<<16 + 1<<15, 32 << 8},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			plr.SetInterpolation(tc.interp)
+			if v := plr.interpolate(tc.sample, tc.pos, uint(tc.sample.Length)<<16); v != tc.want {
+				t.Errorf("Expected %d, got %d", tc.want, v)
+			}
+		})
+	}
+
+	// Interpolation changes the mixed audio
+	render := func(interp Interpolation) []int16 {
+		plr := newPlayerWithTestPattern([][]string{{"C-2  1 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+		}
+		plr.SetInterpolation(interp)
+		out := make([]int16, 1000*2)
+		plr.GenerateAudio(out)
+		return out
+	}
+	none := render(InterpolationNone)
+	for _, interp := range []Interpolation{InterpolationCubic, InterpolationSinc} {
+		if slices.Equal(none, render(interp)) {
+			t.Errorf("Expected interpolation %d to change the audio", interp)
+		}
+	}
+}
+
+func TestBLEP(t *testing.T) {
+	render := func(interp Interpolation, data func(i int) int8) []int16 {
+		plr := newPlayerWithTestPattern([][]string{{"C-2  1 .. ..."}}, t)
+		sample := &plr.Song.Samples[0]
+		for i := range sample.Data {
+			sample.Data[i] = data(i)
+		}
+		sample.LoopLen = testSampleLength
+		plr.SetInterpolation(interp)
+		out := make([]int16, 1000*2)
+		plr.GenerateAudio(out)
+		return out
+	}
+
+	// A constant sample is output delayed but unchanged once the step at the
+	// start of the note has passed
+	flat := func(i int) int8 { return 32 }
+	none, blep := render(InterpolationNone, flat), render(InterpolationBLEP, flat)
+	half := slices.IndexFunc(blep, func(s int16) bool { return s >= none[0]/2 }) / 2
+	if blep[0] > none[0]/64 || blep[0] < -none[0]/64 || half < blepDelay-2 || half > blepDelay {
+		t.Errorf("Expected the output to start %d samples late, got %v", blepDelay, blep[:blepTaps*2])
+	}
+	if !slices.Equal(blep[blepTaps*2:], none[blepTaps*2:]) {
+		t.Errorf("Expected a constant sample to be unchanged after %d samples", blepTaps)
+	}
+
+	// The steps of a square wave are smoothed, ringing past the levels of the
+	// square wave
+	square := func(i int) int8 { return int8((i/8%2)*80 - 40) }
+	none, blep = render(InterpolationNone, square), render(InterpolationBLEP, square)
+	between, over := false, false
+	lo, hi := slices.Min(none), slices.Max(none)
+	for _, s := range blep {
+		between = between || (s > lo && s < hi)
+		over = over || s < lo || s > hi
+	}
+	if !between || !over {
+		t.Errorf("Expected band-limited steps between %d and %d, got between %t overshoot %t", lo, hi, between, over)
+	}
+}
+
+func TestTempoHistory(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"... .. .. ..."},
+		{"... .. .. A04"},
+		{"... .. .. T40"},
+	}, t)
+
+	// Generate enough audio for the first tick of the third row
+	spt := plr.samplesPerTick
+	plr.GenerateAudio(make([]int16, (spt*6+1)*2))
+
+	expected := []TempoChange{
+		{Sample: 0, Order: 0, Row: 0, Tempo: 125, Speed: 2},
+		{Sample: int64(spt * 2), Order: 0, Row: 1, Tempo: 125, Speed: 4},
+		{Sample: int64(spt * 6), Order: 0, Row: 2, Tempo: 64, Speed: 4},
+	}
+	history := plr.TempoHistory()
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d tempo changes, got %d", len(expected), len(history))
+	}
+	for i, ex := range expected {
+		ex.Time = time.Duration(ex.Sample * int64(time.Second) / 44100)
+		if history[i] != ex {
+			t.Errorf("Tempo change %d, expected %+v, got %+v", i, ex, history[i])
+		}
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	positions := map[AmigaClock]uint{}
+	for _, clock := range []AmigaClock{ClockPAL, ClockNTSC} {
+		plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+		plr.SetClock(clock)
+		plr.GenerateAudio(make([]int16, 100*2))
+		positions[clock] = plr.channels[0].samplePosition
+	}
+
+	if positions[ClockNTSC] <= positions[ClockPAL] {
+		t.Errorf("Expected NTSC timing to play faster than PAL, got positions %d and %d", positions[ClockNTSC], positions[ClockPAL])
+	}
+}
+
+func TestTempoModeModern(t *testing.T) {
+	// In modern tempo mode the row duration is independent of speed
+	for _, speed := range []int{3, 6, 7} {
+		plr := newPlayerWithTestPattern([][]string{{""}, {""}, {""}}, t)
+		plr.SetTempoMode(TempoModeModern)
+		plr.setSpeed(speed)
+		plr.updateSamplesPerTick()
+
+		// 125 bpm at 4 rows per beat is 120ms per row, 5292 samples at 44.1Khz
+		out := make([]int16, 5292*2)
+		plr.GenerateAudio(out)
+		plr.GenerateAudio(out)
+		if plr.row != 1 || plr.tickSamplePos != plr.samplesPerTick || plr.tick != speed-1 {
+			t.Errorf("Speed %d, expected to be at the end of the second row, got row %d tick %d", speed, plr.row, plr.tick)
+		}
+	}
+}
+
+func TestTinyLoops(t *testing.T) {
+	cases := []struct {
+		Name    string
+		LoopLen int
+		Note    string
+	}{
+		{"2 byte loop", 2, "A-4"},
+		{"4 byte loop", 4, "C-6"},
+		{"Step longer than loop", 2, "B-7"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			plr := newPlayerWithTestPattern([][]string{{tc.Note + "  1 .. ..."}}, t)
+			smp := &plr.Song.Samples[0]
+			smp.LoopStart = 0
+			smp.LoopLen = tc.LoopLen
+			for i := range smp.Data {
+				smp.Data[i] = int8(64 - 128*(i&1))
+			}
+
+			// Render a few mixer calls and check that the sample position
+			// has advanced at the correct rate through the loop
+			out := make([]int16, 333*2)
+			for i := 0; i < 3; i++ {
+				plr.GenerateAudio(out)
+			}
+
+			c := &plr.channels[0]
+			dr := uint(int(plr.clockHz/float32(c.period))<<16) / plr.samplingFrequency
+			expected := (dr * 333 * 3) % (uint(tc.LoopLen) << 16)
+			if c.sample != 0 || c.samplePosition != expected {
+				t.Errorf("Expected sample position %d, got %d", expected, c.samplePosition)
+			}
+		})
+	}
+}
+
+func TestEffectGlobalVolumeSlide(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Notes   [][]string
+		Volumes []uint
+	}{
+		{"Slide down", [][]string{{"... .. .. W02"}}, []uint{64, 62, 60, 58, 56, 54}},
+		{"Slide up", [][]string{{"... .. .. V10"}, {"... .. .. W30"}}, []uint{16, 19, 22, 25, 28, 31}},
+		{"Slide up clamped", [][]string{{"... .. .. V3E"}, {"... .. .. W10"}}, []uint{62, 63, 64, 64, 64, 64}},
+		{"Slide down clamped", [][]string{{"... .. .. V02"}, {"... .. .. W01"}}, []uint{2, 1, 0, 0, 0, 0}},
+		{"Fine slide down", [][]string{{"... .. .. WF3"}}, []uint{61, 61, 61, 61, 61, 61}},
+		{"Fine slide up", [][]string{{"... .. .. V10"}, {"... .. .. W2F"}}, []uint{18, 18, 18, 18, 18, 18}},
+		{"Memory", [][]string{{"... .. .. W01"}, {"... .. .. W00"}}, []uint{59, 58, 57, 56, 55, 54}},
+	}
+	const speed = 6
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			plr := newPlayerWithTestPattern(tc.Notes, t)
+			plr.setSpeed(speed)
+
+			nrows := len(tc.Notes)
+			for i := -speed * (nrows - 1); i < speed; i++ {
+				plr.sequenceTick()
+				if i < 0 {
+					continue
+				}
+				if plr.globalVolume != tc.Volumes[i] {
+					t.Errorf("On tick %d expected global volume %d, got %d", i+speed*(nrows-1), tc.Volumes[i], plr.globalVolume)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderRange(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 .. ..."},
+		{"... .. .. D02"},
+		{"B-4  2 .. ..."},
+		{"... .. .. ..."},
+	}, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+		plr.Song.Samples[1].Data[i] = int8(i%30 - 15)
+	}
+	rowLen := plr.samplesPerTick * plr.Speed
+
+	// Render the first four rows through normal playback for comparison
+	ref, err := NewPlayer(plr.Song, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]int16, rowLen*4*2)
+	ref.GenerateAudio(expected)
+
+	out := make([]int16, rowLen*4*2)
+	n, err := plr.RenderRange(0, 1, 2, out)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if n != rowLen*2 {
+		t.Fatalf("Expected %d samples, got %d", rowLen*2, n)
+	}
+	if !slices.Equal(out[:n*2], expected[rowLen*2:rowLen*3*2]) {
+		t.Errorf("Rendered rows do not match normal playback")
+	}
+
+	// The player itself is untouched
+	if plr.row != 0 || plr.order != 0 || plr.tick != plr.Speed-1 {
+		t.Errorf("Expected the player position to be unchanged, got order %d row %d tick %d", plr.order, plr.row, plr.tick)
+	}
+
+	if _, err := plr.RenderRange(1, 0, 0, out); err == nil {
+		t.Errorf("Expected an error for an invalid order")
+	}
+	if _, err := plr.RenderRange(0, 3, 2, out); err == nil {
+		t.Errorf("Expected an error for an invalid row range")
+	}
+}
+
+func TestScratchPlayerSettings(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+	plr.SetVolumeBoost(2)
+	plr.SetMasterGain(0.5)
+	plr.SetClipping(ClipSoft)
+	plr.SetStereoSeparation(50)
+	plr.MuteChannel(0)
+	plr.SetClock(ClockNTSC)
+	plr.SetTempoMode(TempoModeModern)
+	plr.SetPeriodMode(PeriodAmiga)
+	plr.SetDeclick(DeclickRamp)
+	plr.SetInterpolation(InterpolationCubic)
+	plr.SetPanning(PanningBinaural)
+	plr.SetMixFormat(MixFloat32)
+	plr.SetMixWorkers(3)
+	plr.SetLoopPolicy(LoopPolicyFade, time.Second)
+	plr.SetLoopCount(2)
+	plr.SetSilenceStop(time.Second)
+	plr.SetTranspose(12)
+	plr.SetTempoScale(1.5)
+
+	s, err := plr.scratchPlayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := plr.opts
+	want.Mute = 1
+	if s.opts != want {
+		t.Errorf("Expected the settings\n%+v\ngot\n%+v", want, s.opts)
+	}
+	if s.pitchRatio != 2 || s.tempoScale != 1.5 || s.loopCount != 2 || s.silenceStop != time.Second {
+		t.Errorf("Expected the scratch player to use the settings")
+	}
+}
+
+func TestPeriodModeAmiga(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Note     string
+		FineTune int
+		Period   int
+	}{
+		{"C-1", "C-5  1 ...", 0, 856 * 4},
+		{"B-3", "B-7  1 ...", 0, 113 * 4},
+		{"Finetune 3", "C-5  1 ...", 3, 838 * 4},
+		{"Finetune -4", "A-6  1 ...", -4, 262 * 4},
+		{"Out of range", "A-4  1 ...", 0, periodA4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			plr := newPlayerWithMODTestPattern([][]string{{tc.Note}}, t)
+			plr.Song.Samples[0].FineTune = tc.FineTune
+			plr.SetPeriodMode(PeriodAmiga)
+			plr.sequenceTick()
+
+			if plr.channels[0].period != tc.Period {
+				t.Errorf("Expected period %d, got %d", tc.Period, plr.channels[0].period)
+			}
+		})
+	}
+}
+
+func TestEventCallbacks(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 .. ...", "... .. .. ..."},
+		{"... .. .. ...", "B-4  2 20 SD1"},
+		{"... .. 00 B00", "... .. .. ..."},
+	}, t)
+
+	var events []string
+	plr.OnOrderChange = func(order int) {
+		events = append(events, fmt.Sprintf("order %d", order))
+	}
+	plr.OnRow = func(order, row int) {
+		events = append(events, fmt.Sprintf("row %d:%d", order, row))
+	}
+	plr.OnNoteTrigger = func(e NoteEvent) {
+		events = append(events, fmt.Sprintf("note ch%d %d:%d.%d ins%d note%d vol%d", e.Channel, e.Order, e.Row, e.Tick, e.Instrument, e.Note, e.Volume))
+	}
+	plr.OnNoteStop = func(channel int) {
+		events = append(events, fmt.Sprintf("stop ch%d", channel))
+	}
+
+	for i := 0; i < plr.Speed*3; i++ {
+		plr.sequenceTick()
+	}
+
+	expected := []string{
+		"order 0",
+		"row 0:0",
+		"note ch0 0:0.0 ins0 note45 vol60",
+		"row 0:1",
+		"note ch1 0:1.1 ins1 note47 vol20",
+		"row 0:2",
+		"stop ch0",
+	}
+	if !slices.Equal(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func TestNoteWithoutInstrument(t *testing.T) {
+	// No instrument has played on the channel yet, so the note is silent
+	plr := newPlayerWithTestPattern([][]string{
+		{"C-4 .. .. ..."},
+		{"... .. .. ..."},
+	}, t)
+
+	var events []NoteEvent
+	plr.OnNoteTrigger = func(e NoteEvent) {
+		events = append(events, e)
+	}
+	stops := 0
+	plr.OnNoteStop = func(channel int) {
+		stops++
+	}
+
+	for i := 0; i < plr.Speed*2; i++ {
+		plr.sequenceTick()
+	}
+	if len(events) != 0 || stops != 0 {
+		t.Errorf("Expected no note events, got %v and %d stops", events, stops)
+	}
+}
+
+func TestNoteEventsS3M(t *testing.T) {
+	s3m, err := os.ReadFile("mods/caero.s3m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song, err := NewS3MSongFromBytes(s3m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr, err := NewPlayer(song, 11025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plr.SetLoopPolicy(LoopPolicyStop, 0)
+
+	// Every note reported plays a sample of the song
+	triggers := 0
+	plr.OnNoteTrigger = func(e NoteEvent) {
+		triggers++
+		if e.Instrument < 0 || e.Instrument >= len(song.Samples) {
+			t.Fatalf("Note on channel %d at %d:%d has instrument %d", e.Channel, e.Order, e.Row, e.Instrument)
+		}
+	}
+	buf := make([]int16, 4096)
+	for plr.IsPlaying() {
+		plr.GenerateAudio(buf)
+	}
+	if triggers == 0 {
+		t.Errorf("Expected notes to be reported")
+	}
+}
+
+func TestSamplesPlayed(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{""}
+	}
+	plr := newPlayerWithTestPattern(pattern, t)
+
+	var starts []int64
+	plr.OnRow = func(order, row int) {
+		starts = append(starts, plr.SamplesPlayed())
+	}
+
+	buf := make([]int16, 1000)
+	generated := 0
+	for len(starts) < 4 {
+		generated += plr.GenerateAudio(buf)
+	}
+	if got := plr.SamplesPlayed(); got != int64(generated) {
+		t.Errorf("Expected %d samples played, got %d", generated, got)
+	}
+
+	rowLen := int64(plr.samplesPerTick * plr.Speed)
+	for row, start := range starts {
+		if start != int64(row)*rowLen {
+			t.Errorf("Expected row %d to start at %d, got %d", row, int64(row)*rowLen, start)
+		}
+	}
+}
+
+func TestOnSongEnd(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{""}
+	}
+	pattern[0] = []string{"A-4  1 .. ..."}
+	plr := newPlayerWithTestPattern(pattern, t)
+
+	ended := 0
+	plr.OnSongEnd = func() { ended++ }
+
+	// Play every tick of the only pattern
+	for i := 0; i < plr.Speed*64; i++ {
+		plr.sequenceTick()
+	}
+	if ended != 0 {
+		t.Fatalf("Expected the song to still be playing")
+	}
+
+	if !plr.sequenceTick() {
+		t.Errorf("Expected sequenceTick to report the end of the song")
+	}
+	if ended != 1 {
+		t.Errorf("Expected OnSongEnd to be called once, got %d", ended)
+	}
+	if plr.IsPlaying() {
+		t.Errorf("Expected the player to have stopped")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	newPlayer := func(jumpRow int) *Player {
+		pattern := make([][]string, 64)
+		for i := range pattern {
+			pattern[i] = []string{""}
+		}
+		pattern[0] = []string{"A-4  1 .. ..."}
+		if jumpRow >= 0 {
+			pattern[jumpRow] = []string{"... .. .. B00"}
+		}
+		plr := newPlayerWithTestPattern(pattern, t)
+		plr.Song.Orders = []byte{0, 0, 0}
+		return plr
+	}
+	rowLen := int64(882 * 2)
+
+	t.Run("Whole song", func(t *testing.T) {
+		plr := newPlayer(-1)
+		d, err := plr.Duration()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Samples != rowLen*64*3 {
+			t.Errorf("Expected %d samples, got %d", rowLen*64*3, d.Samples)
+		}
+		if d.Total != time.Duration(d.Samples*int64(time.Second)/44100) {
+			t.Errorf("Duration %s does not match %d samples", d.Total, d.Samples)
+		}
+		expected := []time.Duration{0, plr.samplesToDuration(rowLen * 64), plr.samplesToDuration(rowLen * 128)}
+		if !slices.Equal(d.OrderStarts, expected) {
+			t.Errorf("Expected order start times %v, got %v", expected, d.OrderStarts)
+		}
+		if d.LoopStart != -1 {
+			t.Errorf("Expected the song to not loop, got loop start %d", d.LoopStart)
+		}
+
+		// Song.Duration matches a default player
+		sd, err := plr.Song.Duration(44100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sd.Samples != d.Samples {
+			t.Errorf("Expected Song.Duration to return %d samples, got %d", d.Samples, sd.Samples)
+		}
+	})
+
+	t.Run("Order limit", func(t *testing.T) {
+		plr := newPlayer(-1)
+		plr.PlayOrderLimit = 2
+		d, _ := plr.Duration()
+		if d.Samples != rowLen*64*2 {
+			t.Errorf("Expected %d samples, got %d", rowLen*64*2, d.Samples)
+		}
+		if d.OrderStarts[2] != -1 {
+			t.Errorf("Expected order 2 to not be played, got %s", d.OrderStarts[2])
+		}
+	})
+
+	t.Run("Looping song", func(t *testing.T) {
+		plr := newPlayer(3)
+		d, _ := plr.Duration()
+		if d.Samples != rowLen*4 {
+			t.Errorf("Expected %d samples, got %d", rowLen*4, d.Samples)
+		}
+		if d.LoopStart != 0 {
+			t.Errorf("Expected the loop to start at 0, got %d", d.LoopStart)
+		}
+	})
+
+	t.Run("Loop after intro", func(t *testing.T) {
+		plr := newPlayer(3)
+		plr.Song.patterns[0][3].Param = 1 // B01, the second order loops forever
+		d, _ := plr.Duration()
+		if d.Samples != rowLen*8 {
+			t.Errorf("Expected %d samples, got %d", rowLen*8, d.Samples)
+		}
+		if d.LoopStart != rowLen*4 {
+			t.Errorf("Expected the loop to start at %d, got %d", rowLen*4, d.LoopStart)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		plr := newPlayer(-1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := plr.DurationContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+
+	// The player is unaffected
+	plr := newPlayer(-1)
+	plr.Duration()
+	if plr.row != 0 || plr.order != 0 || plr.tick != plr.Speed-1 || plr.samplesPlayed != 0 {
+		t.Errorf("Expected the player to be unchanged")
+	}
+}
+
+func TestSetSilenceStop(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{"... .. .. ..."}
+	}
+	pattern[0] = []string{"A-4  1 .. ..."}
+	plr := newPlayerWithTestPattern(pattern, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = 64
+	}
+	plr.SetSilenceStop(100 * time.Millisecond)
+	ended := false
+	plr.OnSongEnd = func() { ended = true }
+
+	// The sample finishes early in the pattern and is followed by silence
+	total, lastSound := 0, 0
+	plr.PlayUntilDone(context.Background(), make([]int16, 1000), func(out []int16) error {
+		for i := 0; i < len(out); i += 2 {
+			if out[i] != 0 || out[i+1] != 0 {
+				lastSound = total + i/2 + 1
+			}
+		}
+		total += len(out) / 2
+		return nil
+	})
+	if !ended || lastSound == 0 || total < lastSound+4410 || total > lastSound+4410+882 {
+		t.Errorf("Expected the song to end 4410 samples after the sound at %d, ended after %d samples", lastSound, total)
+	}
+
+	// Disabled it plays to the end
+	plr.Restart()
+	plr.SetSilenceStop(0)
+	total = 0
+	plr.PlayUntilDone(context.Background(), make([]int16, 1000), func(out []int16) error {
+		total += len(out) / 2
+		return nil
+	})
+	if total != 882*2*64 {
+		t.Errorf("Expected %d samples, got %d", 882*2*64, total)
+	}
+}
+
+func TestPlayUntilDone(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{"... .. .. ..."}
+	}
+	rowLen := 882 * 2
+
+	plr := newPlayerWithTestPattern(pattern, t)
+	buf := make([]int16, 1000)
+	total := 0
+	err := plr.PlayUntilDone(context.Background(), buf, func(out []int16) error {
+		total += len(out) / 2
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != rowLen*64 {
+		t.Errorf("Expected %d samples, got %d", rowLen*64, total)
+	}
+	if plr.IsPlaying() {
+		t.Errorf("Expected the player to be stopped")
+	}
+
+	// Cancelling stops generation
+	plr.Restart()
+	ctx, cancel := context.WithCancel(context.Background())
+	total = 0
+	err = plr.PlayUntilDone(ctx, buf, func(out []int16) error {
+		total += len(out) / 2
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if total != len(buf)/2 {
+		t.Errorf("Expected %d samples, got %d", len(buf)/2, total)
+	}
+
+	// Errors from write are returned
+	plr.Restart()
+	writeErr := errors.New("write failed")
+	err = plr.PlayUntilDone(context.Background(), buf, func(out []int16) error {
+		return writeErr
+	})
+	if err != writeErr {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{"... .. .. ...", "... .. .. ..."}
+	}
+	pattern[0] = []string{"C-4  1 .. ...", "E-4  2 .. ..."}
+	pattern[32] = []string{"G-4  2 .. ...", "... .. .. ..."}
+	newPlayer := func() *Player {
+		plr := newPlayerWithTestPattern(pattern, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+			plr.Song.Samples[1].Data[i] = int8(i%30 - 15)
+		}
+		plr.Song.Samples[0].LoopLen = testSampleLength
+		plr.Song.Samples[1].LoopLen = testSampleLength
+		return plr
+	}
+
+	// The audio matches audio generated in small buffers
+	var want []int16
+	buf := make([]int16, 1000)
+	err := newPlayer().PlayUntilDone(context.Background(), buf, func(out []int16) error {
+		want = append(want, out...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plr := newPlayer()
+	var got []int16
+	blocks := 0
+	err = plr.RenderAll(context.Background(), func(out []int16) error {
+		got = append(got, out...)
+		blocks++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %d samples matching GenerateAudio, got %d", len(want)/2, len(got)/2)
+	}
+	if blocks != (len(want)+len(plr.mixbuffer)-1)/len(plr.mixbuffer) {
+		t.Errorf("Expected mix buffer sized blocks, got %d blocks", blocks)
+	}
+	if plr.IsPlaying() {
+		t.Errorf("Expected the player to be stopped")
+	}
+
+	// Errors from write stop rendering and are returned
+	plr.Restart()
+	writeErr := errors.New("write failed")
+	calls := 0
+	err = plr.RenderAll(context.Background(), func(out []int16) error {
+		calls++
+		return writeErr
+	})
+	if err != writeErr || calls != 1 {
+		t.Errorf("Expected the write error from 1 call, got %v from %d", err, calls)
+	}
+
+	// Cancelling stops rendering
+	plr.Restart()
+	ctx, cancel := context.WithCancel(context.Background())
+	err = plr.RenderAll(ctx, func(out []int16) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// An Encoder writes the same audio
+	var raw bytes.Buffer
+	enc := NewRawEncoder(&raw)
+	if err := newPlayer().RenderAll(context.Background(), enc.WriteFrames); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encoded := make([]int16, raw.Len()/2)
+	binary.Read(&raw, binary.LittleEndian, encoded)
+	if !slices.Equal(encoded, want) {
+		t.Errorf("Expected the raw encoder to write %d samples matching GenerateAudio, got %d", len(want)/2, len(encoded)/2)
+	}
+}
+
+func TestSeekToTime(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{""}
+	}
+	pattern[0] = []string{"A-4  1 .. ..."}
+	pattern[2] = []string{"... .. .. D04"}
+	pattern[4] = []string{"B-4  2 .. ..."}
+	plr := newPlayerWithTestPattern(pattern, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+		plr.Song.Samples[1].Data[i] = int8(i%30 - 15)
+	}
+	const rowLen = 882 * 2
+	const seekPos = rowLen*3 + 441 // part way through the first tick of row 3
+
+	ref, err := NewPlayer(plr.Song, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]int16, (seekPos+rowLen)*2)
+	ref.GenerateAudio(expected)
+
+	if err := plr.SeekToTime(seekPos * time.Second / 44100); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if plr.order != 0 || plr.row != 3 || plr.tick != 0 {
+		t.Errorf("Expected position 0:3.0, got %d:%d.%d", plr.order, plr.row, plr.tick)
+	}
+	if !plr.IsPlaying() {
+		t.Errorf("Expected the player to still be playing")
+	}
+
+	out := make([]int16, rowLen*2)
+	plr.GenerateAudio(out)
+	if !slices.Equal(out, expected[seekPos*2:]) {
+		t.Errorf("Audio after seeking does not match normal playback")
+	}
+
+	if err := plr.SeekToTime(time.Hour); err == nil {
+		t.Errorf("Expected an error seeking past the end of the song")
+	}
+	if plr.order != 0 || plr.row != 0 || !plr.IsPlaying() {
+		t.Errorf("Expected a failed seek to leave the player playing from 0:0, got %d:%d", plr.order, plr.row)
+	}
+}
+
+func TestFastForwardTo(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{""}
+	}
+	pattern[0] = []string{"A-4  1 .. ..."}
+	pattern[1] = []string{"... .. .. A04"}
+	pattern[2] = []string{"... .. .. D04"}
+	pattern[3] = []string{"... .. .. D00"}
+	pattern[10] = []string{"... .. .. C00"}
+	plr := newPlayerWithTestPattern(pattern, t)
+	plr.Song.Orders = []byte{0, 0}
+	plr.PlayOrderLimit = 1
+
+	if err := plr.FastForwardTo(1, 4); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if plr.order != 1 || plr.row != 4 || plr.tick != 0 {
+		t.Errorf("Expected position 1:4.0, got %d:%d.%d", plr.order, plr.row, plr.tick)
+	}
+	if plr.Speed != 4 {
+		t.Errorf("Expected speed 4, got %d", plr.Speed)
+	}
+	// The volume slide and its memory are replayed after the note
+	if plr.channels[0].volume != 60-4*3*2 {
+		t.Errorf("Expected volume %d, got %d", 60-4*3*2, plr.channels[0].volume)
+	}
+	if plr.PlayOrderLimit != 1 || plr.ordersplayed != 0 {
+		t.Errorf("Expected the order limit to count from the new position")
+	}
+
+	if err := plr.FastForwardTo(2, 0); err == nil {
+		t.Errorf("Expected an error for an invalid order")
+	}
+
+	// The break on row 10 skips the rest of the pattern
+	if err := plr.FastForwardTo(0, 20); err == nil {
+		t.Errorf("Expected an error for a row that is never played")
+	}
+	if plr.order != 0 || plr.row != 0 || plr.Speed != plr.Song.Speed {
+		t.Errorf("Expected a failed fast forward to reset the player, got %d:%d speed %d", plr.order, plr.row, plr.Speed)
+	}
+}
+
+func TestSetLoopCount(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{""}
+	}
+	pattern[0] = []string{"A-4  1 .. ..."}
+	pattern[1] = []string{"... .. .. A04"}
+
+	// Plays up to the last tick of the pattern, the first tick of the song
+	// has already been played if the song restarted.
+	const songTicks = 2 + 4*63
+	playSong := func(plr *Player, ticks int) bool {
+		finished := false
+		for i := 0; i < ticks && !finished; i++ {
+			finished = plr.sequenceTick()
+		}
+		return finished
+	}
+
+	plr := newPlayerWithTestPattern(pattern, t)
+	ended := 0
+	plr.OnSongEnd = func() { ended++ }
+	plr.SetLoopCount(1)
+
+	if playSong(plr, songTicks) {
+		t.Fatalf("Expected the song to not finish before the end of the pattern")
+	}
+	if plr.sequenceTick() {
+		t.Fatalf("Expected the song to restart")
+	}
+	if plr.order != 0 || plr.row != 0 || plr.tick != 0 || plr.Speed != 2 {
+		t.Errorf("Expected the song to restart from the beginning, got %d:%d.%d speed %d", plr.order, plr.row, plr.tick, plr.Speed)
+	}
+	if !plr.IsPlaying() || ended != 0 {
+		t.Errorf("Expected the song to be playing")
+	}
+	validateChan(&plr.channels[0], 0, periodA4, 60, t)
+
+	if playSong(plr, songTicks-1) {
+		t.Fatalf("Expected the song to not finish before the end of the pattern")
+	}
+	if !plr.sequenceTick() {
+		t.Errorf("Expected the song to finish after looping once")
+	}
+	if ended != 1 {
+		t.Errorf("Expected OnSongEnd to be called once, got %d", ended)
+	}
+
+	plr = newPlayerWithTestPattern(pattern, t)
+	plr.SetLoopCount(LoopForever)
+	for i := 0; i < 10; i++ {
+		if playSong(plr, songTicks) {
+			t.Fatalf("Expected the song to loop forever")
+		}
+	}
+}
+
+func TestLoopPolicy(t *testing.T) {
+	pattern := [][]string{
+		{"A-4  1 .. ..."},
+		{"... .. .. ..."},
+		{"... .. .. ..."},
+		{"... .. .. B00"},
+	}
+
+	t.Run("Loop", func(t *testing.T) {
+		plr := newPlayerWithTestPattern(pattern, t)
+		for i := 0; i < plr.Speed*20; i++ {
+			if plr.sequenceTick() {
+				t.Fatalf("Expected the song to keep looping")
+			}
+		}
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		plr := newPlayerWithTestPattern(pattern, t)
+		plr.SetLoopPolicy(LoopPolicyStop, 0)
+		ended := false
+		plr.OnSongEnd = func() { ended = true }
+
+		// Play the four rows
+		for i := 0; i < plr.Speed*4; i++ {
+			if plr.sequenceTick() {
+				t.Fatalf("Expected the song to play until it loops")
+			}
+		}
+		if !plr.sequenceTick() || !ended {
+			t.Errorf("Expected the song to stop when it looped")
+		}
+	})
+
+	t.Run("Fade", func(t *testing.T) {
+		plr := newPlayerWithTestPattern(pattern, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = 100
+		}
+		plr.Song.Samples[0].LoopLen = testSampleLength
+		plr.SetLoopPolicy(LoopPolicyFade, 10*time.Millisecond)
+
+		const rowLen = 882 * 2
+		const fadeLen = 441
+		out := make([]int16, (rowLen*4+fadeLen+100)*2)
+		n := plr.GenerateAudio(out)
+		if n != rowLen*4+fadeLen {
+			t.Errorf("Expected %d samples, got %d", rowLen*4+fadeLen, n)
+		}
+		if plr.IsPlaying() {
+			t.Errorf("Expected the player to stop after the fade")
+		}
+		start := rowLen * 4
+		if out[(start-1)*2] != out[start*2] {
+			t.Errorf("Expected the fade to start at full volume")
+		}
+		for i := start + 1; i < n; i++ {
+			if out[i*2] > out[(i-1)*2] {
+				t.Fatalf("Expected the volume to fall during the fade, sample %d is %d then %d", i, out[(i-1)*2], out[i*2])
+			}
+		}
+		if out[(n-1)*2] >= out[start*2]/10 {
+			t.Errorf("Expected the fade to end almost silent, got %d", out[(n-1)*2])
+		}
+	})
+}
+
+func TestFadeOut(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = 100
+	}
+	plr.SetLoopCount(LoopForever)
+	ended := false
+	plr.OnSongEnd = func() { ended = true }
+
+	out := make([]int16, 1000*2)
+	plr.GenerateAudio(out[:100*2])
+	plr.FadeOut(10 * time.Millisecond)
+
+	const fadeLen = 441
+	n := plr.GenerateAudio(out)
+	if n != fadeLen {
+		t.Errorf("Expected %d samples, got %d", fadeLen, n)
+	}
+	for i := 1; i < n; i++ {
+		if out[i*2] > out[(i-1)*2] {
+			t.Fatalf("Expected the volume to fall during the fade, sample %d is %d then %d", i, out[(i-1)*2], out[i*2])
+		}
+	}
+	if out[0] == 0 || out[(n-1)*2] >= out[0]/10 {
+		t.Errorf("Expected the fade to go from %d to almost silent, got %d", out[0], out[(n-1)*2])
+	}
+	if plr.IsPlaying() || !ended {
+		t.Errorf("Expected the player to stop after the fade")
+	}
+}
+
+func TestSetChannelPan(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 .. ...", "A-4  1 .. ..."},
+		{"... .. .. S82", "... .. .. S82"},
+	}, t)
+	plr.sequenceTick()
+
+	if err := plr.SetChannelPan(0, 100, false); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if err := plr.SetChannelPan(1, 110, true); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if plr.channels[0].pan != 100 || plr.channels[1].pan != 110 {
+		t.Errorf("Expected pan positions 100 and 110, got %d and %d", plr.channels[0].pan, plr.channels[1].pan)
+	}
+
+	// The pan effect only replaces the override that isn't sticky
+	advanceToNextRow(plr)
+	if plr.channels[0].pan != 0x10 || plr.channels[1].pan != 110 {
+		t.Errorf("Expected pan positions %d and 110, got %d and %d", 0x10, plr.channels[0].pan, plr.channels[1].pan)
+	}
+
+	if err := plr.ResetChannelPan(1); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if plr.channels[1].pan != 0x10 {
+		t.Errorf("Expected the song's pan position %d, got %d", 0x10, plr.channels[1].pan)
+	}
+
+	if err := plr.SetChannelPan(2, 0, false); err == nil {
+		t.Errorf("Expected an error for an invalid channel")
+	}
+	if err := plr.SetChannelPan(0, 128, false); err == nil {
+		t.Errorf("Expected an error for an invalid pan position")
+	}
+}
+
+func TestSetStereoSeparation(t *testing.T) {
+	cases := []struct {
+		Name       string
+		Separation int
+		Left       int16
+		Right      int16
+	}{
+		{"Full", 100, 0, 5900},
+		{"Half", 50, 1500, 4400},
+		{"Mono", 0, 2900, 2900},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+			for i := range plr.Song.Samples[0].Data {
+				plr.Song.Samples[0].Data[i] = 100
+			}
+			plr.SetChannelPan(0, 127, true)
+			if err := plr.SetStereoSeparation(tc.Separation); err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+
+			out := make([]int16, 2)
+			plr.GenerateAudio(out)
+			if out[0] != tc.Left || out[1] != tc.Right {
+				t.Errorf("Expected L/R %d/%d, got %d/%d", tc.Left, tc.Right, out[0], out[1])
+			}
+		})
+	}
+
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+	if err := plr.SetStereoSeparation(101); err == nil {
+		t.Errorf("Expected an error for an invalid separation")
+	}
+}
+
+func TestNotePeriodTables(t *testing.T) {
+	plr, err := newTestPlayerFromMod("mods/space_debris.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every sample's notes are looked up and match the computed periods,
+	// including notes and C4 speeds outside the tables
+	samples := append(slices.Clone(plr.Song.Samples), Sample{C4Speed: 12345})
+	for si := range samples {
+		sample := &samples[si]
+		if sample.C4Speed <= 0 {
+			continue
+		}
+		if si < len(plr.Song.Samples) && plr.periodTables[sample.C4Speed] == nil {
+			t.Errorf("Sample %d: no period table for C4 speed %d", si, sample.C4Speed)
+		}
+		for note := playerNote(0); note < periodTableNotes+12; note++ {
+			if got, want := plr.notePeriod(note, sample), periodFromPlayerNote(note, sample.C4Speed); got != want {
+				t.Fatalf("Sample %d note %d: expected period %d, got %d", si, note, want, got)
+			}
+		}
+	}
+}
+
+func TestPeriodSteps(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+
+	// The table matches the steps computed with floating point
+	for _, clock := range []AmigaClock{ClockPAL, ClockNTSC} {
+		for _, hz := range []uint{8000, 44100, 96000} {
+			plr.SetClock(clock)
+			if err := plr.SetSampleRate(hz); err != nil {
+				t.Fatal(err)
+			}
+			for period := minPeriod; period <= maxPeriod; period++ {
+				want := uint64(plr.clockHz/float32(period)) << 16 / uint64(hz)
+				if got := uint64(plr.periodSteps[period]); got != want {
+					t.Fatalf("Clock %v at %dHz period %d: expected step %d, got %d", clock, hz, period, want, got)
+				}
+			}
+		}
+	}
+
+	// Periods outside the table are clamped
+	plr.channels[0].period = maxPeriod + 100
+	if s := plr.sampleStep(&plr.channels[0]); s != uint(plr.periodSteps[maxPeriod]) {
+		t.Errorf("Expected the step of the longest period, got %d", s)
+	}
+	plr.channels[0].period = 0
+	if s := plr.sampleStep(&plr.channels[0]); s != uint(plr.periodSteps[minPeriod]) {
+		t.Errorf("Expected the step of the shortest period, got %d", s)
+	}
+}
+
+func TestSetPitchRatio(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+	plr.sequenceTick()
+	step := plr.sampleStep(&plr.channels[0])
+
+	plr.SetTranspose(12)
+	if s := plr.sampleStep(&plr.channels[0]); s < step*2-1 || s > step*2+1 {
+		t.Errorf("Expected an octave up to double the sample step %d, got %d", step, s)
+	}
+	plr.SetPitchRatio(0.5)
+	if s := plr.sampleStep(&plr.channels[0]); s < step/2-1 || s > step/2+1 {
+		t.Errorf("Expected a ratio of 0.5 to halve the sample step %d, got %d", step, s)
+	}
+
+	// The tempo is unchanged
+	if plr.samplesPerTick != 882 {
+		t.Errorf("Expected samples per tick to be unchanged, got %d", plr.samplesPerTick)
+	}
+
+	if err := plr.SetPitchRatio(0); err == nil {
+		t.Errorf("Expected an error for an invalid pitch ratio")
+	}
+}
+
+func TestSetTempoScale(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"... .. .. ..."},
+		{"... .. .. T40"},
+	}, t)
+	if err := plr.SetTempoScale(2); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if plr.samplesPerTick != 441 {
+		t.Errorf("Expected 441 samples per tick, got %d", plr.samplesPerTick)
+	}
+
+	// The scale is kept through tempo changes
+	plr.sequenceTick()
+	advanceToNextRow(plr)
+	if plr.samplesPerTick != 44100*5/2/(64*2) {
+		t.Errorf("Expected %d samples per tick, got %d", 44100*5/2/(64*2), plr.samplesPerTick)
+	}
+
+	plr.SetTempoMode(TempoModeModern)
+	plr.SetTempoScale(0.5)
+	if rowLen := plr.samplesPerTick * plr.Speed; rowLen < 44100*60*2/(64*4)-1 {
+		t.Errorf("Expected rows to last %d samples, got %d", 44100*60*2/(64*4), rowLen)
+	}
+
+	if err := plr.SetTempoScale(-1); err == nil {
+		t.Errorf("Expected an error for an invalid tempo scale")
+	}
+}
+
+func TestSetSampleRate(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"... .. .. ..."},
+		{"... .. .. T40"},
+	}, t)
+
+	// Change the rate half way through the first tick
+	plr.GenerateAudio(make([]int16, 441*2))
+	if err := plr.SetSampleRate(22050); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if plr.samplesPerTick != 441 || plr.tickSamplePos != 220 {
+		t.Errorf("Expected to be 220 samples into a 441 sample tick, got %d of %d", plr.tickSamplePos, plr.samplesPerTick)
+	}
+
+	// The second row starts 40ms into the song
+	plr.GenerateAudio(make([]int16, (221+441+1)*2))
+	history := plr.TempoHistory()
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 tempo changes, got %d", len(history))
+	}
+	if d := history[1].Time - 40*time.Millisecond; d < 0 || d > time.Millisecond {
+		t.Errorf("Expected the tempo change at 40ms, got %s", history[1].Time)
+	}
+	if history[1].Sample != 441+221+441 {
+		t.Errorf("Expected the tempo change at sample %d, got %d", 441+221+441, history[1].Sample)
+	}
+
+	if err := plr.SetSampleRate(0); err == nil {
+		t.Errorf("Expected an error for an invalid sample rate")
+	}
+}
+
+func TestStats(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 40 ...", "A-4  1 40 ..."}}, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = 127
+	}
+	plr.SetVolumeBoost(4)
+	plr.GenerateAudio(make([]int16, 100*2))
+
+	stats := plr.Stats()
+	if stats.Samples != 200 {
+		t.Errorf("Expected 200 samples, got %d", stats.Samples)
+	}
+	if stats.Clipped == 0 || stats.Peak <= 32767 || stats.Headroom() >= 0 {
+		t.Errorf("Expected the output to clip, got %+v", stats)
+	}
+
+	plr.ResetStats()
+	plr.SetVolumeBoost(1)
+	plr.GenerateAudio(make([]int16, 100*2))
+	stats = plr.Stats()
+	if stats.Clipped != 0 || stats.Peak == 0 || stats.Headroom() <= 0 {
+		t.Errorf("Expected the output to not clip, got %+v", stats)
+	}
+}
+
+func TestMuteChannel(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ...", "A-4  1 .. ..."}}, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = 64
+	}
+
+	for _, ci := range []int{0, 1} {
+		if err := plr.MuteChannel(ci); err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+	}
+	if err := plr.UnmuteChannel(0); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if plr.MuteMask() != 2 {
+		t.Errorf("Expected mute mask 2, got %d", plr.MuteMask())
+	}
+	for _, ci := range []int{-1, 2} {
+		if err := plr.MuteChannel(ci); err == nil {
+			t.Errorf("Expected an error muting channel %d", ci)
+		}
+	}
+
+	// Muting can happen while audio is being generated
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			plr.MuteChannel(i % 2)
+			plr.UnmuteChannel(i % 2)
+		}
+	}()
+	out := make([]int16, 100*2)
+	for i := 0; i < 10; i++ {
+		plr.GenerateAudio(out)
+	}
+	<-done
+
+	plr.SetMuteMask(3)
+	plr.GenerateAudio(out)
+	for i, s := range out {
+		if s != 0 {
+			t.Fatalf("Expected silence with all channels muted, got %d at sample %d", s, i)
+		}
+	}
+}
+
+func TestPlayNote(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"... .. .. V00"}}, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = 64
+	}
+
+	silent := func(out []int16) bool {
+		for _, s := range out {
+			if s != 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	// The song sets the global volume to 0, which doesn't affect the voice
+	if err := plr.PlayNote(0, 0, "C-5", 64); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	out := make([]int16, 100*2)
+	plr.GenerateAudio(out)
+	if silent(out) {
+		t.Errorf("Expected the note to play")
+	}
+	if d := int(out[0]) - int(out[1]); d < -100 || d > 100 {
+		t.Errorf("Expected the note to be centered, got %d and %d", out[0], out[1])
+	}
+
+	if err := plr.StopNote(0); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	plr.GenerateAudio(out)
+	if !silent(out) {
+		t.Errorf("Expected the note to stop")
+	}
+
+	for _, c := range []struct {
+		voice, sample int
+		note          string
+		volume        int
+	}{
+		{-1, 0, "C-5", 64},
+		{SFXVoices, 0, "C-5", 64},
+		{0, 2, "C-5", 64},
+		{0, 0, "H-5", 64},
+		{0, 0, "C-", 64},
+		{0, 0, "C-5", 65},
+	} {
+		if err := plr.PlayNote(c.voice, c.sample, c.note, c.volume); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+
+	// A request made for a song with more samples than the one playing
+	// when it is applied is dropped
+	plr.PlayNote(0, 0, "C-5", 64)
+	plr.Song.Samples = plr.Song.Samples[:0]
+	plr.GenerateAudio(out)
+	if !silent(out) {
+		t.Errorf("Expected the note for a missing sample to be dropped")
+	}
+}
+
+func TestSetMasterGain(t *testing.T) {
+	newPlayer := func() *Player {
+		plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+		}
+		return plr
+	}
+
+	unity := make([]int16, 100*2)
+	newPlayer().GenerateAudio(unity)
+
+	plr := newPlayer()
+	if err := plr.SetMasterGain(0.5); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	half := make([]int16, 100*2)
+	plr.GenerateAudio(half)
+	for i := range half {
+		if d := int(unity[i])/2 - int(half[i]); d < -1 || d > 1 {
+			t.Fatalf("Sample %d, expected %d, got %d", i, unity[i]/2, half[i])
+		}
+	}
+
+	for _, gain := range []float64{0, -1, math.Inf(1), math.NaN()} {
+		if err := plr.SetMasterGain(gain); err == nil {
+			t.Errorf("Expected an error for master gain %f", gain)
+		}
+	}
+}
+
+func TestGenerateAudioPlanar(t *testing.T) {
+	newPlayer := func() *Player {
+		plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ...", "B-4  2 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+			plr.Song.Samples[1].Data[i] = int8(i%30 - 15)
+		}
+		plr.SetChannelPan(0, 20, true)
+		return plr
+	}
+
+	interleaved := make([]int16, 1000*2)
+	if n := newPlayer().GenerateAudio(interleaved); n != 1000 {
+		t.Fatalf("Expected 1000 samples, got %d", n)
+	}
+
+	left := make([]int16, 1000)
+	right := make([]int16, 1200)
+	if n := newPlayer().GenerateAudioPlanar(left, right); n != 1000 {
+		t.Fatalf("Expected 1000 samples, got %d", n)
+	}
+	for i := range left {
+		if left[i] != interleaved[i*2] || right[i] != interleaved[i*2+1] {
+			t.Fatalf("Sample %d is %d/%d, expected %d/%d", i, left[i], right[i], interleaved[i*2], interleaved[i*2+1])
+		}
+	}
+
+	// Float samples are generated a render quantum at a time
+	plr := newPlayer()
+	leftF := make([]float32, RenderQuantum)
+	rightF := make([]float32, RenderQuantum)
+	for q := 0; q < 1000/RenderQuantum; q++ {
+		if n := plr.GenerateAudioFloat32(leftF, rightF); n != RenderQuantum {
+			t.Fatalf("Expected %d samples, got %d", RenderQuantum, n)
+		}
+		for i := range leftF {
+			j := q*RenderQuantum + i
+			if leftF[i] != float32(interleaved[j*2])/32768 || rightF[i] != float32(interleaved[j*2+1])/32768 {
+				t.Fatalf("Sample %d is %f/%f, expected %d/%d", j, leftF[i], rightF[i], interleaved[j*2], interleaved[j*2+1])
+			}
+		}
+	}
+}
+
+func TestStateSnapshot(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 .. ..."},
+		{"... .. .. C00"},
+	}, t)
+
+	state := plr.State()
+	if state.Order != 0 || state.Row != 0 || state.Channels[0].Instrument != -1 {
+		t.Errorf("Expected the initial state to be at 0:0 with no instrument, got %+v", state)
+	}
+
+	// The pattern break waits for the row to finish, the state keeps the row
+	// being played
+	plr.sequenceTick()
+	advanceToNextRow(plr)
+	state = plr.State()
+	if state.Order != 0 || state.Row != 1 || state.Channels[0].Instrument != 0 {
+		t.Errorf("Expected the state to be at 0:1 with instrument 0, got %+v", state)
+	}
+
+	// Callers can't modify the snapshot
+	state.Channels[0].Instrument = 5
+	if plr.State().Channels[0].Instrument != 0 {
+		t.Errorf("Expected the snapshot to be unaffected by changes to a returned state")
+	}
+
+	// State can be read while audio is generated, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			plr.State()
+			plr.IsPlaying()
+		}
+	}()
+	out := make([]int16, 256)
+	for i := 0; i < 100; i++ {
+		plr.GenerateAudio(out)
+	}
+	<-done
+}
+
+func TestChannelStateLive(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 32 ...", "... .. .. S82"},
+		{"... .. .. H44", ""},
+		{"... .. .. H44", ""},
+	}, t)
+
+	plr.sequenceTick()
+	ch := plr.State().Channels[0]
+	period := plr.channels[0].period
+	if ch.Period != period || ch.Frequency != int(plr.clockHz/float32(period)) {
+		t.Errorf("Expected period %d at %dHz, got %d at %dHz", period, int(plr.clockHz/float32(period)), ch.Period, ch.Frequency)
+	}
+	if ch.Volume != 32 || ch.SamplePosition != 0 {
+		t.Errorf("Expected volume 32 at sample position 0, got volume %d position %d", ch.Volume, ch.SamplePosition)
+	}
+	if pitch := 60 + 12*math.Log2(float64(ch.Frequency)/8363); math.Abs(ch.Pitch-pitch) > 0.01 {
+		t.Errorf("Expected pitch %.2f, got %.2f", pitch, ch.Pitch)
+	}
+	if pan := plr.State().Channels[1]; pan.Pan != 0x10 || pan.Frequency != 0 || pan.Pitch != 0 {
+		t.Errorf("Expected silent channel panned to 0x10, got %+v", pan)
+	}
+
+	// Plays the second tick of the row and starts the next one
+	out := make([]int16, 2000)
+	plr.GenerateAudio(out)
+	if state := plr.State(); state.Row != 1 || state.Channels[0].SamplePosition == 0 {
+		t.Errorf("Expected the sample position to have moved on row 1, got row %d position %d", state.Row, state.Channels[0].SamplePosition)
+	}
+
+	// Vibrato shows up in the period
+	advanceToNextRow(plr)
+	plr.sequenceTick()
+	ch = plr.State().Channels[0]
+	if ch.Effect != effectVibrato || ch.Period == period {
+		t.Errorf("Expected vibrato to change the period from %d, got effect %X period %d", period, ch.Effect, ch.Period)
+	}
+
+	// Transposing moves the pitch by whole semitones
+	pitch := plr.State().Channels[0].Pitch
+	if err := plr.SetTranspose(-12); err != nil {
+		t.Fatal(err)
+	}
+	plr.publishState(plr.order, plr.row, plr.tick)
+	if ch := plr.State().Channels[0]; math.Abs(ch.Pitch-(pitch-12)) > 0.001 {
+		t.Errorf("Expected transposing down an octave to give pitch %.2f, got %.2f", pitch-12, ch.Pitch)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 .. H44"},
+		{"... .. .. A04"},
+		{"C-5  1 .. ..."},
+	}, t)
+
+	first := make([]int16, 4000)
+	plr.GenerateAudio(first)
+	if plr.Speed != 4 {
+		t.Fatalf("Expected the song to have changed speed, got %d", plr.Speed)
+	}
+
+	// Playing again from the start gives the same audio
+	plr.Restart()
+	if !plr.IsPlaying() || plr.order != 0 || plr.row != 0 || plr.Speed != 2 {
+		t.Errorf("Expected to be playing from the start at speed 2, got %d:%d speed %d", plr.order, plr.row, plr.Speed)
+	}
+	if plr.channels[0].sample != -1 || plr.channels[0].vibratoDepth != 0 || plr.channels[0].effect != 0 {
+		t.Errorf("Expected clean channel state, got %+v", plr.channels[0])
+	}
+	second := make([]int16, 4000)
+	plr.GenerateAudio(second)
+	if !slices.Equal(first, second) {
+		t.Errorf("Expected the restarted song to sound the same")
+	}
+
+	plr.Reset()
+	if plr.IsPlaying() || plr.row != 0 {
+		t.Errorf("Expected Reset to stop the player at the start of the song")
+	}
+}
+
+func TestNewStoppedPlayer(t *testing.T) {
+	song := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t).Song
+	plr, err := NewStoppedPlayer(song, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plr.IsPlaying() {
+		t.Errorf("Expected the player to be stopped")
+	}
+	if n := plr.GenerateAudio(make([]int16, 256)); n != 0 {
+		t.Errorf("Expected no audio from a stopped player, got %d samples", n)
+	}
+}
+
+func TestStateTiming(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"... .. .. A04", "... .. .. T96", "... .. .. V20"},
+	}, t)
+
+	state := plr.State()
+	if state.Tick != 0 || state.Speed != 2 || state.Tempo != 125 || state.GlobalVolume != 64 {
+		t.Errorf("Expected tick 0, speed 2, tempo 125, global volume 64, got %+v", state)
+	}
+
+	plr.sequenceTick()
+	state = plr.State()
+	if state.Tick != 0 || state.Speed != 4 || state.Tempo != 150 || state.GlobalVolume != 32 {
+		t.Errorf("Expected tick 0, speed 4, tempo 150, global volume 32, got %+v", state)
+	}
+
+	plr.sequenceTick()
+	if state = plr.State(); state.Tick != 1 {
+		t.Errorf("Expected tick 1, got %d", state.Tick)
+	}
+}
+
+func TestChannelMeters(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 32 ...", "A-4  1 32 ..."},
+		{"... .. 00 ...", ""},
+	}, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = 64
+	}
+	plr.SetMuteMask(2)
+
+	// The meters are read at the start of each tick
+	out := make([]int16, plr.samplesPerTick*2)
+	const level = 64.0 * 32 / meterFullScale
+	for i, want := range []struct{ peak, rms float64 }{
+		{0, 0},
+		{level, level},
+		{level, level},
+		{level - float64(plr.samplesPerTick)*meterPeakFall/44100, 0}, // the volume was set to 0
+	} {
+		plr.GenerateAudio(out)
+		ch := plr.State().Channels
+		if math.Abs(ch[0].Peak-want.peak) > 1e-9 || math.Abs(ch[0].RMS-want.rms) > 1e-9 {
+			t.Errorf("Tick %d: expected peak %f and RMS %f, got %f and %f", i, want.peak, want.rms, ch[0].Peak, ch[0].RMS)
+		}
+		if ch[1].Peak != 0 || ch[1].RMS != 0 {
+			t.Errorf("Tick %d: expected the muted channel to be silent, got peak %f RMS %f", i, ch[1].Peak, ch[1].RMS)
+		}
+	}
+}
+
+func TestChannelScope(t *testing.T) {
+	newScopePlayer := func() *Player {
+		plr := newPlayerWithTestPattern([][]string{
+			{"A-4  1 32 ...", "C-5  1 .. ..."},
+		}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i)
+		}
+		plr.SetMuteMask(2)
+		return plr
+	}
+
+	plr := newScopePlayer()
+	if n := plr.ChannelScope(0, make([]int16, 10)); n != 0 {
+		t.Errorf("Expected no scope data when disabled, got %d samples", n)
+	}
+	if err := plr.SetScopeLength(-1); err == nil {
+		t.Errorf("Expected an error for a negative scope length")
+	}
+	if err := plr.SetScopeLength(100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording the scopes doesn't change the audio
+	out := make([]int16, 300*2)
+	plr.GenerateAudio(out)
+	expected := make([]int16, 300*2)
+	newScopePlayer().GenerateAudio(expected)
+	if !slices.Equal(out, expected) {
+		t.Errorf("Expected the same audio with scopes enabled")
+	}
+
+	// Channel 1 is the only one playing, so its scope is the mono output
+	scope := make([]int16, 200)
+	if n := plr.ChannelScope(0, scope); n != 100 {
+		t.Fatalf("Expected 100 samples, got %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		l, r := int(out[(200+i)*2]), int(out[(200+i)*2+1])
+		if int(scope[i]) != (l+r)/2 {
+			t.Fatalf("Sample %d: expected %d, got %d", i, (l+r)/2, scope[i])
+		}
+	}
+
+	// The muted channel is silent
+	if n := plr.ChannelScope(1, scope); n != 100 || slices.ContainsFunc(scope[:n], func(s int16) bool { return s != 0 }) {
+		t.Errorf("Expected a silent scope for the muted channel")
+	}
+	if n := plr.ChannelScope(2, scope); n != 0 {
+		t.Errorf("Expected no scope data for an invalid channel, got %d samples", n)
+	}
+
+	// The scopes can be read while they are replaced and audio is generated
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]int16, 100)
+		for i := 0; i < 100; i++ {
+			plr.ChannelScope(i%2, buf)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		plr.SetScopeLength([]int{0, 50, 100}[i%3])
+		plr.GenerateAudio(out[:20])
+	}
+	<-done
+}
+
+func TestSubscribe(t *testing.T) {
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = []string{"", ""}
+	}
+	pattern[0] = []string{"A-4  1 .. ...", ""}
+	pattern[1] = []string{"", "... .. .. A04"}
+	plr := newPlayerWithTestPattern(pattern, t)
+
+	events := plr.Subscribe(16)
+	full := plr.Subscribe(1)
+
+	// Seeking doesn't send events
+	if err := plr.FastForwardTo(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no events while seeking, got %d", len(events))
+	}
+	plr.Restart()
+
+	const rowLen = 1764
+	out := make([]int16, (rowLen+1)*2)
+	plr.GenerateAudio(out)
+
+	want := []Event{
+		{Sample: 0, Order: 0, Row: 0, Channel: 0},
+		{Sample: rowLen, Order: 0, Row: 1, Channel: 1},
+	}
+	for i, w := range want {
+		ev := <-events
+		if ev.Sample != w.Sample || ev.Order != w.Order || ev.Row != w.Row || ev.Channel != w.Channel {
+			t.Errorf("Event %d: expected %+v, got %+v", i, w, ev)
+		}
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no more events, got %d", len(events))
+	}
+	if ev := <-full; ev.Row != 0 || ev.Instrument != 1 || len(full) != 0 {
+		t.Errorf("Expected the full subscriber to only get the first event, got %+v and %d more", ev, len(full))
+	}
+
+	plr.Unsubscribe(events)
+	if _, ok := <-events; ok {
+		t.Errorf("Expected Unsubscribe to close the channel")
+	}
+	plr.Unsubscribe(full)
+}
+
+func TestNewPlayerWithOptions(t *testing.T) {
+	song := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t).Song
+
+	// The defaults match NewPlayer
+	plr, err := NewPlayerWithOptions(song, 44100, DefaultPlayerOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plr.IsPlaying() || plr.volBoost != 1 || plr.separation != 100 || plr.PlayOrderLimit != -1 || plr.clock != song.Clock || len(plr.mixbuffer) != mixBufferLen*2 {
+		t.Errorf("Expected the default settings")
+	}
+
+	opts := DefaultPlayerOptions()
+	opts.Stopped = true
+	opts.VolumeBoost = 2
+	opts.StereoSeparation = 50
+	opts.Mute = 1
+	opts.Clock = ClockNTSC
+	opts.TempoMode = TempoModeModern
+	opts.LoopPolicy = LoopPolicyFade
+	opts.LoopFade = time.Second
+	opts.LoopCount = 2
+	opts.MixBufferSize = 100
+	plr, err = NewPlayerWithOptions(song, 44100, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plr.IsPlaying() || plr.volBoost != 2 || plr.separation != 50 || plr.MuteMask() != 1 || plr.clock != ClockNTSC {
+		t.Errorf("Expected the player settings to match the options")
+	}
+	if plr.tempoMode != TempoModeModern || plr.loopPolicy != LoopPolicyFade || plr.loopFade != time.Second || plr.loopCount != 2 {
+		t.Errorf("Expected the playback settings to match the options")
+	}
+	if len(plr.mixbuffer) != 200 || plr.MixBufferSize() != 100 {
+		t.Errorf("Expected a mix buffer of 100 stereo samples, got %d", len(plr.mixbuffer)/2)
+	}
+
+	for _, bad := range []func(*PlayerOptions){
+		func(o *PlayerOptions) { o.VolumeBoost = 0 },
+		func(o *PlayerOptions) { o.StereoSeparation = 101 },
+		func(o *PlayerOptions) { o.MixBufferSize = 0 },
+	} {
+		opts := DefaultPlayerOptions()
+		bad(&opts)
+		if _, err := NewPlayerWithOptions(song, 44100, opts); err == nil {
+			t.Errorf("Expected an error for invalid options %+v", opts)
+		}
+	}
+}
+
+func TestCrossfader(t *testing.T) {
+	newPlayer := func() *Player {
+		plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+		}
+		return plr
+	}
+
+	rate := newPlayer().SampleRate()
+	if _, err := NewCrossfader(newPlayer(), rate+1); err == nil {
+		t.Errorf("Expected an error for a mismatched sample rate")
+	}
+
+	ref := make([]int16, 1000*2)
+	newPlayer().GenerateAudio(ref)
+
+	// Fade out to silence over 10ms, generated in chunks that straddle the
+	// end of the fade
+	cf, err := NewCrossfader(newPlayer(), rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.FadeTo(nil, 10*time.Millisecond, FadeLinear); err != nil {
+		t.Fatal(err)
+	}
+	fadeLen := int(rate / 100)
+	out := make([]int16, 1000*2)
+	total := 0
+	for total < len(out)/2 {
+		n := cf.GenerateAudio(out[total*2 : min(total*2+300*2, len(out))])
+		if total < fadeLen && n == 0 {
+			t.Fatalf("Expected audio during the fade at sample %d", total)
+		}
+		total += 300
+	}
+	for i := 0; i < len(ref); i++ {
+		expected := 0.0
+		if i/2 < fadeLen {
+			expected = float64(ref[i]) * (1 - float64(i/2)/float64(fadeLen))
+		}
+		if math.Abs(float64(out[i])-expected) > 1 {
+			t.Fatalf("Sample %d is %d, expected %.1f", i, out[i], expected)
+		}
+	}
+	if cf.Fading() || cf.Current() != nil {
+		t.Errorf("Expected the fade to have finished on silence")
+	}
+	if n := cf.GenerateAudio(out); n != 0 {
+		t.Errorf("Expected no samples after fading out, got %d", n)
+	}
+
+	// Fade in from silence, the player plays unchanged afterwards
+	plr := newPlayer()
+	cf, _ = NewCrossfader(nil, rate)
+	if err := cf.FadeTo(plr, 10*time.Millisecond, FadeEqualPower); err != nil {
+		t.Fatal(err)
+	}
+	if !cf.Fading() || cf.Current() != plr {
+		t.Errorf("Expected to be fading in the player")
+	}
+	if n := cf.GenerateAudio(out); n != 1000 {
+		t.Errorf("Expected 1000 samples, got %d", n)
+	}
+	if out[0] != 0 || !slices.Equal(out[fadeLen*2:], ref[fadeLen*2:]) {
+		t.Errorf("Expected the player to fade in from silence")
+	}
+	if cf.Fading() || cf.Current() != plr {
+		t.Errorf("Expected the fade to have finished on the player")
+	}
+
+	if err := cf.FadeTo(nil, -time.Second, FadeLinear); err == nil {
+		t.Errorf("Expected an error for a negative duration")
+	}
+}
+
+func TestStep(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{
+		{"A-4  1 .. ..."},
+		{"... .. .. ..."},
+		{"B-4  1 .. SD1"},
+		{"... .. .. C00"},
+	}, t)
+	plr.SetLoopPolicy(LoopPolicyStop, 0)
+
+	var ticks []TickEvent
+	for {
+		te, ok := plr.Step()
+		if !ok {
+			break
+		}
+		ticks = append(ticks, te)
+	}
+
+	if len(ticks) != plr.Speed*4 {
+		t.Fatalf("Expected %d ticks, got %d", plr.Speed*4, len(ticks))
+	}
+	tickLen := plr.samplesToDuration(int64(plr.samplesPerTick))
+	for i, te := range ticks {
+		if te.Row != i/plr.Speed || te.Tick != i%plr.Speed || te.Time != time.Duration(i)*tickLen {
+			t.Errorf("Tick %d is row %d tick %d at %v", i, te.Row, te.Tick, te.Time)
+		}
+
+		var expected []int
+		switch i {
+		case 0:
+			expected = []int{int(periodNote(periodA4, 8363))}
+		case plr.Speed*2 + 1:
+			expected = []int{int(periodNote(periodB4, 8363))}
+		}
+		var notes []int
+		for _, e := range te.Notes {
+			notes = append(notes, e.Note)
+		}
+		if !slices.Equal(notes, expected) {
+			t.Errorf("Tick %d triggered notes %v, expected %v", i, notes, expected)
+		}
+	}
+
+	if plr.IsPlaying() {
+		t.Errorf("Expected the song to have ended")
+	}
+	if plr.samplesPlayed != int64(plr.samplesPerTick*len(ticks)) {
+		t.Errorf("Expected the skipped ticks to count as played, got %d samples", plr.samplesPlayed)
+	}
+
+	// A note before any instrument is silent and isn't reported
+	plr = newPlayerWithTestPattern([][]string{{"C-4 .. .. ..."}, {""}}, t)
+	if te, ok := plr.Step(); !ok || len(te.Notes) != 0 {
+		t.Errorf("Expected a tick without notes, got %v, %v", te.Notes, ok)
+	}
+
+	// Stepping through a whole song reports only notes that play a sample
+	s3m, err := os.ReadFile("mods/caero.s3m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song, err := NewS3MSongFromBytes(s3m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plr, err = NewPlayer(song, 44100); err != nil {
+		t.Fatal(err)
+	}
+	plr.SetLoopPolicy(LoopPolicyStop, 0)
+	for {
+		te, ok := plr.Step()
+		if !ok {
+			break
+		}
+		for _, e := range te.Notes {
+			if e.Instrument < 0 {
+				t.Fatalf("Note on channel %d at %d:%d has no instrument", e.Channel, e.Order, e.Row)
+			}
+		}
+	}
+}
+
+func TestMixStereo(t *testing.T) {
+	data := make([]int8, 1000)
+	for i := range data {
+		data[i] = int8(i*37 + i*i)
+	}
+
+	// Every backend mixes the same as the generic mixer
+	for _, backend := range MixerBackends() {
+		mixStereo := backendMixer(backend)
+		for _, n := range []int{0, 1, 3, 4, 7, 8, 9, 17, 100} {
+			for _, dr := range []uint{1 << 16, 1<<16 + 12345, 3 << 15, 1 << 14} {
+				for _, vols := range [][2]int{{0, 254}, {127, 127}, {254, 3}, {300, 1000}} {
+					want := make([]int, n*2)
+					got := make([]int, n*2)
+					for i := range want {
+						want[i] = i * 1000
+						got[i] = i * 1000
+					}
+					wantSum, wantPeak := 5, 7
+					gotSum, gotPeak := 5, 7
+
+					wantPos := mixStereoGeneric(want, data, 12345, dr, vols[0], vols[1], &wantSum, &wantPeak)
+					gotPos := mixStereo(got, data, 12345, dr, vols[0], vols[1], &gotSum, &gotPeak)
+					if gotPos != wantPos || gotSum != wantSum || gotPeak != wantPeak || !slices.Equal(got, want) {
+						t.Errorf("%v %d frames step %X volumes %v: got position %X meters %d/%d, expected %X %d/%d", backend, n, dr, vols, gotPos, gotSum, gotPeak, wantPos, wantSum, wantPeak)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSetMixerBackend(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+	backends := MixerBackends()
+	if plr.MixerBackend() != backends[len(backends)-1] {
+		t.Errorf("Expected the fastest backend %v, got %v", backends[len(backends)-1], plr.MixerBackend())
+	}
+
+	for _, b := range backends {
+		if err := plr.SetMixerBackend(b); err != nil || plr.MixerBackend() != b {
+			t.Errorf("Expected backend %v, got %v error %v", b, plr.MixerBackend(), err)
+		}
+	}
+	if err := plr.SetMixerBackend(MixerBackend(99)); err == nil {
+		t.Errorf("Expected an error for an unsupported backend")
+	}
+	if MixerGeneric.String() != "generic" || MixerAVX2.String() != "avx2" {
+		t.Errorf("Unexpected backend names %v %v", MixerGeneric, MixerAVX2)
+	}
+}
+
+func TestGenerateAudio32(t *testing.T) {
+	newPlayer := func() *Player {
+		plr := newPlayerWithTestPattern([][]string{{"C-4  1 .. ...", "C-4  1 .. ...", "C-4  2 .. ...", "C-4  2 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50*5 - 125)
+			plr.Song.Samples[1].Data[i] = int8(i%50*5 - 125)
+		}
+		if err := plr.SetVolumeBoost(4); err != nil {
+			t.Fatal(err)
+		}
+		plr.SetStereoSeparation(0)
+		return plr
+	}
+
+	out16 := make([]int16, 1000*2)
+	newPlayer().GenerateAudio(out16)
+	plr := newPlayer()
+	out32 := make([]int32, 1000*2)
+	if n := plr.GenerateAudio32(out32); n != 1000 {
+		t.Fatalf("Expected 1000 samples, got %d", n)
+	}
+
+	// The samples match the 16-bit output until it clamps
+	over := 0
+	for i, s := range out32 {
+		if s > math.MaxInt16 || s < math.MinInt16 {
+			over++
+			if int32(out16[i]) != clamp(s, math.MinInt16, math.MaxInt16) {
+				t.Fatalf("Sample %d is %d, expected the 16-bit output %d to be clamped", i, s, out16[i])
+			}
+		} else if int32(out16[i]) != s {
+			t.Fatalf("Sample %d is %d, expected %d", i, s, out16[i])
+		}
+	}
+	if over == 0 {
+		t.Fatalf("Expected samples beyond 16 bits")
+	}
+	if stats := plr.Stats(); stats.Samples != 2000 || stats.Clipped != 0 || stats.Peak <= math.MaxInt16 {
+		t.Errorf("Expected stats for 2000 unclipped samples peaking over full scale, got %+v", stats)
+	}
+}
+
+func TestMixFormat(t *testing.T) {
+	newPlayer := func(f MixFormat) *Player {
+		plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ...", "B-4  2 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+			plr.Song.Samples[1].Data[i] = int8(i%30 - 15)
+		}
+		plr.SetMixFormat(f)
+		if err := plr.SetMasterGain(0.3); err != nil {
+			t.Fatal(err)
+		}
+		return plr
+	}
+
+	// Dither moves 16-bit samples by at most one step, and the integer gain
+	// rounds down rather than to the nearest step
+	intOut := make([]int16, 1000*2)
+	floatOut := make([]int16, 1000*2)
+	newPlayer(MixInt).GenerateAudio(intOut)
+	plr := newPlayer(MixFloat32)
+	plr.GenerateAudio(floatOut)
+	dithered := 0
+	for i := range intOut {
+		if d := int(floatOut[i]) - int(intOut[i]); d < -1 || d > 2 {
+			t.Fatalf("Sample %d is %d, expected %d-1 to %d+2", i, floatOut[i], intOut[i], intOut[i])
+		} else if d != 0 {
+			dithered++
+		}
+	}
+	if dithered == 0 {
+		t.Errorf("Expected dither to change some samples")
+	}
+	if stats := plr.Stats(); stats.Samples != 2000 || stats.Clipped != 0 || stats.Peak == 0 {
+		t.Errorf("Expected stats for 2000 unclipped samples, got %+v", stats)
+	}
+
+	// Float samples keep the precision of the gain
+	left := make([]float32, 1000)
+	right := make([]float32, 1000)
+	newPlayer(MixFloat32).GenerateAudioFloat32(left, right)
+	fractional := 0
+	for i := range left {
+		if d := left[i]*32768 - float32(intOut[i*2]); d < -1 || d > 1 {
+			t.Fatalf("Sample %d is %f, expected about %d", i, left[i]*32768, intOut[i*2])
+		}
+		if left[i]*32768 != float32(math.Round(float64(left[i]*32768))) {
+			fractional++
+		}
+	}
+	if fractional == 0 {
+		t.Errorf("Expected float samples between 16-bit steps")
+	}
+}
+
+func TestSoftClip(t *testing.T) {
+	plr := newPlayerWithTestPattern([][]string{{"A-4  1 .. ..."}}, t)
+	samples := []int{0, 1000, -24576, 24577, 32767, -40000, 100000, -1000000}
+	clipped := slices.Clone(samples)
+
+	plr.applySoftClip(clipped)
+	if !slices.Equal(clipped, samples) {
+		t.Errorf("Expected ClipHard to leave samples unchanged, got %v", clipped)
+	}
+
+	plr.SetClipping(ClipSoft)
+	plr.applySoftClip(clipped)
+	if !slices.Equal(clipped[:3], samples[:3]) {
+		t.Errorf("Expected samples below the knee to be unchanged, got %v", clipped[:3])
+	}
+	for i := 3; i < len(clipped); i++ {
+		if a := max(clipped[i], -clipped[i]); a <= 24576 || a > 32768 || a > max(samples[i], -samples[i]) {
+			t.Errorf("Expected %d to be saturated between the knee and full scale, got %d", samples[i], clipped[i])
+		}
+		if (clipped[i] < 0) != (samples[i] < 0) {
+			t.Errorf("Expected %d to keep its sign, got %d", samples[i], clipped[i])
+		}
+	}
+	if clipped[3] != 24577 || clipped[6] <= clipped[4] {
+		t.Errorf("Expected a smooth, rising curve, got %v", clipped)
+	}
+
+	// Float samples follow the same curve
+	plr.SetMixFormat(MixFloat32)
+	copy(plr.mixbuffer, samples)
+	for i, s := range plr.floatMix(len(samples)) {
+		if d := float64(s)*32768 - float64(clipped[i]); d < -1 || d > 1 {
+			t.Errorf("Sample %d is %f, expected %d", i, float64(s)*32768, clipped[i])
+		}
+	}
+}
+
+func TestMixWorkers(t *testing.T) {
+	newPlayer := func(workers int) *Player {
+		notes := []string{"C-4  1 .. ...", "E-4  2 .. ...", "G-4  1 32 ...", "B-4  2 .. ..."}
+		row := make([]string, 20)
+		for ci := range row {
+			row[ci] = notes[ci%len(notes)]
+		}
+		plr := newPlayerWithTestPattern([][]string{row}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+			plr.Song.Samples[1].Data[i] = int8(i%30 - 15)
+		}
+		plr.SetMixWorkers(workers)
+		return plr
+	}
+
+	single := newPlayer(1)
+	if w := single.channelWorkers(); w != 1 {
+		t.Fatalf("Expected 1 worker, got %d", w)
+	}
+	parallel := newPlayer(4)
+	if w := parallel.channelWorkers(); w != 4 {
+		t.Fatalf("Expected 4 workers, got %d", w)
+	}
+
+	// Generate in uneven amounts so that mixing starts part way into the
+	// buffer
+	for _, n := range []int{100, 333, 1000} {
+		want := make([]int16, n*2)
+		got := make([]int16, n*2)
+		single.GenerateAudio(want)
+		parallel.GenerateAudio(got)
+		if !slices.Equal(got, want) {
+			t.Fatalf("Parallel mix differs from the single goroutine mix")
+		}
+	}
+	if !slices.Equal(parallel.meters, single.meters) {
+		t.Errorf("Expected meters %+v, got %+v", single.meters, parallel.meters)
+	}
+
+	// Few channels are mixed on one goroutine
+	plr := newPlayerWithTestPattern([][]string{{"C-4  1 .. ...", "E-4  2 .. ..."}}, t)
+	plr.SetMixWorkers(4)
+	if w := plr.channelWorkers(); w != 1 {
+		t.Errorf("Expected 1 worker for 2 channels, got %d", w)
+	}
+}
+
+func TestWidenSamples(t *testing.T) {
+	s3m, err := os.ReadFile("mods/caero.s3m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func(interp Interpolation, widen bool) []int16 {
+		song, err := NewS3MSongFromBytes(s3m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if widen {
+			song.WidenSamples()
+			for i, sample := range song.Samples {
+				if len(sample.Data16) != len(sample.Data) {
+					t.Fatalf("Sample %d has %d widened frames, expected %d", i, len(sample.Data16), len(sample.Data))
+				}
+			}
+		}
+		opts := DefaultPlayerOptions()
+		opts.Interpolation = interp
+		plr, err := NewPlayerWithOptions(song, 44100, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []int16
+		buf := make([]int16, 4096*2)
+		for i := 0; i < 20; i++ {
+			n := plr.GenerateAudio(buf)
+			out = append(out, buf[:n*2]...)
+		}
+		return out
+	}
+
+	// Widened samples sound the same in every interpolation mode
+	for _, interp := range []Interpolation{InterpolationNone, InterpolationLinear, InterpolationCubic, InterpolationSinc} {
+		if !slices.Equal(render(interp, true), render(interp, false)) {
+			t.Errorf("Interpolation %d: widened samples change the audio", interp)
+		}
+	}
+}
+
+func TestEffectChain(t *testing.T) {
+	double := EffectFunc(func(in, out []int16) {
+		for i, s := range in {
+			out[i] = s * 2
+		}
+	})
+	addOne := EffectFunc(func(in, out []int16) {
+		for i, s := range in {
+			out[i] = s + 1
+		}
+	})
+
+	// The effects run in order and an empty chain passes the audio through
+	in := []int16{1, 2, 3, 4}
+	out := make([]int16, len(in))
+	chain := NewChain()
+	chain.Process(in, out)
+	if !slices.Equal(out, in) {
+		t.Errorf("Expected an empty chain to pass through %v, got %v", in, out)
+	}
+	chain.Add(double)
+	chain.Add(addOne)
+	chain.Process(in, out)
+	if want := []int16{3, 5, 7, 9}; !slices.Equal(out, want) || chain.Len() != 2 {
+		t.Errorf("Expected %v from 2 effects, got %v from %d", want, out, chain.Len())
+	}
+
+	// The player's output passes through the effect, whichever format it is
+	// generated in
+	newPlayer := func(e Effect) *Player {
+		plr := newPlayerWithTestPattern([][]string{{"C-4  1 .. ..."}}, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(i%50 - 25)
+		}
+		plr.SetEffect(e)
+		return plr
+	}
+	const frames = 1000
+	dry := make([]int16, frames*2)
+	newPlayer(nil).GenerateAudio(dry)
+	formats := map[string]func(e Effect) []int16{
+		"GenerateAudio": func(e Effect) []int16 {
+			out := make([]int16, frames*2)
+			newPlayer(e).GenerateAudio(out)
+			return out
+		},
+		"GenerateAudioPlanar": func(e Effect) []int16 {
+			left, right := make([]int16, frames), make([]int16, frames)
+			newPlayer(e).GenerateAudioPlanar(left, right)
+			out := make([]int16, 0, frames*2)
+			for i := range left {
+				out = append(out, left[i], right[i])
+			}
+			return out
+		},
+		"GenerateAudio32": func(e Effect) []int16 {
+			out32 := make([]int32, frames*2)
+			newPlayer(e).GenerateAudio32(out32)
+			out := make([]int16, len(out32))
+			for i, s := range out32 {
+				out[i] = int16(s)
+			}
+			return out
+		},
+		"GenerateAudioFloat32": func(e Effect) []int16 {
+			left, right := make([]float32, frames), make([]float32, frames)
+			newPlayer(e).GenerateAudioFloat32(left, right)
+			out := make([]int16, 0, frames*2)
+			for i := range left {
+				out = append(out, int16(left[i]*32768), int16(right[i]*32768))
+			}
+			return out
+		},
+	}
+	for name, render := range formats {
+		wet := render(NewChain(double, addOne))
+		for i := range dry {
+			if wet[i] != dry[i]*2+1 {
+				t.Fatalf("%s: sample %d is %d, expected %d", name, i, wet[i], dry[i]*2+1)
+			}
+		}
+	}
+}
+
+func TestBassBoost(t *testing.T) {
+	sine := func(hz float64) []int16 {
+		out := make([]int16, 44100*2)
+		for i := range out {
+			out[i] = int16(3000 * math.Sin(2*math.Pi*hz*float64(i/2)/44100))
+		}
+		return out
+	}
+	rms := func(s []int16) float64 {
+		sum := 0.0
+		for _, v := range s[len(s)/2:] { // skip the filter settling
+			sum += float64(v) * float64(v)
+		}
+		return math.Sqrt(sum / float64(len(s)/2))
+	}
+	// Level of hz in s, from the Goertzel algorithm
+	level := func(s []int16, hz float64) float64 {
+		k := 2 * math.Cos(2*math.Pi*hz/44100)
+		var s1, s2 float64
+		for i := 0; i < len(s); i += 2 {
+			s1, s2 = float64(s[i])+k*s1-s2, s1
+		}
+		return math.Sqrt(s1*s1 + s2*s2 - k*s1*s2)
+	}
+
+	// Low frequencies are boosted by the gain, high ones are unchanged
+	for _, tc := range []struct {
+		hz, ratio float64
+	}{{40, 4}, {8000, 1}} {
+		b, err := NewBassBoost(44100, 150, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := sine(tc.hz)
+		out := make([]int16, len(in))
+		b.Process(in, out)
+		if r := rms(out) / rms(in); r < tc.ratio*0.9 || r > tc.ratio*1.1 {
+			t.Errorf("%gHz: expected the level to change by %g, got %g", tc.hz, tc.ratio, r)
+		}
+	}
+
+	// The exciter adds the third harmonic of the bass
+	plain, _ := NewBassBoost(44100, 150, 6)
+	excited, _ := NewBassBoost(44100, 150, 6)
+	if err := excited.SetExciter(1); err != nil {
+		t.Fatal(err)
+	}
+	in := sine(60)
+	out := make([]int16, len(in))
+	plain.Process(in, out)
+	before := level(out, 180)
+	excited.Process(in, out)
+	if after := level(out, 180); after < before*2 {
+		t.Errorf("Expected the exciter to add a harmonic at 180Hz, level went from %g to %g", before, after)
+	}
+
+	if _, err := NewBassBoost(44100, 30000, 6); err == nil {
+		t.Errorf("Expected an error for a cutoff above the Nyquist frequency")
+	}
+	if _, err := NewBassBoost(44100, 150, 30); err == nil {
+		t.Errorf("Expected an error for a gain above %ddB", maxBassGain)
+	}
+	if err := excited.SetExciter(2); err == nil {
+		t.Errorf("Expected an error for an exciter amount above 1")
+	}
+}
+
+func TestCompressor(t *testing.T) {
+	sine := func(amp float64) []int16 {
+		out := make([]int16, 44100*2)
+		for i := range out {
+			out[i] = int16(amp * 32767 * math.Sin(2*math.Pi*1000*float64(i/2)/44100))
+		}
+		return out
+	}
+	peak := func(s []int16) float64 {
+		m := 0
+		for _, v := range s[len(s)/2:] { // skip the attack
+			m = max(m, int(v), -int(v))
+		}
+		return 20 * math.Log10(float64(m)/32767)
+	}
+
+	for _, tc := range []struct {
+		name            string
+		ratio, in, want float64 // levels in dB
+	}{
+		{"below threshold", 4, -26, -26},
+		{"compressed", 4, -4, -16},
+		{"limited", math.Inf(1), -4, -20},
+	} {
+		c, err := NewCompressor(44100, -20, tc.ratio, time.Millisecond, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := sine(math.Pow(10, tc.in/20))
+		out := make([]int16, len(in))
+		c.Process(in, out)
+		if got := peak(out); math.Abs(got-tc.want) > 0.5 {
+			t.Errorf("%s: expected a peak of %gdB, got %.2fdB", tc.name, tc.want, got)
+		}
+	}
+
+	// Makeup gain brings the compressed audio back up
+	c, _ := NewCompressor(44100, -20, 4, time.Millisecond, 100*time.Millisecond)
+	c.SetMakeup(6)
+	in := sine(math.Pow(10, -4.0/20))
+	c.Process(in, in)
+	if got := peak(in); math.Abs(got+10) > 0.5 {
+		t.Errorf("Expected a peak of -10dB with makeup gain, got %.2fdB", got)
+	}
+
+	if _, err := NewCompressor(44100, 3, 4, 0, 0); err == nil {
+		t.Errorf("Expected an error for a threshold above full scale")
+	}
+	if _, err := NewCompressor(44100, -20, 0.5, 0, 0); err == nil {
+		t.Errorf("Expected an error for a ratio below 1")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	// Returns the gain in dB of f for a sine at hz
+	gain := func(f *Filter, hz float64) float64 {
+		in := make([]int16, 44100*2)
+		for i := range in {
+			in[i] = int16(1000 * math.Sin(2*math.Pi*hz*float64(i/2)/44100))
+		}
+		out := make([]int16, len(in))
+		f.Process(in, out)
+		var sumIn, sumOut float64
+		for i := len(in) / 2; i < len(in); i++ { // skip the filter settling
+			sumIn += float64(in[i]) * float64(in[i])
+			sumOut += float64(out[i]) * float64(out[i])
+		}
+		return 10 * math.Log10(sumOut/sumIn)
+	}
+
+	first := func(kind FilterKind) *Filter {
+		f, err := NewFilter(kind, 44100, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	second := func(kind FilterKind, q float64) *Filter {
+		f, err := NewResonantFilter(kind, 44100, 1000, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	for _, tc := range []struct {
+		name   string
+		f      func() *Filter
+		hz, db float64
+		below  bool // db is the most the gain can be
+	}{
+		{"first order lowpass passband", func() *Filter { return first(LowPass) }, 100, 0, false},
+		{"first order lowpass cutoff", func() *Filter { return first(LowPass) }, 1000, -3, false},
+		{"first order lowpass stopband", func() *Filter { return first(LowPass) }, 10000, -20, true},
+		{"first order highpass passband", func() *Filter { return first(HighPass) }, 10000, 0, false},
+		{"first order highpass stopband", func() *Filter { return first(HighPass) }, 100, -20, true},
+		{"second order lowpass passband", func() *Filter { return second(LowPass, math.Sqrt2/2) }, 100, 0, false},
+		{"second order lowpass cutoff", func() *Filter { return second(LowPass, math.Sqrt2/2) }, 1000, -3, false},
+		{"second order lowpass stopband", func() *Filter { return second(LowPass, math.Sqrt2/2) }, 10000, -40, true},
+		{"second order highpass stopband", func() *Filter { return second(HighPass, math.Sqrt2/2) }, 100, -40, true},
+		{"resonant lowpass cutoff", func() *Filter { return second(LowPass, 4) }, 1000, 12, false},
+	} {
+		got := gain(tc.f(), tc.hz)
+		if tc.below {
+			// The bilinear transform makes the filters steeper near the
+			// Nyquist frequency
+			if got > tc.db {
+				t.Errorf("%s: expected a gain of at most %gdB at %gHz, got %.2fdB", tc.name, tc.db, tc.hz, got)
+			}
+		} else if math.Abs(got-tc.db) > 0.5 {
+			t.Errorf("%s: expected a gain of %gdB at %gHz, got %.2fdB", tc.name, tc.db, tc.hz, got)
+		}
+	}
+
+	if _, err := NewFilter(LowPass, 44100, 30000); err == nil {
+		t.Errorf("Expected an error for a cutoff above the Nyquist frequency")
+	}
+	if _, err := NewResonantFilter(HighPass, 44100, 1000, 0); err == nil {
+		t.Errorf("Expected an error for no resonance")
+	}
+	if _, err := NewFilter(FilterKind(5), 44100, 1000); err == nil {
+		t.Errorf("Expected an error for an invalid filter kind")
+	}
+}
+
+func TestCrossfeed(t *testing.T) {
+	// Returns the levels in dB of the left and right channels of f's
+	// output, for a sine at hz on the left and optionally the right
+	levels := func(f *Crossfeed, hz float64, both bool) (float64, float64) {
+		in := make([]int16, 44100*2)
+		for i := 0; i < len(in); i += 2 {
+			in[i] = int16(8000 * math.Sin(2*math.Pi*hz*float64(i/2)/44100))
+			if both {
+				in[i+1] = in[i]
+			}
+		}
+		f.Process(in, in)
+		var sumL, sumR float64
+		for i := len(in) / 2; i < len(in); i += 2 { // skip the filter settling
+			sumL += float64(in[i]) * float64(in[i])
+			sumR += float64(in[i+1]) * float64(in[i+1])
+		}
+		ref := 8000 * 8000 / 2 * float64(len(in)/4)
+		return 10 * math.Log10(sumL/ref), 10 * math.Log10(sumR/ref)
+	}
+	newCrossfeed := func() *Crossfeed {
+		f, err := NewCrossfeed(44100, DefaultCrossfeedCutoff, DefaultCrossfeedLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	// Bass on one side is fed to the other at the level
+	l, r := levels(newCrossfeed(), 60, false)
+	if diff := l - r; math.Abs(diff-DefaultCrossfeedLevel) > 0.5 {
+		t.Errorf("Expected the bass to be fed across %gdB down, got %.2fdB", DefaultCrossfeedLevel, diff)
+	}
+	// Treble is mostly not fed across
+	l, r = levels(newCrossfeed(), 8000, false)
+	if diff := l - r; diff < 15 {
+		t.Errorf("Expected the treble to be fed across at least 15dB down, got %.2fdB", diff)
+	}
+	// The level of centered bass is unchanged
+	l, r = levels(newCrossfeed(), 60, true)
+	if math.Abs(l) > 0.5 || math.Abs(r) > 0.5 {
+		t.Errorf("Expected centered bass to be unchanged, got %.2fdB and %.2fdB", l, r)
+	}
+
+	// The far channel hears an impulse after the delay
+	f := newCrossfeed()
+	in := make([]int16, 64)
+	in[0] = 32767
+	f.Process(in, in)
+	delay := int(math.Round(crossfeedDelay.Seconds() * 44100))
+	for i := 1; i < len(in); i += 2 {
+		if (in[i] != 0) != (i/2 >= delay) {
+			t.Errorf("Expected the right channel to be silent for %d frames, frame %d is %d", delay, i/2, in[i])
+			break
+		}
+	}
+
+	if _, err := NewCrossfeed(44100, 100, DefaultCrossfeedLevel); err == nil {
+		t.Errorf("Expected an error for a cutoff below 300Hz")
+	}
+	if _, err := NewCrossfeed(44100, DefaultCrossfeedCutoff, 20); err == nil {
+		t.Errorf("Expected an error for a level above 15dB")
+	}
+}
+
+func TestResampler(t *testing.T) {
+	// Returns a player that outputs a sine at hz
+	sinePlayer := func(hz float64) *Player {
+		plr := newPlayerWithTestPattern(make([][]string, 64), t)
+		phase := 0
+		plr.SetEffect(EffectFunc(func(in, out []int16) {
+			for i := 0; i < len(out); i += 2 {
+				v := int16(8000 * math.Sin(2*math.Pi*hz*float64(phase)/float64(plr.SampleRate())))
+				out[i], out[i+1] = v, v
+				phase++
+			}
+		}))
+		return plr
+	}
+
+	// At the player's rate the audio is unchanged
+	want := make([]int16, 4000)
+	sinePlayer(440).GenerateAudio(want)
+	rs, err := NewResampler(sinePlayer(440), 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]int16, len(want))
+	for n := 0; n < len(got)/2; {
+		n += rs.GenerateAudio(got[n*2 : min(n*2+300, len(got))])
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected resampling at the same rate to leave the audio unchanged")
+	}
+
+	// Resampled to 48000Hz the sine keeps its pitch and the player advances
+	// at its own rate
+	plr := sinePlayer(440)
+	rs, _ = NewResampler(plr, 48000)
+	if rs.Rate() != 48000 {
+		t.Errorf("Expected a rate of 48000, got %d", rs.Rate())
+	}
+	out := make([]int16, 48000*2)
+	for n := 0; n < len(out)/2; {
+		n += rs.GenerateAudio(out[n*2 : min(n*2+2000, len(out))])
+	}
+	crossings := 0
+	for i := 2; i < len(out); i += 2 {
+		if (out[i-2] < 0) != (out[i] < 0) {
+			crossings++
+		}
+	}
+	if crossings < 878 || crossings > 882 {
+		t.Errorf("Expected a 440Hz sine with about 880 zero crossings, got %d", crossings)
+	}
+	if played := plr.samplesPlayed; played < 44100 || played > 44100+2*resampleFrames {
+		t.Errorf("Expected the player to generate about 44100 samples, got %d", played)
+	}
+
+	// Downsampled, frequencies above the Nyquist frequency of the new rate
+	// are removed instead of aliasing to lower ones
+	rms := func(s []int16) float64 {
+		sum := 0.0
+		for _, v := range s {
+			sum += float64(v) * float64(v)
+		}
+		return math.Sqrt(sum / float64(len(s)))
+	}
+	for _, tc := range []struct {
+		hz, playerRate, rate int
+		passed               bool
+	}{
+		{1000, 44100, 22050, true},
+		{15000, 44100, 22050, false},
+		{1000, 48000, 32000, true},
+		{22000, 48000, 32000, false},
+	} {
+		plr := sinePlayer(float64(tc.hz))
+		plr.SetSampleRate(uint(tc.playerRate))
+		rs, _ := NewResampler(plr, uint(tc.rate))
+		out := make([]int16, 2000*2)
+		for n := 0; n < len(out)/2; {
+			n += rs.GenerateAudio(out[n*2 : min(n*2+500, len(out))])
+		}
+		level := rms(out[500*2:]) / (8000 / math.Sqrt2) // skip the filter settling
+		if tc.passed && level < 0.95 {
+			t.Errorf("%dHz at %dHz to %dHz: expected the sine to pass, got level %.3f", tc.hz, tc.playerRate, tc.rate, level)
+		}
+		if !tc.passed && level > 0.01 {
+			t.Errorf("%dHz at %dHz to %dHz: expected the sine to be removed, got level %.3f", tc.hz, tc.playerRate, tc.rate, level)
+		}
+	}
+
+	// The end of the song is generated once the player stops
+	frames := func(generate func([]int16) int) int {
+		total := 0
+		buf := make([]int16, 600)
+		for n := generate(buf); n > 0; n = generate(buf) {
+			total += n
+		}
+		return total
+	}
+	plr = sinePlayer(440)
+	plr.SetLoopPolicy(LoopPolicyStop, 0)
+	songFrames := frames(plr.GenerateAudio)
+	for _, rate := range []int{44100, 22050, 48000} {
+		plr := sinePlayer(440)
+		plr.SetLoopPolicy(LoopPolicyStop, 0)
+		rs, _ := NewResampler(plr, uint(rate))
+		want := int(int64(songFrames) * int64(rate) / 44100)
+		if got := frames(rs.GenerateAudio); got < want || got > want+1 {
+			t.Errorf("Resampled to %dHz, expected %d frames, got %d", rate, want, got)
+		}
+	}
+
+	if _, err := NewResampler(plr, 0); err == nil {
+		t.Errorf("Expected an error for a zero rate")
+	}
+}
+
+func TestBinaural(t *testing.T) {
+	// Returns the output of a channel playing a square wave at pan
+	render := func(panning Panning, pan int, separation int) []int16 {
+		pattern := make([][]string, 64)
+		pattern[0] = []string{"A-4  1 .. ..."}
+		for i := 1; i < len(pattern); i++ {
+			pattern[i] = []string{""}
+		}
+		plr := newPlayerWithTestPattern(pattern, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(100 - 200*(i/4%2))
+		}
+		plr.SetPanning(panning)
+		plr.SetChannelPan(0, pan, true)
+		plr.SetStereoSeparation(separation)
+
+		out := make([]int16, 2000*2)
+		plr.GenerateAudio(out)
+		return out
+	}
+	// Returns the energy and first sound of a channel of out
+	measure := func(out []int16, c int) (float64, int) {
+		energy, first := 0.0, -1
+		for i := c; i < len(out); i += 2 {
+			energy += float64(out[i]) * float64(out[i])
+			if first < 0 && out[i] != 0 {
+				first = i / 2
+			}
+		}
+		return energy, first
+	}
+
+	// Hard left the left ear hears the sound first and loudest, but unlike
+	// stereo panning the right ear hears it too
+	out := render(PanningBinaural, 0, 100)
+	left, firstLeft := measure(out, 0)
+	right, firstRight := measure(out, 1)
+	if right == 0 || right >= left {
+		t.Errorf("Expected the right ear to hear a quieter sound, got energy %g left and %g right", left, right)
+	}
+	delay := int(math.Round(headRadius / speedOfSound * (1 + math.Pi/2) * 44100))
+	if firstRight-firstLeft != delay {
+		t.Errorf("Expected the right ear to hear the sound %d samples later, got %d", delay, firstRight-firstLeft)
+	}
+	if right, _ := measure(render(PanningStereo, 0, 100), 1); right != 0 {
+		t.Errorf("Expected stereo panning to leave the right side silent")
+	}
+
+	// Centered both ears hear the same
+	out = render(PanningBinaural, 0, 0)
+	for i := 0; i < len(out); i += 2 {
+		if out[i] != out[i+1] {
+			t.Fatalf("Expected the same output in both ears, frame %d is %d and %d", i/2, out[i], out[i+1])
+		}
+	}
+	if left, _ := measure(out, 0); left == 0 {
+		t.Errorf("Expected a centered sound")
+	}
+}
+
+func TestGenerateSurround(t *testing.T) {
+	for _, tc := range []struct {
+		channel, pan int
+		speaker      int // output channel expected to play the sound
+	}{
+		{0, 0, 0},   // front left
+		{1, 127, 1}, // front right
+		{1, 64, 2},  // center
+		{2, 127, 5}, // rear right
+		{3, 0, 4},   // rear left
+	} {
+		pattern := make([][]string, 64)
+		for i := range pattern {
+			pattern[i] = make([]string, 4)
+		}
+		pattern[0][tc.channel] = "A-4  1 .. ..."
+		plr := newPlayerWithTestPattern(pattern, t)
+		for i := range plr.Song.Samples[0].Data {
+			plr.Song.Samples[0].Data[i] = int8(100 - 200*(i/100%2)) // a bass note
+		}
+		plr.SetChannelPan(tc.channel, tc.pan, true)
+
+		out := make([]int16, 2000*SurroundChannels)
+		if n := plr.GenerateSurround(out); n != 2000 {
+			t.Fatalf("Expected 2000 frames, got %d", n)
+		}
+		for c := 0; c < SurroundChannels; c++ {
+			loud := false
+			for i := c; i < len(out); i += SurroundChannels {
+				loud = loud || out[i] != 0
+			}
+			// The LFE plays everything
+			if want := c == tc.speaker || c == 3; loud != want {
+				t.Errorf("Channel %d panned to %d: expected sound from speaker %d to be %t", tc.channel, tc.pan, c, want)
+			}
+		}
+	}
+
+	// Stereo output still mixes every channel after surround output
+	pattern := make([][]string, 64)
+	for i := range pattern {
+		pattern[i] = make([]string, 4)
+	}
+	pattern[0][2] = "A-4  1 .. ..."
+	plr := newPlayerWithTestPattern(pattern, t)
+	for i := range plr.Song.Samples[0].Data {
+		plr.Song.Samples[0].Data[i] = 100
+	}
+	plr.GenerateSurround(make([]int16, 10*SurroundChannels))
+	out := make([]int16, 10*2)
+	plr.GenerateAudio(out)
+	if out[0] == 0 && out[1] == 0 {
+		t.Errorf("Expected stereo output from a rear channel")
+	}
+}
+
+func TestLoudness(t *testing.T) {
+	type tone struct {
+		level   float64 // dBFS
+		seconds int
+	}
+	// Returns the loudness of stereo sines played in turn
+	measure := func(tones ...tone) Loudness {
+		m := NewLoudnessMeter(48000)
+		buf := make([]int16, 48000*2)
+		phase := 0
+		for _, tone := range tones {
+			amp := 32768 * math.Pow(10, tone.level/20)
+			for s := 0; s < tone.seconds; s++ {
+				for i := 0; i < len(buf); i += 2 {
+					v := int16(math.Round(amp * math.Sin(2*math.Pi*1000*float64(phase)/48000)))
+					buf[i], buf[i+1] = v, v
+					phase++
+				}
+				m.Process(buf, buf)
+			}
+		}
+		return m.Loudness()
+	}
+
+	// Test cases 1 to 4 of EBU Tech 3341
+	for _, tc := range []struct {
+		tones      []tone
+		lufs, peak float64
+	}{
+		{[]tone{{-23, 20}}, -23, -23},
+		{[]tone{{-33, 20}}, -33, -33},
+		{[]tone{{-36, 10}, {-23, 60}, {-36, 10}}, -23, -23},                       // relative gate
+		{[]tone{{-72, 10}, {-36, 10}, {-23, 60}, {-36, 10}, {-72, 10}}, -23, -23}, // absolute gate
+	} {
+		l := measure(tc.tones...)
+		if math.Abs(l.Integrated-tc.lufs) > 0.1 {
+			t.Errorf("%v: expected %g LUFS, got %.2f", tc.tones, tc.lufs, l.Integrated)
+		}
+		if math.Abs(l.Peak-tc.peak) > 0.01 {
+			t.Errorf("%v: expected a peak of %gdB, got %.2fdB", tc.tones, tc.peak, l.Peak)
+		}
+		if g := l.Gain(-18); math.Abs(g-(-18-l.Integrated)) > 1e-9 {
+			t.Errorf("%v: expected a gain of %g, got %g", tc.tones, -18-l.Integrated, g)
+		}
+	}
+	if l := measure(tone{-3, 5}); l.Gain(5) != -l.Peak {
+		t.Errorf("Expected the gain to be limited by the peak, got %g", l.Gain(5))
+	}
+	if l := NewLoudnessMeter(48000).Loudness(); !math.IsInf(l.Integrated, -1) || l.Gain(-18) != 0 {
+		t.Errorf("Expected silence to measure -Inf LUFS and need no gain, got %g", l.Integrated)
+	}
+
+	// AnalyzeLoudness measures what the player would play
+	newPlayer := func() *Player {
+		pattern := make([][]string, 64)
+		pattern[0] = []string{"A-4  1 .. ..."}
+		plr := newPlayerWithTestPattern(pattern, t)
+		sample := &plr.Song.Samples[0]
+		for i := range sample.Data {
+			sample.Data[i] = int8(100 - 200*(i/20%2))
+		}
+		sample.LoopLen = testSampleLength
+		return plr
+	}
+	plr := newPlayer()
+	plr.GenerateAudio(make([]int16, 1000)) // the analysis starts from the beginning
+	got, err := plr.AnalyzeLoudness(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewLoudnessMeter(44100)
+	plr = newPlayer()
+	plr.SetEffect(m)
+	for plr.IsPlaying() {
+		plr.GenerateAudio(make([]int16, 2000))
+	}
+	if want := m.Loudness(); got != want || math.IsInf(want.Integrated, -1) {
+		t.Errorf("Expected AnalyzeLoudness to measure %v, got %v", want, got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := plr.AnalyzeLoudness(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled analysis to fail, got %v", err)
+	}
+}
+
+func TestExportSamples(t *testing.T) {
+	song := &Song{Samples: []Sample{
+		{Length: 4, LoopStart: 1, LoopLen: 2, C4Speed: 8363, Data: []int8{0, 64, -64, 127}},
+		{}, // empty samples are skipped
+		{Length: 2, C4Speed: 16726, Data: []int8{-128, 1}},
+	}}
+	dir := t.TempDir()
+	if err := song.ExportSamples(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + "/02.wav"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the empty sample to be skipped, got %v", err)
+	}
+
+	cases := []struct {
+		File string
+		Rate uint32
+		Data []int16
+		Loop []uint32 // start and end frame of the smpl loop, nil for none
+	}{
+		{"01.wav", 8363, []int16{0, 64 << 8, -64 << 8, 127 << 8}, []uint32{1, 2}},
+		{"03.wav", 16726, []int16{-128 << 8, 1 << 8}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.File, func(t *testing.T) {
+			b, err := os.ReadFile(dir + "/" + tc.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Walk the chunks after the RIFF header
+			var rate uint32
+			var data []int16
+			var loop []uint32
+			for i := 12; i+8 <= len(b); {
+				id, size := string(b[i:i+4]), int(binary.LittleEndian.Uint32(b[i+4:]))
+				body := b[i+8 : i+8+size]
+				switch id {
+				case "fmt ":
+					rate = binary.LittleEndian.Uint32(body[4:])
+				case "data":
+					data = make([]int16, size/2)
+					binary.Read(bytes.NewReader(body), binary.LittleEndian, data)
+				case "smpl":
+					loop = []uint32{binary.LittleEndian.Uint32(body[44:]), binary.LittleEndian.Uint32(body[48:])}
+				}
+				i += 8 + size + size%2
+			}
+
+			if rate != tc.Rate {
+				t.Errorf("Expected sample rate %d, got %d", tc.Rate, rate)
+			}
+			if !slices.Equal(data, tc.Data) {
+				t.Errorf("Expected data %v, got %v", tc.Data, data)
+			}
+			if !slices.Equal(loop, tc.Loop) {
+				t.Errorf("Expected loop %v, got %v", tc.Loop, loop)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package modplayer
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	resampleFrames  = 1024 // stereo frames read from the player at a time
+	resampleMaxTaps = 256  // most frames each output frame is computed from
+)
+
+// Resampler converts the audio generated by a Player to another sample rate,
+// so that a song can be mixed at one rate and played on a device running at
+// another, e.g. mixed at 44100Hz and played at 48000Hz. The audio is resampled
+// with the windowed sinc filter used by InterpolationSinc, which is cut off at
+// the Nyquist frequency of the lower of the two rates so that downsampling
+// doesn't alias.
+type Resampler struct {
+	player *Player
+	rate   uint
+	step   uint64 // player frames advanced per output frame, 32.32 fixed point
+
+	// The filter for the player's rate, taps coefficients for each phase
+	playerRate uint
+	taps       int
+	coefs      []int32
+
+	// Stereo frames from the player, starting with the frames before the
+	// output position that the filter still needs. Frames outside of in are
+	// silent.
+	in    []int16
+	n     int    // frames in in
+	pos   uint64 // position of the next output frame in in, 32.32 fixed point
+	ended bool   // the song ended after the frames in in
+}
+
+// NewResampler returns a Resampler that generates the audio of p at rate Hz.
+// Once created, audio should be generated with the Resampler's GenerateAudio
+// instead of p's. Changing p's sample rate with SetSampleRate changes the
+// conversion.
+func NewResampler(p *Player, rate uint) (*Resampler, error) {
+	if rate == 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", rate)
+	}
+
+	r := &Resampler{player: p, rate: rate}
+	r.setPlayerRate(p.SampleRate())
+	return r, nil
+}
+
+// Rate returns the sample rate the Resampler generates audio at.
+func (r *Resampler) Rate() uint {
+	return r.rate
+}
+
+// Builds the filter for converting from the player's sample rate. When
+// downsampling the cutoff is lowered, and the filter widened to match.
+func (r *Resampler) setPlayerRate(rate uint) {
+	cutoff := min(1, float64(r.rate)/float64(rate))
+	r.taps = min(2*int(math.Ceil(sincTaps/2/cutoff)), resampleMaxTaps)
+	r.coefs = newSincTable(r.taps, cutoff)
+	r.playerRate = rate
+	r.step = uint64(rate) << 32 / uint64(r.rate)
+
+	if need := (resampleFrames + r.taps) * 2; len(r.in) < need {
+		in := make([]int16, need)
+		copy(in, r.in[:r.n*2])
+		r.in = in
+	}
+}
+
+// GenerateAudio fills out with stereo sample data (LRLRLR...) at the
+// Resampler's rate and returns the number of stereo samples generated. Like
+// Player.GenerateAudio, it advances the player through the song and may
+// generate fewer samples than out can hold, or none, when the player is paused
+// or reaches the end of the song. The last of the song is generated once the
+// player has stopped at the end.
+func (r *Resampler) GenerateAudio(out []int16) int {
+	if rate := r.player.SampleRate(); rate != r.playerRate {
+		r.setPlayerRate(rate)
+	}
+
+	generated := 0
+	for generated < len(out)/2 {
+		i := int(r.pos >> 32)
+		if i+r.taps/2 >= r.n && !r.ended {
+			if !r.fill() {
+				break
+			}
+			continue
+		}
+		if i >= r.n {
+			// All of the song has been generated, start afresh in case the
+			// player is restarted
+			r.n, r.pos, r.ended = 0, 0, false
+			break
+		}
+
+		phase := int(r.pos>>(32-sincPhaseBits)) & (sincPhases - 1)
+		coefs := r.coefs[phase*r.taps : (phase+1)*r.taps]
+		first := i - r.taps/2 + 1
+		// 16 bit frames times 17 bit coefficients overflow a 32 bit int
+		var left, right int64
+		for k := max(-first, 0); k < min(len(coefs), r.n-first); k++ {
+			left += int64(r.in[(first+k)*2+0]) * int64(coefs[k])
+			right += int64(r.in[(first+k)*2+1]) * int64(coefs[k])
+		}
+		out[generated*2+0] = int16(clamp(left>>sincBits, math.MinInt16, math.MaxInt16))
+		out[generated*2+1] = int16(clamp(right>>sincBits, math.MinInt16, math.MaxInt16))
+
+		r.pos += r.step
+		generated++
+	}
+
+	return generated
+}
+
+// Drops the frames the filter no longer needs and reads more from the player.
+// Returns false if the player generated nothing and the song hasn't ended.
+func (r *Resampler) fill() bool {
+	drop := max(min(int(r.pos>>32)-r.taps/2+1, r.n), 0)
+	copy(r.in, r.in[drop*2:r.n*2])
+	r.n -= drop
+	r.pos -= uint64(drop) << 32
+
+	// The player stops partway through generating at the end of the song,
+	// but generates nothing at all when it was already stopped or paused
+	want := min(len(r.in)/2-r.n, r.player.MixBufferSize())
+	playing := r.player.IsPlaying()
+	got := r.player.GenerateAudio(r.in[r.n*2 : (r.n+want)*2])
+	r.n += got
+	if playing && got < want && !r.player.IsPlaying() {
+		r.ended = true
+	}
+	return got > 0 || r.ended
+}
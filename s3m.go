@@ -25,17 +25,40 @@ const (
 	s3mfx_Special            = 0x13 // 'S'
 	s3mfx_SetTempo           = 0x14 // 'T'
 	s3mfx_SetGlobalVolume    = 0x16 // 'V'
+	s3mfx_GlobalVolumeSlide  = 0x17 // 'W'
 )
 
 var ErrInvalidS3M = errors.New("invalid S3M file")
 
+// Trackers identified by the top nibble of the S3M tracker version
+var s3mTrackers = map[uint16]string{
+	1: "Scream Tracker",
+	2: "Imago Orpheus",
+	3: "Impulse Tracker",
+	4: "Schism Tracker",
+	5: "OpenMPT",
+	6: "BeRoTracker",
+	7: "CreamTracker",
+}
+
+// Returns the name and version of the tracker from the S3M tracker version
+// field, 0xCxyy where C identifies the tracker and x.yy is the version.
+func s3mTrackerName(cwtv uint16) string {
+	name, ok := s3mTrackers[cwtv>>12]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%s %d.%02X", name, (cwtv>>8)&0xF, cwtv&0xFF)
+}
+
 func NewS3MSongFromBytes(songBytes []byte) (*Song, error) {
 	// Check if the song is an S3M
 	if len(songBytes) < 48 || string(songBytes[44:48]) != "SCRM" {
 		return nil, ErrInvalidS3M
 	}
 
-	song := &Song{Type: SongTypeS3M}
+	song := &Song{Type: SongTypeS3M, Clock: ClockPAL}
 	buf := bytes.NewReader(songBytes)
 	y := make([]byte, 28)
 	if _, err := buf.Read(y); err != nil {
@@ -70,6 +93,9 @@ func NewS3MSongFromBytes(songBytes []byte) (*Song, error) {
 	song.Tempo = int(header.Tempo)
 	song.Speed = int(header.Speed)
 	song.GlobalVolume = int(header.GlobalVolume)
+	song.FileFormat = "SCRM"
+	song.TrackerName = s3mTrackerName(header.Tracker)
+	song.Flags = int(header.Flags)
 
 	// Count up the number of channels and build the channel remap table
 	remap := make([]int, 32)
@@ -84,6 +110,7 @@ func NewS3MSongFromBytes(songBytes []byte) (*Song, error) {
 	dumpf("Channels:\t%d\n", song.Channels)
 	dumpf("Speed:\t\t%d\n", song.Speed)
 	dumpf("Tempo:\t\t%d\n", song.Tempo)
+	dumpf("Tracker:\t%s\n", song.TrackerName)
 
 	// Read in the orders
 	orders := make([]byte, header.Length)
@@ -385,6 +412,8 @@ func convertS3MEffect(efc, parm byte, _ptn, _row, _chn int) (effect byte, param
 		effect = effectSetSpeed
 	case s3mfx_SetGlobalVolume:
 		effect = effectS3MGlobalVolume
+	case s3mfx_GlobalVolumeSlide:
+		effect = effectGlobalVolumeSlide
 	case s3mfx_RetrigNoteVolSlide:
 		effect = effectNoteRetrigVolSlide
 	case s3mfx_Tremolo:
@@ -0,0 +1,107 @@
+package modplayer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Ring buffers of the most recent output of each channel, see
+// Player.SetScopeLength
+type scopes struct {
+	mu     sync.Mutex
+	data   [][]int // mono output of each channel, in mix buffer units
+	length int     // length of each ring buffer
+	pos    int     // ring buffer position of the next sample
+	before []int   // mix buffer contents before a channel was mixed
+}
+
+// SetScopeLength enables per-channel oscilloscope buffers that hold the most
+// recent n samples of each channel's output, see ChannelScope. Passing 0
+// disables them, which is the default. Recording the scopes makes mixing
+// slower.
+func (p *Player) SetScopeLength(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid scope length %d", n)
+	}
+	if n == 0 {
+		p.scopes.Store(nil)
+		return nil
+	}
+
+	s := &scopes{
+		data:   make([][]int, p.Song.Channels),
+		length: n,
+		before: make([]int, len(p.mixbuffer)),
+	}
+	for i := range s.data {
+		s.data[i] = make([]int, n)
+	}
+	p.scopes.Store(s)
+
+	return nil
+}
+
+// ChannelScope copies the most recent output of channel ci into buf, oldest
+// sample first, and returns the number of samples copied. The samples are the
+// channel's mono output at the same scale as GenerateAudio, muted channels are
+// silent. Returns 0 if the scopes are disabled, see SetScopeLength.
+//
+// It is safe to call ChannelScope from a different goroutine to the one
+// generating audio.
+func (p *Player) ChannelScope(ci int, buf []int16) int {
+	s := p.scopes.Load()
+	if s == nil || ci < 0 || ci >= len(s.data) {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ring := s.data[ci]
+	n := min(len(buf), s.length)
+	start := s.pos - n + s.length
+	for i := 0; i < n; i++ {
+		buf[i] = clampSample(ring[(start+i)%s.length])
+	}
+
+	return n
+}
+
+// Mixes the channels like mixChannels and records the output of each one in
+// the scope buffers s. The output of a channel is found by comparing the mix
+// buffer before and after it is mixed in.
+func (p *Player) mixChannelsScoped(s *scopes, nSamples, offset int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mb := p.mixbuffer[offset*2 : (offset+nSamples)*2]
+	before := s.before[:len(mb)]
+
+	// Only the last samples mixed fit in the ring buffers
+	first := max(nSamples-s.length, 0)
+	for _, ring := range s.data {
+		for i := first; i < nSamples; i++ {
+			ring[(s.pos+i)%s.length] = 0
+		}
+	}
+	mix := func(c *channel, ci int) {
+		copy(before, mb)
+		p.mixChannel(c, ci, nSamples, offset)
+
+		ring := s.data[ci]
+		for i := first; i < nSamples; i++ {
+			l := mb[i*2+0] - before[i*2+0]
+			r := mb[i*2+1] - before[i*2+1]
+			ring[(s.pos+i)%s.length] += (l + r) / 2
+		}
+	}
+
+	for ci := range p.channels {
+		p.meters[ci].samples += nSamples
+		mix(&p.channels[ci], ci)
+	}
+	for vi := range p.voices {
+		mix(&p.voices[vi], p.voices[vi].owner)
+	}
+	s.pos = (s.pos + nSamples) % s.length
+}
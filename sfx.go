@@ -0,0 +1,114 @@
+package modplayer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SFXVoices is the number of sound effect voices, see Player.PlayNote.
+const SFXVoices = 8
+
+// A request to start or stop a sound effect voice
+type sfxRequest struct {
+	voice  int
+	sample int // -1 to stop the voice
+	note   playerNote
+	volume int
+}
+
+// Sound effect voices and the requests waiting to be applied to them
+type sfx struct {
+	mu      sync.Mutex
+	pending []sfxRequest
+	voices  [SFXVoices]channel
+}
+
+// PlayNote plays sample (an index into Song.Samples) at note, e.g. "C-5",
+// and volume, 0-64, on sound effect voice voice, 0 to SFXVoices-1. Sound
+// effect voices are mixed with the song but are not affected by it, which
+// lets games play sound effects through the same mixer as their music. A
+// note already playing on the voice is replaced. The note starts with the
+// next call to GenerateAudio and plays while the player is playing.
+//
+// It is safe to call PlayNote and StopNote from a different goroutine to the
+// one generating audio.
+func (p *Player) PlayNote(voice, sample int, note string, volume int) error {
+	if voice < 0 || voice >= SFXVoices {
+		return fmt.Errorf("invalid voice %d", voice)
+	}
+	if sample < 0 || sample >= len(p.Song.Samples) {
+		return fmt.Errorf("invalid sample %d", sample)
+	}
+	if volume < 0 || volume > maxVolume {
+		return fmt.Errorf("invalid volume %d", volume)
+	}
+	pn, err := parseNote(note)
+	if err != nil {
+		return err
+	}
+
+	p.requestSFX(sfxRequest{voice: voice, sample: sample, note: pn, volume: volume})
+	return nil
+}
+
+// StopNote stops the note playing on sound effect voice voice, see PlayNote.
+func (p *Player) StopNote(voice int) error {
+	if voice < 0 || voice >= SFXVoices {
+		return fmt.Errorf("invalid voice %d", voice)
+	}
+
+	p.requestSFX(sfxRequest{voice: voice, sample: -1})
+	return nil
+}
+
+func (p *Player) requestSFX(req sfxRequest) {
+	p.sfx.mu.Lock()
+	p.sfx.pending = append(p.sfx.pending, req)
+	p.sfx.mu.Unlock()
+}
+
+// Starts and stops the sound effect voices that have been requested since the
+// last call.
+func (p *Player) updateSFX() {
+	p.sfx.mu.Lock()
+	defer p.sfx.mu.Unlock()
+
+	for _, req := range p.sfx.pending {
+		v := &p.sfx.voices[req.voice]
+		if req.sample == -1 {
+			v.sample = -1
+			continue
+		}
+		if req.sample >= len(p.Song.Samples) {
+			// PlayNote checked the sample against a song that has since
+			// been replaced
+			continue
+		}
+
+		*v = channel{
+			volume: req.volume,
+			pan:    64,
+			sfx:    true,
+		}
+		v.triggerNote(p.notePeriod(req.note, &p.Song.Samples[req.sample]), req.sample, 0, 0, 0)
+	}
+	p.sfx.pending = p.sfx.pending[:0]
+}
+
+// Stops every sound effect voice and discards any pending requests.
+func (p *Player) stopSFX() {
+	p.sfx.mu.Lock()
+	defer p.sfx.mu.Unlock()
+
+	for i := range p.sfx.voices {
+		p.sfx.voices[i].sample = -1
+	}
+	p.sfx.pending = p.sfx.pending[:0]
+}
+
+// Mixes the sound effect voices into the mix buffer.
+func (p *Player) mixSFX(nSamples, offset int) {
+	for i := range p.sfx.voices {
+		p.mixChannel(&p.sfx.voices[i], -1, nSamples, offset)
+	}
+}
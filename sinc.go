@@ -0,0 +1,110 @@
+package modplayer
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	sincTaps      = 16                 // sample frames each output sample is computed from
+	sincPhaseBits = 10                 // log2 of the number of phases
+	sincPhases    = 1 << sincPhaseBits // positions between two frames that have their own coefficients
+	sincBits      = 16                 // fraction bits of the coefficients
+)
+
+var (
+	// Windowed-sinc coefficients for each phase, built on first use by
+	// initSinc. The coefficients of each phase sum to 1.
+	sincTable [sincPhases][sincTaps]int32
+	sincOnce  sync.Once
+)
+
+// Builds the windowed-sinc coefficient table.
+func initSinc() {
+	sincOnce.Do(func() {
+		table := newSincTable(sincTaps, 1)
+		for ph := range sincTable {
+			copy(sincTable[ph][:], table[ph*sincTaps:])
+		}
+	})
+}
+
+// Returns windowed-sinc coefficients for each of the sincPhases phases, taps
+// coefficients per phase, with the filter cut off at cutoff times the Nyquist
+// frequency. The coefficients of each phase sum to 1.
+func newSincTable(taps int, cutoff float64) []int32 {
+	half := taps / 2
+	table := make([]int32, sincPhases*taps)
+	coefs := make([]float64, taps)
+	for ph := 0; ph < sincPhases; ph++ {
+		x := float64(ph) / sincPhases
+
+		// Tap k is frame i-half+1+k, where i is the frame before the sample
+		// position
+		sum := 0.0
+		for k := range coefs {
+			d := float64(k-half+1) - x
+			coefs[k] = sinc(cutoff*d) * blackman(d/float64(half))
+			sum += coefs[k]
+		}
+
+		// Normalize so that a constant signal stays constant, putting any
+		// rounding error in the largest coefficient
+		phase := table[ph*taps : (ph+1)*taps]
+		total, largest := int32(0), 0
+		for k, c := range coefs {
+			phase[k] = int32(math.Round(c / sum * (1 << sincBits)))
+			total += phase[k]
+			if phase[k] > phase[largest] {
+				largest = k
+			}
+		}
+		phase[largest] += 1<<sincBits - total
+	}
+	return table
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// Blackman window over -1 to 1
+func blackman(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*x) + 0.08*math.Cos(2*math.Pi*x)
+}
+
+// Returns the value of sample at the 16.16 fixed point position pos, with 8
+// bits of fraction, computed with the windowed-sinc table. end is the frame
+// the sample ends or loops at.
+func sincInterpolate(sample *Sample, pos uint, end int) int {
+	i := int(pos >> 16)
+	coefs := &sincTable[(pos&0xFFFF)>>(16-sincPhaseBits)]
+
+	first := i - sincTaps/2 + 1
+	// 16 bit frames times 17 bit coefficients overflow a 32 bit int
+	var v int64
+	switch {
+	case first < 0 || first+sincTaps > end:
+		// Some frames are outside the sample
+		for k := range coefs {
+			v += int64(sampleFrame(sample, first+k, end)) * int64(coefs[k])
+		}
+		return int(v >> sincBits)
+	case sample.Data16 != nil:
+		for k, sd := range sample.Data16[first : first+sincTaps] {
+			v += int64(sd) * int64(coefs[k])
+		}
+		return int(v >> sincBits)
+	default:
+		for k, sd := range sample.Data[first : first+sincTaps] {
+			v += int64(sd) * int64(coefs[k])
+		}
+		return int(v >> (sincBits - 8))
+	}
+}
@@ -0,0 +1,137 @@
+package modplayer
+
+import "math"
+
+const (
+	// SurroundChannels is the number of channels in each frame generated by
+	// GenerateSurround.
+	SurroundChannels = 6
+
+	surroundCenterWidth = 16  // double resolution pan positions either side of center that play from the center speaker
+	surroundLFECutoff   = 120 // Hz
+)
+
+const (
+	surroundFront = iota
+	surroundCenter
+	surroundRear
+)
+
+// State of the 5.1 surround mix, see GenerateSurround. The front channels are
+// mixed into the mix buffer.
+type surroundMix struct {
+	active bool   // a surround mix is being generated
+	center []int  // stereo mix of the channels played from the center speaker
+	rear   []int  // stereo mix of the channels played from the rear speakers
+	rate   uint   // sample rate lfe was set up for
+	lfe    biquad // lowpass of the mix for the LFE channel
+}
+
+// GenerateSurround fills out with 5.1 surround sample data and returns the
+// number of frames generated. Each frame is SurroundChannels samples, front
+// left, front right, center, LFE, rear left and rear right, the order of a
+// 5.1 WAV file.
+//
+// The tracker channels are spread around the listener. Channels panned close
+// to the center play from the center speaker, the others from the front or
+// rear speakers in pairs, so that a 4 channel MOD plays its first two
+// channels from the front and the other two from the rear. Within the front
+// and rear each channel is panned between left and right as in a stereo mix.
+// The LFE channel is a lowpass of the whole mix. Sound effects play from the
+// front.
+//
+// Like GenerateAudio this advances the player through the song. The effect set
+// with SetEffect only applies to stereo output and is not applied.
+func (p *Player) GenerateSurround(out []int16) int {
+	s := p.surround
+	if s == nil {
+		s = &surroundMix{
+			center: make([]int, len(p.mixbuffer)),
+			rear:   make([]int, len(p.mixbuffer)),
+		}
+		p.surround = s
+	}
+	if s.rate != p.samplingFrequency {
+		s.rate = p.samplingFrequency
+		s.lfe = lfeFilter(s.rate)
+	}
+
+	count := len(out) / SurroundChannels
+	clear(s.center[0 : count*2])
+	clear(s.rear[0 : count*2])
+	s.active = true
+	generated := p.generate(count)
+	s.active = false
+
+	n := generated * 2
+	for _, buf := range [][]int{p.mixbuffer[0:n], s.center[0:n], s.rear[0:n]} {
+		p.applyGain(buf)
+		p.updateStats(buf)
+	}
+	for i := 0; i < generated; i++ {
+		fl, fr := p.mixbuffer[i*2+0], p.mixbuffer[i*2+1]
+		c := s.center[i*2+0] + s.center[i*2+1]
+		rl, rr := s.rear[i*2+0], s.rear[i*2+1]
+
+		// The LFE is fed the average of the left and right sides
+		lfe := s.lfe.process(float64(fl+fr+c+rl+rr)/2, 0)
+
+		f := out[i*SurroundChannels : (i+1)*SurroundChannels]
+		f[0], f[1] = clampSample(fl), clampSample(fr)
+		f[2] = clampSample(c)
+		f[3] = clampSample(int(math.Round(lfe)))
+		f[4], f[5] = clampSample(rl), clampSample(rr)
+	}
+
+	return generated
+}
+
+// Returns a second order lowpass filter for the LFE channel at sampleRate.
+func lfeFilter(sampleRate uint) biquad {
+	f, _ := NewResonantFilter(LowPass, sampleRate, min(surroundLFECutoff, float64(sampleRate)/4), math.Sqrt2/2)
+	return f.biquad
+}
+
+// Returns which speakers channel (tracker channel index ci) plays from in the
+// surround mix.
+func (p *Player) surroundGroup(channel *channel, ci int) int {
+	if ci < 0 {
+		return surroundFront
+	}
+	if pan := p.stereoPan(channel); pan >= 127-surroundCenterWidth && pan <= 127+surroundCenterWidth {
+		return surroundCenter
+	}
+	if ci/2%2 == 1 {
+		return surroundRear
+	}
+	return surroundFront
+}
+
+// Mixes the tracker channels like mixChannels, but into the mix buffer of
+// the speakers each channel plays from.
+func (p *Player) mixChannelsSurround(nSamples, offset int) {
+	buffers := [...][]int{
+		surroundFront:  p.mixbuffer,
+		surroundCenter: p.surround.center,
+		surroundRear:   p.surround.rear,
+	}
+
+	for ci := range p.channels {
+		c := &p.channels[ci]
+		p.meters[ci].samples += nSamples
+		p.mixChannelInto(buffers[p.surroundGroup(c, ci)], c, ci, nSamples, offset)
+	}
+	for vi := range p.voices {
+		v := &p.voices[vi]
+		p.mixChannelInto(buffers[p.surroundGroup(v, v.owner)], v, v.owner, nSamples, offset)
+	}
+}
+
+// Returns the buffers being mixed into, the mix buffer and when generating
+// surround sound the center and rear buffers.
+func (p *Player) mixBuffers() [][]int {
+	if s := p.surround; s != nil && s.active {
+		return [][]int{p.mixbuffer, s.center, s.rear}
+	}
+	return [][]int{p.mixbuffer}
+}
@@ -0,0 +1,449 @@
+// Package wav is a _very_ simple WAVE file writer, used by the commands to
+// save the audio generated by the player.
+// Wrote my own after trying out a couple of others I found but
+// both required me to know the quantity of audio data before I
+// write it.
+// See http://soundfile.sapp.org/doc/WaveFormat/ for format
+// documentation.
+// Files over 4GB are written in the RF64 format, see EBU Tech 3306.
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	wavTypePCM        = 1
+	wavTypeExtensible = 0xFFFE // format with a channel layout, for more than 2 channels
+)
+
+// Speaker positions for Format.ChannelMask
+const (
+	SpeakerFrontLeft   = 0x1
+	SpeakerFrontRight  = 0x2
+	SpeakerFrontCenter = 0x4
+	SpeakerLFE         = 0x8
+	SpeakerBackLeft    = 0x10
+	SpeakerBackRight   = 0x20
+
+	// Speaker positions of a 5.1 file, in the order of the channels
+	Surround51 = SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLFE | SpeakerBackLeft | SpeakerBackRight
+)
+
+// Offset of the chunk reserved for the RF64 sizes, after the RIFF header
+const ds64Pos = 12
+
+// KSDATAFORMAT_SUBTYPE_PCM, the sub format of PCM data in an extensible format
+var subtypePCM = [16]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// ErrInvalidChunkHeaderLength means that the provided letter chunk
+// name was not 4 characters.
+var ErrInvalidChunkHeaderLength = errors.New("chunk header name is not 4 characters")
+
+// Format describes the audio in a WAV file.
+type Format struct {
+	SampleRate    int
+	Channels      int    // interleaved samples in each frame
+	BitsPerSample int    // 16, 24 or 32
+	ChannelMask   uint32 // speaker position of each channel, 0 for the default layout
+}
+
+// A Writer writes a WAV file into WS
+type Writer struct {
+	WS io.WriteSeeker
+
+	format      Format
+	dataSizePos int64  // offset of the data chunk size
+	buf         []byte // samples converted to the file format
+	cues        []cue
+	loop        *sampleLoop
+}
+
+// A marker in the audio, written to the cue and label chunks
+type cue struct {
+	frame int64
+	label string
+}
+
+type sampler struct {
+	Manufacturer      uint32
+	Product           uint32
+	SamplePeriod      uint32 // nanoseconds per frame
+	MIDIUnityNote     uint32
+	MIDIPitchFraction uint32
+	SMPTEFormat       uint32
+	SMPTEOffset       uint32
+	NumSampleLoops    uint32
+	SamplerData       uint32
+}
+
+type sampleLoop struct {
+	CuePointID uint32
+	Type       uint32 // 0 loops forward
+	Start      uint32
+	End        uint32 // last frame of the loop
+	Fraction   uint32
+	PlayCount  uint32 // 0 loops forever
+}
+
+type cuePoint struct {
+	ID           uint32
+	Position     uint32
+	DataChunkID  [4]byte
+	ChunkStart   uint32
+	BlockStart   uint32
+	SampleOffset uint32
+}
+
+type format struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// 64-bit sizes of an RF64 file, which has 0xFFFFFFFF in the 32-bit sizes
+type ds64 struct {
+	RIFFSize    uint64
+	DataSize    uint64
+	SampleCount uint64 // frames
+	TableLength uint32
+}
+
+type extension struct {
+	Size        uint16
+	ValidBits   uint16
+	ChannelMask uint32
+	SubFormat   [16]byte
+}
+
+// NewWriter returns a Writer that writes a 16-bit stereo WAV file and
+// sample data to ws
+func NewWriter(ws io.WriteSeeker, sampleRate int) (*Writer, error) {
+	return NewFormatWriter(ws, Format{SampleRate: sampleRate, Channels: 2, BitsPerSample: 16})
+}
+
+// NewMonoWriter returns a Writer that writes a 16-bit mono WAV file and
+// sample data to ws
+func NewMonoWriter(ws io.WriteSeeker, sampleRate int) (*Writer, error) {
+	return NewFormatWriter(ws, Format{SampleRate: sampleRate, Channels: 1, BitsPerSample: 16})
+}
+
+// NewSurroundWriter returns a Writer that writes a 16-bit 5.1 surround WAV
+// file and sample data to ws. Each frame is 6 samples, front left, front
+// right, center, LFE, back left and back right.
+func NewSurroundWriter(ws io.WriteSeeker, sampleRate int) (*Writer, error) {
+	return NewFormatWriter(ws, Format{SampleRate: sampleRate, Channels: 6, BitsPerSample: 16, ChannelMask: Surround51})
+}
+
+// NewFormatWriter returns a Writer that writes a WAV file of audio in format f
+// and sample data to ws. Files with more than 2 channels, more than 16 bits
+// per sample or a channel mask are written in the extensible format.
+func NewFormatWriter(ws io.WriteSeeker, f Format) (*Writer, error) {
+	if f.SampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", f.SampleRate)
+	}
+	if f.Channels < 1 || f.Channels > 18 {
+		return nil, fmt.Errorf("invalid number of channels %d", f.Channels)
+	}
+	if f.BitsPerSample != 16 && f.BitsPerSample != 24 && f.BitsPerSample != 32 {
+		return nil, fmt.Errorf("invalid bits per sample %d", f.BitsPerSample)
+	}
+	writer := &Writer{WS: ws, format: f}
+
+	// Zero length for now, come back and fill this later
+	if err := writer.writeChunkHeader("RIFF", 0); err != nil {
+		return nil, err
+	}
+
+	if _, err := ws.Write([]byte("WAVE")); err != nil {
+		return nil, err
+	}
+
+	// Reserve room for the 64-bit sizes, in case the file grows over 4GB.
+	// Readers skip JUNK chunks.
+	if err := writer.writeChunkHeader("JUNK", binary.Size(ds64{})); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(ws, binary.LittleEndian, ds64{}); err != nil {
+		return nil, err
+	}
+
+	// Write format chunk
+	bytes := f.BitsPerSample / 8
+	header := format{
+		AudioFormat:   wavTypePCM,
+		Channels:      uint16(f.Channels),
+		SampleRate:    uint32(f.SampleRate),
+		ByteRate:      uint32(f.SampleRate * f.Channels * bytes),
+		BlockAlign:    uint16(f.Channels * bytes),
+		BitsPerSample: uint16(f.BitsPerSample),
+	}
+	extensible := f.Channels > 2 || f.BitsPerSample > 16 || f.ChannelMask != 0
+	size := binary.Size(header)
+	if extensible {
+		header.AudioFormat = wavTypeExtensible
+		size += binary.Size(extension{})
+	}
+	if err := writer.writeChunkHeader("fmt ", size); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(ws, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	if extensible {
+		ext := extension{ValidBits: uint16(f.BitsPerSample), ChannelMask: f.ChannelMask, SubFormat: subtypePCM}
+		ext.Size = uint16(binary.Size(ext) - 2)
+		if err := binary.Write(ws, binary.LittleEndian, ext); err != nil {
+			return nil, err
+		}
+	}
+
+	// Start audio data chunk
+	if err := writer.writeChunkHeader("data", 0); err != nil {
+		return nil, err
+	}
+	pos, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	writer.dataSizePos = pos - 4
+
+	return writer, nil
+}
+
+// Format returns the format of the audio being written.
+func (w *Writer) Format() Format {
+	return w.format
+}
+
+// WriteFrame writes the provided interleaved samples to w, Format().Channels
+// per frame. Files with more bits per sample get the samples shifted up to
+// the full range.
+func (w *Writer) WriteFrame(samples []int16) error {
+	if w.format.BitsPerSample == 16 {
+		return binary.Write(w.WS, binary.LittleEndian, samples)
+	}
+
+	buf := w.buffer(len(samples))
+	for i, s := range samples {
+		w.putSample(buf, i, int32(s)<<16)
+	}
+	_, err := w.WS.Write(buf)
+	return err
+}
+
+// WriteFloat32 writes the provided interleaved samples, from -1 to 1 full
+// scale, to w. Samples outside the range are clipped.
+func (w *Writer) WriteFloat32(samples []float32) error {
+	buf := w.buffer(len(samples))
+	for i, s := range samples {
+		v := math.Round(float64(s) * (1 << 31))
+		w.putSample(buf, i, int32(max(min(v, math.MaxInt32), math.MinInt32)))
+	}
+	_, err := w.WS.Write(buf)
+	return err
+}
+
+// AddCue marks frame, counted from the start of the audio, with a cue point
+// called label, which audio editors show as a marker. The cue points are
+// written by Finish.
+func (w *Writer) AddCue(frame int64, label string) {
+	w.cues = append(w.cues, cue{frame, label})
+}
+
+// SetLoop marks frames start up to end, counted from the start of the audio,
+// as a loop that repeats forever, for game engines and samplers that loop the
+// file. The loop is written by Finish.
+func (w *Writer) SetLoop(start, end int64) error {
+	if start < 0 || end <= start {
+		return fmt.Errorf("invalid loop %d-%d", start, end)
+	}
+	w.loop = &sampleLoop{Start: uint32(start), End: uint32(end - 1)}
+	return nil
+}
+
+// WriteFrames is WriteFrame, for the modplayer.Encoder interface.
+func (w *Writer) WriteFrames(samples []int16) error {
+	return w.WriteFrame(samples)
+}
+
+// Close is Finish, for the modplayer.Encoder interface. It doesn't close WS.
+func (w *Writer) Close() error {
+	_, err := w.Finish()
+	return err
+}
+
+// Returns a buffer for n samples in the file format.
+func (w *Writer) buffer(n int) []byte {
+	size := n * w.format.BitsPerSample / 8
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	return w.buf[:size]
+}
+
+// Stores sample i in buf, reduced from 32 bits to the file format.
+func (w *Writer) putSample(buf []byte, i int, s int32) {
+	switch w.format.BitsPerSample {
+	case 16:
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(s>>16))
+	case 24:
+		s >>= 8
+		buf[i*3+0], buf[i*3+1], buf[i*3+2] = byte(s), byte(s>>8), byte(s>>16)
+	case 32:
+		binary.LittleEndian.PutUint32(buf[i*4:], uint32(s))
+	}
+}
+
+// Finish must be called when all data has been written to the writer
+// This writes the loop and cue points, if there are any, and allows the
+// writer to update placeholders values with the correct values. Files over
+// 4GB are turned into RF64 files.
+func (w *Writer) Finish() (int64, error) {
+	dataEnd, err := w.WS.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	// Chunks must start at an even offset
+	if dataEnd%2 == 1 && (w.loop != nil || len(w.cues) > 0) {
+		if _, err := w.WS.Write([]byte{0}); err != nil {
+			return 0, err
+		}
+	}
+	if w.loop != nil {
+		if err := w.writeLoop(); err != nil {
+			return 0, err
+		}
+	}
+	if len(w.cues) > 0 {
+		if err := w.writeCues(); err != nil {
+			return 0, err
+		}
+	}
+	wlen, err := w.WS.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	riffSize, dataSize := uint64(wlen-8), uint64(dataEnd-w.dataSizePos-4)
+	if riffSize > math.MaxUint32 {
+		// The 32-bit sizes are replaced by the ds64 chunk
+		sizes := ds64{
+			RIFFSize:    riffSize,
+			DataSize:    dataSize,
+			SampleCount: dataSize / uint64(w.format.Channels*w.format.BitsPerSample/8),
+		}
+		if _, err := w.WS.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if err := w.writeChunkHeader("RF64", -1); err != nil {
+			return 0, err
+		}
+		if _, err := w.WS.Seek(ds64Pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if err := w.writeChunkHeader("ds64", binary.Size(sizes)); err != nil {
+			return 0, err
+		}
+		if err := binary.Write(w.WS, binary.LittleEndian, sizes); err != nil {
+			return 0, err
+		}
+		riffSize, dataSize = math.MaxUint32, math.MaxUint32
+	}
+
+	if _, err := w.WS.Seek(4, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w.WS, binary.LittleEndian, uint32(riffSize)); err != nil {
+		return 0, err
+	}
+	if _, err := w.WS.Seek(w.dataSizePos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w.WS, binary.LittleEndian, uint32(dataSize)); err != nil {
+		return 0, err
+	}
+
+	return wlen, nil
+}
+
+// Writes a sampler chunk with the loop.
+func (w *Writer) writeLoop() error {
+	smpl := sampler{
+		SamplePeriod:   uint32(1e9 / w.format.SampleRate),
+		MIDIUnityNote:  60,
+		NumSampleLoops: 1,
+	}
+	if err := w.writeChunkHeader("smpl", binary.Size(smpl)+binary.Size(w.loop)); err != nil {
+		return err
+	}
+	if err := binary.Write(w.WS, binary.LittleEndian, smpl); err != nil {
+		return err
+	}
+	return binary.Write(w.WS, binary.LittleEndian, w.loop)
+}
+
+// Writes a cue chunk with the cue points and a list chunk with their labels.
+func (w *Writer) writeCues() error {
+	points := make([]cuePoint, len(w.cues))
+	for i, c := range w.cues {
+		points[i] = cuePoint{
+			ID:           uint32(i + 1),
+			Position:     uint32(c.frame),
+			DataChunkID:  [4]byte{'d', 'a', 't', 'a'},
+			SampleOffset: uint32(c.frame),
+		}
+	}
+	if err := w.writeChunkHeader("cue ", 4+binary.Size(points)); err != nil {
+		return err
+	}
+	if err := binary.Write(w.WS, binary.LittleEndian, uint32(len(points))); err != nil {
+		return err
+	}
+	if err := binary.Write(w.WS, binary.LittleEndian, points); err != nil {
+		return err
+	}
+
+	// Each label is the cue point ID and a null terminated string, padded
+	// to an even length
+	var labels []byte
+	for i, c := range w.cues {
+		size := 4 + len(c.label) + 1
+		labels = append(labels, "labl"...)
+		labels = binary.LittleEndian.AppendUint32(labels, uint32(size))
+		labels = binary.LittleEndian.AppendUint32(labels, uint32(i+1))
+		labels = append(labels, c.label...)
+		labels = append(labels, 0)
+		if size%2 == 1 {
+			labels = append(labels, 0)
+		}
+	}
+	if err := w.writeChunkHeader("LIST", 4+len(labels)); err != nil {
+		return err
+	}
+	if _, err := w.WS.Write([]byte("adtl")); err != nil {
+		return err
+	}
+	_, err := w.WS.Write(labels)
+	return err
+}
+
+func (w *Writer) writeChunkHeader(chunk string, initialSize int) error {
+	if len(chunk) != 4 {
+		return ErrInvalidChunkHeaderLength
+	}
+
+	if n, err := w.WS.Write([]byte(chunk)); n != 4 || err != nil {
+		return err
+	}
+
+	return binary.Write(w.WS, binary.LittleEndian, int32(initialSize))
+}
@@ -0,0 +1,306 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+// An in-memory file for the writer. Only the bytes written are stored, so
+// seeking past the end stands in for gigabytes of audio.
+type sparseFile struct {
+	data map[int64]byte
+	pos  int64
+	size int64
+}
+
+func newSparseFile() *sparseFile {
+	return &sparseFile{data: make(map[int64]byte)}
+}
+
+func (f *sparseFile) Write(p []byte) (int, error) {
+	for i, b := range p {
+		f.data[f.pos+int64(i)] = b
+	}
+	f.pos += int64(len(p))
+	f.size = max(f.size, f.pos)
+	return len(p), nil
+}
+
+func (f *sparseFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Returns n bytes from offset, with zeros where nothing was written.
+func (f *sparseFile) read(offset int64, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = f.data[offset+int64(i)]
+	}
+	return b
+}
+
+// Returns the whole file.
+func (f *sparseFile) bytes() []byte {
+	return f.read(0, int(f.size))
+}
+
+// Returns the chunks of a WAV file by ID, checking that they fill the file.
+func chunks(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Fatalf("Expected a RIFF WAVE header, got %q", data[0:12])
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Errorf("Expected RIFF size %d, got %d", len(data)-8, size)
+	}
+	found := make(map[string][]byte)
+	pos := 12
+	for pos < len(data) {
+		if pos%2 != 0 {
+			t.Errorf("Chunk at odd offset %d", pos)
+		}
+		id, size := string(data[pos:pos+4]), int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if pos+8+size > len(data) {
+			t.Fatalf("Chunk %q of %d bytes runs past the end of the file", id, size)
+		}
+		found[id] = data[pos+8 : pos+8+size]
+		pos += 8 + size + size%2
+	}
+	return found
+}
+
+func TestWriter16(t *testing.T) {
+	f := newSparseFile()
+	w, err := NewWriter(f, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []int16{1, -1, math.MaxInt16, math.MinInt16}
+	if err := w.WriteFrames(samples); err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != f.size {
+		t.Errorf("Finish returned %d, expected the file size %d", n, f.size)
+	}
+
+	c := chunks(t, f.bytes())
+	if len(c["JUNK"]) != binary.Size(ds64{}) {
+		t.Errorf("Expected %d bytes reserved for RF64, got %d", binary.Size(ds64{}), len(c["JUNK"]))
+	}
+	var got format
+	binary.Read(bytes.NewReader(c["fmt "]), binary.LittleEndian, &got)
+	want := format{AudioFormat: wavTypePCM, Channels: 2, SampleRate: 44100, ByteRate: 44100 * 4, BlockAlign: 4, BitsPerSample: 16}
+	if len(c["fmt "]) != binary.Size(want) || got != want {
+		t.Errorf("Expected format %+v, got %+v", want, got)
+	}
+	if wantData := []byte{1, 0, 0xFF, 0xFF, 0xFF, 0x7F, 0x00, 0x80}; !bytes.Equal(c["data"], wantData) {
+		t.Errorf("Expected data % x, got % x", wantData, c["data"])
+	}
+}
+
+func TestWriterWideSamples(t *testing.T) {
+	cases := []struct {
+		bits   int
+		frames []int16
+		floats []float32
+		want   []byte
+	}{
+		{24, []int16{0x1234, -2}, nil, []byte{0x00, 0x34, 0x12, 0x00, 0xFE, 0xFF}},
+		{32, []int16{0x1234, -2}, nil, []byte{0x00, 0x00, 0x34, 0x12, 0x00, 0x00, 0xFE, 0xFF}},
+		{24, nil, []float32{0.5, -1, 2, -2}, []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0x80, 0xFF, 0xFF, 0x7F, 0x00, 0x00, 0x80}},
+		{32, nil, []float32{0.5, -1, 2}, []byte{0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x80, 0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+	for _, tc := range cases {
+		f := newSparseFile()
+		w, err := NewFormatWriter(f, Format{SampleRate: 48000, Channels: 1, BitsPerSample: tc.bits})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.frames != nil {
+			err = w.WriteFrame(tc.frames)
+		} else {
+			err = w.WriteFloat32(tc.floats)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		c := chunks(t, f.bytes())
+		if !bytes.Equal(c["data"], tc.want) {
+			t.Errorf("%d bits: expected data % x, got % x", tc.bits, tc.want, c["data"])
+		}
+
+		// Wide samples need the extensible format
+		var header format
+		var ext extension
+		r := bytes.NewReader(c["fmt "])
+		binary.Read(r, binary.LittleEndian, &header)
+		binary.Read(r, binary.LittleEndian, &ext)
+		size := uint16(tc.bits / 8)
+		wantHeader := format{AudioFormat: wavTypeExtensible, Channels: 1, SampleRate: 48000, ByteRate: 48000 * uint32(size), BlockAlign: size, BitsPerSample: uint16(tc.bits)}
+		if header != wantHeader {
+			t.Errorf("%d bits: expected format %+v, got %+v", tc.bits, wantHeader, header)
+		}
+		wantExt := extension{Size: 22, ValidBits: uint16(tc.bits), SubFormat: subtypePCM}
+		if ext != wantExt {
+			t.Errorf("%d bits: expected extension %+v, got %+v", tc.bits, wantExt, ext)
+		}
+	}
+}
+
+func TestSurroundFormat(t *testing.T) {
+	f := newSparseFile()
+	w, err := NewSurroundWriter(f, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var ext extension
+	binary.Read(bytes.NewReader(chunks(t, f.bytes())["fmt "][binary.Size(format{}):]), binary.LittleEndian, &ext)
+	if ext.ChannelMask != Surround51 {
+		t.Errorf("Expected channel mask %#x, got %#x", Surround51, ext.ChannelMask)
+	}
+}
+
+func TestCuesAndLoop(t *testing.T) {
+	// One 24-bit mono frame leaves the data chunk an odd length
+	f := newSparseFile()
+	w, err := NewFormatWriter(f, Format{SampleRate: 8000, Channels: 1, BitsPerSample: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrame([]int16{1}); err != nil {
+		t.Fatal(err)
+	}
+	w.AddCue(0, "Intro")
+	w.AddCue(1, "Odd")
+	if err := w.SetLoop(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := chunks(t, f.bytes())
+	if len(c["data"]) != 3 {
+		t.Errorf("Expected 3 bytes of data, got %d", len(c["data"]))
+	}
+
+	r := bytes.NewReader(c["cue "])
+	var count uint32
+	binary.Read(r, binary.LittleEndian, &count)
+	points := make([]cuePoint, count)
+	binary.Read(r, binary.LittleEndian, points)
+	if count != 2 || r.Len() != 0 {
+		t.Fatalf("Expected 2 cue points filling the chunk, got %d and %d bytes left", count, r.Len())
+	}
+	for i, frame := range []uint32{0, 1} {
+		want := cuePoint{ID: uint32(i + 1), Position: frame, DataChunkID: [4]byte{'d', 'a', 't', 'a'}, SampleOffset: frame}
+		if points[i] != want {
+			t.Errorf("Cue %d: expected %+v, got %+v", i, want, points[i])
+		}
+	}
+
+	wantList := []byte("adtl" +
+		"labl\x0A\x00\x00\x00\x01\x00\x00\x00Intro\x00" +
+		"labl\x08\x00\x00\x00\x02\x00\x00\x00Odd\x00")
+	if !bytes.Equal(c["LIST"], wantList) {
+		t.Errorf("Expected labels %q, got %q", wantList, c["LIST"])
+	}
+
+	r = bytes.NewReader(c["smpl"])
+	var smpl sampler
+	var loop sampleLoop
+	binary.Read(r, binary.LittleEndian, &smpl)
+	binary.Read(r, binary.LittleEndian, &loop)
+	if smpl.SamplePeriod != 125000 || smpl.NumSampleLoops != 1 || loop != (sampleLoop{Start: 0, End: 0}) || r.Len() != 0 {
+		t.Errorf("Unexpected sampler chunk %+v, loop %+v", smpl, loop)
+	}
+
+	if err := w.SetLoop(5, 5); err == nil {
+		t.Error("Expected an error for an empty loop")
+	}
+}
+
+func TestRF64(t *testing.T) {
+	f := newSparseFile()
+	w, err := NewWriter(f, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrames([]int16{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	// Skip ahead as if 5GB of audio had been written
+	const skipped = 5 << 30
+	if _, err := f.Seek(skipped, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	w.AddCue(0, "Start")
+	n, err := w.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != f.size {
+		t.Errorf("Finish returned %d, expected the file size %d", n, f.size)
+	}
+
+	if id := string(f.read(0, 4)); id != "RF64" {
+		t.Errorf("Expected an RF64 header, got %q", id)
+	}
+	if size := binary.LittleEndian.Uint32(f.read(4, 4)); size != math.MaxUint32 {
+		t.Errorf("Expected RIFF size ffffffff, got %x", size)
+	}
+	if id := string(f.read(ds64Pos, 4)); id != "ds64" {
+		t.Errorf("Expected the JUNK chunk to become ds64, got %q", id)
+	}
+	if size := binary.LittleEndian.Uint32(f.read(ds64Pos+4, 4)); int(size) != binary.Size(ds64{}) {
+		t.Errorf("Expected ds64 size %d, got %d", binary.Size(ds64{}), size)
+	}
+	var sizes ds64
+	binary.Read(bytes.NewReader(f.read(ds64Pos+8, binary.Size(sizes))), binary.LittleEndian, &sizes)
+	dataSize := uint64(4 + skipped)
+	want := ds64{RIFFSize: uint64(f.size - 8), DataSize: dataSize, SampleCount: dataSize / 4}
+	if sizes != want {
+		t.Errorf("Expected ds64 %+v, got %+v", want, sizes)
+	}
+	if size := binary.LittleEndian.Uint32(f.read(w.dataSizePos, 4)); size != math.MaxUint32 {
+		t.Errorf("Expected data size ffffffff, got %x", size)
+	}
+	cueStart := w.dataSizePos + 4 + int64(dataSize)
+	if id := string(f.read(cueStart, 4)); id != "cue " {
+		t.Errorf("Expected the cue chunk after the data, got %q", id)
+	}
+}
+
+func TestNewFormatWriterErrors(t *testing.T) {
+	for _, f := range []Format{
+		{SampleRate: 0, Channels: 2, BitsPerSample: 16},
+		{SampleRate: 44100, Channels: 0, BitsPerSample: 16},
+		{SampleRate: 44100, Channels: 19, BitsPerSample: 16},
+		{SampleRate: 44100, Channels: 2, BitsPerSample: 8},
+	} {
+		if _, err := NewFormatWriter(newSparseFile(), f); err == nil {
+			t.Errorf("%+v: expected an error", f)
+		}
+	}
+}